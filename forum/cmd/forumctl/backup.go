@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"forum/internal/backup"
+	"forum/internal/models"
+)
+
+// runBackup writes a backup of the database to the path given, or to the
+// backup directory.
+func runBackup(ctx context.Context, c *cli, args []string) error {
+	if len(args) > 1 {
+		return errUsage
+	}
+	db, err := c.openDB(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var path string
+	switch {
+	case len(args) == 1:
+		path = args[0]
+		err = db.Backup(ctx, path)
+	case c.cfg.Backup.Dir != "":
+		path, err = backup.Take(ctx, db, c.cfg.Backup.Dir, c.cfg.Backup.Keep)
+	default:
+		return errors.New("no path given and -backupDir is blank")
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(c.out, path)
+	return nil
+}
+
+// runRestore replaces the content of the database with a backup.
+func runRestore(ctx context.Context, c *cli, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	_, err := os.Stat(args[0])
+	if err != nil {
+		return err
+	}
+	db, err := c.openDB(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	err = db.Restore(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.out, "restored %s\n", args[0])
+	return nil
+}
+
+// runCheck checks the integrity of the database, or of a backup, and lists
+// the problems found.
+func runCheck(ctx context.Context, c *cli, args []string) error {
+	if len(args) > 1 {
+		return errUsage
+	}
+	var (
+		db  *models.DB
+		err error
+	)
+	if len(args) == 1 {
+		// Opening a missing file would create an empty database.
+		_, err = os.Stat(args[0])
+		if err != nil {
+			return err
+		}
+		db, err = models.Open(args[0], models.Options{})
+		if err == nil {
+			err = checkVersion(ctx, db)
+		}
+	} else {
+		db, err = c.openDB(ctx)
+	}
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	problems, err := db.Check(ctx)
+	if err != nil {
+		return err
+	}
+	for _, p := range problems {
+		fmt.Fprintln(c.out, p)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%d problems found", len(problems))
+	}
+	fmt.Fprintln(c.out, "ok")
+	return nil
+}
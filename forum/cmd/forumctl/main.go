@@ -0,0 +1,214 @@
+// Command forumctl runs maintenance and moderation tasks on the database of
+// the forum. It reads the same configuration as the web server: a TOML file,
+// environment variables and flags, given before the command. Results are
+// printed as a table, or as JSON with -json.
+//
+// Commands refuse a database whose schema is older than forumctl, rather than
+// upgrading it behind the back of the server still using it. Run them with
+// -migrate to apply the migrations first, as the server does when it starts.
+//
+// Usage:
+//
+//	forumctl [flags] command [arguments]
+//
+// A web server using the same SQLite database notices the changes made by
+// forumctl within cache.check_interval and drops its cache. With PostgreSQL,
+// the cached threads are only refreshed once they expire.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"reflect"
+	"slices"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+
+	"forum/internal/config"
+	"forum/internal/models"
+)
+
+// command is a subcommand of forumctl. Its name may have several words, such
+// as "user create".
+type command struct {
+	name string
+	args string
+	help string
+	run  func(ctx context.Context, c *cli, args []string) error
+}
+
+// commands lists the subcommands in the order of the usage message.
+var commands = []command{
+	{"backup", "[path]", "Write a consistent copy of the database to path, or to -backupDir, removing the backups beyond -backupKeep", runBackup},
+	{"restore", "path", "Replace the content of the database with the backup at path; stop the server first", runRestore},
+	{"check", "[path]", "Check the integrity of the database, or of the backup at path", runCheck},
+	{"user create", "username email [role]", "Create a user with a random password, printed once", runUserCreate},
+	{"user list", "", "List the users", runUserList},
+	{"user set-role", "username role", "Make the user a member, a moderator or an admin", runUserSetRole},
+	{"user disable", "username", "Log the user out and prevent them from logging in again", runUserDisable},
+	{"user enable", "username", "Allow a disabled user to log in again", runUserEnable},
+	{"user reset-password", "username", "Replace the password of the user with a random one, printed once", runUserResetPassword},
+	{"thread lock", "id", "Prevent new posts in the thread", runThreadLock},
+	{"thread unlock", "id", "Allow new posts in the thread again", runThreadUnlock},
+	{"thread delete", "id", "Delete the thread with its posts and their attachments", runThreadDelete},
+	{"thread move", "id target", "Move the posts of the thread to the target thread, and delete it", runThreadMove},
+	{"post purge-by-user", "username", "Delete every post of the user, with their attachments", runPostPurgeByUser},
+}
+
+// cli holds the configuration and output of a run of forumctl.
+type cli struct {
+	cfg     *config.Config
+	out     io.Writer
+	json    bool
+	migrate bool
+}
+
+var (
+	// errUsage reports a command line that is not understood.
+	errUsage = errors.New("invalid usage")
+
+	// errOlderSchema reports a database that needs its migrations applied,
+	// which forumctl only does with -migrate.
+	errOlderSchema = errors.New("database schema is older than forumctl; run with -migrate to upgrade it")
+)
+
+func main() {
+	fs := flag.NewFlagSet("forumctl", flag.ExitOnError)
+	fs.Usage = func() { usage(fs) }
+	jsonOutput := fs.Bool("json", false, "Print the results as JSON")
+	migrate := fs.Bool("migrate", false, "Upgrade an older database schema before running the command")
+	cfg, err := config.Load(fs, os.Args[1:], os.Getenv)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	err = run(ctx, &cli{cfg: cfg, out: os.Stdout, json: *jsonOutput, migrate: *migrate}, fs.Args())
+	if errors.Is(err, errUsage) {
+		fs.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "forumctl:", err)
+		os.Exit(1)
+	}
+}
+
+// run runs the command named by the first words of args.
+func run(ctx context.Context, c *cli, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	for _, cmd := range commands {
+		words := strings.Fields(cmd.name)
+		if len(args) >= len(words) && slices.Equal(args[:len(words)], words) {
+			return cmd.run(ctx, c, args[len(words):])
+		}
+	}
+	return fmt.Errorf("unknown command %q: %w", strings.Join(args, " "), errUsage)
+}
+
+func usage(fs *flag.FlagSet) {
+	w := fs.Output()
+	fmt.Fprintf(w, "Usage: forumctl [flags] command [arguments]\n\nCommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %s\n    \t%s\n", strings.TrimSpace(cmd.name+" "+cmd.args), cmd.help)
+	}
+	fmt.Fprintf(w, "\nFlags:\n")
+	fs.PrintDefaults()
+}
+
+// openDB opens the database of the configuration, refusing one whose schema
+// is newer than this binary.
+func (c *cli) openDB(ctx context.Context) (*models.DB, error) {
+	db, err := models.Open(c.cfg.Database.DSN(), models.Options{
+		JournalMode:  c.cfg.Database.JournalMode,
+		BusyTimeout:  c.cfg.Database.BusyTimeout,
+		ForeignKeys:  c.cfg.Database.ForeignKeys,
+		Synchronous:  c.cfg.Database.Synchronous,
+		ReadConns:    1,
+		QueryTimeout: c.cfg.Database.QueryTimeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = checkVersion(ctx, db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// checkVersion returns ErrNewerSchema if the schema of db is newer than this
+// binary.
+func checkVersion(ctx context.Context, db *models.DB) error {
+	version, err := db.Version(ctx)
+	if err != nil {
+		return err
+	}
+	if version > models.SchemaVersion() {
+		return fmt.Errorf("database at version %d, forumctl at version %d: %w", version, models.SchemaVersion(), models.ErrNewerSchema)
+	}
+	return nil
+}
+
+// openModels opens the database like openDB and returns the models of the
+// forum's content. A schema older than this binary is only brought up to date
+// with -migrate.
+func (c *cli) openModels(ctx context.Context) (*models.DB, *models.ThreadModel, *models.UserModel, *models.PostModel, error) {
+	db, err := c.openDB(ctx)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	version, err := db.Version(ctx)
+	if err != nil {
+		db.Close()
+		return nil, nil, nil, nil, err
+	}
+	if version < models.SchemaVersion() && !c.migrate {
+		db.Close()
+		return nil, nil, nil, nil, fmt.Errorf("database at version %d, forumctl at version %d: %w", version, models.SchemaVersion(), errOlderSchema)
+	}
+	threads, users, posts, err := models.NewModels(db)
+	if err != nil {
+		db.Close()
+		return nil, nil, nil, nil, err
+	}
+	return db, threads, users, posts, nil
+}
+
+// print prints records, a slice of structs, as JSON with -json, or else as a
+// table with a column per field, headed by its JSON name.
+func (c *cli) print(records any) error {
+	if c.json {
+		enc := json.NewEncoder(c.out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(records)
+	}
+
+	v := reflect.ValueOf(records)
+	t := v.Type().Elem()
+	w := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		fmt.Fprint(w, strings.ToUpper(name), "\t")
+	}
+	fmt.Fprintln(w)
+	for i := 0; i < v.Len(); i++ {
+		for j := 0; j < t.NumField(); j++ {
+			fmt.Fprint(w, v.Index(i).Field(j).Interface(), "\t")
+		}
+		fmt.Fprintln(w)
+	}
+	return w.Flush()
+}
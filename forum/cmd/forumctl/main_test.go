@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"forum/internal/config"
+	"forum/internal/models"
+	"forum/internal/testutil"
+)
+
+// newTestCLI returns a cli on an SQLite database of its own, brought up to
+// date, and the buffer its output goes to.
+func newTestCLI(t *testing.T) (*cli, *bytes.Buffer) {
+	t.Helper()
+	cfg := config.Default()
+	dir := t.TempDir()
+	cfg.Database.Path = filepath.Join(dir, "forum.db")
+	cfg.Storage.BlobDir = filepath.Join(dir, "blobs")
+	var out bytes.Buffer
+	c := &cli{cfg: cfg, out: &out, migrate: true}
+
+	db, _, _, _, err := c.openModels(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	c.migrate = false
+	return c, &out
+}
+
+// runJSON runs a command with -json and decodes its output into v.
+func runJSON(t *testing.T, c *cli, out *bytes.Buffer, v any, args ...string) {
+	t.Helper()
+	out.Reset()
+	c.json = true
+	defer func() { c.json = false }()
+	err := run(context.Background(), c, args)
+	if err != nil {
+		t.Fatalf("%s: %v", strings.Join(args, " "), err)
+	}
+	err = json.Unmarshal(out.Bytes(), v)
+	if err != nil {
+		t.Fatalf("%s: %v in %q", strings.Join(args, " "), err, out)
+	}
+}
+
+func TestRunUsage(t *testing.T) {
+	c, _ := newTestCLI(t)
+	for _, args := range [][]string{
+		nil,
+		{"frobnicate"},
+		{"user"},
+		{"user", "list", "extra"},
+		{"thread", "lock"},
+		{"user", "create", "alice"},
+	} {
+		err := run(context.Background(), c, args)
+		if !errors.Is(err, errUsage) {
+			t.Errorf("%q: got %v; want %v", args, err, errUsage)
+		}
+	}
+}
+
+func TestSchemaVersion(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.Default()
+	cfg.Database.Path = filepath.Join(t.TempDir(), "forum.db")
+	var out bytes.Buffer
+	c := &cli{cfg: cfg, out: &out}
+
+	// An older schema is left alone without -migrate.
+	err := run(ctx, c, []string{"user", "list"})
+	if !errors.Is(err, errOlderSchema) {
+		t.Fatalf("got %v; want %v", err, errOlderSchema)
+	}
+	db, err := c.openDB(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	version, err := db.Version(ctx)
+	db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.Equal(t, version, 0)
+
+	c.migrate = true
+	err = run(ctx, c, []string{"user", "list"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err = c.openDB(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	version, err = db.Version(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.Equal(t, version, models.SchemaVersion())
+
+	// A newer schema is refused, even with -migrate.
+	_, err = db.ExecContext(ctx, `INSERT INTO SchemaVersion (version) VALUES (?)`, models.SchemaVersion()+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = run(ctx, c, []string{"user", "list"})
+	if !errors.Is(err, models.ErrNewerSchema) {
+		t.Errorf("got %v; want %v", err, models.ErrNewerSchema)
+	}
+}
+
+func TestUserCommands(t *testing.T) {
+	ctx := context.Background()
+	c, out := newTestCLI(t)
+
+	var created []passwordRecord
+	runJSON(t, c, out, &created, "user", "create", "alice", "alice@example.com", models.RoleAdmin)
+	testutil.Equal(t, len(created), 1)
+	testutil.Equal(t, created[0].Username, "alice")
+
+	db, _, users, _, err := c.openModels(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	id, err := users.AuthenticateContext(ctx, "alice@example.com", created[0].Password)
+	if err != nil {
+		t.Fatalf("logging in with the printed password: %v", err)
+	}
+	testutil.Equal(t, id, created[0].ID)
+
+	for _, tt := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"user", "create", "Alice", "other@example.com"}, `username "Alice" is already taken`},
+		{[]string{"user", "create", "bob", "not an email"}, "invalid email"},
+		{[]string{"user", "create", "bob", "bob@example.com", "owner"}, `unknown role "owner"`},
+		{[]string{"user", "set-role", "carol", models.RoleAdmin}, `no user named "carol"`},
+	} {
+		err := run(ctx, c, tt.args)
+		if err == nil {
+			t.Errorf("%q succeeded", tt.args)
+			continue
+		}
+		testutil.Contains(t, err.Error(), tt.want)
+	}
+
+	runJSON(t, c, out, &created, "user", "create", "bob", "bob@example.com")
+	var records []userRecord
+	runJSON(t, c, out, &records, "user", "set-role", "BOB", models.RoleModerator)
+	testutil.Equal(t, records[0], userRecord{ID: created[0].ID, Username: "bob", Email: "bob@example.com", Role: models.RoleModerator})
+	runJSON(t, c, out, &records, "user", "disable", "bob")
+	testutil.Equal(t, records[0].Disabled, true)
+	runJSON(t, c, out, &records, "user", "list")
+	testutil.Equal(t, len(records), 2)
+	testutil.Equal(t, records[1].Disabled, true)
+	runJSON(t, c, out, &records, "user", "enable", "bob")
+	testutil.Equal(t, records[0].Disabled, false)
+
+	var reset []passwordRecord
+	runJSON(t, c, out, &reset, "user", "reset-password", "bob")
+	if reset[0].Password == created[0].Password {
+		t.Error("password unchanged")
+	}
+	_, err = users.AuthenticateContext(ctx, "bob@example.com", reset[0].Password)
+	if err != nil {
+		t.Errorf("logging in with the new password: %v", err)
+	}
+
+	// Without -json, the records are printed as a table.
+	out.Reset()
+	err = run(ctx, c, []string{"user", "list"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	testutil.Equal(t, len(lines), 3)
+	testutil.Equal(t, strings.Join(strings.Fields(lines[0]), " "), "ID USERNAME EMAIL ROLE DISABLED")
+	testutil.Equal(t, strings.Join(strings.Fields(lines[1]), " "), strconv.Itoa(id)+" alice alice@example.com admin false")
+}
+
+func TestThreadCommands(t *testing.T) {
+	ctx := context.Background()
+	c, out := newTestCLI(t)
+
+	db, threads, users, posts, err := c.openModels(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	alice, err := users.InsertContext(ctx, "alice", "alice@example.com", "correct horse battery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := users.InsertContext(ctx, "bob", "bob@example.com", "correct horse battery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := threads.InsertContext(ctx, "First", alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := threads.InsertContext(ctx, "Second", alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, thread := range []int{first, first, second} {
+		_, err = posts.InsertContext(ctx, "Hello", thread, bob)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err = posts.InsertContext(ctx, "Hi", second, alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var locked []threadRecord
+	runJSON(t, c, out, &locked, "thread", "lock", strconv.Itoa(first))
+	testutil.Equal(t, locked[0], threadRecord{ID: first, Title: "First", Replies: 2, Locked: true})
+	runJSON(t, c, out, &locked, "thread", "unlock", strconv.Itoa(first))
+	testutil.Equal(t, locked[0].Locked, false)
+
+	for _, tt := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"thread", "lock", "0"}, `invalid thread id "0"`},
+		{[]string{"thread", "lock", "abc"}, `invalid thread id "abc"`},
+		{[]string{"thread", "delete", "999"}, "999"},
+		{[]string{"post", "purge-by-user", "carol"}, `no user named "carol"`},
+	} {
+		err := run(ctx, c, tt.args)
+		if err == nil {
+			t.Errorf("%q succeeded", tt.args)
+			continue
+		}
+		testutil.Contains(t, err.Error(), tt.want)
+	}
+
+	var moved []moveRecord
+	runJSON(t, c, out, &moved, "thread", "move", strconv.Itoa(first), strconv.Itoa(second))
+	testutil.Equal(t, moved[0], moveRecord{Thread: first, Target: second, Posts: 2})
+	_, err = threads.GetContext(ctx, first)
+	if !errors.Is(err, models.ErrNoRecord) {
+		t.Errorf("moved thread: got %v; want %v", err, models.ErrNoRecord)
+	}
+
+	var deleted []deletionRecord
+	runJSON(t, c, out, &deleted, "post", "purge-by-user", "bob")
+	testutil.Equal(t, deleted[0], deletionRecord{Posts: 3})
+	runJSON(t, c, out, &deleted, "thread", "delete", strconv.Itoa(second))
+	testutil.Equal(t, deleted[0], deletionRecord{Posts: 1})
+	_, err = threads.GetContext(ctx, second)
+	if !errors.Is(err, models.ErrNoRecord) {
+		t.Errorf("deleted thread: got %v; want %v", err, models.ErrNoRecord)
+	}
+}
+
+func TestBackupCommands(t *testing.T) {
+	ctx := context.Background()
+	c, out := newTestCLI(t)
+	var created []passwordRecord
+	runJSON(t, c, out, &created, "user", "create", "alice", "alice@example.com")
+
+	path := filepath.Join(t.TempDir(), "forum.db")
+	out.Reset()
+	err := run(ctx, c, []string{"backup", path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.Equal(t, out.String(), path+"\n")
+
+	out.Reset()
+	err = run(ctx, c, []string{"check", path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.Equal(t, out.String(), "ok\n")
+	err = run(ctx, c, []string{"check", filepath.Join(t.TempDir(), "missing.db")})
+	if err == nil {
+		t.Error("checked a missing backup")
+	}
+
+	err = run(ctx, c, []string{"backup"})
+	if err == nil {
+		t.Error("backed up without a path or -backupDir")
+	}
+
+	runJSON(t, c, out, &created, "user", "create", "bob", "bob@example.com")
+	out.Reset()
+	err = run(ctx, c, []string{"restore", path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.Equal(t, out.String(), "restored "+path+"\n")
+	var records []userRecord
+	runJSON(t, c, out, &records, "user", "list")
+	testutil.Equal(t, len(records), 1)
+	testutil.Equal(t, records[0].Username, "alice")
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"forum/internal/blob"
+	"forum/internal/models"
+)
+
+// threadRecord is a thread as printed by forumctl.
+type threadRecord struct {
+	ID      int    `json:"id"`
+	Title   string `json:"title"`
+	Replies int    `json:"replies"`
+	Locked  bool   `json:"locked"`
+}
+
+// moveRecord counts the posts moved from a thread to another.
+type moveRecord struct {
+	Thread int `json:"thread"`
+	Target int `json:"target"`
+	Posts  int `json:"posts"`
+}
+
+// deletionRecord counts what a command deleted.
+type deletionRecord struct {
+	Posts       int `json:"posts"`
+	Attachments int `json:"attachments"`
+}
+
+func runThreadLock(ctx context.Context, c *cli, args []string) error {
+	return setLocked(ctx, c, args, true)
+}
+
+func runThreadUnlock(ctx context.Context, c *cli, args []string) error {
+	return setLocked(ctx, c, args, false)
+}
+
+func setLocked(ctx context.Context, c *cli, args []string, locked bool) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	id, err := parseThreadID(args[0])
+	if err != nil {
+		return err
+	}
+	db, threads, _, _, err := c.openModels(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	err = threads.SetLockedContext(ctx, id, locked)
+	if err != nil {
+		return threadError(id, err)
+	}
+	t, err := threads.GetContext(ctx, id)
+	if err != nil {
+		return err
+	}
+	return c.print([]threadRecord{{ID: t.ID, Title: t.Title, Replies: t.ReplyCount, Locked: t.Locked}})
+}
+
+func runThreadDelete(ctx context.Context, c *cli, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	id, err := parseThreadID(args[0])
+	if err != nil {
+		return err
+	}
+	db, threads, _, _, err := c.openModels(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	n, keys, err := threads.DeleteContext(ctx, id)
+	if err != nil {
+		return threadError(id, err)
+	}
+	err = c.deleteBlobs(ctx, keys)
+	if err != nil {
+		return err
+	}
+	return c.print([]deletionRecord{{Posts: n, Attachments: len(keys)}})
+}
+
+func runThreadMove(ctx context.Context, c *cli, args []string) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+	id, err := parseThreadID(args[0])
+	if err != nil {
+		return err
+	}
+	targetID, err := parseThreadID(args[1])
+	if err != nil {
+		return err
+	}
+	db, threads, _, _, err := c.openModels(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	n, err := threads.MoveContext(ctx, id, targetID)
+	if err != nil {
+		return err
+	}
+	return c.print([]moveRecord{{Thread: id, Target: targetID, Posts: n}})
+}
+
+func runPostPurgeByUser(ctx context.Context, c *cli, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	db, _, users, posts, err := c.openModels(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	u, err := lookupUser(ctx, users, args[0])
+	if err != nil {
+		return err
+	}
+	n, keys, err := posts.PurgeByUserContext(ctx, u.ID)
+	if err != nil {
+		return err
+	}
+	err = c.deleteBlobs(ctx, keys)
+	if err != nil {
+		return err
+	}
+	return c.print([]deletionRecord{{Posts: n, Attachments: len(keys)}})
+}
+
+// deleteBlobs removes the files of deleted attachments from the blob store.
+func (c *cli) deleteBlobs(ctx context.Context, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	blobs, err := blob.Open(blob.Options{
+		Kind:        c.cfg.Storage.BlobStore,
+		Dir:         c.cfg.Storage.BlobDir,
+		S3Endpoint:  c.cfg.Storage.S3Endpoint,
+		S3Bucket:    c.cfg.Storage.S3Bucket,
+		S3Region:    c.cfg.Storage.S3Region,
+		S3AccessKey: c.cfg.Storage.S3AccessKey,
+		S3SecretKey: c.cfg.Storage.S3SecretKey,
+	})
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, key := range keys {
+		err := blobs.Delete(ctx, key)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("deleting attachment %s: %w", key, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func parseThreadID(s string) (int, error) {
+	id, err := strconv.Atoi(s)
+	if err != nil || id < 1 {
+		return 0, fmt.Errorf("invalid thread id %q", s)
+	}
+	return id, nil
+}
+
+// threadError describes a missing thread better than ErrNoRecord does.
+func threadError(id int, err error) error {
+	if errors.Is(err, models.ErrNoRecord) {
+		return fmt.Errorf("thread %d: %w", id, err)
+	}
+	return err
+}
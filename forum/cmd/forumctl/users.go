@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"slices"
+
+	"forum/internal/models"
+	"forum/internal/validator"
+)
+
+// userRecord is a user as printed by forumctl.
+type userRecord struct {
+	ID       int    `json:"id"`
+	Username string `json:"username"`
+	Email    string `json:"email"`
+	Role     string `json:"role"`
+	Disabled bool   `json:"disabled"`
+}
+
+func newUserRecord(u *models.User) userRecord {
+	return userRecord{ID: u.ID, Username: u.Username, Email: u.Email, Role: u.Role, Disabled: u.Disabled}
+}
+
+// passwordRecord is a user given a new password.
+type passwordRecord struct {
+	ID       int    `json:"id"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+func runUserCreate(ctx context.Context, c *cli, args []string) error {
+	if len(args) < 2 || len(args) > 3 {
+		return errUsage
+	}
+	username, email, role := args[0], args[1], models.RoleMember
+	if len(args) == 3 {
+		role = args[2]
+	}
+	if !validator.ValidateEmail(email) {
+		return fmt.Errorf("invalid email %q", email)
+	}
+	if !slices.Contains(models.Roles, role) {
+		return fmt.Errorf("unknown role %q", role)
+	}
+
+	db, _, users, _, err := c.openModels(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	exists, err := users.UsernameExistsContext(ctx, username)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("username %q is already taken", username)
+	}
+	password, err := newPassword()
+	if err != nil {
+		return err
+	}
+
+	var id int
+	err = db.WithTx(ctx, func(ctx context.Context) error {
+		var err error
+		id, err = users.InsertContext(ctx, username, email, password)
+		if err != nil {
+			return err
+		}
+		return users.SetRoleContext(ctx, id, role)
+	})
+	if err != nil {
+		return err
+	}
+	return c.print([]passwordRecord{{ID: id, Username: username, Password: password}})
+}
+
+func runUserList(ctx context.Context, c *cli, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	db, _, users, _, err := c.openModels(ctx)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	list, err := users.ListContext(ctx)
+	if err != nil {
+		return err
+	}
+	records := []userRecord{}
+	for _, u := range list {
+		records = append(records, newUserRecord(u))
+	}
+	return c.print(records)
+}
+
+func runUserSetRole(ctx context.Context, c *cli, args []string) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+	u, err := c.updateUser(ctx, args[0], func(users *models.UserModel, id int) error {
+		return users.SetRoleContext(ctx, id, args[1])
+	})
+	if err != nil {
+		return err
+	}
+	return c.print([]userRecord{newUserRecord(u)})
+}
+
+func runUserDisable(ctx context.Context, c *cli, args []string) error {
+	return setDisabled(ctx, c, args, true)
+}
+
+func runUserEnable(ctx context.Context, c *cli, args []string) error {
+	return setDisabled(ctx, c, args, false)
+}
+
+func setDisabled(ctx context.Context, c *cli, args []string, disabled bool) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	u, err := c.updateUser(ctx, args[0], func(users *models.UserModel, id int) error {
+		return users.SetDisabledContext(ctx, id, disabled)
+	})
+	if err != nil {
+		return err
+	}
+	return c.print([]userRecord{newUserRecord(u)})
+}
+
+func runUserResetPassword(ctx context.Context, c *cli, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	password, err := newPassword()
+	if err != nil {
+		return err
+	}
+	u, err := c.updateUser(ctx, args[0], func(users *models.UserModel, id int) error {
+		return users.SetPasswordContext(ctx, id, password)
+	})
+	if err != nil {
+		return err
+	}
+	return c.print([]passwordRecord{{ID: u.ID, Username: u.Username, Password: password}})
+}
+
+// updateUser applies update to the user named username and returns them as
+// updated.
+func (c *cli) updateUser(ctx context.Context, username string, update func(users *models.UserModel, id int) error) (*models.User, error) {
+	db, _, users, _, err := c.openModels(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	u, err := lookupUser(ctx, users, username)
+	if err != nil {
+		return nil, err
+	}
+	err = update(users, u.ID)
+	if err != nil {
+		return nil, err
+	}
+	return users.GetContext(ctx, u.ID)
+}
+
+// lookupUser returns the user named username, ignoring case.
+func lookupUser(ctx context.Context, users *models.UserModel, username string) (*models.User, error) {
+	u, err := users.GetByUsernameContext(ctx, username)
+	if errors.Is(err, models.ErrNoRecord) {
+		return nil, fmt.Errorf("no user named %q", username)
+	}
+	return u, err
+}
+
+// newPassword returns a random password meeting the rules of the sign-up
+// form.
+func newPassword() (string, error) {
+	b := make([]byte, 15)
+	for {
+		_, err := rand.Read(b)
+		if err != nil {
+			return "", err
+		}
+		password := base32.StdEncoding.EncodeToString(b)
+		if validator.CheckPassword(password) {
+			return password, nil
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"forum/internal/blob"
+	"forum/internal/logging"
+	"forum/internal/models"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// attachmentLimits maps each accepted content type, as sniffed from the
+// file, to the largest size allowed for it.
+var attachmentLimits = map[string]int64{
+	"image/png":          5 << 20,
+	"image/jpeg":         5 << 20,
+	"image/gif":          5 << 20,
+	"image/webp":         5 << 20,
+	"text/plain":         1 << 20,
+	"application/pdf":    10 << 20,
+	"application/zip":    10 << 20,
+	"application/x-gzip": 10 << 20,
+}
+
+// maxPostRequestBytes bounds the size of a post creation request.
+func (app *application) maxPostRequestBytes() int64 {
+	return int64(app.cfg.Limits.MaxAttachments)*(10<<20) + 1<<20
+}
+
+// pendingAttachment is an uploaded file that passed validation and is ready
+// to be stored.
+type pendingAttachment struct {
+	header      *multipart.FileHeader
+	filename    string
+	contentType string
+	key         string
+}
+
+// checkAttachments validates the files attached to a post against the type,
+// size and quota limits. Problems are reported as field errors on the form.
+func (app *application) checkAttachments(ctx context.Context, form *messageCreateForm, files []*multipart.FileHeader, userID int) ([]pendingAttachment, error) {
+	if len(files) == 0 {
+		return nil, nil
+	}
+	if len(files) > app.cfg.Limits.MaxAttachments {
+		form.AddFieldError("attachments", fmt.Sprintf("You cannot attach more than %d files", app.cfg.Limits.MaxAttachments))
+		return nil, nil
+	}
+
+	used, err := app.attachments.UsedBytesContext(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []pendingAttachment
+	for _, fh := range files {
+		contentType, err := sniffContentType(fh)
+		if err != nil {
+			return nil, err
+		}
+		name := filepath.Base(fh.Filename)
+
+		limit, ok := attachmentLimits[contentType]
+		if !ok {
+			form.AddFieldError("attachments", fmt.Sprintf("%s is not an image, text, PDF or archive file", name))
+			continue
+		}
+		if fh.Size > limit {
+			form.AddFieldError("attachments", fmt.Sprintf("%s is larger than %d MB", name, limit>>20))
+			continue
+		}
+		used += fh.Size
+		pending = append(pending, pendingAttachment{header: fh, filename: name, contentType: contentType})
+	}
+
+	quota := app.cfg.Limits.AttachmentQuotaMB
+	if used > int64(quota)<<20 {
+		form.AddFieldError("attachments", fmt.Sprintf("These files would exceed your %d MB storage quota", quota))
+	}
+	return pending, nil
+}
+
+// sniffContentType returns the media type of an uploaded file, detected from
+// its first bytes rather than trusted from the client.
+func sniffContentType(fh *multipart.FileHeader) (string, error) {
+	f, err := fh.Open()
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	mediaType, _, err := mime.ParseMediaType(http.DetectContentType(head[:n]))
+	if err != nil {
+		return "", err
+	}
+	return mediaType, nil
+}
+
+// storeAttachments stores the files in the blob store, under the keys it
+// sets on them. On failure, the files already stored are removed.
+func (app *application) storeAttachments(ctx context.Context, pending []pendingAttachment) error {
+	for i := range pending {
+		p := &pending[i]
+		key, err := blob.NewKey("attachments")
+		if err != nil {
+			app.deleteAttachments(ctx, pending[:i])
+			return err
+		}
+
+		f, err := p.header.Open()
+		if err != nil {
+			app.deleteAttachments(ctx, pending[:i])
+			return err
+		}
+		err = app.blobs.Put(ctx, key, f, p.header.Size, p.contentType)
+		f.Close()
+		if err != nil {
+			app.deleteAttachments(ctx, pending[:i])
+			return err
+		}
+		p.key = key
+	}
+	return nil
+}
+
+// recordAttachments records the stored files as attached to postID.
+func (app *application) recordAttachments(ctx context.Context, pending []pendingAttachment, postID, userID int) error {
+	for _, p := range pending {
+		_, err := app.attachments.InsertContext(ctx, postID, userID, p.filename, p.contentType, p.header.Size, p.key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteAttachments removes stored files from the blob store, even once the
+// request is cancelled. Failures are logged.
+func (app *application) deleteAttachments(ctx context.Context, pending []pendingAttachment) {
+	ctx = context.WithoutCancel(ctx)
+	for _, p := range pending {
+		err := app.blobs.Delete(ctx, p.key)
+		if err != nil {
+			logging.FromContext(ctx).Error(err.Error(), "key", p.key)
+		}
+	}
+}
+
+// attachmentView sends an attached file to an authenticated user. Images are
+// shown inline, every other type is downloaded.
+func (app *application) attachmentView(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		app.notFound(w, r)
+		return
+	}
+
+	attachment, err := app.attachments.GetContext(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	rc, err := app.blobs.Get(r.Context(), attachment.StorageKey)
+	if err != nil {
+		if errors.Is(err, blob.ErrNotFound) {
+			app.notFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	defer rc.Close()
+
+	disposition := "attachment"
+	if attachment.IsImage() {
+		disposition = "inline"
+	}
+	contentType := attachment.ContentType
+	if strings.HasPrefix(contentType, "text/") {
+		contentType += "; charset=utf-8"
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.FormatInt(attachment.Size, 10))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": attachment.Filename}))
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	// The headers are sent by then: a failure can only be logged.
+	_, err = io.Copy(w, rc)
+	if err != nil {
+		app.requestLogger(r).Error(err.Error(), "method", r.Method, "uri", r.URL.RequestURI(), "key", attachment.StorageKey)
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"forum/internal/avatar"
+	"forum/internal/models"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// avatarURL returns the URL of the avatar of a user at the given size. The
+// version is part of the URL so that it can be cached forever.
+func avatarURL(userID, version, size int) string {
+	return fmt.Sprintf("/avatar/%d/%d/%d.png", userID, version, size)
+}
+
+// avatarView serves an avatar, or the identicon of the user if they have not
+// uploaded one.
+func (app *application) avatarView(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || userID < 1 {
+		app.notFound(w, r)
+		return
+	}
+	version, err := strconv.Atoi(r.PathValue("version"))
+	if err != nil || version < 0 {
+		app.notFound(w, r)
+		return
+	}
+	size, err := strconv.Atoi(strings.TrimSuffix(r.PathValue("size"), ".png"))
+	if err != nil || !avatar.ValidSize(size) {
+		app.notFound(w, r)
+		return
+	}
+
+	// Both an uploaded avatar and the identicon of version 0 never change
+	// once served. The identicon served in place of a missing version may be
+	// replaced by the actual avatar, so it is not cached.
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("Content-Type", "image/png")
+
+	if version > 0 {
+		f, modtime, err := app.avatars.Open(userID, version, size)
+		if err == nil {
+			defer f.Close()
+			http.ServeContent(w, r, "", modtime, f)
+			return
+		}
+		if !errors.Is(err, avatar.ErrNotFound) {
+			app.serverError(w, r, err)
+			return
+		}
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+
+	identicon, err := avatar.Identicon(strconv.Itoa(userID), size)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(identicon))
+}
+
+// avatarUploadPOST replaces the avatar of the current user with the picture
+// in the multipart POST request.
+func (app *application) avatarUploadPOST(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, avatar.MaxBytes+64<<10)
+	err := r.ParseMultipartForm(avatar.MaxBytes)
+	if err != nil {
+		app.avatarError(w, r, "The picture must be an image of at most 2 MB")
+		return
+	}
+
+	file, _, err := r.FormFile("avatar")
+	if err != nil {
+		app.avatarError(w, r, "Please choose a picture to upload")
+		return
+	}
+	defer file.Close()
+
+	avatars, err := avatar.Process(file)
+	if err != nil {
+		switch {
+		case errors.Is(err, avatar.ErrUnsupportedType):
+			app.avatarError(w, r, "The picture must be a PNG, JPEG or GIF image")
+		case errors.Is(err, avatar.ErrTooLarge):
+			app.avatarError(w, r, "The picture must be at most 2 MB and 4096x4096 pixels")
+		default:
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	// The files of the new version are saved under a version of their own
+	// before the user is moved to it, so that the user never points to an
+	// avatar that does not exist, and the database is not held while they
+	// are written. If another change of avatar got there first, the upload
+	// is refused rather than undoing it.
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	profile, err := app.users.GetProfileByIDContext(r.Context(), userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	version := avatar.NewVersion()
+	err = app.avatars.Save(userID, version, avatars)
+	if err != nil {
+		if err := app.avatars.Remove(userID, version); err != nil {
+			app.requestLogger(r).Error(err.Error(), "method", r.Method, "uri", r.URL.RequestURI())
+		}
+		app.serverError(w, r, err)
+		return
+	}
+	err = app.users.SetAvatarVersionContext(r.Context(), userID, profile.AvatarVersion, version)
+	if err != nil {
+		// The user does not point to the new version, whose files are of
+		// no use.
+		if err := app.avatars.Remove(userID, version); err != nil {
+			app.requestLogger(r).Error(err.Error(), "method", r.Method, "uri", r.URL.RequestURI())
+		}
+		if errors.Is(err, models.ErrNoRecord) {
+			app.avatarError(w, r, "Your avatar was changed in the meantime, please try again")
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	err = app.avatars.Remove(userID, profile.AvatarVersion)
+	if err != nil {
+		app.requestLogger(r).Error(err.Error(), "method", r.Method, "uri", r.URL.RequestURI())
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Your avatar has been updated.")
+	http.Redirect(w, r, "/account/profile", http.StatusSeeOther)
+}
+
+// avatarDeletePOST removes the avatar of the current user, who falls back to
+// their identicon.
+func (app *application) avatarDeletePOST(w http.ResponseWriter, r *http.Request) {
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	profile, err := app.users.GetProfileByIDContext(r.Context(), userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	err = app.users.SetAvatarVersionContext(r.Context(), userID, profile.AvatarVersion, 0)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.avatarError(w, r, "Your avatar was changed in the meantime, please try again")
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	err = app.avatars.Remove(userID, profile.AvatarVersion)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Your avatar has been removed.")
+	http.Redirect(w, r, "/account/profile", http.StatusSeeOther)
+}
+
+// avatarError shows the profile settings page again with an error about the
+// uploaded avatar.
+func (app *application) avatarError(w http.ResponseWriter, r *http.Request, message string) {
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	profile, err := app.users.GetProfileByIDContext(r.Context(), userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	form := profileEditForm{
+		Bio:          profile.Bio,
+		Location:     profile.Location,
+		Website:      profile.Website,
+		HideActivity: profile.HideActivity,
+	}
+	form.AddFieldError("avatar", message)
+
+	data := app.newTemplateData(r)
+	data.Profile = profile
+	data.Form = form
+	app.render(w, r, http.StatusUnprocessableEntity, "profile-edit", data)
+}
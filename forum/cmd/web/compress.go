@@ -0,0 +1,175 @@
+package main
+
+import (
+	"compress/gzip"
+	"forum/internal/negotiate"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Content codings of compressed responses, by order of preference.
+const (
+	encodingZstd = "zstd"
+	encodingGzip = "gzip"
+)
+
+// compressMinBytes is the size under which responses of known length are
+// not worth compressing.
+const compressMinBytes = 512
+
+// compressibleTypes lists the media types of the responses compressed on the
+// fly. Static files are precompressed, and event streams must not be
+// buffered.
+var compressibleTypes = map[string]bool{
+	"text/html":            true,
+	"application/json":     true,
+	"application/atom+xml": true,
+	"application/rss+xml":  true,
+}
+
+var (
+	gzipWriters = sync.Pool{New: func() any {
+		w, _ := gzip.NewWriterLevel(io.Discard, gzip.DefaultCompression)
+		return w
+	}}
+	zstdWriters = sync.Pool{New: func() any {
+		w, _ := zstd.NewWriter(io.Discard, zstd.WithEncoderLevel(zstd.SpeedDefault), zstd.WithEncoderConcurrency(1))
+		return w
+	}}
+)
+
+// encoder is implemented by the gzip and zstd writers.
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(io.Writer)
+}
+
+// compress compresses HTML, JSON and feed responses with zstd or gzip, as
+// accepted by the client.
+func (app *application) compress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding := negotiate.Encoding(r.Header.Get("Accept-Encoding"), encodingZstd, encodingGzip)
+		if r.Method == http.MethodHead {
+			encoding = ""
+		}
+
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding}
+		defer cw.Close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// compressWriter compresses a response once its headers show it is worth
+// it. An empty encoding means the client accepts none.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding    string
+	wroteHeader bool
+	enc         encoder
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	if w.wroteHeader || status < http.StatusOK {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	w.wroteHeader = true
+
+	if w.compressible(status) {
+		w.Header().Add("Vary", "Accept-Encoding")
+	}
+	if w.encoding != "" && w.compressible(status) && !w.tooSmall() {
+		h := w.Header()
+		h.Set("Content-Encoding", w.encoding)
+		h.Del("Content-Length")
+		if etag := h.Get("ETag"); etag != "" && etag[0] == '"' {
+			// A strong validator names the exact bytes, which change.
+			h.Set("ETag", "W/"+etag)
+		}
+		w.enc = w.newEncoder()
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// compressible reports whether a response with the given status and the
+// current headers can be compressed.
+func (w *compressWriter) compressible(status int) bool {
+	h := w.Header()
+	if status == http.StatusNoContent || status == http.StatusNotModified || status == http.StatusPartialContent {
+		return false
+	}
+	if h.Get("Content-Encoding") != "" || h.Get("Content-Range") != "" {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(h.Get("Content-Type"))
+	return err == nil && compressibleTypes[mediaType]
+}
+
+// tooSmall reports whether the response has a known length too small to be
+// worth compressing.
+func (w *compressWriter) tooSmall() bool {
+	n, err := strconv.Atoi(w.Header().Get("Content-Length"))
+	return err == nil && n < compressMinBytes
+}
+
+func (w *compressWriter) newEncoder() encoder {
+	var enc encoder
+	switch w.encoding {
+	case encodingZstd:
+		enc = zstdWriters.Get().(*zstd.Encoder)
+	default:
+		enc = gzipWriters.Get().(*gzip.Writer)
+	}
+	enc.Reset(w.ResponseWriter)
+	return enc
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.enc == nil {
+		return w.ResponseWriter.Write(b)
+	}
+	return w.enc.Write(b)
+}
+
+// Flush sends the data compressed so far to the client.
+func (w *compressWriter) Flush() {
+	if w.enc != nil {
+		w.enc.Flush()
+	}
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Close completes the compressed stream and returns the encoder to its pool.
+func (w *compressWriter) Close() error {
+	if w.enc == nil {
+		return nil
+	}
+	err := w.enc.Close()
+	w.enc.Reset(io.Discard)
+	switch enc := w.enc.(type) {
+	case *zstd.Encoder:
+		zstdWriters.Put(enc)
+	case *gzip.Writer:
+		gzipWriters.Put(enc)
+	}
+	w.enc = nil
+	return err
+}
+
+// Unwrap lets http.ResponseController reach the Hijacker of the underlying
+// writer.
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
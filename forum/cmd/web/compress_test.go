@@ -0,0 +1,256 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"forum/internal/testutil"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// decode returns the body of a response decoded according to its
+// Content-Encoding.
+func decode(t *testing.T, resp *testutil.Response) string {
+	t.Helper()
+	var r io.Reader
+	var err error
+	switch resp.Header.Get("Content-Encoding") {
+	case "":
+		return resp.Body
+	case encodingGzip:
+		r, err = gzip.NewReader(strings.NewReader(resp.Body))
+	case encodingZstd:
+		var d *zstd.Decoder
+		d, err = zstd.NewReader(strings.NewReader(resp.Body))
+		if err == nil {
+			defer d.Close()
+		}
+		r = d
+	default:
+		t.Fatalf("unexpected encoding %q", resp.Header.Get("Content-Encoding"))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestCompress(t *testing.T) {
+	ta := newTestApp(t)
+	for _, tt := range []struct {
+		accept string
+		want   string
+	}{
+		{"gzip, zstd", encodingZstd},
+		{"gzip", encodingGzip},
+		{"zstd;q=0.5, gzip", encodingGzip},
+		{"br", ""},
+		{"", ""},
+	} {
+		req, err := http.NewRequest(http.MethodGet, ta.srv.URL+"/user/login", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Accept-Encoding", tt.accept)
+		resp := ta.srv.Do(t, req)
+		testutil.Equal(t, resp.Status, http.StatusOK)
+		testutil.Equal(t, resp.Header.Get("Content-Encoding"), tt.want)
+		testutil.Equal(t, slices.Contains(resp.Header.Values("Vary"), "Accept-Encoding"), true)
+		testutil.Contains(t, decode(t, resp), `action='/user/login'`)
+	}
+}
+
+func TestCompressWriter(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		header   http.Header
+		status   int
+		body     string
+		encoding string
+		etag     string
+	}{
+		{
+			name:     "HTML",
+			header:   http.Header{"Content-Type": {"text/html; charset=utf-8"}, "Etag": {`"abc"`}},
+			status:   http.StatusOK,
+			body:     strings.Repeat("<p>Hello</p>", 100),
+			encoding: encodingGzip,
+			etag:     `W/"abc"`,
+		},
+		{
+			name:   "small",
+			header: http.Header{"Content-Type": {"text/html; charset=utf-8"}, "Content-Length": {"11"}, "Etag": {`"abc"`}},
+			status: http.StatusOK,
+			body:   "<p>Hi</p>\n",
+			etag:   `"abc"`,
+		},
+		{
+			name:   "image",
+			header: http.Header{"Content-Type": {"image/png"}},
+			status: http.StatusOK,
+			body:   strings.Repeat("\x89PNG", 200),
+		},
+		{
+			name:   "already encoded",
+			header: http.Header{"Content-Type": {"text/html"}, "Content-Encoding": {encodingGzip}},
+			status: http.StatusOK,
+			body:   strings.Repeat("x", 1000),
+		},
+		{
+			name:   "not modified",
+			header: http.Header{"Content-Type": {"text/html"}, "Etag": {`W/"abc"`}},
+			status: http.StatusNotModified,
+			etag:   `W/"abc"`,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				for k, v := range tt.header {
+					w.Header()[k] = v
+				}
+				w.WriteHeader(tt.status)
+				io.WriteString(w, tt.body)
+			})
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Accept-Encoding", "gzip")
+			rec := httptest.NewRecorder()
+			(&application{}).compress(h).ServeHTTP(rec, r)
+
+			resp := &testutil.Response{Status: rec.Code, Header: rec.Header(), Body: rec.Body.String()}
+			testutil.Equal(t, resp.Status, tt.status)
+			testutil.Equal(t, resp.Header.Get("Content-Encoding"), tt.header.Get("Content-Encoding")+tt.encoding)
+			testutil.Equal(t, resp.Header.Get("ETag"), tt.etag)
+			if tt.encoding != "" {
+				testutil.Equal(t, resp.Header.Get("Content-Length"), "")
+				testutil.Equal(t, decode(t, resp), tt.body)
+			} else {
+				testutil.Equal(t, resp.Body, tt.body)
+			}
+		})
+	}
+}
+
+func TestCompressEventStream(t *testing.T) {
+	ta := newTestApp(t)
+	ta.signup(t, ta.srv, "alice")
+	id := ta.createThread(t, ta.srv, "Events")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ta.srv.URL+"/thread/view/"+strconv.Itoa(id)+"/events?after=0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept-Encoding", "gzip")
+	req.Header.Set("Cookie", "session="+ta.srv.Cookie(t, "session"))
+	resp, err := ta.srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	testutil.Equal(t, resp.Header.Get("Content-Type"), "text/event-stream")
+	testutil.Equal(t, resp.Header.Get("Content-Encoding"), "")
+
+	// Events are sent as they come rather than buffered by an encoder.
+	b := make([]byte, len("retry: 3000\n\n"))
+	_, err = io.ReadFull(resp.Body, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.Equal(t, string(b), "retry: 3000\n\n")
+}
+
+func TestConditionalGet(t *testing.T) {
+	ta := newTestApp(t)
+	for _, path := range []string{"/", "/user/login"} {
+		resp := ta.srv.Get(t, path)
+		testutil.Equal(t, resp.Status, http.StatusOK)
+		etag := resp.Header.Get("ETag")
+		if !strings.HasPrefix(etag, `W/"`) {
+			t.Fatalf("%s: ETag = %q; want a weak one", path, etag)
+		}
+
+		for _, tt := range []struct {
+			ifNoneMatch string
+			want        int
+		}{
+			{etag, http.StatusNotModified},
+			{strings.TrimPrefix(etag, "W/"), http.StatusNotModified},
+			{`W/"other", ` + etag, http.StatusNotModified},
+			{"*", http.StatusNotModified},
+			{`W/"other"`, http.StatusOK},
+		} {
+			req, err := http.NewRequest(http.MethodGet, ta.srv.URL+path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("If-None-Match", tt.ifNoneMatch)
+			resp := ta.srv.Do(t, req)
+			testutil.Equal(t, resp.Status, tt.want)
+			if tt.want == http.StatusNotModified {
+				testutil.Equal(t, resp.Body, "")
+				testutil.Equal(t, resp.Header.Get("ETag"), etag)
+			}
+		}
+	}
+
+	// A change to the page changes its ETag.
+	resp := ta.srv.Get(t, "/")
+	etag := resp.Header.Get("ETag")
+	ta.signup(t, ta.srv, "alice")
+	ta.createThread(t, ta.srv, "Something new")
+	req, err := http.NewRequest(http.MethodGet, ta.srv.URL+"/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("If-None-Match", etag)
+	resp = ta.srv.Do(t, req)
+	testutil.Equal(t, resp.Status, http.StatusOK)
+	testutil.Contains(t, resp.Body, "Something new")
+}
+
+func TestFeedLastModified(t *testing.T) {
+	ta := newTestApp(t)
+	ta.signup(t, ta.srv, "alice")
+	id := ta.createThread(t, ta.srv, "Tips")
+	path := "/feed/atom/thread/" + strconv.Itoa(id)
+
+	anonymous := ta.srv.NewSession(t)
+	resp := anonymous.Get(t, path)
+	testutil.Equal(t, resp.Status, http.StatusOK)
+	lastModified := resp.Header.Get("Last-Modified")
+	modified, err := http.ParseTime(lastModified)
+	if err != nil {
+		t.Fatalf("Last-Modified = %q: %v", lastModified, err)
+	}
+
+	for _, tt := range []struct {
+		since time.Time
+		want  int
+	}{
+		{modified, http.StatusNotModified},
+		{modified.Add(time.Hour), http.StatusNotModified},
+		{modified.Add(-time.Second), http.StatusOK},
+	} {
+		req, err := http.NewRequest(http.MethodGet, anonymous.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("If-Modified-Since", tt.since.UTC().Format(http.TimeFormat))
+		resp := anonymous.Do(t, req)
+		testutil.Equal(t, resp.Status, tt.want)
+	}
+}
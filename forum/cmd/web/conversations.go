@@ -0,0 +1,373 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"forum/internal/models"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"forum/internal/validator"
+)
+
+type conversationCreateForm struct {
+	To      string
+	Subject string
+	Body    string
+	validator.Validator
+}
+
+type conversationReplyForm struct {
+	Body string
+	validator.Validator
+}
+
+type userBlockForm struct {
+	Username string
+	validator.Validator
+}
+
+// conversationInbox shows the conversations of the current user.
+func (app *application) conversationInbox(w http.ResponseWriter, r *http.Request) {
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	archived := r.URL.Query().Get("archived") == "1"
+
+	conversations, err := app.conversations.InboxContext(r.Context(), userID, archived)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	blocked, err := app.blocks.BlockedContext(r.Context(), userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Conversations = conversations
+	data.ShowArchived = archived
+	data.Blocked = blocked
+	data.Form = userBlockForm{}
+	app.render(w, r, http.StatusOK, "conversation-inbox", data)
+}
+
+// conversationCreate shows a form to start a conversation.
+func (app *application) conversationCreate(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Form = conversationCreateForm{To: r.URL.Query().Get("to")}
+	app.render(w, r, http.StatusOK, "conversation-create", data)
+}
+
+// conversationCreatePOST starts a conversation with the info in the POST
+// request.
+func (app *application) conversationCreatePOST(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	form := conversationCreateForm{
+		To:      r.PostForm.Get("to"),
+		Subject: r.PostForm.Get("subject"),
+		Body:    r.PostForm.Get("body"),
+	}
+
+	form.CheckField(validator.NotBlank(form.To), "to", "This field cannot be blank")
+	form.CheckField(validator.NotBlank(form.Subject), "subject", "This field cannot be blank")
+	form.CheckField(validator.MaxChars(form.Subject, app.cfg.Limits.TitleMaxChars), "subject", tooLong(app.cfg.Limits.TitleMaxChars))
+	form.CheckField(validator.NotBlank(form.Body), "body", "This field cannot be blank")
+	form.CheckField(validator.MaxChars(form.Body, app.cfg.Limits.BodyMaxChars), "body", tooLong(app.cfg.Limits.BodyMaxChars))
+
+	authorID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	recipientIDs, err := app.resolveRecipients(r.Context(), &form, authorID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "conversation-create", data)
+		return
+	}
+
+	id, err := app.conversations.InsertContext(r.Context(), form.Subject, form.Body, authorID, recipientIDs)
+	if err != nil {
+		if errors.Is(err, models.ErrBlocked) {
+			form.AddFieldError("to", "You cannot start a conversation with one of these users")
+			data := app.newTemplateData(r)
+			data.Form = form
+			app.render(w, r, http.StatusUnprocessableEntity, "conversation-create", data)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Conversation successfully started!")
+	http.Redirect(w, r, fmt.Sprintf("/conversation/view/%d", id), http.StatusSeeOther)
+}
+
+// resolveRecipients looks up the comma-separated usernames of form.To and
+// returns their ids. Unknown usernames and other problems are reported as
+// field errors on the form.
+func (app *application) resolveRecipients(ctx context.Context, form *conversationCreateForm, authorID int) ([]int, error) {
+	var ids []int
+	seen := map[int]bool{authorID: true}
+	for _, name := range strings.Split(form.To, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		user, err := app.users.GetByUsernameContext(ctx, name)
+		if err != nil {
+			if errors.Is(err, models.ErrNoRecord) {
+				form.AddFieldError("to", fmt.Sprintf("There is no user named %q", name))
+				continue
+			}
+			return nil, err
+		}
+		if seen[user.ID] {
+			continue
+		}
+		seen[user.ID] = true
+		ids = append(ids, user.ID)
+	}
+
+	if len(ids) == 0 {
+		form.AddFieldError("to", "Add at least one other member")
+	}
+	form.CheckField(len(ids) <= app.cfg.Limits.MaxRecipients, "to",
+		fmt.Sprintf("A conversation cannot have more than %d recipients", app.cfg.Limits.MaxRecipients))
+	return ids, nil
+}
+
+// conversationView shows a conversation and marks it as read.
+func (app *application) conversationView(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		app.notFound(w, r)
+		return
+	}
+
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	conversation, err := app.conversations.GetContext(r.Context(), id, userID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	if n := len(conversation.Messages); n > 0 {
+		err = app.conversations.MarkReadContext(r.Context(), id, userID, conversation.Messages[n-1].ID)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+
+	data := app.newTemplateData(r)
+	data.Conversation = conversation
+	data.Form = conversationReplyForm{}
+	app.render(w, r, http.StatusOK, "conversation-view", data)
+}
+
+// conversationReplyPOST adds a message to a conversation with the info in the
+// POST request.
+func (app *application) conversationReplyPOST(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		app.notFound(w, r)
+		return
+	}
+
+	form := conversationReplyForm{
+		Body: r.PostForm.Get("body"),
+	}
+
+	form.CheckField(validator.NotBlank(form.Body), "body", "This field cannot be blank")
+	form.CheckField(validator.MaxChars(form.Body, app.cfg.Limits.BodyMaxChars), "body", tooLong(app.cfg.Limits.BodyMaxChars))
+
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	if form.Valid() {
+		_, err = app.conversations.ReplyContext(r.Context(), id, userID, form.Body)
+		switch {
+		case errors.Is(err, models.ErrNoRecord):
+			app.notFound(w, r)
+			return
+		case errors.Is(err, models.ErrBlocked):
+			form.AddNonFieldError("You can no longer reply to this conversation")
+		case err != nil:
+			app.serverError(w, r, err)
+			return
+		}
+	}
+
+	if !form.Valid() {
+		conversation, err := app.conversations.GetContext(r.Context(), id, userID)
+		if err != nil {
+			if errors.Is(err, models.ErrNoRecord) {
+				app.notFound(w, r)
+			} else {
+				app.serverError(w, r, err)
+			}
+			return
+		}
+		data := app.newTemplateData(r)
+		data.Conversation = conversation
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "conversation-view", data)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/conversation/view/%d", id), http.StatusSeeOther)
+}
+
+// conversationArchivePOST moves a conversation in or out of the archive of
+// the current user.
+func (app *application) conversationArchivePOST(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		app.notFound(w, r)
+		return
+	}
+
+	archived := r.PostForm.Get("archived") == "1"
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	err = app.conversations.SetArchivedContext(r.Context(), id, userID, archived)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	if archived {
+		app.sessionManager.Put(r.Context(), "flash", "Conversation archived.")
+	} else {
+		app.sessionManager.Put(r.Context(), "flash", "Conversation moved back to your inbox.")
+	}
+	http.Redirect(w, r, "/conversation/inbox", http.StatusSeeOther)
+}
+
+// conversationLeavePOST removes the current user from a conversation.
+func (app *application) conversationLeavePOST(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		app.notFound(w, r)
+		return
+	}
+
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	err = app.conversations.LeaveContext(r.Context(), id, userID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "You left the conversation.")
+	http.Redirect(w, r, "/conversation/inbox", http.StatusSeeOther)
+}
+
+// userBlockPOST blocks the user named in the POST request for the current
+// user.
+func (app *application) userBlockPOST(w http.ResponseWriter, r *http.Request) {
+	app.updateBlock(w, r, true)
+}
+
+// userUnblockPOST lifts the block on the user named in the POST request.
+func (app *application) userUnblockPOST(w http.ResponseWriter, r *http.Request) {
+	app.updateBlock(w, r, false)
+}
+
+// updateBlock blocks or unblocks the user named in the POST request, then
+// redirects to the inbox.
+func (app *application) updateBlock(w http.ResponseWriter, r *http.Request, block bool) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	form := userBlockForm{
+		Username: strings.TrimSpace(r.PostForm.Get("username")),
+	}
+	form.CheckField(validator.NotBlank(form.Username), "username", "This field cannot be blank")
+
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	var blocked *models.User
+	if form.Valid() {
+		blocked, err = app.users.GetByUsernameContext(r.Context(), form.Username)
+		if err != nil {
+			if !errors.Is(err, models.ErrNoRecord) {
+				app.serverError(w, r, err)
+				return
+			}
+			form.AddFieldError("username", fmt.Sprintf("There is no user named %q", form.Username))
+		} else if blocked.ID == userID {
+			form.AddFieldError("username", "You cannot block yourself")
+		}
+	}
+
+	if !form.Valid() {
+		conversations, err := app.conversations.InboxContext(r.Context(), userID, false)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		blockedUsers, err := app.blocks.BlockedContext(r.Context(), userID)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		data := app.newTemplateData(r)
+		data.Conversations = conversations
+		data.Blocked = blockedUsers
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "conversation-inbox", data)
+		return
+	}
+
+	if block {
+		err = app.blocks.InsertContext(r.Context(), userID, blocked.ID)
+	} else {
+		err = app.blocks.DeleteContext(r.Context(), userID, blocked.ID)
+	}
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	if block {
+		app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("%s has been blocked.", blocked.Username))
+	} else {
+		app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("%s has been unblocked.", blocked.Username))
+	}
+	http.Redirect(w, r, "/conversation/inbox", http.StatusSeeOther)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"time"
+)
+
+// errorPage describes an error shown to the user.
+type errorPage struct {
+	Status  int
+	Title   string
+	Message string
+}
+
+// errorMessages explains the statuses users may run into. Other statuses are
+// shown with their standard text only.
+var errorMessages = map[int]string{
+	http.StatusBadRequest:            "The request could not be understood. Please check the address or the form you sent.",
+	http.StatusForbidden:             "You do not have permission to see this page.",
+	http.StatusNotFound:              "The page you are looking for does not exist or has been removed.",
+	http.StatusMethodNotAllowed:      "This page cannot be used that way.",
+	http.StatusRequestEntityTooLarge: "What you sent is too large.",
+	http.StatusTooManyRequests:       "You are doing that too often. Please wait a moment and try again.",
+	http.StatusInternalServerError:   "Something went wrong on our side. Please try again later.",
+}
+
+// renderError sends the error page for status. It does not rely on the
+// database, which may be the cause of the error, and only uses the session
+// when the route loaded it.
+func (app *application) renderError(w http.ResponseWriter, r *http.Request, status int) {
+	data := templateData{
+		CurrentYear: time.Now().Year(),
+		Feeds:       []feedLink{siteFeedLink},
+		RequestID:   requestID(r),
+		Error: &errorPage{
+			Status:  status,
+			Title:   http.StatusText(status),
+			Message: errorMessages[status],
+		},
+	}
+	if sessionLoaded(r) {
+		data.IsAuthenticated = app.isAuthenticated(r)
+	}
+	data.Online, data.OnlineCount = app.onlineSummary()
+
+	buf := new(bytes.Buffer)
+	ts, err := app.template("error")
+	if err == nil {
+		err = ts.ExecuteTemplate(buf, "base", data)
+	}
+	if err != nil {
+		app.requestLogger(r).Error(err.Error(), "method", r.Method, "uri", r.URL.RequestURI())
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	buf.WriteTo(w)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"forum/internal/models"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// eventsHeartbeat is how often a comment is sent on idle event streams,
+	// so that proxies do not close them.
+	eventsHeartbeat = 25 * time.Second
+
+	// eventsWriteTimeout bounds each write to an event stream. A client
+	// that cannot keep up is disconnected and resumes from its last event.
+	eventsWriteTimeout = 10 * time.Second
+
+	// eventsBatchSize is the number of posts loaded at once when catching
+	// up a stream.
+	eventsBatchSize = 20
+)
+
+// threadEvents streams the posts added to a thread as Server-Sent Events,
+// each carrying the rendered post. A reconnecting client resumes after the
+// ID in its Last-Event-ID header, a new one after the "after" query
+// parameter.
+func (app *application) threadEvents(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		app.notFound(w, r)
+		return
+	}
+
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("after")
+	}
+	after, err := strconv.Atoi(lastID)
+	if err != nil || after < 0 {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	exists, err := app.threads.ExistsContext(r.Context(), id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if !exists {
+		app.notFound(w, r)
+		return
+	}
+
+	// Subscribe before catching up, so that no post is missed in between.
+	sub := app.hub.Subscribe(models.ThreadTopic(id))
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	stream := &eventStream{w: w, rc: http.NewResponseController(w)}
+	err = stream.write("retry: 3000\n\n")
+	if err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(eventsHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		after, err = app.sendPosts(r.Context(), stream, id, after)
+		if err != nil {
+			if !stream.failed {
+				app.requestLogger(r).Error(err.Error(), "method", r.Method, "uri", r.URL.RequestURI())
+			}
+			return
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-app.shutdown:
+			return
+		case _, ok := <-sub.Events():
+			if !ok {
+				// Dropped for falling behind: the client reconnects and
+				// catches up from its last event.
+				return
+			}
+		case <-heartbeat.C:
+			err = stream.write(": ping\n\n")
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// sendPosts sends the posts of a thread with an ID greater than after, and
+// returns the ID of the last post sent.
+func (app *application) sendPosts(ctx context.Context, stream *eventStream, threadID, after int) (int, error) {
+	for {
+		posts, err := app.posts.SinceContext(ctx, threadID, after, eventsBatchSize)
+		if err != nil {
+			return after, err
+		}
+		err = app.attachments.ForPostsContext(ctx, posts)
+		if err != nil {
+			return after, err
+		}
+
+		for _, p := range posts {
+			html, err := app.renderPartial("thread-view", "post", p)
+			if err != nil {
+				return after, err
+			}
+			err = stream.send(strconv.Itoa(p.ID), models.EventPostCreated, html)
+			if err != nil {
+				return after, err
+			}
+			after = p.ID
+		}
+
+		if len(posts) < eventsBatchSize {
+			return after, nil
+		}
+	}
+}
+
+// eventStream writes Server-Sent Events to a client.
+type eventStream struct {
+	w      http.ResponseWriter
+	rc     *http.ResponseController
+	failed bool
+}
+
+// send writes an event with the given ID, type and data.
+func (s *eventStream) send(id, event string, data []byte) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "id: %s\nevent: %s\n", id, event)
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		fmt.Fprintf(&buf, "data: %s\n", strings.TrimRight(line, "\r"))
+	}
+	buf.WriteByte('\n')
+	return s.write(buf.String())
+}
+
+// write sends raw stream content and flushes it to the client.
+func (s *eventStream) write(content string) error {
+	err := s.rc.SetWriteDeadline(time.Now().Add(eventsWriteTimeout))
+	if err != nil && !errors.Is(err, http.ErrNotSupported) {
+		s.failed = true
+		return err
+	}
+	_, err = s.w.Write([]byte(content))
+	if err == nil {
+		err = s.rc.Flush()
+	}
+	if err != nil {
+		s.failed = true
+	}
+	return err
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"forum/internal/feed"
+	"forum/internal/models"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// feedLink describes a feed advertised in the head of a page. Path is
+// appended to /feed/<format>.
+type feedLink struct {
+	Title string
+	Path  string
+}
+
+// siteFeedLink is the feed of the latest threads, advertised on every page.
+var siteFeedLink = feedLink{Title: "Latest threads", Path: ""}
+
+// absoluteURL returns the absolute URL of path under the configured base URL
+// of the forum. The Host header of requests is chosen by clients, and the
+// scheme they used is unknown behind a proxy terminating TLS.
+func (app *application) absoluteURL(path string) string {
+	return strings.TrimSuffix(app.cfg.Server.BaseURL, "/") + path
+}
+
+// feedFormat returns the format named in the path of r, or false if it is not
+// supported.
+func feedFormat(r *http.Request) (string, bool) {
+	format := r.PathValue("format")
+	_, ok := feed.Formats[format]
+	return format, ok
+}
+
+// siteFeed serves the feed of the latest threads.
+func (app *application) siteFeed(w http.ResponseWriter, r *http.Request) {
+	format, ok := feedFormat(r)
+	if !ok {
+		app.notFound(w, r)
+		return
+	}
+
+	threads, err := app.threads.NewestContext(r.Context(), app.cfg.Limits.FeedSize)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	f := &feed.Feed{
+		Title:       "ForumNova Community — latest threads",
+		Description: "The latest threads started on ForumNova Community.",
+		Link:        app.absoluteURL("/"),
+		Self:        app.absoluteURL(r.URL.Path),
+	}
+	for _, t := range threads {
+		f.Items = append(f.Items, feed.Item{
+			Title:     t.Title,
+			Link:      app.absoluteURL(fmt.Sprintf("/thread/view/%d", t.ID)),
+			Author:    t.Author.Username,
+			Content:   fmt.Sprintf("New thread started by %s.", t.Author.Username),
+			Published: t.Created,
+			Updated:   t.Created,
+		})
+		if t.Created.After(f.Updated) {
+			f.Updated = t.Created
+		}
+	}
+	app.writeFeed(w, r, f, format)
+}
+
+// threadFeed serves the feed of the posts of a thread. Like the other feeds it
+// is public, so it announces each reply without its body, which only
+// signed-in users can read.
+func (app *application) threadFeed(w http.ResponseWriter, r *http.Request) {
+	format, ok := feedFormat(r)
+	if !ok {
+		app.notFound(w, r)
+		return
+	}
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		app.notFound(w, r)
+		return
+	}
+
+	thread, err := app.threads.GetContext(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	link := fmt.Sprintf("/thread/view/%d", thread.ID)
+	f := &feed.Feed{
+		Title:       thread.Title + " — ForumNova Community",
+		Description: fmt.Sprintf("Replies to %q, started by %s.", thread.Title, thread.Author.Username),
+		Link:        app.absoluteURL(link),
+		Self:        app.absoluteURL(r.URL.Path),
+		Updated:     thread.Created,
+	}
+	posts := thread.Posts
+	for i := len(posts) - 1; i >= 0 && len(f.Items) < app.cfg.Limits.FeedSize; i-- {
+		p := posts[i]
+		f.Items = append(f.Items, feed.Item{
+			Title:     fmt.Sprintf("%s replied to %s", p.Author.Username, thread.Title),
+			Link:      app.absoluteURL(fmt.Sprintf("%s#post-%d", link, p.ID)),
+			Author:    p.Author.Username,
+			Content:   fmt.Sprintf("%s replied to %s.", p.Author.Username, thread.Title),
+			Published: p.Created,
+			Updated:   p.Created,
+		})
+		if p.Created.After(f.Updated) {
+			f.Updated = p.Created
+		}
+	}
+	app.writeFeed(w, r, f, format)
+}
+
+// userFeed serves the feed of the activity of a user, unless they chose to
+// hide it. As on their profile, replies come without their body.
+func (app *application) userFeed(w http.ResponseWriter, r *http.Request) {
+	format, ok := feedFormat(r)
+	if !ok {
+		app.notFound(w, r)
+		return
+	}
+
+	profile, err := app.users.GetProfileContext(r.Context(), r.PathValue("username"))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	if profile.HideActivity {
+		app.notFound(w, r)
+		return
+	}
+
+	activity, err := app.users.ActivityContext(r.Context(), profile.ID, app.cfg.Limits.FeedSize, 0)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	f := &feed.Feed{
+		Title:       profile.Username + " — ForumNova Community",
+		Description: fmt.Sprintf("Threads and posts by %s.", profile.Username),
+		Link:        app.absoluteURL("/u/" + url.PathEscape(profile.Username)),
+		Self:        app.absoluteURL(r.URL.Path),
+		Updated:     profile.Joined,
+	}
+	for _, a := range activity {
+		item := feed.Item{
+			Author:    profile.Username,
+			Published: a.Created,
+			Updated:   a.Created,
+		}
+		if a.IsPost() {
+			item.Title = "Reply in " + a.ThreadTitle
+			item.Link = app.absoluteURL(fmt.Sprintf("/thread/view/%d#post-%d", a.ThreadID, a.PostID))
+		} else {
+			item.Title = "New thread: " + a.ThreadTitle
+			item.Link = app.absoluteURL(fmt.Sprintf("/thread/view/%d", a.ThreadID))
+		}
+		f.Items = append(f.Items, item)
+		if a.Created.After(f.Updated) {
+			f.Updated = a.Created
+		}
+	}
+	app.writeFeed(w, r, f, format)
+}
+
+// writeFeed renders the feed and sends it with an ETag and a Last-Modified
+// header, answering conditional requests with 304 Not Modified.
+func (app *application) writeFeed(w http.ResponseWriter, r *http.Request, f *feed.Feed, format string) {
+	if f.Updated.IsZero() {
+		f.Updated = time.Now()
+	}
+
+	buf := new(bytes.Buffer)
+	err := f.Write(buf, format)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	sum := sha256.Sum256(buf.Bytes())
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+	w.Header().Set("Content-Type", feed.Formats[format])
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	http.ServeContent(w, r, "", f.Updated, bytes.NewReader(buf.Bytes()))
+}
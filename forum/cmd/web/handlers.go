@@ -1,13 +1,18 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"forum/internal/models"
+	"mime/multipart"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
 
 	"forum/internal/validator"
+	"forum/internal/webhook"
 )
 
 type accountCreateForm struct {
@@ -36,13 +41,22 @@ type messageCreateForm struct {
 	validator.Validator
 }
 
-// home shows the 10 latest threads.
+// home shows the threads with the most recent activity.
 func (app *application) home(w http.ResponseWriter, r *http.Request) {
-	threads, err := app.threads.Latests()
+	threads, err := app.threads.LatestsContext(r.Context(), app.cfg.Limits.HomeThreads)
 	if err != nil {
 		app.serverError(w, r, err)
 		return
 	}
+	if app.isAuthenticated(r) {
+		userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+		err = app.reads.FlagUnreadContext(r.Context(), userID, threads)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+
 	data := app.newTemplateData(r)
 	data.Threads = threads
 	app.render(w, r, http.StatusOK, "home", data)
@@ -82,7 +96,7 @@ func (app *application) accountCreatePOST(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	isEailExists, _ := app.users.Exists(form.Email)
+	isEailExists, _ := app.users.ExistsContext(r.Context(), form.Email)
 	if isEailExists {
 		data := app.newTemplateData(r)
 		form.AddFieldError("email", "Sorry, this email is aready in use, Plese try another. ")
@@ -91,35 +105,83 @@ func (app *application) accountCreatePOST(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	id, err := app.users.Insert(form.Username, form.Email, form.Password)
+	isUsernameExists, err := app.users.UsernameExistsContext(r.Context(), form.Username)
 	if err != nil {
 		app.serverError(w, r, err)
 		return
 	}
+	if isUsernameExists {
+		data := app.newTemplateData(r)
+		form.AddFieldError("username", "Sorry, this username is already taken, please try another.")
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "account-create", data)
+		return
+	}
+
+	var id, queued int
+	err = app.db.WithTx(r.Context(), func(ctx context.Context) error {
+		var err error
+		id, err = app.users.InsertContext(ctx, form.Username, form.Email, form.Password)
+		if err != nil {
+			return err
+		}
+		queued, err = app.emit(ctx, webhook.EventUserCreated, userEvent{
+			eventUser: eventUser{ID: id, Username: form.Username},
+			URL:       app.absoluteURL("/u/" + url.PathEscape(form.Username)),
+		})
+		return err
+	})
+	if err != nil {
+		// Another signup may have taken the email or username since they
+		// were checked.
+		switch {
+		case errors.Is(err, models.ErrDuplicateEmail):
+			form.AddFieldError("email", "Sorry, this email is aready in use, Plese try another. ")
+		case errors.Is(err, models.ErrDuplicateUsername):
+			form.AddFieldError("username", "Sorry, this username is already taken, please try another.")
+		default:
+			app.serverError(w, r, err)
+			return
+		}
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "account-create", data)
+		return
+	}
+	app.metrics.signups.Inc()
+	if queued > 0 {
+		app.dispatcher.Notify()
+	}
 
 	app.sessionManager.Put(r.Context(), "authenticatedUserID", id)
 	app.sessionManager.Put(r.Context(), "flash", " Your signup was successful.")
 	http.Redirect(w, r, fmt.Sprintf("/account/view/%d", id), http.StatusSeeOther)
 }
 
-// accountView shows information about an account.
+// accountView shows information about the account of the current user. Other
+// accounts are redirected to their public profile.
 func (app *application) accountView(w http.ResponseWriter, r *http.Request) {
 	id, err := strconv.Atoi(r.PathValue("id"))
 	if err != nil || id < 1 {
-		http.NotFound(w, r)
+		app.notFound(w, r)
 		return
 	}
 
-	user, err := app.users.Get(id)
+	user, err := app.users.GetContext(r.Context(), id)
 	if err != nil {
 		if errors.Is(err, models.ErrNoRecord) {
-			http.NotFound(w, r)
+			app.notFound(w, r)
 		} else {
 			app.serverError(w, r, err)
 		}
 		return
 	}
 
+	if user.ID != app.sessionManager.GetInt(r.Context(), "authenticatedUserID") {
+		http.Redirect(w, r, "/u/"+url.PathEscape(user.Username), http.StatusSeeOther)
+		return
+	}
+
 	flash := app.sessionManager.PopString(r.Context(), "flash")
 	data := app.newTemplateData(r)
 	data.User = user
@@ -147,7 +209,7 @@ func (app *application) threadCreatePOST(w http.ResponseWriter, r *http.Request)
 	}
 
 	form.CheckField(validator.NotBlank(form.Title), "title", "This field cannot be blank")
-	form.CheckField(validator.MaxChars(form.Title, 100), "title", "This field cannot be more than 100 characters long")
+	form.CheckField(validator.MaxChars(form.Title, app.cfg.Limits.TitleMaxChars), "title", tooLong(app.cfg.Limits.TitleMaxChars))
 
 	if !form.Valid() {
 		data := app.newTemplateData(r)
@@ -157,47 +219,158 @@ func (app *application) threadCreatePOST(w http.ResponseWriter, r *http.Request)
 	}
 
 	authorID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
-	id, err := app.threads.Insert(form.Title, authorID)
+	var id, queued int
+	err = app.db.WithTx(r.Context(), func(ctx context.Context) error {
+		var err error
+		id, err = app.threads.InsertContext(ctx, form.Title, authorID)
+		if err != nil {
+			return err
+		}
+		err = app.reads.MarkReadContext(ctx, authorID, id, 0)
+		if err != nil {
+			return err
+		}
+		queued, err = app.emit(ctx, webhook.EventThreadCreated, threadEvent{
+			ID:     id,
+			Title:  form.Title,
+			URL:    app.absoluteURL(fmt.Sprintf("/thread/view/%d", id)),
+			Author: eventAuthor(r),
+		})
+		return err
+	})
 	if err != nil {
 		app.serverError(w, r, err)
 		return
 	}
+	app.metrics.threadsCreated.Inc()
+	if queued > 0 {
+		app.dispatcher.Notify()
+	}
 
 	app.sessionManager.Put(r.Context(), "flash", "Thread successfully created!")
 	http.Redirect(w, r, fmt.Sprintf("/thread/view/%d", id), http.StatusSeeOther)
 }
 
-// threadView shows a thread.
+// threadView shows a thread. Threads are only shown to members, on pages
+// that differ for each of them, so they carry no Last-Modified header: the
+// time of the latest post misses the unread markers and members online that
+// change in between. They are revalidated with the ETag of the rendered page.
 func (app *application) threadView(w http.ResponseWriter, r *http.Request) {
 	idSegment := r.PathValue("id")
 	id, err := strconv.Atoi(idSegment)
 	if err != nil || id < 1 {
-		http.NotFound(w, r)
+		app.notFound(w, r)
 		return
 	}
 
-	thread, err := app.threads.Get(id)
+	thread, err := app.threads.GetContext(r.Context(), id)
 	if err != nil {
 		if errors.Is(err, models.ErrNoRecord) {
-			http.NotFound(w, r)
+			app.notFound(w, r)
 		} else {
 			app.serverError(w, r, err)
 		}
 		return
 	}
 
+	err = app.attachments.ForPostsContext(r.Context(), thread.Posts)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	err = app.reads.MarkReadContext(r.Context(), userID, thread.ID, thread.LastPostID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
 	data := app.newTemplateData(r)
 	data.Thread = thread
-	fmt.Printf("%+v\n", data.Thread)
+	data.Feeds = append(data.Feeds, feedLink{
+		Title: "Replies to " + thread.Title,
+		Path:  fmt.Sprintf("/thread/%d", thread.ID),
+	})
 	app.render(w, r, http.StatusOK, "thread-view", data)
 }
 
+// threadUnread redirects to the first post of a thread the user has not read
+// yet, or to the top of the thread if everything has been read.
+func (app *application) threadUnread(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		app.notFound(w, r)
+		return
+	}
+
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	postID, err := app.reads.FirstUnreadContext(r.Context(), userID, id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.Redirect(w, r, fmt.Sprintf("/thread/view/%d", id), http.StatusSeeOther)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/thread/view/%d#post-%d", id, postID), http.StatusSeeOther)
+}
+
+// threadReadAllPOST marks every thread as read for the current user.
+func (app *application) threadReadAllPOST(w http.ResponseWriter, r *http.Request) {
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	err := app.reads.MarkAllReadContext(r.Context(), userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "All threads marked as read.")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// postSearch shows a page of the posts containing every word searched for
+// in the q query parameter, newest first.
+func (app *application) postSearch(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	page := 1
+	if p := query.Get("page"); p != "" {
+		var err error
+		page, err = strconv.Atoi(p)
+		if err != nil || page < 1 {
+			app.notFound(w, r)
+			return
+		}
+	}
+
+	data := app.newTemplateData(r)
+	data.Search = strings.TrimSpace(query.Get("q"))
+	data.Page = page
+
+	pageSize := app.cfg.Limits.SearchPageSize
+	results, err := app.posts.SearchContext(r.Context(), data.Search, pageSize+1, (page-1)*pageSize)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if len(results) > pageSize {
+		results = results[:pageSize]
+		data.NextPage = page + 1
+	}
+	data.SearchResults = results
+	data.PrevPage = page - 1
+
+	app.render(w, r, http.StatusOK, "search", data)
+}
+
 // postCreate shows a form to create a message.
 func (app *application) postCreate(w http.ResponseWriter, r *http.Request) {
 	idSegment := r.PathValue("id")
 	id, err := strconv.Atoi(idSegment)
 	if err != nil || id < 1 {
-		http.NotFound(w, r)
+		app.notFound(w, r)
 		return
 	}
 
@@ -207,18 +380,23 @@ func (app *application) postCreate(w http.ResponseWriter, r *http.Request) {
 	app.render(w, r, http.StatusOK, "message-create", data)
 }
 
-// postCreatePOST creates a message with the info in the POST request.
+// postCreatePOST creates a message with the info in the POST request, which
+// may be multipart to carry attachments.
 func (app *application) postCreatePOST(w http.ResponseWriter, r *http.Request) {
-	err := r.ParseForm()
-	if err != nil {
-		app.clientError(w, http.StatusBadRequest)
+	r.Body = http.MaxBytesReader(w, r.Body, app.maxPostRequestBytes())
+	err := r.ParseMultipartForm(8 << 20)
+	if err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		app.clientError(w, r, http.StatusBadRequest)
 		return
 	}
+	if r.MultipartForm != nil {
+		defer r.MultipartForm.RemoveAll()
+	}
 
 	idSegment := r.PathValue("id")
 	threadId, err := strconv.Atoi(idSegment)
 	if err != nil || threadId < 1 {
-		http.NotFound(w, r)
+		app.notFound(w, r)
 		return
 	}
 
@@ -227,7 +405,18 @@ func (app *application) postCreatePOST(w http.ResponseWriter, r *http.Request) {
 	}
 
 	form.CheckField(validator.NotBlank(form.Body), "body", "This field cannot be blank")
-	form.CheckField(validator.MaxChars(form.Body, 1000), "body", "This field cannot be more than 1000 characters long")
+	form.CheckField(validator.MaxChars(form.Body, app.cfg.Limits.BodyMaxChars), "body", tooLong(app.cfg.Limits.BodyMaxChars))
+
+	authorID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	var files []*multipart.FileHeader
+	if r.MultipartForm != nil {
+		files = r.MultipartForm.File["attachments"]
+	}
+	pending, err := app.checkAttachments(r.Context(), &form, files, authorID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
 
 	if !form.Valid() {
 		data := app.newTemplateData(r)
@@ -237,14 +426,61 @@ func (app *application) postCreatePOST(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	authorID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
-	postID, err := app.posts.Insert(form.Body, threadId, authorID)
-
+	err = app.storeAttachments(r.Context(), pending)
 	if err != nil {
 		app.serverError(w, r, err)
 		return
 	}
 
+	// The post, its attachments, its author's read mark and its webhook
+	// deliveries are saved together, so that the post is never shown without
+	// its attachments.
+	var postID, queued int
+	err = app.db.WithTx(r.Context(), func(ctx context.Context) error {
+		var err error
+		postID, err = app.posts.InsertContext(ctx, form.Body, threadId, authorID)
+		if err != nil {
+			return err
+		}
+		err = app.recordAttachments(ctx, pending, postID, authorID)
+		if err != nil {
+			return err
+		}
+		err = app.reads.MarkReadContext(ctx, authorID, threadId, postID)
+		if err != nil {
+			return err
+		}
+		queued, err = app.emit(ctx, webhook.EventPostCreated, postEvent{
+			ID:       postID,
+			ThreadID: threadId,
+			Body:     form.Body,
+			URL:      app.absoluteURL(fmt.Sprintf("/thread/view/%d#post-%d", threadId, postID)),
+			Author:   eventAuthor(r),
+		})
+		return err
+	})
+	if err != nil {
+		app.deleteAttachments(r.Context(), pending)
+		switch {
+		case errors.Is(err, models.ErrNoRecord):
+			app.notFound(w, r)
+		case errors.Is(err, models.ErrLocked):
+			form.AddNonFieldError("This thread is locked")
+			data := app.newTemplateData(r)
+			data.ThreadID = threadId
+			data.Form = form
+			app.render(w, r, http.StatusUnprocessableEntity, "message-create", data)
+		default:
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.metrics.postsCreated.Inc()
+	if queued > 0 {
+		app.dispatcher.Notify()
+	}
+
 	app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("Message %d successfully created!", postID))
 	http.Redirect(w, r, fmt.Sprintf("/thread/view/%d", threadId), http.StatusSeeOther)
 }
@@ -270,10 +506,14 @@ func (app *application) userLoginPost(w http.ResponseWriter, r *http.Request) {
 		Password: r.PostForm.Get("password"),
 	}
 
-	id, err := app.users.Authenticate(form.Email, form.Password)
+	id, err := app.users.AuthenticateContext(r.Context(), form.Email, form.Password)
 	if err != nil {
-		if errors.Is(err, models.ErrInvalidCredentials) {
-			form.AddFieldError("generic", "Email or password incorrect")
+		if errors.Is(err, models.ErrInvalidCredentials) || errors.Is(err, models.ErrDisabled) {
+			if errors.Is(err, models.ErrDisabled) {
+				form.AddNonFieldError("This account has been disabled")
+			} else {
+				form.AddFieldError("generic", "Email or password incorrect")
+			}
 			data := app.newTemplateData(r)
 			data.Form = form
 			app.render(w, r, http.StatusUnprocessableEntity, "login", data)
@@ -0,0 +1,726 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"forum/internal/avatar"
+	"forum/internal/models"
+	"forum/internal/testutil"
+)
+
+func TestSignup(t *testing.T) {
+	ta := newTestApp(t)
+	srv := ta.srv
+
+	resp := srv.Get(t, "/account/create")
+	testutil.Equal(t, resp.Status, http.StatusOK)
+	testutil.Contains(t, resp.Body, `action='/account/create'`)
+
+	id := ta.signup(t, srv, "alice")
+	if srv.Cookie(t, "session") == "" {
+		t.Fatal("no session cookie after signup")
+	}
+
+	resp = srv.Get(t, fmt.Sprintf("/account/view/%d", id))
+	testutil.Equal(t, resp.Status, http.StatusOK)
+	testutil.Contains(t, resp.Body, "Your signup was successful.")
+	testutil.Contains(t, resp.Body, "alice")
+	testutil.Contains(t, resp.Body, "Logout")
+
+	// The flash is shown once.
+	resp = srv.Get(t, fmt.Sprintf("/account/view/%d", id))
+	testutil.NotContains(t, resp.Body, "Your signup was successful.")
+
+	tests := []struct {
+		name     string
+		username string
+		email    string
+		password string
+		wantBody string
+	}{
+		{"blank email", "bob", "", testPassword, "This field cannot be blank"},
+		{"invalid email", "bob", "bob.example.com", testPassword, "This field must be an email address"},
+		{"short password", "bob", "bob@example.com", "Pa55", "This field must be at least 8 characters long"},
+		{"duplicate email", "bob", "alice@example.com", testPassword, "this email is aready in use"},
+		{"duplicate username", "ALICE", "bob@example.com", testPassword, "this username is already taken"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := srv.NewSession(t)
+			resp := srv.PostForm(t, "/account/create", url.Values{
+				"username": {tt.username},
+				"email":    {tt.email},
+				"password": {tt.password},
+			})
+			testutil.Equal(t, resp.Status, http.StatusUnprocessableEntity)
+			testutil.Contains(t, resp.Body, tt.wantBody)
+			testutil.Equal(t, srv.Cookie(t, "session"), "")
+		})
+	}
+}
+
+func TestLogin(t *testing.T) {
+	ta := newTestApp(t)
+	id := ta.signup(t, ta.srv.NewSession(t), "alice")
+	srv := ta.srv
+
+	// Protected pages send anonymous visitors to the login form.
+	account := fmt.Sprintf("/account/view/%d", id)
+	resp := srv.Get(t, account)
+	testutil.Equal(t, resp.Status, http.StatusSeeOther)
+	testutil.Equal(t, resp.Location(), "/user/login")
+
+	resp = srv.Get(t, "/user/login")
+	testutil.Equal(t, resp.Status, http.StatusOK)
+	testutil.Contains(t, resp.Body, `action='/user/login'`)
+
+	for _, form := range []url.Values{
+		{"email": {"alice@example.com"}, "password": {"wrong-Passw0rd"}},
+		{"email": {"nobody@example.com"}, "password": {testPassword}},
+	} {
+		resp = srv.PostForm(t, "/user/login", form)
+		testutil.Equal(t, resp.Status, http.StatusUnprocessableEntity)
+		testutil.Contains(t, resp.Body, `value='`+form.Get("email")+`'`)
+	}
+	resp = srv.Get(t, account)
+	testutil.Equal(t, resp.Status, http.StatusSeeOther)
+
+	resp = srv.PostForm(t, "/user/login", url.Values{
+		"email":    {"alice@example.com"},
+		"password": {testPassword},
+	})
+	testutil.Equal(t, resp.Status, http.StatusSeeOther)
+	testutil.Equal(t, resp.Location(), account)
+	loggedIn := srv.Cookie(t, "session")
+
+	resp = srv.Get(t, account)
+	testutil.Equal(t, resp.Status, http.StatusOK)
+	testutil.Contains(t, resp.Body, "alice@example.com")
+	if !strings.Contains(resp.Header.Get("Cache-Control"), "no-store") {
+		t.Errorf("Cache-Control = %q; want no-store", resp.Header.Get("Cache-Control"))
+	}
+
+	// Other accounts are only shown as public profiles.
+	other := ta.signup(t, srv.NewSession(t), "bob")
+	resp = srv.Get(t, fmt.Sprintf("/account/view/%d", other))
+	testutil.Equal(t, resp.Status, http.StatusSeeOther)
+	testutil.Equal(t, resp.Location(), "/u/bob")
+
+	// Logging out renews the session token and forgets the user.
+	resp = srv.PostForm(t, "/user/logout", nil)
+	testutil.Equal(t, resp.Status, http.StatusSeeOther)
+	testutil.Equal(t, resp.Location(), "/")
+	if srv.Cookie(t, "session") == loggedIn {
+		t.Error("session token not renewed on logout")
+	}
+	resp = srv.Get(t, "/")
+	testutil.Contains(t, resp.Body, "You&#39;ve been logged out successfully!")
+	testutil.NotContains(t, resp.Body, "Logout")
+	resp = srv.Get(t, account)
+	testutil.Equal(t, resp.Status, http.StatusSeeOther)
+	testutil.Equal(t, resp.Location(), "/user/login")
+}
+
+func TestThreadCreate(t *testing.T) {
+	ta := newTestApp(t)
+	srv := ta.srv
+	visitor := srv.NewSession(t)
+
+	for _, resp := range []*testutil.Response{
+		srv.Get(t, "/thread/create"),
+		srv.PostForm(t, "/thread/create", url.Values{"title": {"Anonymous"}}),
+	} {
+		testutil.Equal(t, resp.Status, http.StatusSeeOther)
+		testutil.Equal(t, resp.Location(), "/user/login")
+	}
+	if threads, _ := ta.threads.LatestsContext(context.Background(), 10); len(threads) != 0 {
+		t.Fatalf("anonymous visitor created %d threads", len(threads))
+	}
+
+	// The home page is cached for anonymous visitors until a thread is
+	// created.
+	resp := visitor.Get(t, "/")
+	testutil.Equal(t, resp.Status, http.StatusOK)
+
+	ta.signup(t, srv, "alice")
+	resp = srv.Get(t, "/thread/create")
+	testutil.Equal(t, resp.Status, http.StatusOK)
+
+	resp = srv.PostForm(t, "/thread/create", url.Values{"title": {""}})
+	testutil.Equal(t, resp.Status, http.StatusUnprocessableEntity)
+	testutil.Contains(t, resp.Body, "This field cannot be blank")
+
+	long := strings.Repeat("a", ta.cfg.Limits.TitleMaxChars+1)
+	resp = srv.PostForm(t, "/thread/create", url.Values{"title": {long}})
+	testutil.Equal(t, resp.Status, http.StatusUnprocessableEntity)
+	testutil.Contains(t, resp.Body, tooLong(ta.cfg.Limits.TitleMaxChars))
+
+	id := ta.createThread(t, srv, "First thread")
+	resp = srv.Get(t, fmt.Sprintf("/thread/view/%d", id))
+	testutil.Equal(t, resp.Status, http.StatusOK)
+	testutil.Contains(t, resp.Body, "Thread successfully created!")
+	testutil.Contains(t, resp.Body, "First thread")
+	testutil.Contains(t, resp.Body, "Post your voice")
+
+	resp = visitor.Get(t, "/")
+	testutil.Contains(t, resp.Body, fmt.Sprintf("/thread/view/%d", id))
+	resp = visitor.Get(t, fmt.Sprintf("/thread/view/%d", id))
+	testutil.Equal(t, resp.Status, http.StatusSeeOther)
+	testutil.Equal(t, resp.Location(), "/user/login")
+
+	resp = srv.Get(t, "/thread/view/999")
+	testutil.Equal(t, resp.Status, http.StatusNotFound)
+}
+
+func TestHomeCache(t *testing.T) {
+	ta := newTestApp(t)
+	srv := ta.srv
+	visitor := srv.NewSession(t)
+	alice := ta.signup(t, srv, "alice")
+	ta.createThread(t, srv, "Cached")
+
+	// The members online are left out of the cached page, which follows the
+	// avatars of the authors it shows.
+	resp := srv.Get(t, "/")
+	testutil.Contains(t, resp.Body, "Online now")
+	resp = visitor.Get(t, "/")
+	testutil.NotContains(t, resp.Body, "Online now")
+	testutil.Contains(t, resp.Body, avatarURL(alice, 0, 32))
+
+	err := ta.users.SetAvatarVersionContext(context.Background(), alice, 0, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp = visitor.Get(t, "/")
+	testutil.Contains(t, resp.Body, avatarURL(alice, 5, 32))
+}
+
+func TestPostCreate(t *testing.T) {
+	ta := newTestApp(t)
+	srv := ta.srv
+	visitor := srv.NewSession(t)
+	ta.signup(t, srv, "alice")
+	id := ta.createThread(t, srv, "Replies")
+	thread := fmt.Sprintf("/thread/view/%d", id)
+	create := thread + "/post/create"
+
+	resp := visitor.PostForm(t, create, url.Values{"body": {"Anonymous"}})
+	testutil.Equal(t, resp.Status, http.StatusSeeOther)
+	testutil.Equal(t, resp.Location(), "/user/login")
+
+	resp = srv.Get(t, create)
+	testutil.Equal(t, resp.Status, http.StatusOK)
+
+	resp = srv.PostForm(t, create, url.Values{"body": {"  "}})
+	testutil.Equal(t, resp.Status, http.StatusUnprocessableEntity)
+	testutil.Contains(t, resp.Body, "This field cannot be blank")
+
+	resp = srv.PostForm(t, "/thread/view/999/post/create", url.Values{"body": {"Lost"}})
+	testutil.Equal(t, resp.Status, http.StatusNotFound)
+
+	resp = srv.PostForm(t, create, url.Values{"body": {"Hello, world"}})
+	testutil.Equal(t, resp.Status, http.StatusSeeOther)
+	testutil.Equal(t, resp.Location(), thread)
+
+	resp = srv.Get(t, thread)
+	testutil.Equal(t, resp.Status, http.StatusOK)
+	testutil.Contains(t, resp.Body, "successfully created!")
+	testutil.Contains(t, resp.Body, "Hello, world")
+
+	resp = srv.PostForm(t, create, url.Values{"body": {"<script>alert(1)</script>"}})
+	testutil.Equal(t, resp.Status, http.StatusSeeOther)
+	resp = srv.Get(t, thread)
+	testutil.Contains(t, resp.Body, "&lt;script&gt;alert(1)&lt;/script&gt;")
+	testutil.NotContains(t, resp.Body, "<script>alert(1)")
+
+	t2, err := ta.threads.GetContext(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.Equal(t, t2.ReplyCount, 2)
+	testutil.Equal(t, len(t2.Posts), 2)
+	testutil.Equal(t, t2.LastPoster.Username, "alice")
+}
+
+func TestSearch(t *testing.T) {
+	ta := newTestApp(t)
+	ta.cfg.Limits.SearchPageSize = 1
+	srv := ta.srv
+	visitor := srv.NewSession(t)
+	ta.signup(t, srv, "alice")
+	id := ta.createThread(t, srv, "Baking")
+	create := fmt.Sprintf("/thread/view/%d/post/create", id)
+	for _, body := range []string{"Sourdough needs time", "A <b>warm</b> sourdough", "Pizza"} {
+		resp := srv.PostForm(t, create, url.Values{"body": {body}})
+		testutil.Equal(t, resp.Status, http.StatusSeeOther)
+	}
+
+	resp := visitor.Get(t, "/search?q=sourdough")
+	testutil.Equal(t, resp.Status, http.StatusSeeOther)
+	testutil.Equal(t, resp.Location(), "/user/login")
+
+	resp = srv.Get(t, "/search?q=sourdough")
+	testutil.Equal(t, resp.Status, http.StatusOK)
+	testutil.Contains(t, resp.Body, `value="sourdough"`)
+	testutil.Contains(t, resp.Body, "A &lt;b&gt;warm&lt;/b&gt; sourdough")
+	testutil.NotContains(t, resp.Body, "Sourdough needs time")
+	testutil.Contains(t, resp.Body, fmt.Sprintf(`href="/thread/view/%d#post-2"`, id))
+	testutil.Contains(t, resp.Body, `href="?q=sourdough&amp;page=2"`)
+
+	resp = srv.Get(t, "/search?q=sourdough&page=2")
+	testutil.Equal(t, resp.Status, http.StatusOK)
+	testutil.Contains(t, resp.Body, "Sourdough needs time")
+	testutil.Contains(t, resp.Body, `href="?q=sourdough&amp;page=1"`)
+	testutil.NotContains(t, resp.Body, "page=3")
+
+	resp = srv.Get(t, "/search?q=bread")
+	testutil.Contains(t, resp.Body, "No posts found.")
+	resp = srv.Get(t, "/search")
+	testutil.Equal(t, resp.Status, http.StatusOK)
+	testutil.NotContains(t, resp.Body, "No posts found.")
+	resp = srv.Get(t, "/search?q=pizza&page=0")
+	testutil.Equal(t, resp.Status, http.StatusNotFound)
+}
+
+func TestThreadActivity(t *testing.T) {
+	ta := newTestApp(t)
+	srv := ta.srv
+	visitor := srv.NewSession(t)
+	ta.signup(t, srv, "alice")
+	older := ta.createThread(t, srv, "Older")
+	ta.createThread(t, srv, "Newer")
+
+	resp := visitor.Get(t, "/")
+	testutil.Contains(t, resp.Body, "0 replies")
+	if strings.Index(resp.Body, "Newer") > strings.Index(resp.Body, "Older") {
+		t.Error("newer thread listed after the older one")
+	}
+
+	// A reply moves its thread to the top of the listing, cached or not.
+	bob := srv.NewSession(t)
+	ta.signup(t, bob, "bob")
+	resp = bob.PostForm(t, fmt.Sprintf("/thread/view/%d/post/create", older), url.Values{"body": {"Bump"}})
+	testutil.Equal(t, resp.Status, http.StatusSeeOther)
+	for _, srv := range []*testutil.Server{visitor, srv} {
+		resp = srv.Get(t, "/")
+		testutil.Contains(t, resp.Body, "1 reply\n")
+		testutil.Contains(t, resp.Body, `by <a href="/u/bob">bob</a>`)
+		if strings.Index(resp.Body, "Older") > strings.Index(resp.Body, "Newer") {
+			t.Error("replied thread not listed first")
+		}
+	}
+}
+
+func TestUnread(t *testing.T) {
+	ta := newTestApp(t)
+	alice := ta.srv
+	ta.signup(t, alice, "alice")
+	id := ta.createThread(t, alice, "Quiet")
+	thread := fmt.Sprintf("/thread/view/%d", id)
+	unread := thread + "/unread"
+
+	// Threads are unread, replies or not, until viewed, except by their
+	// author.
+	resp := alice.Get(t, "/")
+	testutil.NotContains(t, resp.Body, unread)
+	bob := alice.NewSession(t)
+	bobID := ta.signup(t, bob, "bob")
+	resp = bob.Get(t, "/")
+	testutil.Contains(t, resp.Body, unread)
+	resp = bob.Get(t, unread)
+	testutil.Equal(t, resp.Status, http.StatusSeeOther)
+	testutil.Equal(t, resp.Location(), thread)
+	bob.Get(t, thread)
+	resp = bob.Get(t, "/")
+	testutil.NotContains(t, resp.Body, unread)
+
+	// The link jumps to the first reply bob has not read.
+	resp = alice.PostForm(t, thread+"/post/create", url.Values{"body": {"First"}})
+	testutil.Equal(t, resp.Status, http.StatusSeeOther)
+	first, err := ta.reads.FirstUnreadContext(context.Background(), bobID, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice.PostForm(t, thread+"/post/create", url.Values{"body": {"Second"}})
+	resp = bob.Get(t, "/")
+	testutil.Contains(t, resp.Body, unread)
+	resp = bob.Get(t, unread)
+	testutil.Equal(t, resp.Status, http.StatusSeeOther)
+	testutil.Equal(t, resp.Location(), fmt.Sprintf("%s#post-%d", thread, first))
+
+	// Marking all as read covers every thread there is.
+	ta.createThread(t, alice, "Another")
+	resp = bob.PostForm(t, "/thread/read-all", nil)
+	testutil.Equal(t, resp.Status, http.StatusSeeOther)
+	testutil.Equal(t, resp.Location(), "/")
+	resp = bob.Get(t, "/")
+	testutil.Contains(t, resp.Body, "All threads marked as read.")
+	testutil.NotContains(t, resp.Body, "/unread")
+}
+
+func TestConversations(t *testing.T) {
+	ta := newTestApp(t)
+	alice := ta.srv
+	ta.signup(t, alice, "alice")
+	bob := alice.NewSession(t)
+	ta.signup(t, bob, "bob")
+	carol := alice.NewSession(t)
+	ta.signup(t, carol, "carol")
+
+	resp := alice.PostForm(t, "/conversation/create", url.Values{
+		"to": {"nobody"}, "subject": {"Hi"}, "body": {"Hello"},
+	})
+	testutil.Equal(t, resp.Status, http.StatusUnprocessableEntity)
+	testutil.Contains(t, resp.Body, "There is no user named &#34;nobody&#34;")
+
+	resp = alice.PostForm(t, "/conversation/create", url.Values{
+		"to": {"BOB"}, "subject": {"Lunch"}, "body": {"Shall we?"},
+	})
+	id, ok := redirectID(resp.Location(), `^/conversation/view/(\d+)$`)
+	if !ok {
+		t.Fatalf("starting conversation: got %d to %q", resp.Status, resp.Location())
+	}
+	conversation := fmt.Sprintf("/conversation/view/%d", id)
+
+	resp = bob.Get(t, "/conversation/inbox")
+	testutil.Contains(t, resp.Body, "Lunch")
+	testutil.Contains(t, resp.Body, "1 new")
+	resp = bob.Get(t, conversation)
+	testutil.Equal(t, resp.Status, http.StatusOK)
+	testutil.Contains(t, resp.Body, "Shall we?")
+	resp = bob.Get(t, "/conversation/inbox")
+	testutil.NotContains(t, resp.Body, "1 new")
+
+	// Only participants can read or reply.
+	resp = carol.Get(t, conversation)
+	testutil.Equal(t, resp.Status, http.StatusNotFound)
+	resp = carol.PostForm(t, conversation+"/reply", url.Values{"body": {"Me too"}})
+	testutil.Equal(t, resp.Status, http.StatusNotFound)
+
+	// Archived conversations leave the inbox.
+	resp = bob.PostForm(t, conversation+"/archive", url.Values{"archived": {"1"}})
+	testutil.Equal(t, resp.Status, http.StatusSeeOther)
+	resp = bob.Get(t, "/conversation/inbox")
+	testutil.NotContains(t, resp.Body, "Lunch")
+	resp = bob.Get(t, "/conversation/inbox?archived=1")
+	testutil.Contains(t, resp.Body, "Lunch")
+
+	// Blocked members can neither reply nor start a conversation.
+	resp = bob.PostForm(t, "/user/block", url.Values{"username": {"alice"}})
+	testutil.Equal(t, resp.Status, http.StatusSeeOther)
+	resp = alice.PostForm(t, conversation+"/reply", url.Values{"body": {"Hello?"}})
+	testutil.Equal(t, resp.Status, http.StatusUnprocessableEntity)
+	testutil.Contains(t, resp.Body, "You can no longer reply to this conversation")
+	resp = alice.PostForm(t, "/conversation/create", url.Values{
+		"to": {"bob"}, "subject": {"Again"}, "body": {"Hello?"},
+	})
+	testutil.Equal(t, resp.Status, http.StatusUnprocessableEntity)
+	testutil.Contains(t, resp.Body, "You cannot start a conversation with one of these users")
+
+	// Members who left no longer see the conversation.
+	resp = bob.PostForm(t, conversation+"/leave", nil)
+	testutil.Equal(t, resp.Status, http.StatusSeeOther)
+	resp = bob.Get(t, conversation)
+	testutil.Equal(t, resp.Status, http.StatusNotFound)
+}
+
+func TestProfileView(t *testing.T) {
+	ta := newTestApp(t)
+	srv := ta.srv
+	visitor := srv.NewSession(t)
+	ta.signup(t, srv, "alice")
+	id := ta.createThread(t, srv, "Replies")
+	resp := srv.PostForm(t, fmt.Sprintf("/thread/view/%d/post/create", id), url.Values{"body": {"Hello, world"}})
+	testutil.Equal(t, resp.Status, http.StatusSeeOther)
+
+	resp = srv.Get(t, "/u/alice")
+	testutil.Equal(t, resp.Status, http.StatusOK)
+	testutil.Contains(t, resp.Body, "Replied in")
+	testutil.Contains(t, resp.Body, "Hello, world")
+
+	// Anonymous visitors cannot open the thread, so they do not get the
+	// post either.
+	resp = visitor.Get(t, "/u/alice")
+	testutil.Equal(t, resp.Status, http.StatusOK)
+	testutil.Contains(t, resp.Body, "Replied in")
+	testutil.NotContains(t, resp.Body, "Hello, world")
+
+	// Long posts are cut on a character boundary.
+	resp = srv.PostForm(t, fmt.Sprintf("/thread/view/%d/post/create", id), url.Values{"body": {strings.Repeat("é", 150)}})
+	testutil.Equal(t, resp.Status, http.StatusSeeOther)
+	resp = srv.Get(t, "/u/alice")
+	testutil.Contains(t, resp.Body, strings.Repeat("é", 100)+"...")
+	testutil.NotContains(t, resp.Body, strings.Repeat("é", 101))
+}
+
+func TestProfileEdit(t *testing.T) {
+	ta := newTestApp(t)
+	srv := ta.srv
+	visitor := srv.NewSession(t)
+	ta.signup(t, srv, "alice")
+	ta.createThread(t, srv, "Mine")
+
+	resp := srv.PostForm(t, "/account/profile", url.Values{"website": {"javascript:alert(1)"}})
+	testutil.Equal(t, resp.Status, http.StatusUnprocessableEntity)
+	testutil.Contains(t, resp.Body, "This field must be an http or https address")
+
+	resp = srv.PostForm(t, "/account/profile", url.Values{
+		"bio":           {"Gardener"},
+		"location":      {"Lyon"},
+		"website":       {"https://example.com"},
+		"hide_activity": {"1"},
+	})
+	testutil.Equal(t, resp.Status, http.StatusSeeOther)
+	testutil.Equal(t, resp.Location(), "/u/alice")
+
+	// Hidden activity is only shown to its owner, and left out of the
+	// feeds.
+	resp = srv.Get(t, "/u/alice")
+	testutil.Contains(t, resp.Body, "Your profile has been updated.")
+	testutil.Contains(t, resp.Body, "Started")
+	resp = visitor.Get(t, "/u/alice")
+	testutil.Contains(t, resp.Body, "Gardener")
+	testutil.Contains(t, resp.Body, "Lyon")
+	testutil.Contains(t, resp.Body, "https://example.com")
+	testutil.Contains(t, resp.Body, "alice keeps their activity private.")
+	testutil.NotContains(t, resp.Body, "Started")
+	resp = visitor.Get(t, "/feed/rss/user/alice")
+	testutil.Equal(t, resp.Status, http.StatusNotFound)
+
+	resp = visitor.Get(t, "/u/nobody")
+	testutil.Equal(t, resp.Status, http.StatusNotFound)
+}
+
+func TestEmailsNotShown(t *testing.T) {
+	ta := newTestApp(t)
+	srv := ta.srv
+	visitor := srv.NewSession(t)
+	ta.signup(t, srv, "alice")
+	id := ta.createThread(t, srv, "Public")
+	resp := srv.PostForm(t, fmt.Sprintf("/thread/view/%d/post/create", id), url.Values{"body": {"Reply"}})
+	testutil.Equal(t, resp.Status, http.StatusSeeOther)
+	bob := srv.NewSession(t)
+	ta.signup(t, bob, "bob")
+
+	// Only the account page of its owner shows an email.
+	for _, path := range []string{
+		"/",
+		fmt.Sprintf("/thread/view/%d", id),
+		"/u/alice",
+		fmt.Sprintf("/feed/atom/thread/%d", id),
+		"/feed/rss/user/alice",
+	} {
+		for _, srv := range []*testutil.Server{visitor, bob} {
+			resp := srv.Get(t, path)
+			testutil.NotContains(t, resp.Body, "alice@example.com")
+		}
+	}
+}
+
+func TestAvatarView(t *testing.T) {
+	ta := newTestApp(t)
+	id := ta.signup(t, ta.srv, "alice")
+
+	// The identicon of version 0 never changes, unlike the one standing in
+	// for a version whose files are missing.
+	resp := ta.srv.Get(t, avatarURL(id, 0, 64))
+	testutil.Equal(t, resp.Status, http.StatusOK)
+	testutil.Equal(t, resp.Header.Get("Content-Type"), "image/png")
+	testutil.Equal(t, resp.Header.Get("Cache-Control"), "public, max-age=31536000, immutable")
+	resp = ta.srv.Get(t, avatarURL(id, 7, 64))
+	testutil.Equal(t, resp.Status, http.StatusOK)
+	testutil.Equal(t, resp.Header.Get("Cache-Control"), "no-cache")
+}
+
+// racingStore is an avatar store running race once it saved an upload, as if
+// another request changed the avatar meanwhile.
+type racingStore struct {
+	avatar.Store
+	race  func()
+	saved int
+}
+
+func (s *racingStore) Save(userID, version int, avatars map[int][]byte) error {
+	err := s.Store.Save(userID, version, avatars)
+	s.saved = version
+	s.race()
+	return err
+}
+
+func TestAvatarUpload(t *testing.T) {
+	ta := newTestApp(t)
+	srv := ta.srv
+	ctx := context.Background()
+	id := ta.signup(t, srv, "alice")
+
+	var picture bytes.Buffer
+	err := png.Encode(&picture, image.NewRGBA(image.Rect(0, 0, 64, 64)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	upload := func() *testutil.Response {
+		t.Helper()
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		fw, err := mw.CreateFormFile("avatar", "avatar.png")
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write(picture.Bytes())
+		mw.Close()
+		req, err := http.NewRequest(http.MethodPost, srv.URL+"/account/avatar", &body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		return srv.Do(t, req)
+	}
+	version := func() int {
+		t.Helper()
+		p, err := ta.users.GetProfileByIDContext(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p.AvatarVersion
+	}
+
+	resp := upload()
+	testutil.Equal(t, resp.Status, http.StatusSeeOther)
+	first := version()
+	f, _, err := ta.avatars.Open(id, first, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	// An upload losing the race to another change of avatar leaves no
+	// files behind.
+	store := &racingStore{Store: ta.avatars, race: func() {
+		err := ta.users.SetAvatarVersionContext(ctx, id, first, 0)
+		if err != nil {
+			t.Error(err)
+		}
+	}}
+	ta.avatars = store
+	resp = upload()
+	testutil.Equal(t, resp.Status, http.StatusUnprocessableEntity)
+	testutil.Contains(t, resp.Body, "Your avatar was changed in the meantime")
+	testutil.Equal(t, version(), 0)
+	_, _, err = store.Open(id, store.saved, 64)
+	if !errors.Is(err, avatar.ErrNotFound) {
+		t.Errorf("files of the refused upload: got %v; want %v", err, avatar.ErrNotFound)
+	}
+}
+
+func TestFeeds(t *testing.T) {
+	ta := newTestApp(t)
+	srv := ta.srv
+	visitor := srv.NewSession(t)
+	ta.signup(t, srv, "alice")
+	id := ta.createThread(t, srv, "Feeds")
+	resp := srv.PostForm(t, fmt.Sprintf("/thread/view/%d/post/create", id), url.Values{"body": {"Members only"}})
+	testutil.Equal(t, resp.Status, http.StatusSeeOther)
+
+	// Feed readers have no session: the feeds are public, and leave out the
+	// bodies of posts.
+	for _, path := range []string{
+		fmt.Sprintf("/feed/atom/thread/%d", id),
+		"/feed/rss/user/alice",
+	} {
+		resp := visitor.Get(t, path)
+		testutil.Equal(t, resp.Status, http.StatusOK)
+		testutil.Contains(t, resp.Body, "Feeds")
+		testutil.NotContains(t, resp.Body, "Members only")
+		testutil.Equal(t, resp.Header.Get("Cache-Control"), "public, max-age=300")
+	}
+
+	// Links use the configured base URL, whatever the Host header says.
+	ta.cfg.Server.BaseURL = "https://forum.example.com/"
+	req, err := http.NewRequest(http.MethodGet, visitor.URL+"/feed/atom", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Host = "attacker.example"
+	resp = visitor.Do(t, req)
+	testutil.Contains(t, resp.Body, fmt.Sprintf("https://forum.example.com/thread/view/%d", id))
+	testutil.NotContains(t, resp.Body, "attacker.example")
+}
+
+func TestRequireAdmin(t *testing.T) {
+	ta := newTestApp(t)
+	srv := ta.srv
+	id := ta.signup(t, srv, "alice")
+
+	resp := srv.Get(t, "/admin/webhooks")
+	testutil.Equal(t, resp.Status, http.StatusForbidden)
+	resp = srv.Get(t, "/")
+	testutil.NotContains(t, resp.Body, "href='/admin/webhooks'")
+
+	err := ta.users.SetRoleContext(context.Background(), id, models.RoleAdmin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp = srv.Get(t, "/admin/webhooks")
+	testutil.Equal(t, resp.Status, http.StatusOK)
+
+	// Admins get the link on public pages too.
+	resp = srv.Get(t, "/")
+	testutil.Contains(t, resp.Body, "href='/admin/webhooks'")
+}
+
+func TestModeration(t *testing.T) {
+	ta := newTestApp(t)
+	srv := ta.srv
+	ctx := context.Background()
+	alice := ta.signup(t, srv, "alice")
+	id := ta.createThread(t, srv, "Moderated")
+	thread := fmt.Sprintf("/thread/view/%d", id)
+
+	err := ta.threads.SetLockedContext(ctx, id, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := srv.Get(t, thread)
+	testutil.Contains(t, resp.Body, "This thread is locked")
+	testutil.NotContains(t, resp.Body, "Post your voice")
+	resp = srv.PostForm(t, thread+"/post/create", url.Values{"body": {"Too late"}})
+	testutil.Equal(t, resp.Status, http.StatusUnprocessableEntity)
+	testutil.Contains(t, resp.Body, "This thread is locked")
+
+	// Disabled users are logged out and cannot log in again.
+	err = ta.users.SetDisabledContext(ctx, alice, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp = srv.Get(t, "/thread/create")
+	testutil.Equal(t, resp.Status, http.StatusSeeOther)
+	testutil.Equal(t, resp.Location(), "/user/login")
+	resp = srv.PostForm(t, "/user/login", url.Values{
+		"email":    {"alice@example.com"},
+		"password": {testPassword},
+	})
+	testutil.Equal(t, resp.Status, http.StatusUnprocessableEntity)
+	testutil.Contains(t, resp.Body, "This account has been disabled")
+}
+
+func TestNotFound(t *testing.T) {
+	ta := newTestApp(t)
+	for _, path := range []string{"/nowhere", "/static/missing.css", "/static/"} {
+		resp := ta.srv.Get(t, path)
+		testutil.Equal(t, resp.Status, http.StatusNotFound)
+		testutil.Contains(t, resp.Body, "The page you are looking for does not exist")
+	}
+
+	resp := ta.srv.Get(t, ta.static.Path("css/main.css"))
+	testutil.Equal(t, resp.Status, http.StatusOK)
+}
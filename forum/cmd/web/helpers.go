@@ -1,7 +1,14 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"forum/internal/logging"
+	"forum/internal/models"
+	"log/slog"
 	"net/http"
+	"strings"
 )
 
 // serverError writes a log entry at Error level (including the request
@@ -13,14 +20,26 @@ func (app *application) serverError(w http.ResponseWriter, r *http.Request, err
 		uri    = r.URL.RequestURI()
 	)
 
-	app.logger.Error(err.Error(), "method", method, "uri", uri)
-	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	app.requestLogger(r).Error(err.Error(), "method", method, "uri", uri)
+	app.renderError(w, r, http.StatusInternalServerError)
 }
 
-// clientError sends a specific status code and corresponding description
-// to the user.
-func (app *application) clientError(w http.ResponseWriter, status int) {
-	http.Error(w, http.StatusText(status), status)
+// clientError sends a specific status code and corresponding error page to
+// the user.
+func (app *application) clientError(w http.ResponseWriter, r *http.Request, status int) {
+	app.renderError(w, r, status)
+}
+
+// notFound sends a 404 Not Found error page to the user.
+func (app *application) notFound(w http.ResponseWriter, r *http.Request) {
+	app.renderError(w, r, http.StatusNotFound)
+}
+
+// sessionLoaded reports whether the session of the current request was loaded,
+// which only the routes of the dynamic chain do.
+func sessionLoaded(r *http.Request) bool {
+	loaded, _ := r.Context().Value(sessionLoadedContextKey).(bool)
+	return loaded
 }
 
 // Return true if the current request is from an authenticated user, otherwise
@@ -28,3 +47,80 @@ func (app *application) clientError(w http.ResponseWriter, status int) {
 func (app *application) isAuthenticated(r *http.Request) bool {
 	return app.sessionManager.Exists(r.Context(), "authenticatedUserID")
 }
+
+// etagMatches reports whether a GET or HEAD request already has the version
+// of a page identified by etag, according to its If-None-Match header.
+func etagMatches(r *http.Request, etag string) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	weak := strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == weak {
+			return true
+		}
+	}
+	return false
+}
+
+// tooLong returns the error message of a field longer than n characters.
+func tooLong(n int) string {
+	return fmt.Sprintf("This field cannot be more than %d characters long", n)
+}
+
+type contextKey string
+
+const (
+	requestIDContextKey     = contextKey("requestID")
+	sessionLoadedContextKey = contextKey("sessionLoaded")
+	userContextKey          = contextKey("user")
+	cachedPageContextKey    = contextKey("cachedPage")
+)
+
+// requestIDHeader carries the ID of a request, accepted from clients and
+// proxies, and echoed in responses.
+const requestIDHeader = "X-Request-ID"
+
+// validRequestID reports whether a request ID received from a client is safe
+// to log and echo.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID returns a random request ID.
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// requestID returns the ID of the current request.
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDContextKey).(string)
+	return id
+}
+
+// authenticatedUser returns the current user, as loaded by
+// requireAuthentication, or nil outside of the routes it protects.
+func authenticatedUser(r *http.Request) *models.User {
+	user, _ := r.Context().Value(userContextKey).(*models.User)
+	return user
+}
+
+// requestLogger returns the logger of the current request, which records its
+// ID and trace.
+func (app *application) requestLogger(r *http.Request) *slog.Logger {
+	return logging.FromContext(r.Context())
+}
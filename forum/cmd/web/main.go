@@ -1,44 +1,126 @@
 package main
 
 import (
-	"database/sql"
+	"context"
 	"flag"
+	"fmt"
+	"forum/internal/assets"
+	"forum/internal/avatar"
+	"forum/internal/backup"
+	"forum/internal/blob"
+	"forum/internal/cache"
+	"forum/internal/config"
+	"forum/internal/logging"
 	"forum/internal/models"
+	"forum/internal/presence"
+	"forum/internal/pubsub"
+	"forum/internal/webhook"
+	"forum/ui"
+	"html/template"
+	"io/fs"
 	"log/slog"
 	"net/http"
 	"os"
-	"text/template"
-	"time"
+	"sync"
 
 	"github.com/alexedwards/scs/v2"
-	_ "github.com/mattn/go-sqlite3"
 )
 
 // application contains the server's dependencies.
 type application struct {
-	logger         *slog.Logger
-	threads        *models.ThreadModel
-	users          *models.UserModel
-	posts          *models.PostModel
-	templateCache  map[string]*template.Template
-	sessionManager *scs.SessionManager
+	cfg              *config.Config
+	logger           *slog.Logger
+	db               models.Transactor
+	threads          models.ThreadStore
+	users            models.UserStore
+	posts            models.PostStore
+	reads            models.ReadStore
+	conversations    models.ConversationStore
+	blocks           models.BlockStore
+	avatars          avatar.Store
+	attachments      models.AttachmentStore
+	blobs            blob.Store
+	webhooks         models.WebhookStore
+	dispatcher       *webhook.Dispatcher
+	metrics          *appMetrics
+	cache            cache.Cache
+	hub              pubsub.Hub
+	presence         *presence.Tracker
+	static           *assets.Pipeline
+	templateCache    map[string]*template.Template
+	templateReloader *templateReloader
+	sessionManager   *scs.SessionManager
+	workers          sync.WaitGroup
+	shutdown         chan struct{}
 }
 
 func main() {
-	addr := flag.String("addr", ":5000", "HTTP network address")
-	dbPath := flag.String("dbPath", "./db.sqlite", "Path to database file")
-	flag.Parse()
+	printConfig := flag.Bool("printConfig", false, "Print the effective configuration, with secrets redacted, and exit")
+	cfg, err := config.Load(flag.CommandLine, os.Args[1:], os.Getenv)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if *printConfig {
+		err = cfg.WriteTOML(os.Stdout)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
 
-	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+	// The level is validated with the configuration.
+	level, _ := cfg.Log.SlogLevel()
+	logger, err := logging.NewLogger(os.Stdout, cfg.Log.Format, level)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	slog.SetDefault(logger)
+	logger.Info("Loaded configuration", "config", cfg)
 
-	db, err := openDB(*dbPath)
+	db, err := models.Open(cfg.Database.DSN(), models.Options{
+		JournalMode:  cfg.Database.JournalMode,
+		BusyTimeout:  cfg.Database.BusyTimeout,
+		ForeignKeys:  cfg.Database.ForeignKeys,
+		Synchronous:  cfg.Database.Synchronous,
+		ReadConns:    cfg.Database.ReadConns,
+		QueryTimeout: cfg.Database.QueryTimeout,
+	})
 	if err != nil {
 		logger.Error(err.Error())
 		os.Exit(1)
 	}
 	defer db.Close()
 
-	templateCache, err := newTemplateCache()
+	// The templates and static files are embedded, unless they are read
+	// from disk in dev mode.
+	uiFiles := fs.FS(ui.Files)
+	if cfg.Server.Dev {
+		uiFiles = os.DirFS(cfg.Server.UIDir)
+		logger.Info("Serving templates and static files from disk", "dir", cfg.Server.UIDir)
+	}
+	staticFiles, err := fs.Sub(uiFiles, "static")
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	var static *assets.Pipeline
+	var templateReloader *templateReloader
+	if cfg.Server.Dev {
+		static = assets.NewLive(staticFiles, "/static")
+		templateReloader = newTemplateReloader(uiFiles, static)
+	} else {
+		static, err = assets.New(staticFiles, "/static")
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+	}
+
+	templateCache, err := newTemplateCache(uiFiles, static)
 	if err != nil {
 		logger.Error(err.Error())
 		os.Exit(1)
@@ -50,35 +132,116 @@ func main() {
 		os.Exit(1)
 	}
 
-	sessionManager := scs.New()
-	sessionManager.Lifetime = 12 * time.Hour
+	pageCache := cache.NewLRU(cfg.Cache.MaxEntries)
+	threadModel.Cache = pageCache
+	threadModel.CacheTTL = cfg.Cache.QueryTTL
+	postModel.Cache = pageCache
+	userModel.Cache = pageCache
 
-	app := &application{
-		logger:         logger,
-		threads:        threadModel,
-		users:          userModel,
-		posts:          postModel,
-		templateCache:  templateCache,
-		sessionManager: sessionManager,
+	hub := pubsub.NewMemoryHub(16)
+	postModel.Hub = hub
+	tracker := presence.NewTracker(hub)
+
+	readModel, err := models.NewReadModel(db)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
 	}
 
-	logger.Info("Starting server", "addr", *addr)
+	conversationModel, err := models.NewConversationModel(db)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
 
-	err = http.ListenAndServe(*addr, app.routes())
-	logger.Error(err.Error())
-	os.Exit(1)
-}
+	blockModel, err := models.NewBlockModel(db)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
 
-// openDB opens a connection to the databate and returns an handle.
-func openDB(path string) (*sql.DB, error) {
-	db, err := sql.Open("sqlite3", path)
+	avatarStore, err := avatar.NewFileStore(cfg.Storage.AvatarDir)
 	if err != nil {
-		return nil, err
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	attachmentModel, err := models.NewAttachmentModel(db)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	blobs, err := blob.Open(blob.Options{
+		Kind:        cfg.Storage.BlobStore,
+		Dir:         cfg.Storage.BlobDir,
+		S3Endpoint:  cfg.Storage.S3Endpoint,
+		S3Bucket:    cfg.Storage.S3Bucket,
+		S3Region:    cfg.Storage.S3Region,
+		S3AccessKey: cfg.Storage.S3AccessKey,
+		S3SecretKey: cfg.Storage.S3SecretKey,
+	})
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	webhookModel, err := models.NewWebhookModel(db)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+	dispatcher := webhook.NewDispatcher(webhookModel, logger)
+
+	sessionManager := scs.New()
+	sessionManager.Lifetime = cfg.Session.Lifetime
+	sessionManager.Cookie.Secure = cfg.Server.UseTLS()
+
+	app := &application{
+		cfg:              cfg,
+		logger:           logger,
+		db:               db,
+		threads:          threadModel,
+		users:            userModel,
+		posts:            postModel,
+		reads:            readModel,
+		conversations:    conversationModel,
+		blocks:           blockModel,
+		avatars:          avatarStore,
+		attachments:      attachmentModel,
+		blobs:            blobs,
+		webhooks:         webhookModel,
+		dispatcher:       dispatcher,
+		metrics:          newAppMetrics(sessionManager.Store, func() int { return len(tracker.Online()) }, pageCache.Stats),
+		cache:            pageCache,
+		hub:              hub,
+		presence:         tracker,
+		static:           static,
+		templateCache:    templateCache,
+		templateReloader: templateReloader,
+		sessionManager:   sessionManager,
+		shutdown:         make(chan struct{}),
+	}
+	static.NotFound = http.HandlerFunc(app.notFound)
+
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	app.runWorker(workerCtx, dispatcher.Run)
+	app.runWorker(workerCtx, tracker.Run)
+	if db.Dialect == models.SQLite {
+		app.runWorker(workerCtx, func(ctx context.Context) {
+			app.clearOnExternalWrites(ctx, db, cfg.Cache.CheckInterval)
+		})
+	}
+	if cfg.Backup.Dir != "" {
+		scheduler := backup.NewScheduler(db, cfg.Backup.Dir, logger)
+		scheduler.Interval = cfg.Backup.Interval
+		scheduler.Keep = cfg.Backup.Keep
+		app.runWorker(workerCtx, scheduler.Run)
 	}
-	err = db.Ping()
+
+	err = app.serve(stopWorkers)
 	if err != nil {
-		db.Close()
-		return nil, err
+		logger.Error(err.Error())
+		os.Exit(1)
 	}
-	return db, nil
 }
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"forum/internal/cache"
+	"forum/internal/metrics"
+	"forum/internal/models"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"time"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/justinas/alice"
+)
+
+// appMetrics holds the metrics recorded by the application.
+type appMetrics struct {
+	registry *metrics.Registry
+
+	requests        *metrics.Counter
+	requestDuration *metrics.Histogram
+	queryDuration   *metrics.Histogram
+	renderDuration  *metrics.Histogram
+	signups         *metrics.Counter
+	threadsCreated  *metrics.Counter
+	postsCreated    *metrics.Counter
+}
+
+// newAppMetrics registers the metrics of the application, and reports the
+// duration of database queries to them.
+func newAppMetrics(sessions scs.Store, online func() int, cacheStats func() cache.Stats) *appMetrics {
+	reg := metrics.NewRegistry()
+	m := &appMetrics{
+		registry: reg,
+		requests: reg.Counter("forum_http_requests_total",
+			"HTTP requests served, by route pattern and status.", "route", "status"),
+		requestDuration: reg.Histogram("forum_http_request_duration_seconds",
+			"Time taken to serve HTTP requests, by route pattern and status.", metrics.DefBuckets, "route", "status"),
+		queryDuration: reg.Histogram("forum_db_query_duration_seconds",
+			"Time taken by the database queries of each model method.", metrics.DefBuckets, "method"),
+		renderDuration: reg.Histogram("forum_template_render_duration_seconds",
+			"Time taken to render templates, by page.", metrics.DefBuckets, "page"),
+		signups:        reg.Counter("forum_signups_total", "Accounts created."),
+		threadsCreated: reg.Counter("forum_threads_created_total", "Threads created."),
+		postsCreated:   reg.Counter("forum_posts_created_total", "Posts created."),
+	}
+
+	if store, ok := sessions.(scs.IterableStore); ok {
+		reg.GaugeFunc("forum_sessions", "Sessions currently stored.", func() float64 {
+			all, err := store.All()
+			if err != nil {
+				return 0
+			}
+			return float64(len(all))
+		})
+	}
+	reg.GaugeFunc("forum_online_members", "Members seen within the online window.", func() float64 {
+		return float64(online())
+	})
+
+	reg.CounterFunc("forum_cache_hits_total", "Lookups answered by the cache.", func() float64 {
+		return float64(cacheStats().Hits)
+	})
+	reg.CounterFunc("forum_cache_misses_total", "Lookups the cache could not answer.", func() float64 {
+		return float64(cacheStats().Misses)
+	})
+	reg.CounterFunc("forum_cache_evictions_total", "Entries evicted from the full cache.", func() float64 {
+		return float64(cacheStats().Evictions)
+	})
+	reg.CounterFunc("forum_cache_invalidations_total", "Invalidations of cached data by writes.", func() float64 {
+		return float64(cacheStats().Invalidations)
+	})
+	reg.GaugeFunc("forum_cache_entries", "Entries currently cached.", func() float64 {
+		return float64(cacheStats().Entries)
+	})
+
+	models.QueryObserver = func(method string, d time.Duration) {
+		m.queryDuration.Observe(d.Seconds(), method)
+	}
+	return m
+}
+
+// instrument records the count and duration of the requests served by mux,
+// labelled with the pattern of the route they matched.
+func (app *application) instrument(mux *http.ServeMux) alice.Constructor {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, route := mux.Handler(r)
+			if route == "" || route == "/" {
+				route = "unmatched"
+			}
+
+			start := time.Now()
+			sw := &statusWriter{ResponseWriter: w}
+			next.ServeHTTP(sw, r)
+
+			labels := []string{route, strconv.Itoa(sw.Status())}
+			app.metrics.requests.Inc(labels...)
+			app.metrics.requestDuration.Observe(time.Since(start).Seconds(), labels...)
+		})
+	}
+}
+
+// metricsHandler serves the metrics to the clients allowed by the
+// configuration.
+func (app *application) metricsHandler() http.Handler {
+	// The networks are validated with the configuration.
+	allowed, _ := app.cfg.Metrics.AllowedPrefixes()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(allowed) > 0 && !addrAllowed(r.RemoteAddr, allowed) {
+			http.NotFound(w, r)
+			return
+		}
+		app.metrics.registry.ServeHTTP(w, r)
+	})
+}
+
+// addrAllowed reports whether the IP of a remote address belongs to one of
+// the allowed networks.
+func addrAllowed(remoteAddr string, allowed []netip.Prefix) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	ip = ip.Unmap()
+	for _, prefix := range allowed {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
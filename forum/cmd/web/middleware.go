@@ -1,29 +1,98 @@
 package main
 
-import "net/http"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"forum/internal/logging"
+	"forum/internal/models"
+	"forum/internal/trace"
+	"net/http"
+	"runtime/debug"
+	"time"
+)
 
-// logRequest logs all incoming requests.
+// logRequest identifies each request by the ID in its X-Request-ID header,
+// or a new one, and continues its trace. It attaches a logger recording both
+// to the request context, and logs the request once it completes.
 func (app *application) logRequest(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		var (
-			ip     = r.RemoteAddr
-			proto  = r.Proto
-			method = r.Method
-			uri    = r.URL.RequestURI()
+		start := time.Now()
+
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		span := trace.FromRequest(r)
+
+		logger := app.logger.With(
+			"request_id", id,
+			"trace_id", span.TraceIDString(),
+			"span_id", span.SpanIDString(),
 		)
+		ctx := context.WithValue(r.Context(), requestIDContextKey, id)
+		ctx = trace.NewContext(ctx, span)
+		ctx = logging.NewContext(ctx, logger)
 
-		app.logger.Info("received request", "ip", ip, "proto", proto, "method", method, "uri", uri)
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r.WithContext(ctx))
 
-		next.ServeHTTP(w, r)
+		logger.Info("request completed",
+			"ip", r.RemoteAddr,
+			"proto", r.Proto,
+			"method", r.Method,
+			"uri", r.URL.RequestURI(),
+			"status", sw.Status(),
+			"bytes", sw.bytes,
+			"duration", time.Since(start),
+		)
+	})
+}
+
+// recoverPanic turns a panic in a handler into a logged error with its stack
+// and a 500 error page, then closes the connection. When the response has
+// already started, the connection is aborted instead.
+func (app *application) recoverPanic(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusWriter{ResponseWriter: w}
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+			app.requestLogger(r).Error(fmt.Sprint("panic: ", v),
+				"method", r.Method,
+				"uri", r.URL.RequestURI(),
+				"stack", string(debug.Stack()),
+			)
+			if sw.status != 0 {
+				panic(http.ErrAbortHandler)
+			}
+			w.Header().Set("Connection", "close")
+			app.renderError(w, r, http.StatusInternalServerError)
+		}()
+		next.ServeHTTP(sw, r)
+	})
+}
+
+// markSessionLoaded records that the session of the request was loaded, so
+// that error pages know they can use it.
+func markSessionLoaded(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), sessionLoadedContextKey, true)
+		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
 // commonHeaders add common headers to all responses.
-func commonHeaders(next http.Handler) http.Handler {
+func (app *application) commonHeaders(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
-		w.Header().Set("Content-Security-Policy",
-			"default-src 'self'; style-src 'self' fonts.googleapis.com; font-src fonts.gstatic.com")
+		w.Header().Set("Content-Security-Policy", app.cfg.Security.ContentSecurityPolicy)
 		w.Header().Set("Referrer-Policy", "origin-when-cross-origin")
 		w.Header().Set("X-Content-Type-Options", "nosniff")
 		w.Header().Set("X-Frame-Options", "deny")
@@ -34,15 +103,89 @@ func commonHeaders(next http.Handler) http.Handler {
 	})
 }
 
+// strictTransport tells browsers to only reach the site over HTTPS from now
+// on. It is used when the server runs with TLS.
+func strictTransport(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Strict-Transport-Security", "max-age=63072000; includeSubDomains")
+		next.ServeHTTP(w, r)
+	})
+}
+
 // requireAuthentication ensures that the user is authenticated before allowing
-// access to the next handler.
+// access to the next handler. Users disabled since they logged in are logged
+// out. The user is added to the request context, for authenticatedUser.
 func (app *application) requireAuthentication(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if !app.isAuthenticated(r) {
 			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
 			return
 		}
+		userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+		user, err := app.users.GetContext(r.Context(), userID)
+		if err != nil && !errors.Is(err, models.ErrNoRecord) {
+			app.serverError(w, r, err)
+			return
+		}
+		if err != nil || user.Disabled {
+			app.sessionManager.Remove(r.Context(), "authenticatedUserID")
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+			return
+		}
 		w.Header().Add("Cache-Control", "no-store")
+		ctx := context.WithValue(r.Context(), userContextKey, user)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requireAdmin ensures that the authenticated user is an admin before
+// allowing access to the next handler. It must come after
+// requireAuthentication.
+func (app *application) requireAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := authenticatedUser(r)
+		if user == nil || user.Role != models.RoleAdmin {
+			app.clientError(w, r, http.StatusForbidden)
+			return
+		}
 		next.ServeHTTP(w, r)
 	})
 }
+
+// statusWriter records the status and size of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Status returns the status of the response. It is 200 OK when nothing was
+// written, or when the connection was hijacked.
+func (w *statusWriter) Status() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+// Unwrap lets http.ResponseController reach the Flusher and Hijacker of the
+// underlying writer.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"testing"
+
+	"forum/internal/testutil"
+
+	"github.com/justinas/alice"
+)
+
+// logBuffer collects the logs written by concurrent requests.
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestRecoverPanic(t *testing.T) {
+	ta := newTestApp(t)
+	var logs logBuffer
+	ta.logger = slog.New(slog.NewTextHandler(&logs, nil))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /before", func(w http.ResponseWriter, r *http.Request) {
+		panic("before writing")
+	})
+	mux.HandleFunc("GET /after", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("partial"))
+		http.NewResponseController(w).Flush()
+		panic("after writing")
+	})
+	srv := testutil.NewServer(t, alice.New(ta.logRequest, ta.recoverPanic).Then(mux))
+
+	// A panic before the response starts gets the error page.
+	r, err := srv.Client().Get(srv.URL + "/before")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Body.Close()
+	testutil.Equal(t, r.Close, true)
+	resp := srv.Get(t, "/before")
+	testutil.Equal(t, resp.Status, http.StatusInternalServerError)
+	testutil.Equal(t, resp.Header.Get("Content-Type"), "text/html; charset=utf-8")
+	testutil.Contains(t, resp.Body, "Something went wrong on our side")
+	testutil.Contains(t, resp.Body, "<code>"+resp.Header.Get(requestIDHeader)+"</code>")
+	testutil.Contains(t, logs.String(), `msg="panic: before writing"`)
+	testutil.Contains(t, logs.String(), "stack=")
+
+	// A panic after it started aborts the connection, so that the client
+	// does not take the partial response for a complete one.
+	r, err = srv.Client().Get(srv.URL + "/after")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Body.Close()
+	testutil.Equal(t, r.StatusCode, http.StatusOK)
+	_, err = io.ReadAll(r.Body)
+	if err == nil {
+		t.Error("read the whole response of an aborted request")
+	}
+	testutil.Contains(t, logs.String(), `msg="panic: after writing"`)
+}
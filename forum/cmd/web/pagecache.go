@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"forum/internal/models"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/justinas/alice"
+)
+
+// errUncacheable is returned by the page loader for responses that are not
+// kept, such as errors and redirects.
+var errUncacheable = errors.New("response not cacheable")
+
+// cachedPage is a response kept for anonymous visitors.
+type cachedPage struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+// pageRecorder records a response to cache it.
+type pageRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *pageRecorder) Header() http.Header {
+	return rec.header
+}
+
+func (rec *pageRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+}
+
+func (rec *pageRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	return rec.body.Write(b)
+}
+
+// cachePages serves the pages of a route to anonymous visitors from the
+// cache, for the page TTL or until the data tagged by tags changes. The
+// first visitor to miss renders the page for all those waiting for it. The
+// cached pages leave out the members online, which change all the time.
+func (app *application) cachePages(tags func(r *http.Request) []string) alice.Constructor {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if (r.Method != http.MethodGet && r.Method != http.MethodHead) ||
+				app.isAuthenticated(r) || app.sessionManager.Exists(r.Context(), "flash") {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			key := "page:" + r.URL.RequestURI()
+			var own *cachedPage
+			v, err := app.cache.GetOrLoad(key, app.cfg.Cache.PageTTL, tags(r), func() (any, error) {
+				// The page is rendered in full, whatever the caller already
+				// has, since it is shared. It is not cut short when this
+				// visitor goes away, as others may be waiting for it.
+				pr := r.Clone(context.WithValue(context.WithoutCancel(r.Context()), cachedPageContextKey, true))
+				pr.Method = http.MethodGet
+				pr.Header.Del("If-None-Match")
+
+				rec := &pageRecorder{header: http.Header{}}
+				next.ServeHTTP(rec, pr)
+				own = &cachedPage{status: rec.status, header: rec.header, body: rec.body.Bytes()}
+				if own.status != http.StatusOK {
+					return own, errUncacheable
+				}
+				return own, nil
+			})
+			if err != nil {
+				// An uncacheable response is only sent to the visitor it
+				// was rendered for; those who waited for it render their
+				// own.
+				if own != nil {
+					app.writePage(w, r, own)
+				} else {
+					next.ServeHTTP(w, r)
+				}
+				return
+			}
+			app.writePage(w, r, v.(*cachedPage))
+		})
+	}
+}
+
+// writePage sends a cached page, or 304 Not Modified when the client already
+// has it.
+func (app *application) writePage(w http.ResponseWriter, r *http.Request, page *cachedPage) {
+	h := w.Header()
+	for name, values := range page.header {
+		h[name] = slices.Clone(values)
+	}
+
+	if page.status == http.StatusOK {
+		if etag := h.Get("ETag"); etag != "" && etagMatches(r, etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	h.Set("Content-Length", strconv.Itoa(len(page.body)))
+	w.WriteHeader(page.status)
+	w.Write(page.body)
+}
+
+// clearOnExternalWrites clears the cache whenever another process, such as
+// forumctl, changes the SQLite database, checking every interval until ctx is
+// cancelled. The writes of the server invalidate what they affect already.
+func (app *application) clearOnExternalWrites(ctx context.Context, db *models.DB, interval time.Duration) {
+	last, err := db.DataVersion(ctx)
+	if err != nil {
+		app.logger.Error(err.Error())
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		version, err := db.DataVersion(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				app.logger.Error(err.Error())
+			}
+			continue
+		}
+		if version != last {
+			app.cache.Clear()
+			last = version
+		}
+	}
+}
+
+// homeTags returns the cache tags of the home page, which lists threads with
+// the avatars of their authors.
+func homeTags(r *http.Request) []string {
+	return []string{models.TagThreads, models.TagUsers}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bufio"
+	"forum/internal/models"
+	"forum/internal/presence"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// presencePingInterval is how often connections are pinged. A client
+	// that does not answer within presencePongWait is disconnected.
+	presencePingInterval = 30 * time.Second
+	presencePongWait     = 60 * time.Second
+
+	// presenceWriteTimeout bounds each write to a presence connection.
+	presenceWriteTimeout = 10 * time.Second
+
+	// presenceTypingInterval is the shortest interval between two typing
+	// events relayed for a connection. Clients sending more than
+	// presenceMaxBurst messages within it are disconnected.
+	presenceTypingInterval = 2 * time.Second
+	presenceMaxBurst       = 10
+
+	// onlineShown is the number of online members listed in the footer.
+	onlineShown = 20
+)
+
+// upgrader upgrades presence connections. Its default origin check rejects
+// pages from other sites, which would otherwise ride on the session cookie.
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// hijackWriter exposes the Hijacker of a ResponseWriter wrapped by
+// middleware, which the websocket upgrader requires.
+type hijackWriter struct {
+	http.ResponseWriter
+}
+
+func (w hijackWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
+
+// presenceMessage is a message sent by a client on a presence connection.
+type presenceMessage struct {
+	Type string `json:"type"`
+}
+
+// threadPresence follows who is viewing and typing in a thread over a
+// WebSocket. It sends the current state on connection, then every change,
+// and accepts typing events from the client.
+func (app *application) threadPresence(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		app.notFound(w, r)
+		return
+	}
+
+	exists, err := app.threads.ExistsContext(r.Context(), id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if !exists {
+		app.notFound(w, r)
+		return
+	}
+
+	user := authenticatedUser(r)
+	if user == nil {
+		app.clientError(w, r, http.StatusUnauthorized)
+		return
+	}
+
+	conn, err := upgrader.Upgrade(hijackWriter{w}, r, nil)
+	if err != nil {
+		// The upgrader has already answered with an error status.
+		return
+	}
+	defer conn.Close()
+
+	sub := app.hub.Subscribe(presence.Topic(id))
+	defer sub.Close()
+
+	snapshot := app.presence.Join(id, presence.User{ID: user.ID, Username: user.Username})
+	defer app.presence.Leave(id, user.ID)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		app.readPresence(conn, app.requestLogger(r), id, user.ID)
+	}()
+
+	conn.SetWriteDeadline(time.Now().Add(presenceWriteTimeout))
+	err = conn.WriteJSON(snapshot)
+	if err != nil {
+		return
+	}
+
+	ping := time.NewTicker(presencePingInterval)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-app.shutdown:
+			conn.WriteControl(
+				websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"),
+				time.Now().Add(presenceWriteTimeout),
+			)
+			return
+		case e, ok := <-sub.Events():
+			if !ok {
+				// Dropped for falling behind: the client reconnects and
+				// gets a fresh snapshot.
+				return
+			}
+			conn.SetWriteDeadline(time.Now().Add(presenceWriteTimeout))
+			err = conn.WriteMessage(websocket.TextMessage, e.Data)
+			if err != nil {
+				return
+			}
+		case <-ping.C:
+			err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(presenceWriteTimeout))
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// readPresence handles the messages of a client until its connection
+// closes, keeping it among the viewers of the thread as long as it answers
+// pings.
+func (app *application) readPresence(conn *websocket.Conn, logger *slog.Logger, threadID, userID int) {
+	conn.SetReadLimit(512)
+	conn.SetReadDeadline(time.Now().Add(presencePongWait))
+	conn.SetPongHandler(func(string) error {
+		app.presence.Touch(threadID, userID)
+		return conn.SetReadDeadline(time.Now().Add(presencePongWait))
+	})
+
+	var lastTyping, burstStart time.Time
+	burst := 0
+	for {
+		var msg presenceMessage
+		err := conn.ReadJSON(&msg)
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				logger.Debug("presence connection closed", "error", err)
+			}
+			return
+		}
+
+		now := time.Now()
+		if now.Sub(burstStart) > presenceTypingInterval {
+			burstStart, burst = now, 0
+		}
+		burst++
+		if burst > presenceMaxBurst {
+			conn.WriteControl(
+				websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "too many messages"),
+				now.Add(presenceWriteTimeout),
+			)
+			return
+		}
+
+		if msg.Type == presence.MessageTyping && now.Sub(lastTyping) >= presenceTypingInterval {
+			lastTyping = now
+			app.presence.Typing(threadID, userID)
+		}
+	}
+}
+
+// onlineSummary returns up to onlineShown of the members online, and how
+// many there are in total.
+func (app *application) onlineSummary() ([]presence.User, int) {
+	online := app.presence.Online()
+	if len(online) > onlineShown {
+		return online[:onlineShown], len(online)
+	}
+	return online, len(online)
+}
+
+// seenUser records the authenticated user as online and returns their
+// account, as loaded by requireAuthentication on the routes requiring it,
+// or from the database on the others.
+func (app *application) seenUser(r *http.Request, userID int) (*models.User, error) {
+	user := authenticatedUser(r)
+	if user == nil {
+		var err error
+		user, err = app.users.GetContext(r.Context(), userID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	app.presence.Seen(presence.User{ID: user.ID, Username: user.Username})
+	return user, nil
+}
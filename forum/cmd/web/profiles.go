@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"forum/internal/models"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"forum/internal/validator"
+)
+
+type profileEditForm struct {
+	Bio          string
+	Location     string
+	Website      string
+	HideActivity bool
+	validator.Validator
+}
+
+// profileView shows the public profile of a user and a page of their recent
+// activity, unless they chose to hide it. Only signed-in users see excerpts
+// of the posts, as only they can read the threads.
+func (app *application) profileView(w http.ResponseWriter, r *http.Request) {
+	profile, err := app.users.GetProfileContext(r.Context(), r.PathValue("username"))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	page := 1
+	if p := r.URL.Query().Get("page"); p != "" {
+		page, err = strconv.Atoi(p)
+		if err != nil || page < 1 {
+			app.notFound(w, r)
+			return
+		}
+	}
+
+	data := app.newTemplateData(r)
+	data.Profile = profile
+	data.Page = page
+	data.IsOwnProfile = app.sessionManager.GetInt(r.Context(), "authenticatedUserID") == profile.ID
+
+	if !profile.HideActivity {
+		data.Feeds = append(data.Feeds, feedLink{
+			Title: "Activity of " + profile.Username,
+			Path:  "/user/" + url.PathEscape(profile.Username),
+		})
+	}
+
+	if !profile.HideActivity || data.IsOwnProfile {
+		pageSize := app.cfg.Limits.ActivityPageSize
+		activity, err := app.users.ActivityContext(r.Context(), profile.ID, pageSize+1, (page-1)*pageSize)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		if len(activity) > pageSize {
+			activity = activity[:pageSize]
+			data.NextPage = page + 1
+		}
+		data.Activity = activity
+		data.PrevPage = page - 1
+	}
+
+	app.render(w, r, http.StatusOK, "profile-view", data)
+}
+
+// profileEdit shows a form to edit the public profile of the current user.
+func (app *application) profileEdit(w http.ResponseWriter, r *http.Request) {
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	profile, err := app.users.GetProfileByIDContext(r.Context(), userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Profile = profile
+	data.Form = profileEditForm{
+		Bio:          profile.Bio,
+		Location:     profile.Location,
+		Website:      profile.Website,
+		HideActivity: profile.HideActivity,
+	}
+	app.render(w, r, http.StatusOK, "profile-edit", data)
+}
+
+// profileEditPOST updates the public profile of the current user with the
+// info in the POST request.
+func (app *application) profileEditPOST(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	form := profileEditForm{
+		Bio:          strings.TrimSpace(r.PostForm.Get("bio")),
+		Location:     strings.TrimSpace(r.PostForm.Get("location")),
+		Website:      strings.TrimSpace(r.PostForm.Get("website")),
+		HideActivity: r.PostForm.Get("hide_activity") == "1",
+	}
+
+	form.CheckField(validator.MaxChars(form.Bio, 500), "bio", "This field cannot be more than 500 characters long")
+	form.CheckField(validator.MaxChars(form.Location, 100), "location", "This field cannot be more than 100 characters long")
+	form.CheckField(validator.MaxChars(form.Website, 200), "website", "This field cannot be more than 200 characters long")
+	if form.Website != "" {
+		form.CheckField(validator.ValidateURL(form.Website), "website", "This field must be an http or https address")
+	}
+
+	userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+	profile, err := app.users.GetProfileByIDContext(r.Context(), userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Profile = profile
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "profile-edit", data)
+		return
+	}
+
+	err = app.users.UpdateProfileContext(r.Context(), userID, form.Bio, form.Location, form.Website, form.HideActivity)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Your profile has been updated.")
+	http.Redirect(w, r, "/u/"+url.PathEscape(profile.Username), http.StatusSeeOther)
+}
@@ -10,27 +10,69 @@ import (
 func (app *application) routes() http.Handler {
 	mux := http.NewServeMux()
 
-	dynamic := alice.New(app.sessionManager.LoadAndSave)
+	dynamic := alice.New(app.sessionManager.LoadAndSave, markSessionLoaded)
 
-	mux.Handle("GET /{$}", dynamic.ThenFunc(http.HandlerFunc(app.home)))
+	mux.Handle("GET /{$}", dynamic.Append(app.cachePages(homeTags)).ThenFunc(app.home))
 	mux.Handle("GET /account/create", dynamic.ThenFunc(app.accountCreate))
 	mux.Handle("POST /account/create", dynamic.ThenFunc(app.accountCreatePOST))
 	mux.Handle("GET /user/login", dynamic.ThenFunc(app.userLogin))
 	mux.Handle("POST /user/login", dynamic.ThenFunc(app.userLoginPost))
+	mux.Handle("GET /u/{username}", dynamic.ThenFunc(app.profileView))
+	mux.Handle("GET /avatar/{id}/{version}/{size}", http.HandlerFunc(app.avatarView))
+	mux.Handle("GET /feed/{format}", http.HandlerFunc(app.siteFeed))
+	mux.Handle("GET /feed/{format}/thread/{id}", http.HandlerFunc(app.threadFeed))
+	mux.Handle("GET /feed/{format}/user/{username}", http.HandlerFunc(app.userFeed))
 
 	protected := dynamic.Append(app.requireAuthentication)
 
 	mux.Handle("POST /user/logout", protected.ThenFunc(app.userLogoutPost))
 	mux.Handle("GET /account/view/{id}", protected.ThenFunc(app.accountView))
+	mux.Handle("GET /account/profile", protected.ThenFunc(app.profileEdit))
+	mux.Handle("POST /account/profile", protected.ThenFunc(app.profileEditPOST))
+	mux.Handle("POST /account/avatar", protected.ThenFunc(app.avatarUploadPOST))
+	mux.Handle("POST /account/avatar/delete", protected.ThenFunc(app.avatarDeletePOST))
 	mux.Handle("GET /thread/create", protected.ThenFunc(app.threadCreate))
 	mux.Handle("POST /thread/create", protected.ThenFunc(app.threadCreatePOST))
 	mux.Handle("GET /thread/view/{id}", protected.ThenFunc(app.threadView))
+	mux.Handle("GET /thread/view/{id}/unread", protected.ThenFunc(app.threadUnread))
+	mux.Handle("GET /thread/view/{id}/events", protected.ThenFunc(app.threadEvents))
+	mux.Handle("GET /thread/view/{id}/presence", protected.ThenFunc(app.threadPresence))
+	mux.Handle("POST /thread/read-all", protected.ThenFunc(app.threadReadAllPOST))
+	mux.Handle("GET /search", protected.ThenFunc(app.postSearch))
 	mux.Handle("GET /thread/view/{id}/post/create", protected.ThenFunc(app.postCreate))
 	mux.Handle("POST /thread/view/{id}/post/create", protected.ThenFunc(app.postCreatePOST))
+	mux.Handle("GET /attachment/{id}", protected.ThenFunc(app.attachmentView))
+	mux.Handle("GET /conversation/inbox", protected.ThenFunc(app.conversationInbox))
+	mux.Handle("GET /conversation/create", protected.ThenFunc(app.conversationCreate))
+	mux.Handle("POST /conversation/create", protected.ThenFunc(app.conversationCreatePOST))
+	mux.Handle("GET /conversation/view/{id}", protected.ThenFunc(app.conversationView))
+	mux.Handle("POST /conversation/view/{id}/reply", protected.ThenFunc(app.conversationReplyPOST))
+	mux.Handle("POST /conversation/view/{id}/archive", protected.ThenFunc(app.conversationArchivePOST))
+	mux.Handle("POST /conversation/view/{id}/leave", protected.ThenFunc(app.conversationLeavePOST))
+	mux.Handle("POST /user/block", protected.ThenFunc(app.userBlockPOST))
+	mux.Handle("POST /user/unblock", protected.ThenFunc(app.userUnblockPOST))
 
-	fileServer := http.FileServer(http.Dir("./ui/static/"))
-	mux.Handle("GET /static/", http.StripPrefix("/static", fileServer))
+	admin := protected.Append(app.requireAdmin)
 
-	standard := alice.New(app.logRequest, commonHeaders)
+	mux.Handle("GET /admin/webhooks", admin.ThenFunc(app.webhookList))
+	mux.Handle("POST /admin/webhooks", admin.ThenFunc(app.webhookCreatePOST))
+	mux.Handle("GET /admin/webhooks/{id}", admin.ThenFunc(app.webhookView))
+	mux.Handle("POST /admin/webhooks/{id}/active", admin.ThenFunc(app.webhookActivePOST))
+	mux.Handle("POST /admin/webhooks/{id}/delete", admin.ThenFunc(app.webhookDeletePOST))
+	mux.Handle("POST /admin/webhooks/{id}/deliveries/{delivery}/redeliver", admin.ThenFunc(app.webhookRedeliverPOST))
+
+	mux.Handle("GET /static/", app.static)
+
+	// Paths matching no other route get the 404 page, with the session.
+	mux.Handle("/", dynamic.ThenFunc(app.notFound))
+
+	if app.cfg.Metrics.Addr == "" {
+		mux.Handle("GET /metrics", app.metricsHandler())
+	}
+
+	standard := alice.New(app.instrument(mux), app.logRequest, app.recoverPanic, app.commonHeaders, app.compress)
+	if app.cfg.Server.UseTLS() {
+		standard = standard.Append(strictTransport)
+	}
 	return standard.Then(mux)
 }
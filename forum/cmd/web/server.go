@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"os/signal"
+	"syscall"
+)
+
+// tlsConfig restricts TLS to modern versions, curves and AEAD cipher
+// suites. TLS 1.3 suites are not configurable and all are safe.
+var tlsConfig = &tls.Config{
+	MinVersion:       tls.VersionTLS12,
+	CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256},
+	CipherSuites: []uint16{
+		tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+		tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+	},
+}
+
+// runWorker runs fn in the background until ctx is cancelled. serve waits
+// for every worker to return before exiting.
+func (app *application) runWorker(ctx context.Context, fn func(context.Context)) {
+	app.workers.Add(1)
+	go func() {
+		defer app.workers.Done()
+		fn(ctx)
+	}()
+}
+
+// serve runs the application until it receives SIGINT or SIGTERM. It then
+// stops accepting connections, lets in-flight requests complete within the
+// shutdown timeout, closes the event streams and stops the workers started
+// with the stopWorkers context.
+func (app *application) serve(stopWorkers context.CancelFunc) error {
+	cfg := app.cfg.Server
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	errorLog := slog.NewLogLogger(app.logger.Handler(), slog.LevelError)
+	srv := &http.Server{
+		Addr:              cfg.Addr,
+		Handler:           app.routes(),
+		ErrorLog:          errorLog,
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+	}
+	// Event streams outlive any shutdown timeout, so they are told to close
+	// as soon as the shutdown starts.
+	srv.RegisterOnShutdown(func() { close(app.shutdown) })
+
+	servers := []*http.Server{srv}
+	if cfg.UseTLS() {
+		srv.TLSConfig = tlsConfig
+		if cfg.RedirectAddr != "" {
+			servers = append(servers, &http.Server{
+				Addr:              cfg.RedirectAddr,
+				Handler:           httpsRedirect(cfg.Addr),
+				ErrorLog:          errorLog,
+				ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+				ReadTimeout:       cfg.ReadTimeout,
+				WriteTimeout:      cfg.WriteTimeout,
+				IdleTimeout:       cfg.IdleTimeout,
+			})
+		}
+	}
+	if addr := app.cfg.Metrics.Addr; addr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("GET /metrics", app.metricsHandler())
+		servers = append(servers, &http.Server{
+			Addr:              addr,
+			Handler:           mux,
+			ErrorLog:          errorLog,
+			ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+			ReadTimeout:       cfg.ReadTimeout,
+			WriteTimeout:      cfg.WriteTimeout,
+			IdleTimeout:       cfg.IdleTimeout,
+		})
+	}
+
+	serveErr := make(chan error, len(servers))
+	for _, s := range servers {
+		go func() {
+			var err error
+			if s == srv && cfg.UseTLS() {
+				app.logger.Info("Starting server", "addr", s.Addr, "tls", true)
+				err = s.ListenAndServeTLS(cfg.TLSCert, cfg.TLSKey)
+			} else {
+				app.logger.Info("Starting server", "addr", s.Addr)
+				err = s.ListenAndServe()
+			}
+			if !errors.Is(err, http.ErrServerClosed) {
+				serveErr <- err
+			}
+		}()
+	}
+
+	var err error
+	select {
+	case err = <-serveErr:
+	case <-ctx.Done():
+		app.logger.Info("Shutting down", "timeout", cfg.ShutdownTimeout)
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	for _, s := range servers {
+		shutdownErr := s.Shutdown(shutdownCtx)
+		if shutdownErr != nil && err == nil {
+			err = shutdownErr
+		}
+	}
+
+	stopWorkers()
+	app.workers.Wait()
+	app.logger.Info("Stopped")
+	return err
+}
+
+// httpsRedirect redirects every request to the same URL over HTTPS, on the
+// port of tlsAddr.
+func httpsRedirect(tlsAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(tlsAddr)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		target := "https://" + host + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+	})
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"forum/internal/testutil"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// freeAddr returns a local address nothing listens on.
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+func TestServeShutdown(t *testing.T) {
+	ta := newTestApp(t)
+	ta.signup(t, ta.srv, "alice")
+	id := ta.createThread(t, ta.srv, "Shutdown")
+	cookie := "session=" + ta.srv.Cookie(t, "session")
+
+	addr := freeAddr(t)
+	ta.cfg.Server.Addr = addr
+	ta.cfg.Server.ShutdownTimeout = 5 * time.Second
+	ta.cfg.Metrics.Addr = ""
+
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	workerStopped := make(chan struct{})
+	ta.runWorker(workerCtx, func(ctx context.Context) {
+		<-ctx.Done()
+		close(workerStopped)
+	})
+	served := make(chan error, 1)
+	go func() { served <- ta.serve(stopWorkers) }()
+
+	// Wait for the server to accept connections.
+	var conn net.Conn
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var err error
+		conn, err = net.Dial("tcp", addr)
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	defer conn.Close()
+
+	req, err := http.NewRequest(http.MethodGet, "http://"+addr+"/thread/view/"+strconv.Itoa(id)+"/events?after=0", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Cookie", cookie)
+	stream, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Body.Close()
+	testutil.Equal(t, stream.StatusCode, http.StatusOK)
+	line, err := bufio.NewReader(stream.Body).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.Equal(t, line, "retry: 3000\n")
+
+	// Start a request that is still in flight when the shutdown starts: the
+	// server asks for its body once the handler reads it.
+	body := "email=alice%40example.com&password=" + url.QueryEscape(testPassword)
+	_, err = io.WriteString(conn, "POST /user/login HTTP/1.1\r\n"+
+		"Host: "+addr+"\r\n"+
+		"Cookie: "+cookie+"\r\n"+
+		"Content-Type: application/x-www-form-urlencoded\r\n"+
+		"Content-Length: "+strconv.Itoa(len(body))+"\r\n"+
+		"Expect: 100-continue\r\n\r\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := bufio.NewReader(conn)
+	line, err = r.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.Equal(t, line, "HTTP/1.1 100 Continue\r\n")
+	_, err = r.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Signal(syscall.SIGTERM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-ta.shutdown:
+	case <-time.After(5 * time.Second):
+		t.Fatal("shutdown did not start")
+	}
+
+	// New connections are refused, event streams end and the request in
+	// flight completes.
+	_, err = net.Dial("tcp", addr)
+	if err == nil {
+		t.Error("connected after the shutdown started")
+	}
+	rest, err := io.ReadAll(stream.Body)
+	if err != nil {
+		t.Errorf("reading the event stream: %v", err)
+	}
+	if strings.Contains(string(rest), "event:") {
+		t.Errorf("got events %q; want none", rest)
+	}
+
+	_, err = io.WriteString(conn, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	testutil.Equal(t, resp.StatusCode, http.StatusSeeOther)
+	testutil.Equal(t, resp.Close, true)
+
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("serve did not return")
+	}
+	select {
+	case <-workerStopped:
+	default:
+		t.Error("serve returned before its workers")
+	}
+}
+
+func TestHTTPSRedirect(t *testing.T) {
+	for _, tt := range []struct {
+		tlsAddr string
+		target  string
+		want    string
+	}{
+		{":443", "http://example.com/thread/view/1?page=2", "https://example.com/thread/view/1?page=2"},
+		{":8443", "http://example.com:8080/", "https://example.com:8443/"},
+		{"", "http://example.com/", "https://example.com/"},
+	} {
+		rec := httptest.NewRecorder()
+		httpsRedirect(tt.tlsAddr).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.target, nil))
+		testutil.Equal(t, rec.Code, http.StatusMovedPermanently)
+		testutil.Equal(t, rec.Header().Get("Location"), tt.want)
+	}
+}
@@ -2,65 +2,197 @@ package main
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"forum/internal/assets"
 	"forum/internal/models"
+	"forum/internal/presence"
+	"html/template"
+	"io/fs"
 	"net/http"
-	"path/filepath"
-	"text/template"
+	"net/url"
+	"path"
+	"sync"
 	"time"
+	"unicode/utf8"
 )
 
 // templateData holds data to be passed to templates.
 type templateData struct {
-	CurrentYear     int
-	Thread          *models.Thread
-	Threads         []*models.Thread
-	ThreadID        int
-	User            *models.User
-	Form            any
-	Flash           string
-	IsAuthenticated bool
+	CurrentYear         int
+	Thread              *models.Thread
+	Threads             []*models.Thread
+	ThreadID            int
+	User                *models.User
+	Conversation        *models.Conversation
+	Conversations       []*models.Conversation
+	ShowArchived        bool
+	Blocked             []*models.User
+	UnreadConversations int
+	Profile             *models.Profile
+	Activity            []*models.Activity
+	Search              string
+	SearchResults       []*models.SearchResult
+	IsOwnProfile        bool
+	Page                int
+	PrevPage            int
+	NextPage            int
+	Feeds               []feedLink
+	Webhook             *models.Webhook
+	Webhooks            []*models.Webhook
+	WebhookEvents       []string
+	Deliveries          []*models.WebhookDelivery
+	IsAdmin             bool
+	Error               *errorPage
+	RequestID           string
+	Online              []presence.User
+	OnlineCount         int
+	Form                any
+	Flash               string
+	IsAuthenticated     bool
 }
 
 // newTemplateData returns a new templateData.
 func (app *application) newTemplateData(r *http.Request) templateData {
-	return templateData{
+	data := templateData{
 		CurrentYear:     time.Now().Year(),
 		Flash:           app.sessionManager.PopString(r.Context(), "flash"),
 		IsAuthenticated: app.isAuthenticated(r),
+		Feeds:           []feedLink{siteFeedLink},
 	}
+
+	if data.IsAuthenticated {
+		userID := app.sessionManager.GetInt(r.Context(), "authenticatedUserID")
+		n, err := app.conversations.UnreadCountContext(r.Context(), userID)
+		if err != nil {
+			app.requestLogger(r).Error(err.Error(), "method", r.Method, "uri", r.URL.RequestURI())
+		}
+		data.UnreadConversations = n
+
+		user, err := app.seenUser(r, userID)
+		if err != nil {
+			app.requestLogger(r).Error(err.Error(), "method", r.Method, "uri", r.URL.RequestURI())
+		} else {
+			data.IsAdmin = user.Role == models.RoleAdmin
+		}
+	}
+
+	// The members online change too often to be kept in the cached pages.
+	if cached, _ := r.Context().Value(cachedPageContextKey).(bool); !cached {
+		data.Online, data.OnlineCount = app.onlineSummary()
+	}
+	return data
+}
+
+// templateFunctions returns the custom functions available in templates.
+// asset maps the logical name of a static file to its URL.
+func templateFunctions(static *assets.Pipeline) template.FuncMap {
+	return template.FuncMap{
+		"pathEscape": url.PathEscape,
+		"truncate":   truncate,
+		"avatar":     avatarURL,
+		"asset":      static.Path,
+	}
+}
+
+// truncate returns s cut to its first n characters, followed by an ellipsis
+// if it was any longer.
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n]) + "..."
 }
 
-// newTemplateCache parses all templates, and returns a map of
-// template.Template.
-func newTemplateCache() (map[string]*template.Template, error) {
+// newTemplateCache parses all templates of the ui file system, and returns a
+// map of template.Template.
+func newTemplateCache(ui fs.FS, static *assets.Pipeline) (map[string]*template.Template, error) {
 	cache := map[string]*template.Template{}
-	pages, err := filepath.Glob("./ui/html/pages/*.html")
+	pages, err := fs.Glob(ui, "html/pages/*.html")
 	if err != nil {
 		return nil, err
 	}
 	for _, page := range pages {
-		name := filepath.Base(page)
-		ts, err := template.ParseFiles("./ui/html/base.html")
+		name := path.Base(page)
+		ts, err := template.New(name).Funcs(templateFunctions(static)).ParseFS(ui, "html/base.html", "html/partials/*.html", page)
 		if err != nil {
 			return nil, err
 		}
+		cache[name] = ts
+	}
+	return cache, nil
+}
+
+// templateReloader parses the templates again whenever one of them changes.
+// It lets templates be edited without restarting the server, in dev mode.
+type templateReloader struct {
+	ui      fs.FS
+	static  *assets.Pipeline
+	mu      sync.Mutex
+	modTime time.Time
+	cache   map[string]*template.Template
+}
+
+// newTemplateReloader returns a reloader of the templates of the ui file
+// system.
+func newTemplateReloader(ui fs.FS, static *assets.Pipeline) *templateReloader {
+	return &templateReloader{ui: ui, static: static}
+}
+
+// load returns the templates, parsed again if any changed since the last
+// call.
+func (t *templateReloader) load() (map[string]*template.Template, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
 
-		ts, err = ts.ParseGlob("./ui/html/partials/*.html")
+	var latest time.Time
+	err := fs.WalkDir(t.ui, "html", func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
-			return nil, err
+			return err
 		}
-
-		ts, err = ts.ParseFiles(page)
+		info, err := d.Info()
 		if err != nil {
-			return nil, err
+			return err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
 		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if t.cache != nil && !latest.After(t.modTime) {
+		return t.cache, nil
+	}
 
-		cache[name] = ts
+	cache, err := newTemplateCache(t.ui, t.static)
+	if err != nil {
+		return nil, err
 	}
+	t.cache, t.modTime = cache, latest
 	return cache, nil
 }
 
+// template returns the template set of a page, parsed again in dev mode if
+// it changed.
+func (app *application) template(page string) (*template.Template, error) {
+	cache := app.templateCache
+	if app.templateReloader != nil {
+		var err error
+		cache, err = app.templateReloader.load()
+		if err != nil {
+			return nil, err
+		}
+	}
+	ts, ok := cache[page+".html"]
+	if !ok {
+		return nil, fmt.Errorf("the template %s does not exist", page)
+	}
+	return ts, nil
+}
+
 // render renders the template with the given page name.
 func (app *application) render(
 	w http.ResponseWriter,
@@ -69,20 +201,49 @@ func (app *application) render(
 	page string,
 	data templateData,
 ) {
-	ts, ok := app.templateCache[page+".html"]
-	if !ok {
-		err := fmt.Errorf("the template %s does not exist", page)
+	ts, err := app.template(page)
+	if err != nil {
 		app.serverError(w, r, err)
 		return
 	}
 
+	start := time.Now()
 	buf := new(bytes.Buffer)
-	err := ts.ExecuteTemplate(buf, "base", data)
+	err = ts.ExecuteTemplate(buf, "base", data)
+	app.metrics.renderDuration.Observe(time.Since(start).Seconds(), page)
 	if err != nil {
 		app.serverError(w, r, err)
 		return
 	}
 
+	// The weak ETag lets clients revalidate pages they already have.
+	sum := sha256.Sum256(buf.Bytes())
+	etag := `W/"` + hex.EncodeToString(sum[:16]) + `"`
+	w.Header().Set("ETag", etag)
+	if status == http.StatusOK && etagMatches(r, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	w.WriteHeader(status)
 	buf.WriteTo(w)
 }
+
+// renderPartial executes the named template from the set of the given page,
+// for responses that carry a fragment of a page rather than a whole one.
+func (app *application) renderPartial(page, name string, data any) ([]byte, error) {
+	ts, err := app.template(page)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	buf := new(bytes.Buffer)
+	err = ts.ExecuteTemplate(buf, name, data)
+	app.metrics.renderDuration.Observe(time.Since(start).Seconds(), page+"/"+name)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
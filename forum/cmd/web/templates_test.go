@@ -0,0 +1,97 @@
+package main
+
+import (
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"forum/internal/testutil"
+	"forum/ui"
+)
+
+// copyTemplates copies the embedded templates to a temporary directory, and
+// returns it.
+func copyTemplates(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	err := fs.WalkDir(ui.Files, "html", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return os.MkdirAll(filepath.Join(dir, path), 0o755)
+		}
+		b, err := fs.ReadFile(ui.Files, path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(filepath.Join(dir, path), b, 0o644)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestTemplateReloader(t *testing.T) {
+	ta := newTestApp(t)
+	dir := copyTemplates(t)
+	ta.templateReloader = newTemplateReloader(os.DirFS(dir), ta.static)
+
+	first, err := ta.templateReloader.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := ta.templateReloader.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again["error.html"] != first["error.html"] {
+		t.Error("templates parsed again without any change")
+	}
+
+	resp := ta.srv.Get(t, "/nowhere")
+	testutil.Contains(t, resp.Body, "Back to the home page")
+
+	// An edited template is used by the next request, without a restart.
+	page := filepath.Join(dir, "html", "pages", "error.html")
+	b, err := os.ReadFile(page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edited := strings.Replace(string(b), "Back to the home page", "Return home", 1)
+	err = os.WriteFile(page, []byte(edited), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	err = os.Chtimes(page, later, later)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp = ta.srv.Get(t, "/nowhere")
+	testutil.Equal(t, resp.Status, http.StatusNotFound)
+	testutil.Contains(t, resp.Body, "Return home")
+	testutil.NotContains(t, resp.Body, "Back to the home page")
+
+	// A template that no longer parses fails the request instead of
+	// keeping the old templates.
+	err = os.WriteFile(page, []byte(`{{define "main"}}{{end`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	later = later.Add(time.Minute)
+	err = os.Chtimes(page, later, later)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ta.templateReloader.load()
+	if err == nil {
+		t.Error("loaded a template that does not parse")
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"forum/internal/assets"
+	"forum/internal/avatar"
+	"forum/internal/blob"
+	"forum/internal/cache"
+	"forum/internal/config"
+	"forum/internal/models/memory"
+	"forum/internal/presence"
+	"forum/internal/pubsub"
+	"forum/internal/testutil"
+	"forum/internal/webhook"
+	"forum/ui"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"testing"
+
+	"github.com/alexedwards/scs/v2"
+)
+
+// testApp is an application backed by in-memory models, served by a test
+// server.
+type testApp struct {
+	*application
+	db      *memory.DB
+	users   *memory.UserModel
+	threads *memory.ThreadModel
+	srv     *testutil.Server
+}
+
+// newTestApp returns an application with the default configuration, storing
+// its data in memory and its files in temporary directories.
+func newTestApp(t *testing.T) *testApp {
+	t.Helper()
+	cfg := config.Default()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	staticFiles, err := fs.Sub(ui.Files, "static")
+	if err != nil {
+		t.Fatal(err)
+	}
+	static, err := assets.New(staticFiles, "/static")
+	if err != nil {
+		t.Fatal(err)
+	}
+	templateCache, err := newTemplateCache(ui.Files, static)
+	if err != nil {
+		t.Fatal(err)
+	}
+	avatarStore, err := avatar.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	blobs, err := blob.NewDiskStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := memory.New()
+	pageCache := cache.NewLRU(cfg.Cache.MaxEntries)
+	hub := pubsub.NewMemoryHub(16)
+	threads := memory.NewThreadModel(db)
+	threads.Cache = pageCache
+	posts := memory.NewPostModel(db)
+	posts.Hub = hub
+	posts.Cache = pageCache
+	users := memory.NewUserModel(db)
+	users.Cache = pageCache
+	webhooks := memory.NewWebhookModel(db)
+	tracker := presence.NewTracker(hub)
+	sessionManager := scs.New()
+
+	app := &application{
+		cfg:            cfg,
+		logger:         logger,
+		db:             db,
+		threads:        threads,
+		users:          users,
+		posts:          posts,
+		reads:          memory.NewReadModel(db),
+		conversations:  memory.NewConversationModel(db),
+		blocks:         memory.NewBlockModel(db),
+		avatars:        avatarStore,
+		attachments:    memory.NewAttachmentModel(db),
+		blobs:          blobs,
+		webhooks:       webhooks,
+		dispatcher:     webhook.NewDispatcher(webhooks, logger),
+		metrics:        newAppMetrics(sessionManager.Store, func() int { return len(tracker.Online()) }, pageCache.Stats),
+		cache:          pageCache,
+		hub:            hub,
+		presence:       tracker,
+		static:         static,
+		templateCache:  templateCache,
+		sessionManager: sessionManager,
+		shutdown:       make(chan struct{}),
+	}
+	static.NotFound = http.HandlerFunc(app.notFound)
+	return &testApp{
+		application: app,
+		db:          db,
+		users:       users,
+		threads:     threads,
+		srv:         testutil.NewServer(t, app.routes()),
+	}
+}
+
+// testPassword is the password of the accounts created by signup.
+const testPassword = "Passw0rd!"
+
+// signup creates an account with the given username through the signup form,
+// logging srv in, and returns its id.
+func (ta *testApp) signup(t *testing.T, srv *testutil.Server, username string) int {
+	t.Helper()
+	resp := srv.PostForm(t, "/account/create", url.Values{
+		"username": {username},
+		"email":    {username + "@example.com"},
+		"password": {testPassword},
+	})
+	id, ok := redirectID(resp.Location(), `^/account/view/(\d+)$`)
+	if !ok {
+		t.Fatalf("signup of %s: got %d to %q", username, resp.Status, resp.Location())
+	}
+	return id
+}
+
+// createThread creates a thread through the form, as the user logged in on
+// srv, and returns its id.
+func (ta *testApp) createThread(t *testing.T, srv *testutil.Server, title string) int {
+	t.Helper()
+	resp := srv.PostForm(t, "/thread/create", url.Values{"title": {title}})
+	id, ok := redirectID(resp.Location(), `^/thread/view/(\d+)$`)
+	if !ok {
+		t.Fatalf("creating thread %q: got %d to %q", title, resp.Status, resp.Location())
+	}
+	return id
+}
+
+// redirectID extracts the id captured by pattern from the location of a
+// redirect.
+func redirectID(location, pattern string) (int, bool) {
+	m := regexp.MustCompile(pattern).FindStringSubmatch(location)
+	if m == nil {
+		return 0, false
+	}
+	id, err := strconv.Atoi(m[1])
+	return id, err == nil
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"forum/internal/models"
+	"forum/internal/validator"
+	"forum/internal/webhook"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// webhookDeliveriesShown is the number of deliveries listed on a webhook's
+// page.
+const webhookDeliveriesShown = 50
+
+type webhookForm struct {
+	URL    string
+	Secret string
+	Events map[string]bool
+	validator.Validator
+}
+
+// eventUser is the JSON representation of a user in webhook payloads.
+type eventUser struct {
+	ID       int    `json:"id"`
+	Username string `json:"username"`
+}
+
+// threadEvent is the data of a thread.created event.
+type threadEvent struct {
+	ID     int       `json:"id"`
+	Title  string    `json:"title"`
+	URL    string    `json:"url"`
+	Author eventUser `json:"author"`
+}
+
+// postEvent is the data of a post.created event.
+type postEvent struct {
+	ID       int       `json:"id"`
+	ThreadID int       `json:"thread_id"`
+	Body     string    `json:"body"`
+	URL      string    `json:"url"`
+	Author   eventUser `json:"author"`
+}
+
+// userEvent is the data of a user.created event.
+type userEvent struct {
+	eventUser
+	URL string `json:"url"`
+}
+
+// emit queues event for delivery to the subscribed webhooks. It must be
+// called in the unit of work of the action raising event, so that the event
+// is queued if and only if the action is saved. It returns the number of
+// deliveries queued, for which the dispatcher is notified once the unit of
+// work commits.
+func (app *application) emit(ctx context.Context, event string, data any) (int, error) {
+	payload, err := webhook.Payload(event, data)
+	if err != nil {
+		return 0, err
+	}
+	return app.webhooks.EnqueueContext(ctx, event, payload)
+}
+
+// eventAuthor returns the current user for an event payload, or an empty
+// user outside of the routes requiring authentication.
+func eventAuthor(r *http.Request) eventUser {
+	user := authenticatedUser(r)
+	if user == nil {
+		return eventUser{}
+	}
+	return eventUser{ID: user.ID, Username: user.Username}
+}
+
+// webhookList shows the registered webhooks and a form to add one.
+func (app *application) webhookList(w http.ResponseWriter, r *http.Request) {
+	webhooks, err := app.webhooks.AllContext(r.Context())
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Webhooks = webhooks
+	data.WebhookEvents = webhook.Events
+	data.Form = webhookForm{Events: map[string]bool{}}
+	app.render(w, r, http.StatusOK, "admin-webhooks", data)
+}
+
+// webhookCreatePOST registers a webhook with the info in the POST request. A
+// random secret is generated when none is given.
+func (app *application) webhookCreatePOST(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	form := webhookForm{
+		URL:    strings.TrimSpace(r.PostForm.Get("url")),
+		Secret: strings.TrimSpace(r.PostForm.Get("secret")),
+		Events: map[string]bool{},
+	}
+	var events []string
+	for _, event := range r.PostForm["events"] {
+		if slices.Contains(webhook.Events, event) && !form.Events[event] {
+			form.Events[event] = true
+			events = append(events, event)
+		}
+	}
+
+	form.CheckField(validator.NotBlank(form.URL), "url", "This field cannot be blank")
+	form.CheckField(validator.ValidateURL(form.URL), "url", "This field must be an http or https address")
+	form.CheckField(validator.MaxChars(form.URL, 500), "url", "This field cannot be more than 500 characters long")
+	form.CheckField(validator.MaxChars(form.Secret, 200), "secret", "This field cannot be more than 200 characters long")
+	form.CheckField(len(events) > 0, "events", "Choose at least one event")
+
+	if !form.Valid() {
+		webhooks, err := app.webhooks.AllContext(r.Context())
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		data := app.newTemplateData(r)
+		data.Webhooks = webhooks
+		data.WebhookEvents = webhook.Events
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "admin-webhooks", data)
+		return
+	}
+
+	if form.Secret == "" {
+		b := make([]byte, 32)
+		_, err = rand.Read(b)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		form.Secret = hex.EncodeToString(b)
+	}
+
+	id, err := app.webhooks.InsertContext(r.Context(), form.URL, form.Secret, events)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Webhook successfully created!")
+	http.Redirect(w, r, fmt.Sprintf("/admin/webhooks/%d", id), http.StatusSeeOther)
+}
+
+// webhookView shows a webhook and its latest deliveries.
+func (app *application) webhookView(w http.ResponseWriter, r *http.Request) {
+	hook, ok := app.webhookFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	deliveries, err := app.webhooks.DeliveriesContext(r.Context(), hook.ID, webhookDeliveriesShown)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Webhook = hook
+	data.Deliveries = deliveries
+	app.render(w, r, http.StatusOK, "admin-webhook-view", data)
+}
+
+// webhookActivePOST enables or disables a webhook.
+func (app *application) webhookActivePOST(w http.ResponseWriter, r *http.Request) {
+	hook, ok := app.webhookFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	active := r.PostForm.Get("active") == "1"
+	err = app.webhooks.SetActiveContext(r.Context(), hook.ID, active)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	flash := "Webhook disabled."
+	if active {
+		flash = "Webhook enabled."
+	}
+	app.sessionManager.Put(r.Context(), "flash", flash)
+	http.Redirect(w, r, fmt.Sprintf("/admin/webhooks/%d", hook.ID), http.StatusSeeOther)
+}
+
+// webhookDeletePOST removes a webhook and its delivery log.
+func (app *application) webhookDeletePOST(w http.ResponseWriter, r *http.Request) {
+	hook, ok := app.webhookFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.webhooks.DeleteContext(r.Context(), hook.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "Webhook deleted.")
+	http.Redirect(w, r, "/admin/webhooks", http.StatusSeeOther)
+}
+
+// webhookRedeliverPOST queues a delivery of a webhook to be sent again.
+func (app *application) webhookRedeliverPOST(w http.ResponseWriter, r *http.Request) {
+	hook, ok := app.webhookFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	deliveryID, err := strconv.Atoi(r.PathValue("delivery"))
+	if err != nil || deliveryID < 1 {
+		app.notFound(w, r)
+		return
+	}
+
+	delivery, err := app.webhooks.GetDeliveryContext(r.Context(), deliveryID)
+	if err == nil && delivery.WebhookID != hook.ID {
+		err = models.ErrNoRecord
+	}
+	if err == nil {
+		err = app.webhooks.RedeliverContext(r.Context(), delivery.ID)
+	}
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	app.dispatcher.Notify()
+
+	app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("Delivery %d queued for redelivery.", delivery.ID))
+	http.Redirect(w, r, fmt.Sprintf("/admin/webhooks/%d", hook.ID), http.StatusSeeOther)
+}
+
+// webhookFromPath returns the webhook whose id is in the path of r. If there
+// is none, an error response has been sent and ok is false.
+func (app *application) webhookFromPath(w http.ResponseWriter, r *http.Request) (hook *models.Webhook, ok bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		app.notFound(w, r)
+		return nil, false
+	}
+
+	hook, err = app.webhooks.GetContext(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return nil, false
+	}
+	return hook, true
+}
@@ -5,8 +5,13 @@ go 1.22.5
 require github.com/mattn/go-sqlite3 v1.14.24
 
 require (
+	github.com/BurntSushi/toml v1.6.0
 	github.com/alexedwards/scs/v2 v2.8.0
+	github.com/andybalholm/brotli v1.2.6
+	github.com/gorilla/websocket v1.5.3
 	github.com/justinas/alice v1.2.0
+	github.com/klauspost/compress v1.17.11
+	github.com/lib/pq v1.12.3
 )
 
 require golang.org/x/crypto v0.29.0 // indirect
@@ -0,0 +1,252 @@
+// Package assets serves static files under content-hashed names, which can
+// be cached forever since a change to a file changes its name. Text files
+// are also served precompressed with brotli or gzip.
+package assets
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"forum/internal/negotiate"
+
+	"github.com/andybalholm/brotli"
+)
+
+// Encodings of the precompressed variants, by order of preference.
+const (
+	EncodingBrotli = "br"
+	EncodingGzip   = "gzip"
+)
+
+// compressible lists the extensions of the files worth compressing.
+var compressible = map[string]bool{
+	".css":  true,
+	".js":   true,
+	".svg":  true,
+	".txt":  true,
+	".json": true,
+	".ico":  true,
+}
+
+// cssURL matches the references of stylesheets to other static files.
+var cssURL = regexp.MustCompile(`url\(["']?(/static/[^"')]+)["']?\)`)
+
+// asset is a static file and its compressed variants.
+type asset struct {
+	name     string
+	hash     string
+	content  []byte
+	variants map[string][]byte
+	modTime  time.Time
+}
+
+// Pipeline serves the files of a file system under Prefix. Each file is
+// available under its hashed name, cached forever, and its logical name,
+// revalidated on every use.
+type Pipeline struct {
+	// Prefix is the URL path under which the files are served.
+	Prefix string
+	// NotFound, if set, answers the requests for files that do not exist.
+	// http.NotFound does otherwise.
+	NotFound http.Handler
+
+	fsys   fs.FS
+	live   bool
+	assets map[string]*asset // by logical name
+	hashed map[string]*asset // by hashed name
+}
+
+// New loads, hashes and compresses every file of fsys.
+func New(fsys fs.FS, prefix string) (*Pipeline, error) {
+	p := &Pipeline{
+		Prefix: prefix,
+		fsys:   fsys,
+		assets: map[string]*asset{},
+		hashed: map[string]*asset{},
+	}
+
+	var names, stylesheets []string
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if path.Ext(name) == ".css" {
+			stylesheets = append(stylesheets, name)
+		} else {
+			names = append(names, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Stylesheets are loaded last, once the files they reference have their
+	// hashed names.
+	for _, name := range append(names, stylesheets...) {
+		content, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+		if path.Ext(name) == ".css" {
+			content = p.rewriteCSS(content)
+		}
+		a, err := newAsset(name, content)
+		if err != nil {
+			return nil, err
+		}
+		p.assets[name] = a
+		p.hashed[a.hashedName()] = a
+	}
+	return p, nil
+}
+
+// NewLive returns a pipeline serving the files of fsys as they are on every
+// request, without hashing, caching or compression. It is meant for
+// development.
+func NewLive(fsys fs.FS, prefix string) *Pipeline {
+	return &Pipeline{Prefix: prefix, fsys: fsys, live: true}
+}
+
+func newAsset(name string, content []byte) (*asset, error) {
+	sum := sha256.Sum256(content)
+	a := &asset{
+		name:     name,
+		hash:     hex.EncodeToString(sum[:5]),
+		content:  content,
+		variants: map[string][]byte{},
+		modTime:  time.Now(),
+	}
+	if !compressible[path.Ext(name)] {
+		return a, nil
+	}
+
+	var br bytes.Buffer
+	bw := brotli.NewWriterLevel(&br, brotli.BestCompression)
+	bw.Write(content)
+	err := bw.Close()
+	if err != nil {
+		return nil, err
+	}
+	if br.Len() < len(content) {
+		a.variants[EncodingBrotli] = br.Bytes()
+	}
+
+	var gz bytes.Buffer
+	gw, _ := gzip.NewWriterLevel(&gz, gzip.BestCompression)
+	gw.Write(content)
+	err = gw.Close()
+	if err != nil {
+		return nil, err
+	}
+	if gz.Len() < len(content) {
+		a.variants[EncodingGzip] = gz.Bytes()
+	}
+	return a, nil
+}
+
+// hashedName returns the name of the asset with its hash before the
+// extension, as in css/main.0123456789.css.
+func (a *asset) hashedName() string {
+	ext := path.Ext(a.name)
+	return strings.TrimSuffix(a.name, ext) + "." + a.hash + ext
+}
+
+// rewriteCSS points the references of a stylesheet to other files at their
+// hashed names.
+func (p *Pipeline) rewriteCSS(css []byte) []byte {
+	return cssURL.ReplaceAllFunc(css, func(m []byte) []byte {
+		target := string(cssURL.FindSubmatch(m)[1])
+		return []byte(`url("` + p.Path(strings.TrimPrefix(target, p.Prefix+"/")) + `")`)
+	})
+}
+
+// Path returns the URL of the file with the given logical name, such as
+// css/main.css. It falls back to the logical URL for unknown files.
+func (p *Pipeline) Path(name string) string {
+	name = strings.TrimPrefix(name, "/")
+	if a, ok := p.assets[name]; ok {
+		return p.Prefix + "/" + a.hashedName()
+	}
+	return p.Prefix + "/" + name
+}
+
+// ServeHTTP serves the file named by the request path, stripped of Prefix.
+// Directories are never listed.
+func (p *Pipeline) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name, ok := strings.CutPrefix(r.URL.Path, p.Prefix+"/")
+	if !ok || name == "" || strings.HasSuffix(name, "/") {
+		p.notFound(w, r)
+		return
+	}
+
+	if p.live {
+		p.serveLive(w, r, name)
+		return
+	}
+
+	a, immutable := p.hashed[name]
+	if !immutable {
+		a, ok = p.assets[name]
+		if !ok {
+			p.notFound(w, r)
+			return
+		}
+	}
+
+	h := w.Header()
+	if immutable {
+		h.Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		h.Set("Cache-Control", "no-cache")
+	}
+	if ctype := mime.TypeByExtension(path.Ext(a.name)); ctype != "" {
+		h.Set("Content-Type", ctype)
+	}
+
+	content, etag := a.content, a.hash
+	if len(a.variants) > 0 {
+		h.Add("Vary", "Accept-Encoding")
+		var offers []string
+		for _, encoding := range []string{EncodingBrotli, EncodingGzip} {
+			if _, ok := a.variants[encoding]; ok {
+				offers = append(offers, encoding)
+			}
+		}
+		if encoding := negotiate.Encoding(r.Header.Get("Accept-Encoding"), offers...); encoding != "" {
+			content, etag = a.variants[encoding], a.hash+"-"+encoding
+			h.Set("Content-Encoding", encoding)
+		}
+	}
+	h.Set("ETag", `"`+etag+`"`)
+	http.ServeContent(w, r, "", a.modTime, bytes.NewReader(content))
+}
+
+// notFound answers a request for a file that does not exist.
+func (p *Pipeline) notFound(w http.ResponseWriter, r *http.Request) {
+	if p.NotFound != nil {
+		p.NotFound.ServeHTTP(w, r)
+		return
+	}
+	http.NotFound(w, r)
+}
+
+// serveLive serves a file as it is on disk.
+func (p *Pipeline) serveLive(w http.ResponseWriter, r *http.Request, name string) {
+	info, err := fs.Stat(p.fsys, name)
+	if err != nil || info.IsDir() {
+		p.notFound(w, r)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeFileFS(w, r, p.fsys, name)
+}
@@ -0,0 +1,194 @@
+package assets
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/andybalholm/brotli"
+)
+
+var testFiles = fstest.MapFS{
+	"css/main.css":   {Data: []byte(strings.Repeat("body { background: url('/static/img/bg.png'); }\n", 20))},
+	"img/bg.png":     {Data: []byte("\x89PNG not really")},
+	"js/main.js":     {Data: []byte(strings.Repeat("console.log('hello');\n", 20))},
+	"robots.txt":     {Data: []byte("x")},
+	"img/logo/a.svg": {Data: []byte("<svg/>")},
+}
+
+// get requests path from h with the given Accept-Encoding and If-None-Match
+// headers.
+func get(h http.Handler, path, acceptEncoding, ifNoneMatch string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	if acceptEncoding != "" {
+		r.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	if ifNoneMatch != "" {
+		r.Header.Set("If-None-Match", ifNoneMatch)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestPipeline(t *testing.T) {
+	p, err := New(testFiles, "/static")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	png := p.Path("img/bg.png")
+	if !strings.HasPrefix(png, "/static/img/bg.") || !strings.HasSuffix(png, ".png") || png == "/static/img/bg.png" {
+		t.Errorf("Path(img/bg.png) = %q; want a hashed name", png)
+	}
+	if got := p.Path("/img/bg.png"); got != png {
+		t.Errorf("p.Path(\"/img/bg.png\") = %v; want %v", got, png)
+	}
+	if got := p.Path("missing.css"); got != "/static/missing.css" {
+		t.Errorf("p.Path(\"missing.css\") = %q; want %q", got, "/static/missing.css")
+	}
+
+	// Stylesheets reference the hashed names of other files.
+	resp := get(p, p.Path("css/main.css"), "", "")
+	if resp.Code != http.StatusOK {
+		t.Errorf("resp.Code = %v; want %v", resp.Code, http.StatusOK)
+	}
+	if !strings.Contains(resp.Body.String(), `url("`+png+`")`) {
+		t.Errorf("%q not found in:\n%s", `url("`+png+`")`, resp.Body.String())
+	}
+	if strings.Contains(resp.Body.String(), "/static/img/bg.png") {
+		t.Errorf("%q unexpectedly found in:\n%s", "/static/img/bg.png", resp.Body.String())
+	}
+	if got := resp.Header().Get("Content-Type"); got != "text/css; charset=utf-8" {
+		t.Errorf("resp.Header().Get(\"Content-Type\") = %q; want %q", got, "text/css; charset=utf-8")
+	}
+	if got := resp.Header().Get("Cache-Control"); got != "public, max-age=31536000, immutable" {
+		t.Errorf("resp.Header().Get(\"Cache-Control\") = %q; want %q", got, "public, max-age=31536000, immutable")
+	}
+
+	// The logical name is revalidated on every use.
+	resp = get(p, "/static/img/bg.png", "", "")
+	if resp.Code != http.StatusOK {
+		t.Errorf("resp.Code = %v; want %v", resp.Code, http.StatusOK)
+	}
+	if got := resp.Body.String(); got != "\x89PNG not really" {
+		t.Errorf("resp.Body.String() = %v; want %v", got, "\x89PNG not really")
+	}
+	if got := resp.Header().Get("Cache-Control"); got != "no-cache" {
+		t.Errorf("resp.Header().Get(\"Cache-Control\") = %q; want %q", got, "no-cache")
+	}
+	if got := resp.Header().Get("Vary"); got != "" {
+		t.Errorf("resp.Header().Get(\"Vary\") = %q; want %q", got, "")
+	}
+	resp = get(p, "/static/img/bg.png", "", resp.Header().Get("ETag"))
+	if resp.Code != http.StatusNotModified {
+		t.Errorf("resp.Code = %v; want %v", resp.Code, http.StatusNotModified)
+	}
+
+	for _, path := range []string{"/static/missing.js", "/static/", "/static/img/", "/static/img/logo", "/other/robots.txt"} {
+		resp := get(p, path, "", "")
+		if resp.Code != http.StatusNotFound {
+			t.Errorf("GET %s: got %d; want 404", path, resp.Code)
+		}
+	}
+	p.NotFound = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "custom", http.StatusNotFound)
+	})
+	if got := get(p, "/static/missing.js", "", "").Body.String(); got != "custom\n" {
+		t.Errorf("get(p, \"/static/missing.js\", \"\", \"\").Body.String() = %v; want %v", got, "custom\n")
+	}
+}
+
+func TestPipelineCompression(t *testing.T) {
+	p, err := New(testFiles, "/static")
+	if err != nil {
+		t.Fatal(err)
+	}
+	js := string(testFiles["js/main.js"].Data)
+
+	for _, tt := range []struct {
+		acceptEncoding string
+		want           string
+		decode         func(io.Reader) (io.Reader, error)
+	}{
+		{"", "", nil},
+		{"gzip, deflate", EncodingGzip, func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) }},
+		{"gzip, br", EncodingBrotli, func(r io.Reader) (io.Reader, error) { return brotli.NewReader(r), nil }},
+		{"br;q=0, gzip", EncodingGzip, func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) }},
+	} {
+		resp := get(p, "/static/js/main.js", tt.acceptEncoding, "")
+		if resp.Code != http.StatusOK {
+			t.Errorf("resp.Code = %v; want %v", resp.Code, http.StatusOK)
+		}
+		if got := resp.Header().Get("Vary"); got != "Accept-Encoding" {
+			t.Errorf("resp.Header().Get(\"Vary\") = %q; want %q", got, "Accept-Encoding")
+		}
+		if got := resp.Header().Get("Content-Encoding"); got != tt.want {
+			t.Errorf("resp.Header().Get(\"Content-Encoding\") = %v; want %v", got, tt.want)
+		}
+
+		var body io.Reader = resp.Body
+		if tt.decode != nil {
+			body, err = tt.decode(body)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		got, err := io.ReadAll(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != js {
+			t.Errorf("Accept-Encoding %q: got %q", tt.acceptEncoding, got)
+		}
+
+		// Each variant has its own ETag.
+		etag := resp.Header().Get("ETag")
+		if !strings.HasSuffix(etag, tt.want+`"`) {
+			t.Errorf("ETag %s does not name the %q variant", etag, tt.want)
+		}
+		if got := get(p, "/static/js/main.js", tt.acceptEncoding, etag).Code; got != http.StatusNotModified {
+			t.Errorf("get(p, \"/static/js/main.js\", tt.acceptEncoding, etag).Code = %v; want %v", got, http.StatusNotModified)
+		}
+	}
+
+	// Files that do not shrink are not compressed.
+	resp := get(p, "/static/robots.txt", "br, gzip", "")
+	if got := resp.Header().Get("Content-Encoding"); got != "" {
+		t.Errorf("resp.Header().Get(\"Content-Encoding\") = %q; want %q", got, "")
+	}
+	if got := resp.Body.String(); got != "x" {
+		t.Errorf("resp.Body.String() = %q; want %q", got, "x")
+	}
+}
+
+func TestPipelineLive(t *testing.T) {
+	files := fstest.MapFS{"css/main.css": {Data: []byte("body {}")}}
+	p := NewLive(files, "/static")
+	if got := p.Path("css/main.css"); got != "/static/css/main.css" {
+		t.Errorf("p.Path(\"css/main.css\") = %q; want %q", got, "/static/css/main.css")
+	}
+
+	resp := get(p, "/static/css/main.css", "", "")
+	if resp.Code != http.StatusOK {
+		t.Errorf("resp.Code = %v; want %v", resp.Code, http.StatusOK)
+	}
+	if got := resp.Body.String(); got != "body {}" {
+		t.Errorf("resp.Body.String() = %q; want %q", got, "body {}")
+	}
+	if got := resp.Header().Get("Cache-Control"); got != "no-cache" {
+		t.Errorf("resp.Header().Get(\"Cache-Control\") = %q; want %q", got, "no-cache")
+	}
+
+	files["css/main.css"] = &fstest.MapFile{Data: []byte("body { color: red }")}
+	if got := get(p, "/static/css/main.css", "", "").Body.String(); got != "body { color: red }" {
+		t.Errorf("get(p, \"/static/css/main.css\", \"\", \"\").Body.String() = %q; want %q", got, "body { color: red }")
+	}
+	if got := get(p, "/static/css", "", "").Code; got != http.StatusNotFound {
+		t.Errorf("get(p, \"/static/css\", \"\", \"\").Code = %v; want %v", got, http.StatusNotFound)
+	}
+}
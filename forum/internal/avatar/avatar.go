@@ -0,0 +1,187 @@
+// Package avatar turns uploaded pictures into square PNG avatars of a few
+// fixed sizes, and generates identicons for users who have not uploaded one.
+package avatar
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+)
+
+// Sizes lists the widths, in pixels, of the avatars generated for each upload.
+var Sizes = []int{32, 64, 128}
+
+const (
+	// MaxBytes is the largest upload accepted.
+	MaxBytes = 2 << 20
+
+	// maxPixels bounds the decoded size of an upload, so that a small file
+	// cannot expand to gigabytes of pixels.
+	maxPixels = 4096 * 4096
+)
+
+var (
+	ErrUnsupportedType = errors.New("avatar: unsupported image type")
+	ErrTooLarge        = errors.New("avatar: image too large")
+)
+
+// allowedTypes lists the sniffed content types accepted for uploads.
+var allowedTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+}
+
+// ValidSize reports whether size is one of Sizes.
+func ValidSize(size int) bool {
+	for _, s := range Sizes {
+		if s == size {
+			return true
+		}
+	}
+	return false
+}
+
+// Process decodes an uploaded picture, crops it to a centered square and
+// re-encodes it as a PNG for each of Sizes. Re-encoding drops any metadata
+// the original file carried. The content type is sniffed from the data
+// rather than trusted from the client.
+func Process(r io.Reader) (map[int][]byte, error) {
+	br := bufio.NewReader(io.LimitReader(r, MaxBytes+1))
+	head, err := br.Peek(512)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("reading upload: %w", err)
+	}
+	if !allowedTypes[http.DetectContentType(head)] {
+		return nil, ErrUnsupportedType
+	}
+
+	data, err := io.ReadAll(br)
+	if err != nil {
+		return nil, fmt.Errorf("reading upload: %w", err)
+	}
+	if len(data) > MaxBytes {
+		return nil, ErrTooLarge
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedType
+	}
+	if cfg.Width*cfg.Height > maxPixels {
+		return nil, ErrTooLarge
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedType
+	}
+	src = cropSquare(src)
+
+	out := make(map[int][]byte, len(Sizes))
+	for _, size := range Sizes {
+		var buf bytes.Buffer
+		err := png.Encode(&buf, resize(src, size))
+		if err != nil {
+			return nil, fmt.Errorf("encoding %dpx avatar: %w", size, err)
+		}
+		out[size] = buf.Bytes()
+	}
+	return out, nil
+}
+
+// cropSquare returns the largest centered square of img.
+func cropSquare(img image.Image) image.Image {
+	b := img.Bounds()
+	side := min(b.Dx(), b.Dy())
+	x0 := b.Min.X + (b.Dx()-side)/2
+	y0 := b.Min.Y + (b.Dy()-side)/2
+	rect := image.Rect(x0, y0, x0+side, y0+side)
+
+	dst := image.NewRGBA(image.Rect(0, 0, side, side))
+	for y := 0; y < side; y++ {
+		for x := 0; x < side; x++ {
+			dst.Set(x, y, img.At(rect.Min.X+x, rect.Min.Y+y))
+		}
+	}
+	return dst
+}
+
+// resize scales a square image to size x size. Each destination pixel is the
+// average of the source pixels it covers, which gives smooth thumbnails when
+// shrinking and falls back to the nearest pixel when enlarging.
+func resize(src image.Image, size int) *image.RGBA {
+	b := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		sy0 := b.Min.Y + y*b.Dy()/size
+		sy1 := max(b.Min.Y+(y+1)*b.Dy()/size, sy0+1)
+		for x := 0; x < size; x++ {
+			sx0 := b.Min.X + x*b.Dx()/size
+			sx1 := max(b.Min.X+(x+1)*b.Dx()/size, sx0+1)
+
+			var r, g, bl, a, n uint64
+			for sy := sy0; sy < sy1; sy++ {
+				for sx := sx0; sx < sx1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, bl, a = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca)
+					n++
+				}
+			}
+			dst.SetRGBA64(x, y, color.RGBA64{
+				R: uint16(r / n), G: uint16(g / n), B: uint16(bl / n), A: uint16(a / n),
+			})
+		}
+	}
+	return dst
+}
+
+// Identicon returns a PNG of size x size pixels showing a symmetric 5x5
+// pattern derived from seed, so that each user gets a stable, recognizable
+// default avatar.
+func Identicon(seed string, size int) ([]byte, error) {
+	sum := sha256.Sum256([]byte(seed))
+	fg := color.RGBA{R: sum[0]/2 + 64, G: sum[1]/2 + 64, B: sum[2]/2 + 64, A: 255}
+	bg := color.RGBA{R: 240, G: 240, B: 240, A: 255}
+
+	const cells = 5
+	var grid [cells][cells]bool
+	for row := 0; row < cells; row++ {
+		for col := 0; col < (cells+1)/2; col++ {
+			on := sum[3+row*3+col]%2 == 0
+			grid[row][col] = on
+			grid[row][cells-1-col] = on
+		}
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	margin := size / 10
+	cell := max((size-2*margin)/cells, 1)
+	offset := (size - cell*cells) / 2
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			c := bg
+			row, col := (y-offset)/cell, (x-offset)/cell
+			if x >= offset && y >= offset && row < cells && col < cells && grid[row][col] {
+				c = fg
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+
+	var buf bytes.Buffer
+	err := png.Encode(&buf, img)
+	if err != nil {
+		return nil, fmt.Errorf("encoding identicon: %w", err)
+	}
+	return buf.Bytes(), nil
+}
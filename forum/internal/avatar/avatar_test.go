@@ -0,0 +1,269 @@
+package avatar
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// encode returns a width x height picture in the given format, red on its
+// left half and blue on its right half.
+func encode(t *testing.T, format string, width, height int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			c := color.RGBA{R: 255, A: 255}
+			if x >= width/2 {
+				c = color.RGBA{B: 255, A: 255}
+			}
+			img.SetRGBA(x, y, c)
+		}
+	}
+
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case "png":
+		err = png.Encode(&buf, img)
+	case "jpeg":
+		err = jpeg.Encode(&buf, img, nil)
+	case "gif":
+		err = gif.Encode(&buf, img, nil)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// decode decodes a PNG avatar.
+func decode(t *testing.T, data []byte) image.Image {
+	t.Helper()
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
+
+func TestProcess(t *testing.T) {
+	for _, format := range []string{"png", "jpeg", "gif"} {
+		t.Run(format, func(t *testing.T) {
+			avatars, err := Process(bytes.NewReader(encode(t, format, 300, 200)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := len(avatars); got != len(Sizes) {
+				t.Errorf("len(avatars) = %v; want %v", got, len(Sizes))
+			}
+			for _, size := range Sizes {
+				img := decode(t, avatars[size])
+				if got := img.Bounds(); got != image.Rect(0, 0, size, size) {
+					t.Errorf("img.Bounds() = %v; want %v", got, image.Rect(0, 0, size, size))
+				}
+			}
+
+			// The centered square of a wide picture keeps both halves.
+			img := decode(t, avatars[32])
+			r, _, b, _ := img.At(4, 16).RGBA()
+			if r < 0xc000 || b > 0x4000 {
+				t.Errorf("left of the avatar is %v; want red", img.At(4, 16))
+			}
+			r, _, b, _ = img.At(28, 16).RGBA()
+			if b < 0xc000 || r > 0x4000 {
+				t.Errorf("right of the avatar is %v; want blue", img.At(28, 16))
+			}
+		})
+	}
+}
+
+func TestProcessErrors(t *testing.T) {
+	// A PNG whose header announces more pixels than allowed: the width and
+	// height of its IHDR chunk are raised to 8192, and its CRC updated.
+	huge := encode(t, "png", 1, 1)
+	binary.BigEndian.PutUint32(huge[16:], 8192)
+	binary.BigEndian.PutUint32(huge[20:], 8192)
+	binary.BigEndian.PutUint32(huge[29:], crc32.ChecksumIEEE(huge[12:29]))
+
+	for _, tt := range []struct {
+		name string
+		data io.Reader
+		want error
+	}{
+		{"text", strings.NewReader("hello, world"), ErrUnsupportedType},
+		{"empty", strings.NewReader(""), ErrUnsupportedType},
+		{"truncated", bytes.NewReader(encode(t, "png", 10, 10)[:40]), ErrUnsupportedType},
+		{"too many bytes", io.MultiReader(bytes.NewReader(encode(t, "png", 1, 1)), bytes.NewReader(make([]byte, MaxBytes))), ErrTooLarge},
+		{"too many pixels", bytes.NewReader(huge), ErrTooLarge},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Process(tt.data)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("got %v; want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidSize(t *testing.T) {
+	for _, size := range Sizes {
+		if !ValidSize(size) {
+			t.Errorf("ValidSize(%d) = false; want true", size)
+		}
+	}
+	if ValidSize(0) {
+		t.Error("ValidSize(0) = true; want false")
+	}
+	if ValidSize(100) {
+		t.Error("ValidSize(100) = true; want false")
+	}
+}
+
+func TestIdenticon(t *testing.T) {
+	a, err := Identicon("1", 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := Identicon("1", 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := Identicon("2", 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(a, again) {
+		t.Error("the identicon of a name changed between calls")
+	}
+	if bytes.Equal(a, other) {
+		t.Error("two names have the same identicon")
+	}
+
+	img := decode(t, a)
+	if got := img.Bounds(); got != image.Rect(0, 0, 64, 64) {
+		t.Errorf("img.Bounds() = %v; want %v", got, image.Rect(0, 0, 64, 64))
+	}
+	// The pattern is symmetric.
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 32; x++ {
+			if img.At(x, y) != img.At(63-x, y) {
+				t.Fatalf("pixel (%d, %d) differs from its mirror", x, y)
+			}
+		}
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	s, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	avatars := map[int][]byte{32: []byte("small"), 64: []byte("medium"), 128: []byte("large")}
+
+	_, _, err = s.Open(1, 1, 32)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("opening a missing avatar: got %v; want ErrNotFound", err)
+	}
+	for version := 1; version <= 3; version++ {
+		err = s.Save(1, version, avatars)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = s.Save(2, 1, avatars)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, _, err := s.Open(1, 2, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(f)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(data); got != "medium" {
+		t.Errorf("string(data) = %q; want %q", got, "medium")
+	}
+
+	exists := func(userID, version int) bool {
+		t.Helper()
+		f, _, err := s.Open(userID, version, 128)
+		if errors.Is(err, ErrNotFound) {
+			return false
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+		return true
+	}
+
+	err = s.Remove(1, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists(1, 3) {
+		t.Error("the files of user 1 version 3 were kept")
+	}
+	if !exists(1, 2) {
+		t.Error("the files of user 1 version 2 are missing")
+	}
+
+	if !exists(2, 1) {
+		t.Error("the files of user 2 version 1 are missing")
+	}
+	// Removing an avatar that does not exist is not an error.
+	err = s.Remove(3, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Concurrent saves of the same avatar do not share a temporary file.
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := s.Save(4, 1, map[int][]byte{32: bytes.Repeat([]byte{byte(i)}, 1<<16)})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	entries, err := os.ReadDir(filepath.Join(s.Dir, "4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(entries); got != 1 {
+		t.Errorf("len(entries) = %v; want 1", got)
+	}
+	f, _, err = s.Open(4, 1, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err = io.ReadAll(f)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(data); got != 1<<16 {
+		t.Errorf("len(data) = %v; want %v", got, 1<<16)
+	}
+}
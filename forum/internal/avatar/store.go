@@ -0,0 +1,130 @@
+package avatar
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// ErrNotFound is returned by a Store when the requested avatar does not exist.
+var ErrNotFound = errors.New("avatar: not found")
+
+// Store persists the processed avatars of each user. Every upload gets a new
+// version number so that avatar URLs can be cached forever.
+type Store interface {
+	// Save stores the avatars of userID for the given version, one per size.
+	Save(userID, version int, avatars map[int][]byte) error
+	// Open returns the avatar of userID for the given version and size, and
+	// the time it was saved.
+	Open(userID, version, size int) (io.ReadSeekCloser, time.Time, error)
+	// Remove removes the avatars of userID for the given version.
+	Remove(userID, version int) error
+}
+
+// NewVersion returns a version for a new upload. Versions are random rather
+// than sequential, so that concurrent uploads of the same user never write to
+// the same files.
+func NewVersion() int {
+	return rand.IntN(math.MaxInt32) + 1
+}
+
+// FileStore is a Store that keeps avatars as PNG files under a directory of
+// the local filesystem, laid out as <dir>/<userID>/<version>-<size>.png.
+type FileStore struct {
+	Dir string
+}
+
+// NewFileStore creates dir if needed and returns a FileStore using it.
+func NewFileStore(dir string) (*FileStore, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, fmt.Errorf("creating avatar directory: %w", err)
+	}
+	return &FileStore{Dir: dir}, nil
+}
+
+// userDir returns the directory holding the avatars of userID.
+func (s *FileStore) userDir(userID int) string {
+	return filepath.Join(s.Dir, strconv.Itoa(userID))
+}
+
+// path returns the file holding one avatar.
+func (s *FileStore) path(userID, version, size int) string {
+	return filepath.Join(s.userDir(userID), fmt.Sprintf("%d-%d.png", version, size))
+}
+
+// Save implements Store. Files are written under a temporary name then renamed,
+// so that a reader never sees a partial avatar.
+func (s *FileStore) Save(userID, version int, avatars map[int][]byte) error {
+	dir := s.userDir(userID)
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return fmt.Errorf("creating avatar directory: %w", err)
+	}
+	for size, data := range avatars {
+		err := writeFile(dir, s.path(userID, version, size), data)
+		if err != nil {
+			return fmt.Errorf("writing avatar: %w", err)
+		}
+	}
+	return nil
+}
+
+// writeFile writes data to a temporary file of dir, which it then renames to
+// path.
+func writeFile(dir, path string, data []byte) error {
+	f, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Chmod(0o644)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
+// Open implements Store.
+func (s *FileStore) Open(userID, version, size int) (io.ReadSeekCloser, time.Time, error) {
+	f, err := os.Open(s.path(userID, version, size))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, time.Time{}, ErrNotFound
+		}
+		return nil, time.Time{}, fmt.Errorf("opening avatar: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, time.Time{}, fmt.Errorf("opening avatar: %w", err)
+	}
+	return f, info.ModTime(), nil
+}
+
+// Remove implements Store.
+func (s *FileStore) Remove(userID, version int) error {
+	for _, size := range Sizes {
+		err := os.Remove(s.path(userID, version, size))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("deleting avatar: %w", err)
+		}
+	}
+	return nil
+}
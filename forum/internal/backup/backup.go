@@ -0,0 +1,154 @@
+// Package backup takes snapshots of the database into a directory, on demand
+// or on a schedule, and keeps only the latest ones.
+package backup
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Snapshotter writes a consistent copy of a database to a new file, such as
+// *models.DB.
+type Snapshotter interface {
+	Backup(ctx context.Context, path string) error
+}
+
+// Backups are named after the time they are taken, in UTC, so that their
+// names sort in order.
+const (
+	prefix     = "forum-"
+	suffix     = ".sqlite"
+	timeLayout = "20060102T150405Z"
+)
+
+// Name returns the file name of a backup taken at t.
+func Name(t time.Time) string {
+	return prefix + t.UTC().Format(timeLayout) + suffix
+}
+
+// Backup is a file of a backup directory.
+type Backup struct {
+	Path  string
+	Taken time.Time
+}
+
+// List returns the backups of dir, oldest first. Other files are ignored.
+func List(dir string) ([]Backup, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var backups []Backup
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, suffix) {
+			continue
+		}
+		taken, err := time.Parse(timeLayout, strings.TrimSuffix(strings.TrimPrefix(name, prefix), suffix))
+		if err != nil {
+			continue
+		}
+		backups = append(backups, Backup{Path: filepath.Join(dir, name), Taken: taken})
+	}
+	slices.SortFunc(backups, func(a, b Backup) int { return a.Taken.Compare(b.Taken) })
+	return backups, nil
+}
+
+// Take writes a backup of db into dir, then removes the oldest backups of dir
+// beyond the keep latest ones. It returns the path of the new backup.
+func Take(ctx context.Context, db Snapshotter, dir string, keep int) (string, error) {
+	err := os.MkdirAll(dir, 0o750)
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, Name(time.Now()))
+	err = db.Backup(ctx, path)
+	if err != nil {
+		return "", err
+	}
+	_, err = Rotate(dir, keep)
+	if err != nil {
+		return path, fmt.Errorf("rotating backups: %w", err)
+	}
+	return path, nil
+}
+
+// Rotate removes the oldest backups of dir beyond the keep latest ones, and
+// returns their paths.
+func Rotate(dir string, keep int) ([]string, error) {
+	backups, err := List(dir)
+	if err != nil {
+		return nil, err
+	}
+	var removed []string
+	for len(backups) > keep {
+		err := os.Remove(backups[0].Path)
+		if err != nil {
+			return removed, err
+		}
+		removed = append(removed, backups[0].Path)
+		backups = backups[1:]
+	}
+	return removed, nil
+}
+
+// Scheduler takes a backup every Interval and keeps the Keep latest ones.
+type Scheduler struct {
+	Interval time.Duration
+	Keep     int
+
+	db     Snapshotter
+	dir    string
+	logger *slog.Logger
+}
+
+// NewScheduler returns a Scheduler taking backups of db into dir, daily and
+// for a week by default.
+func NewScheduler(db Snapshotter, dir string, logger *slog.Logger) *Scheduler {
+	return &Scheduler{
+		Interval: 24 * time.Hour,
+		Keep:     7,
+		db:       db,
+		dir:      dir,
+		logger:   logger,
+	}
+}
+
+// Run takes backups until ctx is cancelled. The first one is due an Interval
+// after the latest backup of the directory, so that restarting the server
+// neither delays nor hastens it.
+func (s *Scheduler) Run(ctx context.Context) {
+	timer := time.NewTimer(s.untilNext())
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		path, err := Take(ctx, s.db, s.dir, s.Keep)
+		if err != nil {
+			s.logger.Error("backup failed", "error", err)
+		} else {
+			s.logger.Info("backup taken", "path", path)
+		}
+		timer.Reset(s.Interval)
+	}
+}
+
+// untilNext returns the time left before the next backup is due.
+func (s *Scheduler) untilNext() time.Duration {
+	backups, err := List(s.dir)
+	if err != nil || len(backups) == 0 {
+		return 0
+	}
+	return max(time.Until(backups[len(backups)-1].Taken.Add(s.Interval)), 0)
+}
@@ -0,0 +1,81 @@
+package backup
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"forum/internal/testutil"
+)
+
+// fileSnapshotter writes an empty file as a backup.
+type fileSnapshotter struct{}
+
+func (fileSnapshotter) Backup(ctx context.Context, path string) error {
+	return os.WriteFile(path, nil, 0o600)
+}
+
+func TestRotate(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		err := os.WriteFile(filepath.Join(dir, Name(start.Add(time.Duration(i)*time.Hour))), nil, 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := os.WriteFile(filepath.Join(dir, "notes.txt"), nil, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := Rotate(dir, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.Equal(t, len(removed), 2)
+	testutil.Equal(t, filepath.Base(removed[0]), "forum-20240301T120000Z.sqlite")
+
+	backups, err := List(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.Equal(t, len(backups), 3)
+	testutil.Equal(t, backups[0].Taken, start.Add(2*time.Hour))
+	_, err = os.Stat(filepath.Join(dir, "notes.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTake(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "backups")
+	err := os.MkdirAll(dir, 0o750)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := filepath.Join(dir, Name(time.Now().Add(-time.Hour)))
+	err = os.WriteFile(old, nil, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path, err := Take(context.Background(), fileSnapshotter{}, dir, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backups, err := List(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.Equal(t, len(backups), 1)
+	testutil.Equal(t, backups[0].Path, path)
+
+	s := NewScheduler(fileSnapshotter{}, dir, nil)
+	s.Interval = time.Hour
+	if d := s.untilNext(); d < 59*time.Minute || d > time.Hour {
+		t.Errorf("got next backup in %v; want about an hour", d)
+	}
+}
@@ -0,0 +1,63 @@
+// Package blob stores opaque files, such as post attachments, behind a small
+// interface with a local disk and an S3-compatible implementation.
+package blob
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrNotFound is returned by a Store when no blob exists under a key.
+var ErrNotFound = errors.New("blob: not found")
+
+// Store persists blobs under string keys. Keys may contain slashes.
+type Store interface {
+	// Put stores size bytes read from r under key, replacing any previous
+	// blob.
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Get returns a reader over the blob stored under key. The caller must
+	// close it.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the blob stored under key. Deleting a missing blob is not
+	// an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// Options selects the store returned by Open. Kind is either "disk", which
+// keeps the blobs under Dir, or "s3", which keeps them in an S3 bucket.
+type Options struct {
+	Kind        string
+	Dir         string
+	S3Endpoint  string
+	S3Bucket    string
+	S3Region    string
+	S3AccessKey string
+	S3SecretKey string
+}
+
+// Open returns the store selected by opts.
+func Open(opts Options) (Store, error) {
+	switch opts.Kind {
+	case "disk":
+		return NewDiskStore(opts.Dir)
+	case "s3":
+		return NewS3Store(opts.S3Endpoint, opts.S3Bucket, opts.S3Region, opts.S3AccessKey, opts.S3SecretKey)
+	default:
+		return nil, fmt.Errorf("unknown blob store %q", opts.Kind)
+	}
+}
+
+// NewKey returns a random key under prefix, suitable for content whose name
+// is chosen by users.
+func NewKey(prefix string) (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return prefix + "/" + hex.EncodeToString(b), nil
+}
@@ -0,0 +1,101 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DiskStore is a Store that keeps blobs as files under a directory of the
+// local filesystem.
+type DiskStore struct {
+	Dir string
+}
+
+// NewDiskStore creates dir if needed and returns a DiskStore using it.
+func NewDiskStore(dir string) (*DiskStore, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, fmt.Errorf("creating blob directory: %w", err)
+	}
+	return &DiskStore{Dir: dir}, nil
+}
+
+// path returns the file holding the blob stored under key, refusing keys that
+// would escape Dir.
+func (s *DiskStore) path(key string) (string, error) {
+	clean := filepath.Clean(filepath.FromSlash(key))
+	if clean == "." || filepath.IsAbs(clean) || clean == ".." ||
+		strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("blob: invalid key %q", key)
+	}
+	return filepath.Join(s.Dir, clean), nil
+}
+
+// Put implements Store. The blob is written under a temporary name then
+// renamed, so that a reader never sees a partial file.
+func (s *DiskStore) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return fmt.Errorf("creating blob directory: %w", err)
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return fmt.Errorf("creating blob: %w", err)
+	}
+	defer os.Remove(f.Name())
+
+	n, err := io.Copy(f, r)
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("writing blob: %w", err)
+	}
+	if n != size {
+		f.Close()
+		return fmt.Errorf("writing blob: wrote %d bytes, expected %d", n, size)
+	}
+	err = f.Close()
+	if err != nil {
+		return fmt.Errorf("writing blob: %w", err)
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// Get implements Store.
+func (s *DiskStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("opening blob: %w", err)
+	}
+	return f, nil
+}
+
+// Delete implements Store.
+func (s *DiskStore) Delete(ctx context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("deleting blob: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,205 @@
+// Package cache keeps computed values, such as query results and rendered
+// pages, for a limited time. Entries carry tags naming the data they were
+// computed from, so that a write can invalidate everything it affects.
+package cache
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Cache stores values under string keys.
+type Cache interface {
+	// Get returns the value stored under key, if it has not expired.
+	Get(key string) (any, bool)
+	// Set stores value under key for ttl, tagged with tags.
+	Set(key string, value any, ttl time.Duration, tags ...string)
+	// GetOrLoad returns the value stored under key, or stores and returns the
+	// value computed by load. Concurrent calls for a missing key share a
+	// single call to load. When load fails, its value and error are returned
+	// and nothing is stored.
+	GetOrLoad(key string, ttl time.Duration, tags []string, load func() (any, error)) (any, error)
+	// Invalidate removes the entries tagged with any of tags.
+	Invalidate(tags ...string)
+	// Clear removes every entry, after a change whose tags are unknown.
+	Clear()
+	// Stats returns the usage statistics of the cache.
+	Stats() Stats
+}
+
+// errLoadPanicked is returned to the callers waiting on a load that
+// panicked.
+var errLoadPanicked = errors.New("cache: load panicked")
+
+// Stats are the usage statistics of a cache.
+type Stats struct {
+	Hits          uint64
+	Misses        uint64
+	Evictions     uint64
+	Invalidations uint64
+	Entries       int
+}
+
+type entry struct {
+	key     string
+	value   any
+	expires time.Time
+	tags    []string
+}
+
+// call is a load in progress.
+type call struct {
+	done  chan struct{}
+	value any
+	err   error
+}
+
+// LRU is an in-memory Cache holding up to a number of entries, evicting the
+// least recently used first.
+type LRU struct {
+	mu       sync.Mutex
+	max      int
+	order    *list.List // front is most recently used
+	entries  map[string]*list.Element
+	tagged   map[string]map[string]struct{}
+	inflight map[string]*call
+	stats    Stats
+}
+
+// NewLRU returns an empty cache holding up to max entries.
+func NewLRU(max int) *LRU {
+	return &LRU{
+		max:      max,
+		order:    list.New(),
+		entries:  map[string]*list.Element{},
+		tagged:   map[string]map[string]struct{}{},
+		inflight: map[string]*call{},
+	}
+}
+
+func (c *LRU) Get(key string) (any, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.get(key)
+}
+
+// get looks key up and records a hit or miss. c.mu must be held.
+func (c *LRU) get(key string) (any, bool) {
+	el, ok := c.entries[key]
+	if ok && time.Now().After(el.Value.(*entry).expires) {
+		c.remove(el)
+		ok = false
+	}
+	if !ok {
+		c.stats.Misses++
+		return nil, false
+	}
+	c.stats.Hits++
+	c.order.MoveToFront(el)
+	return el.Value.(*entry).value, true
+}
+
+func (c *LRU) Set(key string, value any, ttl time.Duration, tags ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.set(key, value, ttl, tags)
+}
+
+// set stores an entry, evicting the least recently used ones beyond the
+// limit. c.mu must be held.
+func (c *LRU) set(key string, value any, ttl time.Duration, tags []string) {
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+	e := &entry{key: key, value: value, expires: time.Now().Add(ttl), tags: tags}
+	c.entries[key] = c.order.PushFront(e)
+	for _, tag := range tags {
+		keys, ok := c.tagged[tag]
+		if !ok {
+			keys = map[string]struct{}{}
+			c.tagged[tag] = keys
+		}
+		keys[key] = struct{}{}
+	}
+
+	for c.order.Len() > c.max {
+		c.remove(c.order.Back())
+		c.stats.Evictions++
+	}
+}
+
+func (c *LRU) GetOrLoad(key string, ttl time.Duration, tags []string, load func() (any, error)) (any, error) {
+	c.mu.Lock()
+	if value, ok := c.get(key); ok {
+		c.mu.Unlock()
+		return value, nil
+	}
+	if cl, ok := c.inflight[key]; ok {
+		c.mu.Unlock()
+		<-cl.done
+		return cl.value, cl.err
+	}
+	cl := &call{done: make(chan struct{}), err: errLoadPanicked}
+	c.inflight[key] = cl
+	gen := c.stats.Invalidations
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.inflight, key)
+		// A value loaded while an invalidation happened may predate the
+		// write, so it is returned but not stored.
+		if cl.err == nil && c.stats.Invalidations == gen {
+			c.set(key, cl.value, ttl, tags)
+		}
+		c.mu.Unlock()
+		close(cl.done)
+	}()
+	cl.value, cl.err = load()
+	return cl.value, cl.err
+}
+
+func (c *LRU) Invalidate(tags ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, tag := range tags {
+		for key := range c.tagged[tag] {
+			if el, ok := c.entries[key]; ok {
+				c.remove(el)
+			}
+		}
+	}
+	c.stats.Invalidations++
+}
+
+func (c *LRU) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.order.Init()
+	c.entries = map[string]*list.Element{}
+	c.tagged = map[string]map[string]struct{}{}
+	c.stats.Invalidations++
+}
+
+func (c *LRU) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Entries = c.order.Len()
+	return stats
+}
+
+// remove deletes an entry. c.mu must be held.
+func (c *LRU) remove(el *list.Element) {
+	e := c.order.Remove(el).(*entry)
+	delete(c.entries, e.key)
+	for _, tag := range e.tags {
+		keys := c.tagged[tag]
+		delete(keys, e.key)
+		if len(keys) == 0 {
+			delete(c.tagged, tag)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package cache
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLRU(t *testing.T) {
+	c := NewLRU(2)
+	c.Set("a", 1, time.Minute)
+	c.Set("b", 2, time.Minute)
+	_, ok := c.Get("a")
+	if !ok {
+		t.Error(`Get("a") missed`)
+	}
+
+	// b is now the least recently used entry.
+	c.Set("c", 3, time.Minute)
+	_, ok = c.Get("b")
+	if ok {
+		t.Error(`Get("b") found the least recently used entry`)
+	}
+	value, ok := c.Get("a")
+	if !ok {
+		t.Error(`Get("a") missed`)
+	}
+	if value != any(1) {
+		t.Errorf("value = %v; want %v", value, any(1))
+	}
+
+	c.Set("expired", 4, -time.Second)
+	_, ok = c.Get("expired")
+	if ok {
+		t.Error(`Get("expired") found an expired entry`)
+	}
+
+	stats := c.Stats()
+	if stats.Hits != uint64(2) {
+		t.Errorf("stats.Hits = %v; want %v", stats.Hits, uint64(2))
+	}
+	if stats.Misses != uint64(2) {
+		t.Errorf("stats.Misses = %v; want %v", stats.Misses, uint64(2))
+	}
+	if stats.Evictions != uint64(2) {
+		t.Errorf("stats.Evictions = %v; want %v", stats.Evictions, uint64(2))
+	}
+	if stats.Entries != 1 {
+		t.Errorf("stats.Entries = %v; want 1", stats.Entries)
+	}
+}
+
+func TestInvalidate(t *testing.T) {
+	c := NewLRU(10)
+	c.Set("home", "home page", time.Minute, "threads")
+	c.Set("thread/1", "thread 1", time.Minute, "threads", "thread:1")
+	c.Set("thread/2", "thread 2", time.Minute, "threads", "thread:2")
+	c.Set("profile", "profile", time.Minute)
+
+	c.Invalidate("thread:1")
+	for key, want := range map[string]bool{"home": true, "thread/1": false, "thread/2": true, "profile": true} {
+		_, ok := c.Get(key)
+		if ok != want {
+			t.Errorf("after invalidating thread:1, Get(%q) found %t; want %t", key, ok, want)
+		}
+	}
+
+	// Replacing an entry drops its previous tags.
+	c.Set("thread/2", "thread 2", time.Minute)
+	c.Invalidate("threads")
+	for key, want := range map[string]bool{"home": false, "thread/2": true, "profile": true} {
+		_, ok := c.Get(key)
+		if ok != want {
+			t.Errorf("after invalidating threads, Get(%q) found %t; want %t", key, ok, want)
+		}
+	}
+	if got := len(c.tagged); got != 0 {
+		t.Errorf("len(c.tagged) = %v; want 0", got)
+	}
+
+	c.Clear()
+	if got := c.Stats().Entries; got != 0 {
+		t.Errorf("c.Stats().Entries = %v; want 0", got)
+	}
+	if got := c.Stats().Invalidations; got != uint64(3) {
+		t.Errorf("c.Stats().Invalidations = %v; want %v", got, uint64(3))
+	}
+}
+
+func TestGetOrLoad(t *testing.T) {
+	c := NewLRU(10)
+	var loads atomic.Int32
+	release := make(chan struct{})
+	load := func() (any, error) {
+		loads.Add(1)
+		<-release
+		return "value", nil
+	}
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			value, err := c.GetOrLoad("key", time.Minute, nil, load)
+			if err != nil || value != "value" {
+				t.Errorf("GetOrLoad returned %v, %v; want value", value, err)
+			}
+		}()
+	}
+	// Let the callers pile up on the first load.
+	for {
+		c.mu.Lock()
+		_, loading := c.inflight["key"]
+		c.mu.Unlock()
+		if loading {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if got := loads.Load(); got != int32(1) {
+		t.Errorf("loads.Load() = %v; want %v", got, int32(1))
+	}
+
+	_, err := c.GetOrLoad("key", time.Minute, nil, func() (any, error) {
+		t.Error("loaded a cached value")
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errLoad := errors.New("load failed")
+	_, err = c.GetOrLoad("failing", time.Minute, nil, func() (any, error) { return nil, errLoad })
+	if !errors.Is(err, errLoad) {
+		t.Errorf("GetOrLoad returned %v; want %v", err, errLoad)
+	}
+	_, ok := c.Get("failing")
+	if ok {
+		t.Error("cached the error of a load")
+	}
+}
+
+// TestGetOrLoadInvalidated checks that a value loaded while its data was
+// written is not cached, as it may predate the write.
+func TestGetOrLoadInvalidated(t *testing.T) {
+	c := NewLRU(10)
+	value, err := c.GetOrLoad("thread/1", time.Minute, []string{"thread:1"}, func() (any, error) {
+		c.Invalidate("thread:1")
+		return "stale", nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != any("stale") {
+		t.Errorf("value = %v; want %v", value, any("stale"))
+	}
+	_, ok := c.Get("thread/1")
+	if ok {
+		t.Error("cached a value loaded before its tag was invalidated")
+	}
+
+	value, err = c.GetOrLoad("thread/1", time.Minute, []string{"thread:1"}, func() (any, error) {
+		return "fresh", nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != any("fresh") {
+		t.Errorf("value = %v; want %v", value, any("fresh"))
+	}
+	value, ok = c.Get("thread/1")
+	if !ok {
+		t.Error("did not cache the value loaded after the invalidation")
+	}
+	if value != any("fresh") {
+		t.Errorf("value = %v; want %v", value, any("fresh"))
+	}
+}
@@ -0,0 +1,584 @@
+// Package config loads the settings of the web server and of forumctl. Each
+// setting has a default, which a TOML file, then an environment variable,
+// then a command-line flag can override, in that order of precedence.
+//
+// Settings are declared as tagged struct fields:
+//
+//	toml:   key of the setting in its section of the file
+//	flag:   name of the command-line flag
+//	env:    environment variable, FORUM_<SECTION>_<KEY> when omitted
+//	help:   usage of the flag
+//	secret: "true" when the value must never be printed
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"net/url"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Config holds every setting of the web server.
+type Config struct {
+	Server   Server   `toml:"server"`
+	Database Database `toml:"database"`
+	Session  Session  `toml:"session"`
+	Storage  Storage  `toml:"storage"`
+	Limits   Limits   `toml:"limits"`
+	Security Security `toml:"security"`
+	Metrics  Metrics  `toml:"metrics"`
+	Log      Log      `toml:"log"`
+	Cache    Cache    `toml:"cache"`
+	Backup   Backup   `toml:"backup"`
+}
+
+// Server holds the settings of the HTTP servers.
+type Server struct {
+	Addr              string        `toml:"addr" flag:"addr" help:"HTTP network address"`
+	BaseURL           string        `toml:"base_url" flag:"baseURL" help:"Public URL of the forum, such as https://forum.example.com, used in the links of feeds and webhook payloads"`
+	ReadHeaderTimeout time.Duration `toml:"read_header_timeout" flag:"readHeaderTimeout" help:"Maximum duration for reading request headers"`
+	ReadTimeout       time.Duration `toml:"read_timeout" flag:"readTimeout" help:"Maximum duration for reading a whole request, including uploads"`
+	WriteTimeout      time.Duration `toml:"write_timeout" flag:"writeTimeout" help:"Maximum duration for writing a response"`
+	IdleTimeout       time.Duration `toml:"idle_timeout" flag:"idleTimeout" help:"Maximum time to wait for the next request on a keep-alive connection"`
+	ShutdownTimeout   time.Duration `toml:"shutdown_timeout" flag:"shutdownTimeout" help:"Maximum time to wait for in-flight requests on shutdown"`
+	TLSCert           string        `toml:"tls_cert" flag:"tlsCert" help:"TLS certificate file; HTTPS is served when set with -tlsKey"`
+	TLSKey            string        `toml:"tls_key" flag:"tlsKey" help:"TLS private key file"`
+	RedirectAddr      string        `toml:"redirect_addr" flag:"redirectAddr" help:"HTTP address redirecting to HTTPS when TLS is enabled, e.g. :80"`
+	Dev               bool          `toml:"dev" flag:"dev" help:"Serve templates and static files from -uiDir on disk, reloading templates when they change"`
+	UIDir             string        `toml:"ui_dir" flag:"uiDir" help:"Directory of the templates and static files read with -dev"`
+}
+
+// UseTLS reports whether the server is configured for HTTPS.
+func (s Server) UseTLS() bool {
+	return s.TLSCert != "" && s.TLSKey != ""
+}
+
+// Database holds the settings of the database.
+type Database struct {
+	Path         string        `toml:"path" flag:"dbPath" help:"Path to database file"`
+	URL          string        `toml:"url" flag:"dbURL" env:"DATABASE_URL" secret:"true" help:"PostgreSQL URL (postgres://...) to use instead of the database file"`
+	JournalMode  string        `toml:"journal_mode" flag:"dbJournalMode" help:"SQLite journal mode: wal, delete, truncate, persist, memory or off"`
+	BusyTimeout  time.Duration `toml:"busy_timeout" flag:"dbBusyTimeout" help:"How long SQLite waits for a lock held by another connection"`
+	ForeignKeys  bool          `toml:"foreign_keys" flag:"dbForeignKeys" help:"Enforce the foreign keys of the SQLite schema"`
+	Synchronous  string        `toml:"synchronous" flag:"dbSynchronous" help:"SQLite synchronous level: off, normal, full or extra"`
+	ReadConns    int           `toml:"read_conns" flag:"dbReadConns" help:"Number of SQLite connections serving queries, besides the single one writing"`
+	QueryTimeout time.Duration `toml:"query_timeout" flag:"dbQueryTimeout" help:"Maximum duration of each call to the database models"`
+}
+
+// DSN returns the name of the database to open: the PostgreSQL URL if set,
+// else the path of the SQLite database.
+func (d Database) DSN() string {
+	if d.URL != "" {
+		return d.URL
+	}
+	return d.Path
+}
+
+// Session holds the settings of the user sessions.
+type Session struct {
+	Lifetime time.Duration `toml:"lifetime" flag:"sessionLifetime" help:"Duration after which a session expires"`
+}
+
+// Storage holds the settings of the file stores.
+type Storage struct {
+	AvatarDir   string `toml:"avatar_dir" flag:"avatarDir" help:"Directory where uploaded avatars are stored"`
+	BlobStore   string `toml:"blob_store" flag:"blobStore" help:"Where attachments are stored: disk or s3"`
+	BlobDir     string `toml:"blob_dir" flag:"blobDir" help:"Directory where attachments are stored with -blobStore=disk"`
+	S3Endpoint  string `toml:"s3_endpoint" flag:"s3Endpoint" help:"S3-compatible endpoint URL used with -blobStore=s3"`
+	S3Bucket    string `toml:"s3_bucket" flag:"s3Bucket" help:"S3 bucket used with -blobStore=s3"`
+	S3Region    string `toml:"s3_region" flag:"s3Region" help:"S3 region used with -blobStore=s3"`
+	S3AccessKey string `toml:"s3_access_key" flag:"s3AccessKey" env:"AWS_ACCESS_KEY_ID" secret:"true" help:"S3 access key ID used with -blobStore=s3"`
+	S3SecretKey string `toml:"s3_secret_key" flag:"s3SecretKey" env:"AWS_SECRET_ACCESS_KEY" secret:"true" help:"S3 secret access key used with -blobStore=s3"`
+}
+
+// Limits holds the sizes of pages and the bounds on user input.
+type Limits struct {
+	TitleMaxChars     int `toml:"title_max_chars" flag:"titleMaxChars" help:"Maximum length of thread titles and conversation subjects"`
+	BodyMaxChars      int `toml:"body_max_chars" flag:"bodyMaxChars" help:"Maximum length of posts and private messages"`
+	HomeThreads       int `toml:"home_threads" flag:"homeThreads" help:"Number of threads listed on the home page"`
+	ActivityPageSize  int `toml:"activity_page_size" flag:"activityPageSize" help:"Number of threads and posts per page of a profile's activity"`
+	SearchPageSize    int `toml:"search_page_size" flag:"searchPageSize" help:"Number of posts per page of search results"`
+	FeedSize          int `toml:"feed_size" flag:"feedSize" help:"Number of entries listed in a feed"`
+	MaxAttachments    int `toml:"max_attachments" flag:"maxAttachments" help:"Maximum number of files attached to one post"`
+	AttachmentQuotaMB int `toml:"attachment_quota_mb" flag:"attachmentQuotaMB" help:"Total size of the files each user can attach, in MB"`
+	MaxRecipients     int `toml:"max_recipients" flag:"maxRecipients" help:"Maximum number of recipients of a conversation, besides its author"`
+}
+
+// Security holds the security headers sent with every response.
+type Security struct {
+	ContentSecurityPolicy string `toml:"content_security_policy" flag:"csp" help:"Content-Security-Policy header sent with every response"`
+}
+
+// Metrics holds the settings of the Prometheus metrics endpoint.
+type Metrics struct {
+	Addr  string `toml:"addr" flag:"metricsAddr" help:"Address serving /metrics on its own; it is served with the site when blank, which requires metrics.allow"`
+	Allow string `toml:"allow" flag:"metricsAllow" help:"Comma-separated IPs and CIDR ranges allowed to read /metrics; anyone reaching metrics.addr when blank"`
+}
+
+// AllowedPrefixes parses the networks allowed to read the metrics.
+func (m Metrics) AllowedPrefixes() ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, field := range strings.Split(m.Allow, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if !strings.Contains(field, "/") {
+			addr, err := netip.ParseAddr(field)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(field)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// Log holds the settings of the log output.
+type Log struct {
+	Format string `toml:"format" flag:"logFormat" help:"Format of the log output: text or json"`
+	Level  string `toml:"level" flag:"logLevel" help:"Lowest level logged: debug, info, warn or error"`
+}
+
+// SlogLevel parses the lowest level logged.
+func (l Log) SlogLevel() (slog.Level, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(l.Level))
+	return level, err
+}
+
+// Cache holds the settings of the cache of pages and query results.
+type Cache struct {
+	MaxEntries    int           `toml:"max_entries" flag:"cacheMaxEntries" help:"Maximum number of pages and query results cached"`
+	PageTTL       time.Duration `toml:"page_ttl" flag:"cachePageTTL" help:"How long pages are cached for anonymous visitors"`
+	QueryTTL      time.Duration `toml:"query_ttl" flag:"cacheQueryTTL" help:"How long query results are cached"`
+	CheckInterval time.Duration `toml:"check_interval" flag:"cacheCheckInterval" help:"How often a SQLite database is checked for changes made by other processes, such as forumctl, which clear the cache"`
+}
+
+// Backup holds the settings of the scheduled backups of the SQLite database.
+type Backup struct {
+	Dir      string        `toml:"dir" flag:"backupDir" help:"Directory of the scheduled backups of the database; none are taken when blank"`
+	Interval time.Duration `toml:"interval" flag:"backupInterval" help:"Time between two scheduled backups"`
+	Keep     int           `toml:"keep" flag:"backupKeep" help:"Number of backups kept in -backupDir, the oldest being removed"`
+}
+
+// Default returns the default settings.
+func Default() *Config {
+	return &Config{
+		Server: Server{
+			Addr:              ":5000",
+			BaseURL:           "http://localhost:5000",
+			ReadHeaderTimeout: 5 * time.Second,
+			ReadTimeout:       30 * time.Second,
+			WriteTimeout:      30 * time.Second,
+			IdleTimeout:       2 * time.Minute,
+			ShutdownTimeout:   30 * time.Second,
+			UIDir:             "./ui",
+		},
+		Database: Database{
+			Path:         "./db.sqlite",
+			JournalMode:  "wal",
+			BusyTimeout:  5 * time.Second,
+			ForeignKeys:  true,
+			Synchronous:  "normal",
+			ReadConns:    4,
+			QueryTimeout: 10 * time.Second,
+		},
+		Session: Session{
+			Lifetime: 12 * time.Hour,
+		},
+		Storage: Storage{
+			AvatarDir: "./data/avatars",
+			BlobStore: "disk",
+			BlobDir:   "./data/blobs",
+			S3Region:  "us-east-1",
+		},
+		Limits: Limits{
+			TitleMaxChars:     100,
+			BodyMaxChars:      1000,
+			HomeThreads:       9,
+			ActivityPageSize:  20,
+			SearchPageSize:    20,
+			FeedSize:          30,
+			MaxAttachments:    5,
+			AttachmentQuotaMB: 50,
+			MaxRecipients:     9,
+		},
+		Security: Security{
+			ContentSecurityPolicy: "default-src 'self'; style-src 'self' fonts.googleapis.com; font-src fonts.gstatic.com",
+		},
+		Metrics: Metrics{
+			Addr: "127.0.0.1:9090",
+		},
+		Log: Log{
+			Format: "text",
+			Level:  "info",
+		},
+		Cache: Cache{
+			MaxEntries:    1000,
+			PageTTL:       30 * time.Second,
+			QueryTTL:      time.Minute,
+			CheckInterval: 2 * time.Second,
+		},
+		Backup: Backup{
+			Interval: 24 * time.Hour,
+			Keep:     7,
+		},
+	}
+}
+
+// setting is a single field of a Config.
+type setting struct {
+	section string
+	key     string
+	flag    string
+	env     string
+	help    string
+	secret  bool
+	value   reflect.Value
+}
+
+// settings returns the settings of c, in declaration order.
+func (c *Config) settings() []setting {
+	var all []setting
+	root := reflect.ValueOf(c).Elem()
+	for i := range root.NumField() {
+		section := root.Type().Field(i)
+		sv := root.Field(i)
+		for j := range sv.NumField() {
+			f := sv.Type().Field(j)
+			s := setting{
+				section: section.Tag.Get("toml"),
+				key:     f.Tag.Get("toml"),
+				flag:    f.Tag.Get("flag"),
+				env:     f.Tag.Get("env"),
+				help:    f.Tag.Get("help"),
+				secret:  f.Tag.Get("secret") == "true",
+				value:   sv.Field(j),
+			}
+			if s.env == "" {
+				s.env = strings.ToUpper("FORUM_" + s.section + "_" + s.key)
+			}
+			all = append(all, s)
+		}
+	}
+	return all
+}
+
+// name returns the name of the setting in the file, as section.key.
+func (s setting) name() string {
+	return s.section + "." + s.key
+}
+
+// set parses text into the setting.
+func (s setting) set(text string) error {
+	v := s.value
+	switch {
+	case v.Type() == reflect.TypeOf(time.Duration(0)):
+		d, err := time.ParseDuration(text)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+	case v.Kind() == reflect.String:
+		v.SetString(text)
+	case v.Kind() == reflect.Int:
+		n, err := strconv.Atoi(text)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(n))
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(text)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+// display returns the value of the setting as shown to operators, with
+// secrets redacted.
+func (s setting) display() string {
+	if s.secret && !s.value.IsZero() {
+		return "<redacted>"
+	}
+	if d, ok := s.value.Interface().(time.Duration); ok {
+		return d.String()
+	}
+	return fmt.Sprint(s.value.Interface())
+}
+
+// flagValue is a flag.Value setting a field of the configuration the flags
+// were registered for.
+type flagValue struct {
+	s setting
+}
+
+func (f flagValue) String() string {
+	if !f.s.value.IsValid() {
+		return ""
+	}
+	return f.s.display()
+}
+
+func (f flagValue) Set(text string) error {
+	return f.s.set(text)
+}
+
+func (f flagValue) IsBoolFlag() bool {
+	return f.s.value.Kind() == reflect.Bool
+}
+
+// Load parses args with fs, on which it registers a flag for every setting
+// and a -config flag naming the TOML file to load. FORUM_CONFIG names the
+// file when the flag is not given. The resulting configuration is validated.
+func Load(fs *flag.FlagSet, args []string, getenv func(string) string) (*Config, error) {
+	// Flags are parsed into a scratch configuration first: they are applied
+	// last, on top of the file and the environment.
+	flags := Default()
+	byFlag := map[string]setting{}
+	for _, s := range flags.settings() {
+		fs.Var(flagValue{s}, s.flag, s.help)
+		byFlag[s.flag] = s
+	}
+	path := fs.String("config", "", "Path to a TOML configuration file")
+
+	err := fs.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+	if *path == "" {
+		*path = getenv("FORUM_CONFIG")
+	}
+
+	cfg := Default()
+	if *path != "" {
+		err = cfg.loadFile(*path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	settings := map[string]setting{}
+	for _, s := range cfg.settings() {
+		settings[s.flag] = s
+		if text := getenv(s.env); text != "" {
+			err := s.set(text)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", s.env, err)
+			}
+		}
+	}
+
+	fs.Visit(func(f *flag.Flag) {
+		if s, ok := byFlag[f.Name]; ok {
+			settings[f.Name].value.Set(s.value)
+		}
+	})
+
+	err = cfg.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// loadFile applies the settings of a TOML file. Unknown keys are errors, so
+// that typos do not go unnoticed.
+func (c *Config) loadFile(path string) error {
+	md, err := toml.DecodeFile(path, c)
+	if err != nil {
+		return fmt.Errorf("loading configuration file: %w", err)
+	}
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		keys := make([]string, len(undecoded))
+		for i, k := range undecoded {
+			keys[i] = k.String()
+		}
+		return fmt.Errorf("loading configuration file %s: unknown settings %s", path, strings.Join(keys, ", "))
+	}
+	return nil
+}
+
+// Validate checks that the settings are usable, and reports every problem
+// found.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	_, _, err := net.SplitHostPort(c.Server.Addr)
+	check(err == nil, "server.addr: %q is not a host:port address", c.Server.Addr)
+	u, err := url.Parse(c.Server.BaseURL)
+	check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" &&
+		strings.TrimSuffix(u.Path, "/") == "" && u.RawQuery == "" && u.Fragment == "",
+		"server.base_url: %q is not an http or https URL without a path", c.Server.BaseURL)
+	for _, d := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"server.read_header_timeout", c.Server.ReadHeaderTimeout},
+		{"server.read_timeout", c.Server.ReadTimeout},
+		{"server.write_timeout", c.Server.WriteTimeout},
+		{"server.idle_timeout", c.Server.IdleTimeout},
+		{"server.shutdown_timeout", c.Server.ShutdownTimeout},
+		{"database.busy_timeout", c.Database.BusyTimeout},
+		{"database.query_timeout", c.Database.QueryTimeout},
+		{"session.lifetime", c.Session.Lifetime},
+		{"cache.page_ttl", c.Cache.PageTTL},
+		{"cache.query_ttl", c.Cache.QueryTTL},
+		{"cache.check_interval", c.Cache.CheckInterval},
+		{"backup.interval", c.Backup.Interval},
+	} {
+		check(d.value > 0, "%s: must be positive", d.name)
+	}
+	check((c.Server.TLSCert == "") == (c.Server.TLSKey == ""), "server.tls_cert and server.tls_key: must be set together")
+	for _, f := range []struct{ name, path string }{
+		{"server.tls_cert", c.Server.TLSCert},
+		{"server.tls_key", c.Server.TLSKey},
+	} {
+		if f.path != "" {
+			_, err := os.Stat(f.path)
+			check(err == nil, "%s: %v", f.name, err)
+		}
+	}
+	if c.Server.RedirectAddr != "" {
+		check(c.Server.UseTLS(), "server.redirect_addr: requires TLS")
+		_, _, err := net.SplitHostPort(c.Server.RedirectAddr)
+		check(err == nil, "server.redirect_addr: %q is not a host:port address", c.Server.RedirectAddr)
+	}
+
+	if c.Server.Dev {
+		info, err := os.Stat(c.Server.UIDir)
+		check(err == nil && info.IsDir(), "server.ui_dir: %q is not a directory", c.Server.UIDir)
+	}
+
+	check(c.Database.Path != "", "database.path: cannot be blank")
+	check(c.Database.URL == "" || strings.HasPrefix(c.Database.URL, "postgres://") || strings.HasPrefix(c.Database.URL, "postgresql://"),
+		"database.url: must start with postgres:// or postgresql://")
+	check(slices.Contains([]string{"wal", "delete", "truncate", "persist", "memory", "off"}, c.Database.JournalMode),
+		"database.journal_mode: must be wal, delete, truncate, persist, memory or off, not %q", c.Database.JournalMode)
+	check(slices.Contains([]string{"off", "normal", "full", "extra"}, c.Database.Synchronous),
+		"database.synchronous: must be off, normal, full or extra, not %q", c.Database.Synchronous)
+
+	check(c.Backup.Dir == "" || c.Database.URL == "", "backup.dir: PostgreSQL databases are backed up with pg_dump")
+
+	check(c.Storage.AvatarDir != "", "storage.avatar_dir: cannot be blank")
+	check(slices.Contains([]string{"disk", "s3"}, c.Storage.BlobStore), "storage.blob_store: must be disk or s3, not %q", c.Storage.BlobStore)
+	switch c.Storage.BlobStore {
+	case "disk":
+		check(c.Storage.BlobDir != "", "storage.blob_dir: cannot be blank with the disk blob store")
+	case "s3":
+		check(c.Storage.S3Endpoint != "", "storage.s3_endpoint: cannot be blank with the s3 blob store")
+		check(c.Storage.S3Bucket != "", "storage.s3_bucket: cannot be blank with the s3 blob store")
+		check(c.Storage.S3Region != "", "storage.s3_region: cannot be blank with the s3 blob store")
+		check(c.Storage.S3AccessKey != "" && c.Storage.S3SecretKey != "", "storage.s3_access_key and storage.s3_secret_key: required by the s3 blob store")
+	}
+
+	for _, l := range []struct {
+		name       string
+		value, max int
+	}{
+		{"database.read_conns", c.Database.ReadConns, 100},
+		{"limits.title_max_chars", c.Limits.TitleMaxChars, 1000},
+		{"limits.body_max_chars", c.Limits.BodyMaxChars, 100000},
+		{"limits.home_threads", c.Limits.HomeThreads, 100},
+		{"limits.activity_page_size", c.Limits.ActivityPageSize, 100},
+		{"limits.search_page_size", c.Limits.SearchPageSize, 100},
+		{"limits.feed_size", c.Limits.FeedSize, 100},
+		{"limits.max_attachments", c.Limits.MaxAttachments, 20},
+		{"limits.attachment_quota_mb", c.Limits.AttachmentQuotaMB, 100000},
+		{"limits.max_recipients", c.Limits.MaxRecipients, 100},
+		{"cache.max_entries", c.Cache.MaxEntries, 1000000},
+		{"backup.keep", c.Backup.Keep, 1000},
+	} {
+		check(l.value >= 1 && l.value <= l.max, "%s: must be between 1 and %d", l.name, l.max)
+	}
+
+	check(strings.TrimSpace(c.Security.ContentSecurityPolicy) != "", "security.content_security_policy: cannot be blank")
+
+	if c.Metrics.Addr != "" {
+		_, _, err := net.SplitHostPort(c.Metrics.Addr)
+		check(err == nil, "metrics.addr: %q is not a host:port address", c.Metrics.Addr)
+		check(c.Metrics.Addr != c.Server.Addr, "metrics.addr: must differ from server.addr")
+	} else {
+		// Behind a reverse proxy every visitor seems to come from the
+		// proxy, so the site's address must not expose the metrics unless
+		// told who may read them.
+		check(strings.TrimSpace(c.Metrics.Allow) != "", "metrics.allow: required when metrics.addr is blank")
+	}
+	_, err = c.Metrics.AllowedPrefixes()
+	check(err == nil, "metrics.allow: %v", err)
+
+	check(slices.Contains([]string{"text", "json"}, c.Log.Format), "log.format: must be text or json, not %q", c.Log.Format)
+	_, err = c.Log.SlogLevel()
+	check(err == nil, "log.level: %v", err)
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// LogValue implements slog.LogValuer, logging the configuration with its
+// secrets redacted.
+func (c *Config) LogValue() slog.Value {
+	var attrs []slog.Attr
+	for _, s := range c.settings() {
+		attrs = append(attrs, slog.String(s.name(), s.display()))
+	}
+	return slog.GroupValue(attrs...)
+}
+
+// WriteTOML writes the configuration as a TOML file, with its secrets
+// redacted.
+func (c *Config) WriteTOML(w io.Writer) error {
+	section := ""
+	for _, s := range c.settings() {
+		if s.section != section {
+			if section != "" {
+				fmt.Fprintln(w)
+			}
+			section = s.section
+			fmt.Fprintf(w, "[%s]\n", section)
+		}
+
+		value := s.display()
+		switch s.value.Kind() {
+		case reflect.String:
+			value = strconv.Quote(value)
+		case reflect.Int64:
+			value = strconv.Quote(value) // time.Duration
+		}
+		_, err := fmt.Fprintf(w, "%s = %s\n", s.key, value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,203 @@
+package config
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeFile writes a configuration file in a temporary directory and returns
+// its path.
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "forum.toml")
+	err := os.WriteFile(path, []byte(content), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// load runs Load with a fresh flag set and env as the environment.
+func load(args []string, env map[string]string) (*Config, error) {
+	fs := flag.NewFlagSet("forum", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	return Load(fs, args, func(key string) string { return env[key] })
+}
+
+func TestLoadDefaults(t *testing.T) {
+	cfg, err := load(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cfg, Default()) {
+		t.Errorf("got %+v; want the defaults", cfg)
+	}
+}
+
+// TestLoadPrecedence checks that the file overrides the defaults, the
+// environment the file, and the flags the environment.
+func TestLoadPrecedence(t *testing.T) {
+	path := writeFile(t, `
+[limits]
+feed_size = 10
+home_threads = 11
+title_max_chars = 12
+
+[session]
+lifetime = "3h"
+
+[database]
+busy_timeout = "3s"
+`)
+	env := map[string]string{
+		"FORUM_LIMITS_HOME_THREADS":    "21",
+		"FORUM_LIMITS_TITLE_MAX_CHARS": "22",
+		"FORUM_SESSION_LIFETIME":       "4h",
+		"AWS_ACCESS_KEY_ID":            "forum",
+		"FORUM_DATABASE_FOREIGN_KEYS":  "false",
+		"DATABASE_URL":                 "postgres://forum@localhost/forum",
+	}
+	// A flag given its default value still overrides the environment.
+	args := []string{"-config", path, "-titleMaxChars", "32", "-sessionLifetime", "12h", "-dbForeignKeys=true"}
+
+	cfg, err := load(args, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Limits.BodyMaxChars != Default().Limits.BodyMaxChars {
+		t.Errorf("cfg.Limits.BodyMaxChars = %v; want %v", cfg.Limits.BodyMaxChars, Default().Limits.BodyMaxChars)
+	}
+	if cfg.Limits.FeedSize != 10 {
+		t.Errorf("cfg.Limits.FeedSize = %v; want 10", cfg.Limits.FeedSize)
+	}
+	if cfg.Limits.HomeThreads != 21 {
+		t.Errorf("cfg.Limits.HomeThreads = %v; want 21", cfg.Limits.HomeThreads)
+	}
+	if cfg.Limits.TitleMaxChars != 32 {
+		t.Errorf("cfg.Limits.TitleMaxChars = %v; want 32", cfg.Limits.TitleMaxChars)
+	}
+	if cfg.Session.Lifetime != 12*time.Hour {
+		t.Errorf("cfg.Session.Lifetime = %v; want %v", cfg.Session.Lifetime, 12*time.Hour)
+	}
+	if cfg.Storage.S3AccessKey != "forum" {
+		t.Errorf("cfg.Storage.S3AccessKey = %q; want %q", cfg.Storage.S3AccessKey, "forum")
+	}
+	if cfg.Database.BusyTimeout != 3*time.Second {
+		t.Errorf("cfg.Database.BusyTimeout = %v; want %v", cfg.Database.BusyTimeout, 3*time.Second)
+	}
+	if !cfg.Database.ForeignKeys {
+		t.Error("cfg.Database.ForeignKeys = false; want true")
+	}
+	if got := cfg.Database.DSN(); got != "postgres://forum@localhost/forum" {
+		t.Errorf("cfg.Database.DSN() = %q; want %q", got, "postgres://forum@localhost/forum")
+	}
+
+	// FORUM_CONFIG names the file when -config is not given.
+	env["FORUM_CONFIG"] = path
+	cfg, err = load(nil, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Limits.FeedSize != 10 {
+		t.Errorf("cfg.Limits.FeedSize = %v; want 10", cfg.Limits.FeedSize)
+	}
+	if cfg.Session.Lifetime != 4*time.Hour {
+		t.Errorf("cfg.Session.Lifetime = %v; want %v", cfg.Session.Lifetime, 4*time.Hour)
+	}
+	if cfg.Database.ForeignKeys {
+		t.Error("cfg.Database.ForeignKeys = true; want false")
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		file string
+		env  map[string]string
+		args []string
+		want string
+	}{
+		{
+			name: "unknown setting",
+			file: "[limits]\nfeed_sise = 10\n",
+			want: "unknown settings limits.feed_sise",
+		},
+		{
+			name: "invalid environment variable",
+			env:  map[string]string{"FORUM_LIMITS_FEED_SIZE": "many"},
+			want: "invalid FORUM_LIMITS_FEED_SIZE",
+		},
+		{
+			name: "invalid flag",
+			args: []string{"-dbBusyTimeout", "soon"},
+			want: "invalid value",
+		},
+		{
+			name: "invalid settings",
+			args: []string{"-feedSize", "0", "-dbJournalMode", "fast"},
+			want: "limits.feed_size: must be between 1 and 100",
+		},
+		{
+			name: "base URL with a path",
+			args: []string{"-baseURL", "https://example.com/forum"},
+			want: "server.base_url: \"https://example.com/forum\" is not an http or https URL without a path",
+		},
+		{
+			name: "blank metrics address",
+			args: []string{"-metricsAddr", ""},
+			want: "metrics.allow: required when metrics.addr is blank",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			args := tt.args
+			if tt.file != "" {
+				args = append([]string{"-config", writeFile(t, tt.file)}, args...)
+			}
+			_, err := load(args, tt.env)
+			if err == nil {
+				t.Fatal("loaded an invalid configuration")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("%q not found in:\n%s", tt.want, err.Error())
+			}
+		})
+	}
+}
+
+// TestWriteTOML checks that the file written can be loaded back, and does
+// not reveal secrets.
+func TestWriteTOML(t *testing.T) {
+	cfg := Default()
+	cfg.Limits.FeedSize = 42
+	cfg.Cache.PageTTL = 90 * time.Second
+	cfg.Storage.S3SecretKey = "hunter2"
+
+	var buf bytes.Buffer
+	err := cfg.WriteTOML(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "hunter2") {
+		t.Errorf("%q unexpectedly found in:\n%s", "hunter2", buf.String())
+	}
+	if !strings.Contains(buf.String(), `s3_secret_key = "<redacted>"`) {
+		t.Errorf("%q not found in:\n%s", `s3_secret_key = "<redacted>"`, buf.String())
+	}
+
+	loaded := Default()
+	err = loaded.loadFile(writeFile(t, buf.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded.Storage.S3SecretKey = cfg.Storage.S3SecretKey
+	if !reflect.DeepEqual(loaded, cfg) {
+		t.Errorf("loaded %+v; want %+v", loaded, cfg)
+	}
+}
@@ -0,0 +1,120 @@
+package feed
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestWrite(t *testing.T) {
+	for _, tt := range []struct {
+		format string
+		want   string
+	}{
+		{"atom", `<?xml version="1.0" encoding="UTF-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+  <title>Tips &amp; tricks</title>
+  <id>https://forum.example.com/feed/atom/thread/1</id>
+  <updated>2024-03-01T10:00:00Z</updated>
+  <link href="https://forum.example.com/thread/view/1" rel="alternate" type="text/html"></link>
+  <link href="https://forum.example.com/feed/atom/thread/1" rel="self" type="application/atom+xml"></link>
+  <entry>
+    <title>alice replied to Tips &amp; tricks</title>
+    <id>https://forum.example.com/thread/view/1#post-2</id>
+    <link href="https://forum.example.com/thread/view/1#post-2" rel="alternate"></link>
+    <published>2024-03-01T10:00:00Z</published>
+    <updated>2024-03-01T10:00:00Z</updated>
+    <author>
+      <name>alice</name>
+    </author>
+    <content type="text">Use &lt;b&gt;bold&lt;/b&gt; &amp; more</content>
+  </entry>
+  <entry>
+    <title>New thread: Tips &amp; tricks</title>
+    <id>https://forum.example.com/thread/view/1</id>
+    <link href="https://forum.example.com/thread/view/1" rel="alternate"></link>
+    <published>2024-03-01T09:00:00Z</published>
+    <updated>2024-03-01T09:00:00Z</updated>
+  </entry>
+</feed>`},
+		{"rss", `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0" xmlns:atom="http://www.w3.org/2005/Atom" xmlns:dc="http://purl.org/dc/elements/1.1/">
+  <channel>
+    <title>Tips &amp; tricks</title>
+    <link>https://forum.example.com/thread/view/1</link>
+    <description>Replies to &lt;Tips&gt;.</description>
+    <lastBuildDate>Fri, 01 Mar 2024 10:00:00 +0000</lastBuildDate>
+    <atom:link href="https://forum.example.com/feed/rss/thread/1" rel="self" type="application/rss+xml"></atom:link>
+    <item>
+      <title>alice replied to Tips &amp; tricks</title>
+      <link>https://forum.example.com/thread/view/1#post-2</link>
+      <guid isPermaLink="true">https://forum.example.com/thread/view/1#post-2</guid>
+      <pubDate>Fri, 01 Mar 2024 10:00:00 +0000</pubDate>
+      <dc:creator>alice</dc:creator>
+      <description>Use &lt;b&gt;bold&lt;/b&gt; &amp; more</description>
+    </item>
+    <item>
+      <title>New thread: Tips &amp; tricks</title>
+      <link>https://forum.example.com/thread/view/1</link>
+      <guid isPermaLink="true">https://forum.example.com/thread/view/1</guid>
+      <pubDate>Fri, 01 Mar 2024 09:00:00 +0000</pubDate>
+    </item>
+  </channel>
+</rss>`},
+	} {
+		t.Run(tt.format, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := testFeed(tt.format).Write(&buf, tt.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.String(); got != tt.want {
+				t.Errorf("buf.String() = %v; want %v", got, tt.want)
+			}
+
+			// The document is well-formed.
+			dec := xml.NewDecoder(&buf)
+			for {
+				_, err := dec.Token()
+				if err != nil {
+					if !errors.Is(err, io.EOF) {
+						t.Errorf("parsing the feed: %v", err)
+					}
+					break
+				}
+			}
+		})
+	}
+}
+
+// testFeed returns a feed of two items, one with neither author nor content,
+// whose text needs escaping.
+func testFeed(format string) *Feed {
+	published := time.Date(2024, 3, 1, 10, 0, 0, 0, time.FixedZone("CET", 3600))
+	return &Feed{
+		Title:       "Tips & tricks",
+		Description: "Replies to <Tips>.",
+		Link:        "https://forum.example.com/thread/view/1",
+		Self:        "https://forum.example.com/feed/" + format + "/thread/1",
+		Updated:     published.Add(time.Hour),
+		Items: []Item{
+			{
+				Title:     "alice replied to Tips & tricks",
+				Link:      "https://forum.example.com/thread/view/1#post-2",
+				Author:    "alice",
+				Content:   "Use <b>bold</b> & more",
+				Published: published.Add(time.Hour),
+				Updated:   published.Add(time.Hour),
+			},
+			{
+				Title:     "New thread: Tips & tricks",
+				Link:      "https://forum.example.com/thread/view/1",
+				Published: published,
+				Updated:   published,
+			},
+		},
+	}
+}
@@ -0,0 +1,84 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWriteText(t *testing.T) {
+	r := NewRegistry()
+	requests := r.Counter("forum_requests_total", "HTTP requests served.", "method", "status")
+	r.Counter("forum_signups_total", "Accounts created.")
+	duration := r.Histogram("forum_query_seconds", "Duration of queries.\nIn seconds.", []float64{0.1, 1}, "method")
+	r.GaugeFunc("forum_online_users", "Members online.", func() float64 { return 3 })
+	r.CounterFunc("forum_cache_hits_total", "Cache hits.", func() float64 { return 1.5 })
+
+	requests.Inc("POST", "303")
+	requests.Inc("GET", "200")
+	requests.Add(2, "GET", "200")
+	requests.Inc("GET", `a"b\c`)
+	duration.Observe(0.05, "Get")
+	duration.Observe(0.1, "Get")
+	duration.Observe(0.5, "Get")
+	duration.Observe(2, "Get")
+
+	var buf bytes.Buffer
+	err := r.WriteText(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP forum_requests_total HTTP requests served.
+# TYPE forum_requests_total counter
+forum_requests_total{method="GET",status="200"} 3
+forum_requests_total{method="GET",status="a\"b\\c"} 1
+forum_requests_total{method="POST",status="303"} 1
+# HELP forum_signups_total Accounts created.
+# TYPE forum_signups_total counter
+forum_signups_total 0
+# HELP forum_query_seconds Duration of queries.\nIn seconds.
+# TYPE forum_query_seconds histogram
+forum_query_seconds_bucket{method="Get",le="0.1"} 2
+forum_query_seconds_bucket{method="Get",le="1"} 3
+forum_query_seconds_bucket{method="Get",le="+Inf"} 4
+forum_query_seconds_sum{method="Get"} 2.65
+forum_query_seconds_count{method="Get"} 4
+# HELP forum_online_users Members online.
+# TYPE forum_online_users gauge
+forum_online_users 3
+# HELP forum_cache_hits_total Cache hits.
+# TYPE forum_cache_hits_total counter
+forum_cache_hits_total 1.5
+`
+	if got := buf.String(); got != want {
+		t.Errorf("buf.String() = %v; want %v", got, want)
+	}
+}
+
+func TestLabelCount(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("no panic on a missing label value")
+		}
+	}()
+	NewRegistry().Counter("forum_requests_total", "HTTP requests served.", "method").Inc()
+}
+
+func TestServeHTTP(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("forum_signups_total", "Accounts created.").Inc()
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("rec.Code = %v; want %v", rec.Code, http.StatusOK)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Errorf("rec.Header().Get(\"Content-Type\") = %q; want %q", got, "text/plain; version=0.0.4; charset=utf-8")
+	}
+	if !strings.Contains(rec.Body.String(), "forum_signups_total 1\n") {
+		t.Errorf("%q not found in:\n%s", "forum_signups_total 1\n", rec.Body.String())
+	}
+}
@@ -0,0 +1,168 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Attachment holds data about a file attached to a post. The file itself
+// lives in a blob store under StorageKey.
+type Attachment struct {
+	ID          int
+	PostID      int
+	UserID      int
+	Filename    string
+	ContentType string
+	Size        int64
+	StorageKey  string
+	Created     time.Time
+}
+
+// IsImage reports whether the attachment can be shown inline as an image.
+func (a *Attachment) IsImage() bool {
+	return strings.HasPrefix(a.ContentType, "image/")
+}
+
+// AttachmentModel holds a database handle to manipulate attachments.
+type AttachmentModel struct {
+	DB *DB
+}
+
+// NewAttachmentModel creates an Attachments table and returns a new
+// AttachmentModel.
+func NewAttachmentModel(db *DB) (*AttachmentModel, error) {
+	m := AttachmentModel{db}
+	err := m.createTable()
+	if err != nil {
+		return nil, fmt.Errorf("creating table: %w", err)
+	}
+	return &m, nil
+}
+
+// createTable creates an Attachments table.
+func (m *AttachmentModel) createTable() error {
+	stmts := []string{
+		`
+			CREATE TABLE IF NOT EXISTS Attachments (
+			    id INTEGER PRIMARY KEY,
+			    post_id INTEGER NOT NULL REFERENCES Posts,
+			    user_id INTEGER NOT NULL REFERENCES Users,
+			    filename TEXT NOT NULL,
+			    content_type TEXT NOT NULL,
+			    size BIGINT NOT NULL,
+			    storage_key TEXT NOT NULL UNIQUE,
+			    created DATE NOT NULL
+			);
+		`,
+		`CREATE INDEX IF NOT EXISTS attachments_post ON Attachments (post_id);`,
+		`CREATE INDEX IF NOT EXISTS attachments_user ON Attachments (user_id);`,
+	}
+	err := m.DB.createTables(stmts...)
+	if err != nil {
+		return fmt.Errorf("creating Attachments table: %w", err)
+	}
+	return nil
+}
+
+// InsertContext records a file already stored under storageKey as attached to
+// the post.
+func (m *AttachmentModel) InsertContext(ctx context.Context, postID, userID int, filename, contentType string, size int64, storageKey string) (int, error) {
+	ctx, done := m.DB.start(ctx, "AttachmentModel.Insert")
+	defer done()
+	stmt := `
+		INSERT INTO Attachments (post_id, user_id, filename, content_type, size, storage_key, created)
+		VALUES (?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+	`
+	id, err := m.DB.InsertContext(ctx, stmt, postID, userID, filename, contentType, size, storageKey)
+	if err != nil {
+		return 0, fmt.Errorf("inserting new attachment in db: %w", err)
+	}
+	return id, nil
+}
+
+// GetContext retrieves the attachment with the given id.
+func (m *AttachmentModel) GetContext(ctx context.Context, id int) (*Attachment, error) {
+	ctx, done := m.DB.start(ctx, "AttachmentModel.Get")
+	defer done()
+	stmt := `
+		SELECT id, post_id, user_id, filename, content_type, size, storage_key, created
+		FROM Attachments WHERE id = ?
+	`
+	var a Attachment
+	err := m.DB.QueryRowContext(ctx, stmt, id).Scan(
+		&a.ID, &a.PostID, &a.UserID, &a.Filename, &a.ContentType, &a.Size, &a.StorageKey, &a.Created,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, fmt.Errorf("querying attachment: %w", err)
+	}
+	return &a, nil
+}
+
+// ForPostsContext loads the attachments of each post into its Attachments
+// field.
+func (m *AttachmentModel) ForPostsContext(ctx context.Context, posts []*Post) error {
+	ctx, done := m.DB.start(ctx, "AttachmentModel.ForPosts")
+	defer done()
+	if len(posts) == 0 {
+		return nil
+	}
+
+	args := make([]any, len(posts))
+	placeholders := make([]string, len(posts))
+	byID := make(map[int]*Post, len(posts))
+	for i, p := range posts {
+		args[i] = p.ID
+		placeholders[i] = "?"
+		byID[p.ID] = p
+	}
+
+	stmt := fmt.Sprintf(
+		`
+			SELECT id, post_id, user_id, filename, content_type, size, storage_key, created
+			FROM Attachments WHERE post_id IN (%s)
+			ORDER BY id
+		`,
+		strings.Join(placeholders, ", "),
+	)
+	rows, err := m.DB.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return fmt.Errorf("getting attachments: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var a Attachment
+		err := rows.Scan(
+			&a.ID, &a.PostID, &a.UserID, &a.Filename, &a.ContentType, &a.Size, &a.StorageKey, &a.Created,
+		)
+		if err != nil {
+			return fmt.Errorf("scanning attachment: %w", err)
+		}
+		p := byID[a.PostID]
+		p.Attachments = append(p.Attachments, &a)
+	}
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("iterating over attachments: %w", err)
+	}
+	return nil
+}
+
+// UsedBytesContext returns the total size of the files attached by the user.
+func (m *AttachmentModel) UsedBytesContext(ctx context.Context, userID int) (int64, error) {
+	ctx, done := m.DB.start(ctx, "AttachmentModel.UsedBytes")
+	defer done()
+	stmt := `SELECT COALESCE(SUM(size), 0) FROM Attachments WHERE user_id = ?`
+	var n int64
+	err := m.DB.QueryRowContext(ctx, stmt, userID).Scan(&n)
+	if err != nil {
+		return 0, fmt.Errorf("summing attachment sizes: %w", err)
+	}
+	return n, nil
+}
@@ -0,0 +1,269 @@
+package models_test
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"forum/internal/models"
+	"forum/internal/models/memory"
+)
+
+// stores is a set of empty stores sharing one database.
+type stores struct {
+	tx            models.Transactor
+	threads       models.ThreadStore
+	posts         models.PostStore
+	users         models.UserStore
+	reads         models.ReadStore
+	conversations models.ConversationStore
+	blocks        models.BlockStore
+	attachments   models.AttachmentStore
+	webhooks      models.WebhookStore
+}
+
+// backends are the databases the model tests run against. The postgres
+// backend uses the server at FORUM_TEST_POSTGRES_DSN, or else starts a
+// throwaway one if initdb and pg_ctl can be found; it is skipped otherwise,
+// except in CI, where it must run.
+var backends = []struct {
+	name string
+	open func(t *testing.T) *stores
+}{
+	{"sqlite", openSQLite},
+	{"postgres", openPostgres},
+	{"memory", openMemory},
+}
+
+// forEachBackend runs test as a subtest against each backend.
+func forEachBackend(t *testing.T, test func(t *testing.T, s *stores)) {
+	for _, b := range backends {
+		t.Run(b.name, func(t *testing.T) {
+			test(t, b.open(t))
+		})
+	}
+}
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	stopPostgres()
+	os.Exit(code)
+}
+
+// sqliteOptions are the default settings of the server.
+var sqliteOptions = models.Options{
+	JournalMode: "wal",
+	BusyTimeout: 5 * time.Second,
+	ForeignKeys: true,
+	Synchronous: "normal",
+	ReadConns:   4,
+}
+
+func openSQLite(t *testing.T) *stores {
+	db, err := models.Open(filepath.Join(t.TempDir(), "test.sqlite"), sqliteOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return sqlStores(t, db)
+}
+
+func openMemory(t *testing.T) *stores {
+	db := memory.New()
+	return &stores{
+		tx:            db,
+		threads:       memory.NewThreadModel(db),
+		posts:         memory.NewPostModel(db),
+		users:         memory.NewUserModel(db),
+		reads:         memory.NewReadModel(db),
+		conversations: memory.NewConversationModel(db),
+		blocks:        memory.NewBlockModel(db),
+		attachments:   memory.NewAttachmentModel(db),
+		webhooks:      memory.NewWebhookModel(db),
+	}
+}
+
+// sqlStores returns the models of package models backed by db.
+func sqlStores(t *testing.T, db *models.DB) *stores {
+	t.Helper()
+	threads, users, posts, err := models.NewModels(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reads, err := models.NewReadModel(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conversations, err := models.NewConversationModel(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks, err := models.NewBlockModel(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	attachments, err := models.NewAttachmentModel(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	webhooks, err := models.NewWebhookModel(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &stores{
+		tx:            db,
+		threads:       threads,
+		posts:         posts,
+		users:         users,
+		reads:         reads,
+		conversations: conversations,
+		blocks:        blocks,
+		attachments:   attachments,
+		webhooks:      webhooks,
+	}
+}
+
+var testDBs atomic.Int64
+
+// openPostgres creates a database for the test on the PostgreSQL server,
+// dropped at its end.
+func openPostgres(t *testing.T) *stores {
+	dsn := postgresDSN(t)
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer admin.Close()
+
+	name := fmt.Sprintf("forum_test_%d_%d", os.Getpid(), testDBs.Add(1))
+	_, err = admin.Exec("CREATE DATABASE " + name)
+	if err != nil {
+		t.Fatalf("creating test database: %v", err)
+	}
+	t.Cleanup(func() {
+		admin, err := sql.Open("postgres", dsn)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer admin.Close()
+		_, err = admin.Exec("DROP DATABASE IF EXISTS " + name)
+		if err != nil {
+			t.Errorf("dropping test database: %v", err)
+		}
+	})
+
+	u, err := url.Parse(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.Path = "/" + name
+	db, err := models.Open(u.String(), models.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return sqlStores(t, db)
+}
+
+// The throwaway PostgreSQL server, started by the first test needing it.
+var postgres struct {
+	once    sync.Once
+	dsn     string
+	err     error
+	dir     string
+	pgCtl   string
+	started bool
+}
+
+// postgresDSN returns the URL of the PostgreSQL server to test against, or
+// skips the test if there is none. It fails the test instead in CI, so that
+// PostgreSQL cannot go untested there.
+func postgresDSN(t *testing.T) string {
+	t.Helper()
+	postgres.once.Do(func() {
+		if dsn := os.Getenv("FORUM_TEST_POSTGRES_DSN"); dsn != "" {
+			postgres.dsn = dsn
+			return
+		}
+		postgres.dsn, postgres.err = startPostgres()
+	})
+	if postgres.err != nil && os.Getenv("CI") != "" {
+		t.Fatalf("PostgreSQL unavailable in CI: %v", postgres.err)
+	}
+	if postgres.err != nil {
+		t.Skipf("PostgreSQL unavailable, set FORUM_TEST_POSTGRES_DSN to test against it: %v", postgres.err)
+	}
+	return postgres.dsn
+}
+
+// startPostgres initializes a cluster in a temporary directory and starts a
+// server for it, listening on a free local port.
+func startPostgres() (string, error) {
+	initdb, err := findPostgresTool("initdb")
+	if err != nil {
+		return "", err
+	}
+	postgres.pgCtl, err = findPostgresTool("pg_ctl")
+	if err != nil {
+		return "", err
+	}
+
+	postgres.dir, err = os.MkdirTemp("", "forum-postgres-")
+	if err != nil {
+		return "", err
+	}
+	data := filepath.Join(postgres.dir, "data")
+	out, err := exec.Command(initdb, "-D", data, "-U", "postgres", "-A", "trust", "--no-sync").CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("initdb: %v: %s", err, out)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	opts := fmt.Sprintf("-p %d -k %s -c listen_addresses=127.0.0.1 -c fsync=off", port, postgres.dir)
+	out, err = exec.Command(postgres.pgCtl, "start", "-w", "-D", data, "-l", filepath.Join(postgres.dir, "log"), "-o", opts).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("pg_ctl start: %v: %s", err, out)
+	}
+	postgres.started = true
+	return fmt.Sprintf("postgres://postgres@127.0.0.1:%d/postgres?sslmode=disable", port), nil
+}
+
+// stopPostgres stops the throwaway server, if one was started, and removes
+// its cluster.
+func stopPostgres() {
+	if postgres.started {
+		exec.Command(postgres.pgCtl, "stop", "-m", "immediate", "-D", filepath.Join(postgres.dir, "data")).Run()
+	}
+	if postgres.dir != "" {
+		os.RemoveAll(postgres.dir)
+	}
+}
+
+// findPostgresTool looks for a PostgreSQL program on the PATH, then where
+// Debian and Ubuntu install them.
+func findPostgresTool(name string) (string, error) {
+	if path, err := exec.LookPath(name); err == nil {
+		return path, nil
+	}
+	matches, _ := filepath.Glob(filepath.Join("/usr/lib/postgresql/*/bin", name))
+	if len(matches) > 0 {
+		return matches[len(matches)-1], nil
+	}
+	return "", errors.New(name + " not found")
+}
@@ -0,0 +1,299 @@
+package models
+
+import (
+	"context"
+	"time"
+)
+
+// The methods below call the context-aware methods of the models with the
+// background context, for callers with no request to tie them to.
+
+// Insert is InsertContext with the background context.
+func (m *ThreadModel) Insert(title string, authorId int) (int, error) {
+	return m.InsertContext(context.Background(), title, authorId)
+}
+
+// Get is GetContext with the background context.
+func (m *ThreadModel) Get(id int) (*Thread, error) {
+	return m.GetContext(context.Background(), id)
+}
+
+// Exists is ExistsContext with the background context.
+func (m *ThreadModel) Exists(id int) (bool, error) {
+	return m.ExistsContext(context.Background(), id)
+}
+
+// Newest is NewestContext with the background context.
+func (m *ThreadModel) Newest(limit int) ([]*Thread, error) {
+	return m.NewestContext(context.Background(), limit)
+}
+
+// Latests is LatestsContext with the background context.
+func (m *ThreadModel) Latests(limit int) ([]*Thread, error) {
+	return m.LatestsContext(context.Background(), limit)
+}
+
+// SetLocked is SetLockedContext with the background context.
+func (m *ThreadModel) SetLocked(id int, locked bool) error {
+	return m.SetLockedContext(context.Background(), id, locked)
+}
+
+// Delete is DeleteContext with the background context.
+func (m *ThreadModel) Delete(id int) (int, []string, error) {
+	return m.DeleteContext(context.Background(), id)
+}
+
+// Move is MoveContext with the background context.
+func (m *ThreadModel) Move(id, targetID int) (int, error) {
+	return m.MoveContext(context.Background(), id, targetID)
+}
+
+// Insert is InsertContext with the background context.
+func (m *PostModel) Insert(body string, threadId, authorId int) (int, error) {
+	return m.InsertContext(context.Background(), body, threadId, authorId)
+}
+
+// Since is SinceContext with the background context.
+func (m *PostModel) Since(threadID, afterID, limit int) ([]*Post, error) {
+	return m.SinceContext(context.Background(), threadID, afterID, limit)
+}
+
+// Search is SearchContext with the background context.
+func (m *PostModel) Search(query string, limit, offset int) ([]*SearchResult, error) {
+	return m.SearchContext(context.Background(), query, limit, offset)
+}
+
+// PurgeByUser is PurgeByUserContext with the background context.
+func (m *PostModel) PurgeByUser(userID int) (int, []string, error) {
+	return m.PurgeByUserContext(context.Background(), userID)
+}
+
+// Insert is InsertContext with the background context.
+func (m *UserModel) Insert(username, email, password string) (int, error) {
+	return m.InsertContext(context.Background(), username, email, password)
+}
+
+// Get is GetContext with the background context.
+func (m *UserModel) Get(id int) (*User, error) {
+	return m.GetContext(context.Background(), id)
+}
+
+// GetByUsername is GetByUsernameContext with the background context.
+func (m *UserModel) GetByUsername(username string) (*User, error) {
+	return m.GetByUsernameContext(context.Background(), username)
+}
+
+// UsernameExists is UsernameExistsContext with the background context.
+func (m *UserModel) UsernameExists(username string) (bool, error) {
+	return m.UsernameExistsContext(context.Background(), username)
+}
+
+// SetAvatarVersion is SetAvatarVersionContext with the background context.
+func (m *UserModel) SetAvatarVersion(id, old, version int) error {
+	return m.SetAvatarVersionContext(context.Background(), id, old, version)
+}
+
+// Role is RoleContext with the background context.
+func (m *UserModel) Role(id int) (string, error) {
+	return m.RoleContext(context.Background(), id)
+}
+
+// Exists is ExistsContext with the background context.
+func (m *UserModel) Exists(email string) (bool, error) {
+	return m.ExistsContext(context.Background(), email)
+}
+
+// Authenticate is AuthenticateContext with the background context.
+func (m *UserModel) Authenticate(email, password string) (int, error) {
+	return m.AuthenticateContext(context.Background(), email, password)
+}
+
+// List is ListContext with the background context.
+func (m *UserModel) List() ([]*User, error) {
+	return m.ListContext(context.Background())
+}
+
+// SetRole is SetRoleContext with the background context.
+func (m *UserModel) SetRole(id int, role string) error {
+	return m.SetRoleContext(context.Background(), id, role)
+}
+
+// SetDisabled is SetDisabledContext with the background context.
+func (m *UserModel) SetDisabled(id int, disabled bool) error {
+	return m.SetDisabledContext(context.Background(), id, disabled)
+}
+
+// SetPassword is SetPasswordContext with the background context.
+func (m *UserModel) SetPassword(id int, password string) error {
+	return m.SetPasswordContext(context.Background(), id, password)
+}
+
+// GetProfile is GetProfileContext with the background context.
+func (m *UserModel) GetProfile(username string) (*Profile, error) {
+	return m.GetProfileContext(context.Background(), username)
+}
+
+// GetProfileByID is GetProfileByIDContext with the background context.
+func (m *UserModel) GetProfileByID(id int) (*Profile, error) {
+	return m.GetProfileByIDContext(context.Background(), id)
+}
+
+// UpdateProfile is UpdateProfileContext with the background context.
+func (m *UserModel) UpdateProfile(id int, bio, location, website string, hideActivity bool) error {
+	return m.UpdateProfileContext(context.Background(), id, bio, location, website, hideActivity)
+}
+
+// Activity is ActivityContext with the background context.
+func (m *UserModel) Activity(userID, limit, offset int) ([]*Activity, error) {
+	return m.ActivityContext(context.Background(), userID, limit, offset)
+}
+
+// MarkRead is MarkReadContext with the background context.
+func (m *ReadModel) MarkRead(userID, threadID, postID int) error {
+	return m.MarkReadContext(context.Background(), userID, threadID, postID)
+}
+
+// MarkAllRead is MarkAllReadContext with the background context.
+func (m *ReadModel) MarkAllRead(userID int) error {
+	return m.MarkAllReadContext(context.Background(), userID)
+}
+
+// FlagUnread is FlagUnreadContext with the background context.
+func (m *ReadModel) FlagUnread(userID int, threads []*Thread) error {
+	return m.FlagUnreadContext(context.Background(), userID, threads)
+}
+
+// FirstUnread is FirstUnreadContext with the background context.
+func (m *ReadModel) FirstUnread(userID, threadID int) (int, error) {
+	return m.FirstUnreadContext(context.Background(), userID, threadID)
+}
+
+// Insert is InsertContext with the background context.
+func (m *ConversationModel) Insert(subject, body string, authorID int, recipientIDs []int) (int, error) {
+	return m.InsertContext(context.Background(), subject, body, authorID, recipientIDs)
+}
+
+// Reply is ReplyContext with the background context.
+func (m *ConversationModel) Reply(conversationID, authorID int, body string) (int, error) {
+	return m.ReplyContext(context.Background(), conversationID, authorID, body)
+}
+
+// Get is GetContext with the background context.
+func (m *ConversationModel) Get(conversationID, userID int) (*Conversation, error) {
+	return m.GetContext(context.Background(), conversationID, userID)
+}
+
+// Inbox is InboxContext with the background context.
+func (m *ConversationModel) Inbox(userID int, archived bool) ([]*Conversation, error) {
+	return m.InboxContext(context.Background(), userID, archived)
+}
+
+// UnreadCount is UnreadCountContext with the background context.
+func (m *ConversationModel) UnreadCount(userID int) (int, error) {
+	return m.UnreadCountContext(context.Background(), userID)
+}
+
+// MarkRead is MarkReadContext with the background context.
+func (m *ConversationModel) MarkRead(conversationID, userID, messageID int) error {
+	return m.MarkReadContext(context.Background(), conversationID, userID, messageID)
+}
+
+// SetArchived is SetArchivedContext with the background context.
+func (m *ConversationModel) SetArchived(conversationID, userID int, archived bool) error {
+	return m.SetArchivedContext(context.Background(), conversationID, userID, archived)
+}
+
+// Leave is LeaveContext with the background context.
+func (m *ConversationModel) Leave(conversationID, userID int) error {
+	return m.LeaveContext(context.Background(), conversationID, userID)
+}
+
+// Insert is InsertContext with the background context.
+func (m *BlockModel) Insert(blockerID, blockedID int) error {
+	return m.InsertContext(context.Background(), blockerID, blockedID)
+}
+
+// Delete is DeleteContext with the background context.
+func (m *BlockModel) Delete(blockerID, blockedID int) error {
+	return m.DeleteContext(context.Background(), blockerID, blockedID)
+}
+
+// Blocked is BlockedContext with the background context.
+func (m *BlockModel) Blocked(blockerID int) ([]*User, error) {
+	return m.BlockedContext(context.Background(), blockerID)
+}
+
+// Insert is InsertContext with the background context.
+func (m *AttachmentModel) Insert(postID, userID int, filename, contentType string, size int64, storageKey string) (int, error) {
+	return m.InsertContext(context.Background(), postID, userID, filename, contentType, size, storageKey)
+}
+
+// Get is GetContext with the background context.
+func (m *AttachmentModel) Get(id int) (*Attachment, error) {
+	return m.GetContext(context.Background(), id)
+}
+
+// ForPosts is ForPostsContext with the background context.
+func (m *AttachmentModel) ForPosts(posts []*Post) error {
+	return m.ForPostsContext(context.Background(), posts)
+}
+
+// UsedBytes is UsedBytesContext with the background context.
+func (m *AttachmentModel) UsedBytes(userID int) (int64, error) {
+	return m.UsedBytesContext(context.Background(), userID)
+}
+
+// Insert is InsertContext with the background context.
+func (m *WebhookModel) Insert(url, secret string, events []string) (int, error) {
+	return m.InsertContext(context.Background(), url, secret, events)
+}
+
+// Get is GetContext with the background context.
+func (m *WebhookModel) Get(id int) (*Webhook, error) {
+	return m.GetContext(context.Background(), id)
+}
+
+// All is AllContext with the background context.
+func (m *WebhookModel) All() ([]*Webhook, error) {
+	return m.AllContext(context.Background())
+}
+
+// SetActive is SetActiveContext with the background context.
+func (m *WebhookModel) SetActive(id int, active bool) error {
+	return m.SetActiveContext(context.Background(), id, active)
+}
+
+// Delete is DeleteContext with the background context.
+func (m *WebhookModel) Delete(id int) error {
+	return m.DeleteContext(context.Background(), id)
+}
+
+// Enqueue is EnqueueContext with the background context.
+func (m *WebhookModel) Enqueue(event string, payload []byte) (int, error) {
+	return m.EnqueueContext(context.Background(), event, payload)
+}
+
+// Claim is ClaimContext with the background context.
+func (m *WebhookModel) Claim(limit int, lease time.Duration) ([]*WebhookDelivery, error) {
+	return m.ClaimContext(context.Background(), limit, lease)
+}
+
+// RecordAttempt is RecordAttemptContext with the background context.
+func (m *WebhookModel) RecordAttempt(a *WebhookAttempt, status string, next time.Time) error {
+	return m.RecordAttemptContext(context.Background(), a, status, next)
+}
+
+// Redeliver is RedeliverContext with the background context.
+func (m *WebhookModel) Redeliver(id int) error {
+	return m.RedeliverContext(context.Background(), id)
+}
+
+// GetDelivery is GetDeliveryContext with the background context.
+func (m *WebhookModel) GetDelivery(id int) (*WebhookDelivery, error) {
+	return m.GetDeliveryContext(context.Background(), id)
+}
+
+// Deliveries is DeliveriesContext with the background context.
+func (m *WebhookModel) Deliveries(webhookID, limit int) ([]*WebhookDelivery, error) {
+	return m.DeliveriesContext(context.Background(), webhookID, limit)
+}
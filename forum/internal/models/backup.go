@@ -0,0 +1,275 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+var (
+	// ErrNotSQLite is returned by the operations on database files when the
+	// database is PostgreSQL, which is backed up with pg_dump.
+	ErrNotSQLite = errors.New("models: only SQLite databases can be backed up and restored; use pg_dump for PostgreSQL")
+	// ErrNewerSchema is returned when a database has a schema version newer
+	// than SchemaVersion.
+	ErrNewerSchema = errors.New("models: database schema is newer than this binary")
+)
+
+// Version returns the schema version recorded in the database, or 0 if no
+// migration has been applied yet.
+func (db *DB) Version(ctx context.Context) (int, error) {
+	exists, err := db.hasTable(ctx, "SchemaVersion")
+	if err != nil || !exists {
+		return 0, err
+	}
+	var version int
+	err = db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM SchemaVersion`).Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("reading schema version: %w", err)
+	}
+	return version, nil
+}
+
+// Backup writes a consistent copy of the SQLite database to path, which must
+// not exist. The copy is made with the online backup API of SQLite, from a
+// connection of its own, so that the database stays writable meanwhile.
+func (db *DB) Backup(ctx context.Context, path string) error {
+	if db.Dialect != SQLite {
+		return ErrNotSQLite
+	}
+	_, err := os.Lstat(path)
+	if err == nil {
+		return fmt.Errorf("backing up database: %s already exists", path)
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("backing up database: %w", err)
+	}
+
+	var (
+		seq        int
+		name, file string
+	)
+	err = db.read.QueryRowContext(ctx, `PRAGMA database_list`).Scan(&seq, &name, &file)
+	if err != nil {
+		return fmt.Errorf("locating database file: %w", err)
+	}
+	if file == "" {
+		return errors.New("backing up database: the database has no file")
+	}
+	srcPool, err := openPool(SQLite.Name, withParams("file:"+file, url.Values{"mode": {"ro"}}))
+	if err != nil {
+		return fmt.Errorf("opening database: %w", err)
+	}
+	defer srcPool.Close()
+	dstPool, err := openPool(SQLite.Name, withParams("file:"+path, url.Values{"mode": {"rwc"}}))
+	if err != nil {
+		return fmt.Errorf("creating backup: %w", err)
+	}
+	defer dstPool.Close()
+
+	err = copyDatabase(ctx, dstPool, srcPool)
+	if err == nil {
+		// The copy keeps the journal mode of the database: a backup in
+		// rollback mode is a single file.
+		_, err = dstPool.ExecContext(ctx, `PRAGMA journal_mode = DELETE`)
+	}
+	if err != nil {
+		dstPool.Close()
+		os.Remove(path)
+		return fmt.Errorf("backing up database: %w", err)
+	}
+	return nil
+}
+
+// Restore replaces the content of the SQLite database with the backup at
+// path, after checking its integrity and that its schema is not newer than
+// SchemaVersion. An older schema is then migrated, so that the restored
+// database is ready for this binary. The copy is made with the online backup
+// API of SQLite, so that other connections see the database either before or
+// after it.
+func (db *DB) Restore(ctx context.Context, path string) error {
+	if db.Dialect != SQLite {
+		return ErrNotSQLite
+	}
+	srcPool, err := openPool(SQLite.Name, withParams("file:"+path, url.Values{"mode": {"ro"}}))
+	if err != nil {
+		return fmt.Errorf("opening backup: %w", err)
+	}
+	defer srcPool.Close()
+	src := &DB{DB: srcPool, Dialect: SQLite, read: srcPool}
+
+	version, err := src.Version(ctx)
+	if err != nil {
+		return err
+	}
+	if version > SchemaVersion() {
+		return fmt.Errorf("backup at version %d: %w", version, ErrNewerSchema)
+	}
+	problems, err := src.Check(ctx)
+	if err != nil {
+		return fmt.Errorf("checking backup: %w", err)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("backup failed its integrity check: %s", problems[0])
+	}
+
+	err = copyDatabase(ctx, db.DB, srcPool)
+	if err != nil {
+		return fmt.Errorf("restoring database: %w", err)
+	}
+	if version < SchemaVersion() {
+		_, _, _, err = NewModels(db)
+		if err != nil {
+			return fmt.Errorf("restoring database at version %d: %w", version, err)
+		}
+	}
+	return nil
+}
+
+// copyDatabase copies the main database of src over the one of dst, with the
+// online backup API of SQLite.
+func copyDatabase(ctx context.Context, dst, src *sql.DB) error {
+	srcConn, err := src.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer srcConn.Close()
+	dstConn, err := dst.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer dstConn.Close()
+
+	return dstConn.Raw(func(dst any) error {
+		return srcConn.Raw(func(src any) error {
+			b, err := dst.(*sqlite3.SQLiteConn).Backup("main", src.(*sqlite3.SQLiteConn), "main")
+			if err != nil {
+				return err
+			}
+			// Step reports neither done nor an error while the database is
+			// locked by another connection: try again until it is free.
+			for {
+				done, err := b.Step(-1)
+				if err != nil {
+					b.Close()
+					return err
+				}
+				if done {
+					return b.Close()
+				}
+				select {
+				case <-ctx.Done():
+					b.Close()
+					return ctx.Err()
+				case <-time.After(100 * time.Millisecond):
+				}
+			}
+		})
+	})
+}
+
+// Check looks for damage in the database: corruption detected by SQLite,
+// violated foreign keys, and rows whose owner is gone. It returns a
+// description of each problem found.
+func (db *DB) Check(ctx context.Context) ([]string, error) {
+	var problems []string
+	if db.Dialect == SQLite {
+		rows, err := db.read.QueryContext(ctx, `PRAGMA integrity_check`)
+		if err != nil {
+			return nil, fmt.Errorf("checking integrity: %w", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var msg string
+			err := rows.Scan(&msg)
+			if err != nil {
+				return nil, fmt.Errorf("checking integrity: %w", err)
+			}
+			if msg != "ok" {
+				problems = append(problems, "integrity: "+msg)
+			}
+		}
+		if err = rows.Err(); err != nil {
+			return nil, fmt.Errorf("checking integrity: %w", err)
+		}
+
+		rows, err = db.read.QueryContext(ctx, `PRAGMA foreign_key_check`)
+		if err != nil {
+			return nil, fmt.Errorf("checking foreign keys: %w", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var (
+				table, parent string
+				rowid         sql.NullInt64
+				fkid          int
+			)
+			err := rows.Scan(&table, &rowid, &parent, &fkid)
+			if err != nil {
+				return nil, fmt.Errorf("checking foreign keys: %w", err)
+			}
+			problems = append(problems, fmt.Sprintf("foreign key: row %d of %s references a missing row of %s", rowid.Int64, table, parent))
+		}
+		if err = rows.Err(); err != nil {
+			return nil, fmt.Errorf("checking foreign keys: %w", err)
+		}
+	}
+
+	// The tables of a model are created when it first starts, so a database
+	// may lack the tables of the newer ones.
+	orphans := []struct {
+		what, table, stmt string
+	}{
+		{"post in a missing thread", "Posts", `SELECT P.id FROM Posts P LEFT JOIN Threads T ON P.thread_id = T.id WHERE T.id IS NULL`},
+		{"post by a missing user", "Posts", `SELECT P.id FROM Posts P LEFT JOIN Users U ON P.author_id = U.id WHERE U.id IS NULL`},
+		{"thread by a missing user", "Threads", `SELECT T.id FROM Threads T LEFT JOIN Users U ON T.author_id = U.id WHERE U.id IS NULL`},
+		{"attachment of a missing post", "Attachments", `SELECT A.id FROM Attachments A LEFT JOIN Posts P ON A.post_id = P.id WHERE P.id IS NULL`},
+	}
+	for _, o := range orphans {
+		exists, err := db.hasTable(ctx, o.table)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			continue
+		}
+		rows, err := db.QueryContext(ctx, o.stmt)
+		if err != nil {
+			return nil, fmt.Errorf("looking for orphans: %w", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var id int
+			err := rows.Scan(&id)
+			if err != nil {
+				return nil, fmt.Errorf("looking for orphans: %w", err)
+			}
+			problems = append(problems, fmt.Sprintf("orphan: %s, id %d", o.what, id))
+		}
+		if err = rows.Err(); err != nil {
+			return nil, fmt.Errorf("looking for orphans: %w", err)
+		}
+	}
+	return problems, nil
+}
+
+// hasTable reports whether the database has a table named name.
+func (db *DB) hasTable(ctx context.Context, name string) (bool, error) {
+	stmt := `SELECT EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = ? COLLATE NOCASE)`
+	if db.Dialect != SQLite {
+		stmt = `SELECT to_regclass(lower(?)) IS NOT NULL`
+	}
+	var exists bool
+	err := db.QueryRowContext(ctx, stmt, name).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("looking for table %s: %w", name, err)
+	}
+	return exists, nil
+}
@@ -0,0 +1,177 @@
+package models_test
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"forum/internal/models"
+	"forum/internal/testutil"
+)
+
+func TestBackupRestore(t *testing.T) {
+	db, err := models.Open(filepath.Join(t.TempDir(), "test.sqlite"), sqliteOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	s := sqlStores(t, db)
+	ctx := context.Background()
+	alice := s.addUser(t, "alice")
+	thread := s.addThread(t, "Kept", alice)
+
+	version, err := db.Version(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.Equal(t, version, models.SchemaVersion())
+
+	path := filepath.Join(t.TempDir(), "backup.sqlite")
+	err = db.Backup(ctx, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Backup(ctx, path)
+	if err == nil {
+		t.Fatal("backed up over an existing file")
+	}
+	lost := s.addThread(t, "Lost", alice)
+
+	err = db.Restore(ctx, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.threads.GetContext(ctx, thread)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.threads.GetContext(ctx, lost)
+	isErr(t, err, models.ErrNoRecord)
+
+	_, err = db.ExecContext(ctx, `UPDATE SchemaVersion SET version = version + 1`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newer := filepath.Join(t.TempDir(), "newer.sqlite")
+	err = db.Backup(ctx, newer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Restore(ctx, newer)
+	isErr(t, err, models.ErrNewerSchema)
+}
+
+// TestRestoreOlder restores a backup of a version 6 database, which is
+// migrated along the way.
+func TestRestoreOlder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "backup.sqlite")
+	old, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stmts := append(version6Schema,
+		`INSERT INTO Users (id, username, email, hashed_password, created)
+		 VALUES (1, 'alice', 'alice@example.com', '', '2024-01-01 00:00:00')`,
+	)
+	for _, stmt := range stmts {
+		_, err := old.Exec(stmt)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	old.Close()
+
+	db, err := models.Open(filepath.Join(t.TempDir(), "test.sqlite"), sqliteOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	s := sqlStores(t, db)
+	ctx := context.Background()
+
+	err = db.Restore(ctx, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	version, err := db.Version(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.Equal(t, version, models.SchemaVersion())
+	s.addThread(t, "Restored", 1)
+}
+
+func TestCheck(t *testing.T) {
+	options := sqliteOptions
+	options.ForeignKeys = false
+	db, err := models.Open(filepath.Join(t.TempDir(), "test.sqlite"), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	s := sqlStores(t, db)
+	ctx := context.Background()
+	alice := s.addUser(t, "alice")
+	thread := s.addThread(t, "Orphaned", alice)
+
+	problems, err := db.Check(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.Equal(t, len(problems), 0)
+
+	_, err = db.ExecContext(ctx, `DELETE FROM Users WHERE id = ?`, alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	problems, err = db.Check(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Contains(problems, "orphan: thread by a missing user, id "+fmt.Sprint(thread)) {
+		t.Errorf("got problems %q; want the thread by alice", problems)
+	}
+}
+
+func TestDataVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.sqlite")
+	db, err := models.Open(path, sqliteOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	s := sqlStores(t, db)
+	ctx := context.Background()
+
+	version, err := db.DataVersion(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice := s.addUser(t, "alice")
+	after, err := db.DataVersion(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.Equal(t, after, version)
+
+	// Another process, such as forumctl, has a connection of its own.
+	other, err := models.Open(path, sqliteOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	_, err = other.ExecContext(ctx, `UPDATE Users SET bio = 'changed' WHERE id = ?`, alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err = db.DataVersion(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after == version {
+		t.Fatal("data version unchanged by another connection")
+	}
+}
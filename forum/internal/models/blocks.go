@@ -0,0 +1,100 @@
+package models
+
+import (
+	"context"
+	"fmt"
+)
+
+// BlockModel holds a database handle to manipulate the users each user has
+// blocked.
+type BlockModel struct {
+	DB *DB
+}
+
+// NewBlockModel creates a UserBlocks table and returns a new BlockModel.
+func NewBlockModel(db *DB) (*BlockModel, error) {
+	m := BlockModel{db}
+	err := m.createTable()
+	if err != nil {
+		return nil, fmt.Errorf("creating table: %w", err)
+	}
+	return &m, nil
+}
+
+// createTable creates a UserBlocks table.
+func (m *BlockModel) createTable() error {
+	stmt := `
+		CREATE TABLE IF NOT EXISTS UserBlocks (
+		    blocker_id INTEGER NOT NULL REFERENCES Users,
+		    blocked_id INTEGER NOT NULL REFERENCES Users,
+		    created DATE NOT NULL,
+		    PRIMARY KEY (blocker_id, blocked_id)
+		) WITHOUT ROWID;
+	`
+	err := m.DB.createTables(stmt)
+	if err != nil {
+		return fmt.Errorf("creating UserBlocks table: %w", err)
+	}
+	return nil
+}
+
+// InsertContext records that blockerID blocks blockedID. Blocking someone
+// twice is not an error.
+func (m *BlockModel) InsertContext(ctx context.Context, blockerID, blockedID int) error {
+	ctx, done := m.DB.start(ctx, "BlockModel.Insert")
+	defer done()
+	stmt := `
+		INSERT INTO UserBlocks (blocker_id, blocked_id, created)
+		VALUES (?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT DO NOTHING
+	`
+	_, err := m.DB.ExecContext(ctx, stmt, blockerID, blockedID)
+	if err != nil {
+		return fmt.Errorf("blocking user %d: %w", blockedID, err)
+	}
+	return nil
+}
+
+// DeleteContext lifts the block of blockerID on blockedID.
+func (m *BlockModel) DeleteContext(ctx context.Context, blockerID, blockedID int) error {
+	ctx, done := m.DB.start(ctx, "BlockModel.Delete")
+	defer done()
+	stmt := `DELETE FROM UserBlocks WHERE blocker_id = ? AND blocked_id = ?`
+	_, err := m.DB.ExecContext(ctx, stmt, blockerID, blockedID)
+	if err != nil {
+		return fmt.Errorf("unblocking user %d: %w", blockedID, err)
+	}
+	return nil
+}
+
+// BlockedContext retrieves the users blocked by blockerID.
+func (m *BlockModel) BlockedContext(ctx context.Context, blockerID int) ([]*User, error) {
+	ctx, done := m.DB.start(ctx, "BlockModel.Blocked")
+	defer done()
+	stmt := `
+		SELECT U.id, U.username
+		FROM UserBlocks B
+		JOIN Users U ON B.blocked_id = U.id
+		WHERE B.blocker_id = ?
+		ORDER BY U.username
+	`
+	rows, err := m.DB.QueryContext(ctx, stmt, blockerID)
+	if err != nil {
+		return nil, fmt.Errorf("getting blocked users: %w", err)
+	}
+	defer rows.Close()
+
+	var users []*User
+	for rows.Next() {
+		var u User
+		err := rows.Scan(&u.ID, &u.Username)
+		if err != nil {
+			return nil, fmt.Errorf("scanning blocked user: %w", err)
+		}
+		users = append(users, &u)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating over blocked users: %w", err)
+	}
+	return users, nil
+}
@@ -0,0 +1,561 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Conversation holds data about a private conversation between users.
+type Conversation struct {
+	ID            int
+	Subject       string
+	Created       time.Time
+	LastMessageAt time.Time
+	Participants  []*User
+	Messages      []*PrivateMessage
+	Unread        int
+	Archived      bool
+}
+
+// PrivateMessage holds data about a single message in a Conversation.
+type PrivateMessage struct {
+	ID      int
+	Body    string
+	Author  *User
+	Created time.Time
+}
+
+// ConversationModel holds a database handle to manipulate conversations.
+type ConversationModel struct {
+	DB *DB
+}
+
+// NewConversationModel creates the conversation tables and returns a new
+// ConversationModel.
+func NewConversationModel(db *DB) (*ConversationModel, error) {
+	m := ConversationModel{db}
+	err := m.createTable()
+	if err != nil {
+		return nil, fmt.Errorf("creating table: %w", err)
+	}
+	return &m, nil
+}
+
+// createTable creates the Conversations, ConversationParticipants and
+// PrivateMessages tables.
+func (m *ConversationModel) createTable() error {
+	stmts := []string{
+		`
+			CREATE TABLE IF NOT EXISTS Conversations (
+			    id INTEGER PRIMARY KEY,
+			    subject TEXT NOT NULL,
+			    created DATE NOT NULL,
+			    last_message_at DATE NOT NULL
+			);
+		`,
+		`
+			CREATE TABLE IF NOT EXISTS ConversationParticipants (
+			    conversation_id INTEGER NOT NULL REFERENCES Conversations,
+			    user_id INTEGER NOT NULL REFERENCES Users,
+			    last_read_message_id INTEGER NOT NULL DEFAULT 0,
+			    archived BOOLEAN NOT NULL DEFAULT FALSE,
+			    left_conversation BOOLEAN NOT NULL DEFAULT FALSE,
+			    PRIMARY KEY (conversation_id, user_id)
+			);
+		`,
+		`
+			CREATE INDEX IF NOT EXISTS conversation_participants_user
+			ON ConversationParticipants (user_id);
+		`,
+		`
+			CREATE TABLE IF NOT EXISTS PrivateMessages (
+			    id INTEGER PRIMARY KEY,
+			    conversation_id INTEGER NOT NULL REFERENCES Conversations,
+			    author_id INTEGER NOT NULL REFERENCES Users,
+			    body TEXT NOT NULL,
+			    created DATE NOT NULL
+			);
+		`,
+		`
+			CREATE INDEX IF NOT EXISTS private_messages_conversation
+			ON PrivateMessages (conversation_id, id);
+		`,
+	}
+	err := m.DB.createTables(stmts...)
+	if err != nil {
+		return fmt.Errorf("creating conversation tables: %w", err)
+	}
+	return nil
+}
+
+// InsertContext starts a new conversation between the author and the
+// recipients, with body as its first message. It returns ErrBlocked if any
+// recipient has blocked the author or has been blocked by them.
+func (m *ConversationModel) InsertContext(ctx context.Context, subject, body string, authorID int, recipientIDs []int) (int, error) {
+	ctx, done := m.DB.start(ctx, "ConversationModel.Insert")
+	defer done()
+	tx, err := m.DB.BeginTx(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	blocked, err := blockedBetween(ctx, tx, authorID, recipientIDs)
+	if err != nil {
+		return 0, err
+	}
+	if blocked {
+		return 0, ErrBlocked
+	}
+
+	stmt := `
+		INSERT INTO Conversations (subject, created, last_message_at)
+		VALUES (?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+	`
+	id, err := tx.InsertContext(ctx, stmt, subject)
+	if err != nil {
+		return 0, fmt.Errorf("inserting new conversation in db: %w", err)
+	}
+
+	stmt = `
+		INSERT INTO ConversationParticipants (conversation_id, user_id)
+		VALUES (?, ?)
+	`
+	for _, userID := range append([]int{authorID}, recipientIDs...) {
+		_, err := tx.ExecContext(ctx, stmt, id, userID)
+		if err != nil {
+			return 0, fmt.Errorf("adding participant %d: %w", userID, err)
+		}
+	}
+
+	msgID, err := insertPrivateMessage(ctx, tx, id, authorID, body)
+	if err != nil {
+		return 0, err
+	}
+	_, err = tx.ExecContext(ctx,
+		`UPDATE ConversationParticipants SET last_read_message_id = ?
+		 WHERE conversation_id = ? AND user_id = ?`,
+		msgID, id, authorID,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("marking first message as read: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("committing conversation: %w", err)
+	}
+	return id, nil
+}
+
+// ReplyContext adds a message from authorID to the conversation and brings it
+// back to the inbox of every participant who archived it. It returns
+// ErrNoRecord if the author is not an active participant, and ErrBlocked if
+// the author and another active participant have blocked each other.
+func (m *ConversationModel) ReplyContext(ctx context.Context, conversationID, authorID int, body string) (int, error) {
+	ctx, done := m.DB.start(ctx, "ConversationModel.Reply")
+	defer done()
+	tx, err := m.DB.BeginTx(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	err = checkParticipant(ctx, tx, conversationID, authorID)
+	if err != nil {
+		return 0, err
+	}
+
+	others, err := otherParticipants(ctx, tx, conversationID, authorID)
+	if err != nil {
+		return 0, err
+	}
+	blocked, err := blockedBetween(ctx, tx, authorID, others)
+	if err != nil {
+		return 0, err
+	}
+	if blocked {
+		return 0, ErrBlocked
+	}
+
+	id, err := insertPrivateMessage(ctx, tx, conversationID, authorID, body)
+	if err != nil {
+		return 0, err
+	}
+
+	stmt := `
+		UPDATE ConversationParticipants
+		SET archived = FALSE,
+		    last_read_message_id = CASE WHEN user_id = ? THEN ? ELSE last_read_message_id END
+		WHERE conversation_id = ?
+	`
+	_, err = tx.ExecContext(ctx, stmt, authorID, id, conversationID)
+	if err != nil {
+		return 0, fmt.Errorf("updating participants: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("committing reply: %w", err)
+	}
+	return id, nil
+}
+
+// insertPrivateMessage inserts a message and bumps the last activity of its
+// conversation.
+func insertPrivateMessage(ctx context.Context, tx *Tx, conversationID, authorID int, body string) (int, error) {
+	stmt := `
+		INSERT INTO PrivateMessages (conversation_id, author_id, body, created)
+		VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+	`
+	id, err := tx.InsertContext(ctx, stmt, conversationID, authorID, body)
+	if err != nil {
+		return 0, fmt.Errorf("inserting new message in db: %w", err)
+	}
+
+	stmt = `
+		UPDATE Conversations
+		SET last_message_at = (SELECT created FROM PrivateMessages WHERE id = ?)
+		WHERE id = ?
+	`
+	_, err = tx.ExecContext(ctx, stmt, id, conversationID)
+	if err != nil {
+		return 0, fmt.Errorf("bumping conversation activity: %w", err)
+	}
+	return id, nil
+}
+
+// checkParticipant returns ErrNoRecord unless userID is an active participant
+// of the conversation.
+func checkParticipant(ctx context.Context, q querier, conversationID, userID int) error {
+	var ok bool
+	stmt := `
+		SELECT EXISTS (
+		    SELECT 1 FROM ConversationParticipants
+		    WHERE conversation_id = ? AND user_id = ? AND left_conversation = FALSE
+		)
+	`
+	err := q.QueryRowContext(ctx, stmt, conversationID, userID).Scan(&ok)
+	if err != nil {
+		return fmt.Errorf("checking participant: %w", err)
+	}
+	if !ok {
+		return ErrNoRecord
+	}
+	return nil
+}
+
+// otherParticipants returns the ids of the active participants of the
+// conversation other than userID.
+func otherParticipants(ctx context.Context, tx *Tx, conversationID, userID int) ([]int, error) {
+	stmt := `
+		SELECT user_id FROM ConversationParticipants
+		WHERE conversation_id = ? AND user_id != ? AND left_conversation = FALSE
+	`
+	rows, err := tx.QueryContext(ctx, stmt, conversationID, userID)
+	if err != nil {
+		return nil, fmt.Errorf("getting participants: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		err := rows.Scan(&id)
+		if err != nil {
+			return nil, fmt.Errorf("scanning participant: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating over participants: %w", err)
+	}
+	return ids, nil
+}
+
+// GetContext retrieves a conversation with its participants and messages, as
+// seen by userID. It returns ErrNoRecord if the conversation does not exist or
+// userID is not an active participant, so that callers cannot tell the two
+// apart.
+func (m *ConversationModel) GetContext(ctx context.Context, conversationID, userID int) (*Conversation, error) {
+	ctx, done := m.DB.start(ctx, "ConversationModel.Get")
+	defer done()
+	stmt := `
+		SELECT C.id, C.subject, C.created, C.last_message_at, CP.archived
+		FROM Conversations C
+		JOIN ConversationParticipants CP ON CP.conversation_id = C.id
+		WHERE C.id = ? AND CP.user_id = ? AND CP.left_conversation = FALSE
+	`
+	var c Conversation
+	err := m.DB.QueryRowContext(ctx, stmt, conversationID, userID).Scan(
+		&c.ID, &c.Subject, &c.Created, &c.LastMessageAt, &c.Archived,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, fmt.Errorf("querying conversation: %w", err)
+	}
+
+	c.Participants, err = m.participants(ctx, c.ID)
+	if err != nil {
+		return nil, fmt.Errorf("getting participants: %w", err)
+	}
+
+	stmt = `
+		SELECT M.id, M.body, M.created, U.id, U.username
+		FROM PrivateMessages M
+		JOIN Users U ON M.author_id = U.id
+		WHERE M.conversation_id = ?
+		ORDER BY M.id ASC
+	`
+	rows, err := m.DB.QueryContext(ctx, stmt, c.ID)
+	if err != nil {
+		return nil, fmt.Errorf("getting messages: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			msg PrivateMessage
+			u   User
+		)
+		err := rows.Scan(&msg.ID, &msg.Body, &msg.Created, &u.ID, &u.Username)
+		if err != nil {
+			return nil, fmt.Errorf("scanning message: %w", err)
+		}
+		msg.Author = &u
+		c.Messages = append(c.Messages, &msg)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating over messages: %w", err)
+	}
+
+	return &c, nil
+}
+
+// participants returns the active participants of a conversation.
+func (m *ConversationModel) participants(ctx context.Context, conversationID int) ([]*User, error) {
+	stmt := `
+		SELECT U.id, U.username
+		FROM ConversationParticipants CP
+		JOIN Users U ON CP.user_id = U.id
+		WHERE CP.conversation_id = ? AND CP.left_conversation = FALSE
+		ORDER BY U.username
+	`
+	rows, err := m.DB.QueryContext(ctx, stmt, conversationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []*User
+	for rows.Next() {
+		var u User
+		err := rows.Scan(&u.ID, &u.Username)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, &u)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// InboxContext retrieves the conversations of userID, most recently active
+// first, with the number of messages they have not read yet. If archived is
+// true it returns the archived conversations instead.
+func (m *ConversationModel) InboxContext(ctx context.Context, userID int, archived bool) ([]*Conversation, error) {
+	ctx, done := m.DB.start(ctx, "ConversationModel.Inbox")
+	defer done()
+	stmt := `
+		SELECT C.id, C.subject, C.created, C.last_message_at, CP.archived,
+		       (SELECT COUNT(*) FROM PrivateMessages M
+		        WHERE M.conversation_id = C.id
+		          AND M.id > CP.last_read_message_id
+		          AND M.author_id != CP.user_id)
+		FROM Conversations C
+		JOIN ConversationParticipants CP ON CP.conversation_id = C.id
+		WHERE CP.user_id = ? AND CP.left_conversation = FALSE AND CP.archived = ?
+		ORDER BY C.last_message_at DESC, C.id DESC
+	`
+	rows, err := m.DB.QueryContext(ctx, stmt, userID, archived)
+	if err != nil {
+		return nil, fmt.Errorf("getting inbox: %w", err)
+	}
+	defer rows.Close()
+
+	var conversations []*Conversation
+	for rows.Next() {
+		var c Conversation
+		err := rows.Scan(&c.ID, &c.Subject, &c.Created, &c.LastMessageAt, &c.Archived, &c.Unread)
+		if err != nil {
+			return nil, fmt.Errorf("scanning conversation: %w", err)
+		}
+		conversations = append(conversations, &c)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating over inbox: %w", err)
+	}
+
+	byID := make(map[int]*Conversation, len(conversations))
+	for _, c := range conversations {
+		byID[c.ID] = c
+	}
+	stmt = `
+		SELECT CP.conversation_id, U.id, U.username
+		FROM ConversationParticipants CP
+		JOIN Users U ON CP.user_id = U.id
+		WHERE CP.left_conversation = FALSE AND CP.conversation_id IN (
+		    SELECT conversation_id FROM ConversationParticipants
+		    WHERE user_id = ? AND left_conversation = FALSE AND archived = ?
+		)
+		ORDER BY U.username
+	`
+	rows, err = m.DB.QueryContext(ctx, stmt, userID, archived)
+	if err != nil {
+		return nil, fmt.Errorf("getting participants: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			conversationID int
+			u              User
+		)
+		err := rows.Scan(&conversationID, &u.ID, &u.Username)
+		if err != nil {
+			return nil, fmt.Errorf("scanning participant: %w", err)
+		}
+		if c, ok := byID[conversationID]; ok {
+			c.Participants = append(c.Participants, &u)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating over participants: %w", err)
+	}
+	return conversations, nil
+}
+
+// UnreadCountContext returns the number of conversations of userID with unread
+// messages, archived ones included.
+func (m *ConversationModel) UnreadCountContext(ctx context.Context, userID int) (int, error) {
+	ctx, done := m.DB.start(ctx, "ConversationModel.UnreadCount")
+	defer done()
+	stmt := `
+		SELECT COUNT(*)
+		FROM ConversationParticipants CP
+		WHERE CP.user_id = ? AND CP.left_conversation = FALSE
+		  AND EXISTS (
+		      SELECT 1 FROM PrivateMessages M
+		      WHERE M.conversation_id = CP.conversation_id
+		        AND M.id > CP.last_read_message_id
+		        AND M.author_id != CP.user_id
+		  )
+	`
+	var n int
+	err := m.DB.QueryRowContext(ctx, stmt, userID).Scan(&n)
+	if err != nil {
+		return 0, fmt.Errorf("counting unread conversations: %w", err)
+	}
+	return n, nil
+}
+
+// MarkReadContext records that userID has read the conversation up to
+// messageID.
+func (m *ConversationModel) MarkReadContext(ctx context.Context, conversationID, userID, messageID int) error {
+	ctx, done := m.DB.start(ctx, "ConversationModel.MarkRead")
+	defer done()
+	stmt := `
+		UPDATE ConversationParticipants SET last_read_message_id = ?
+		WHERE conversation_id = ? AND user_id = ? AND last_read_message_id < ?
+	`
+	_, err := m.DB.ExecContext(ctx, stmt, messageID, conversationID, userID, messageID)
+	if err != nil {
+		return fmt.Errorf("marking conversation %d as read: %w", conversationID, err)
+	}
+	return nil
+}
+
+// SetArchivedContext moves the conversation in or out of the archive of
+// userID. It returns ErrNoRecord if userID is not an active participant.
+func (m *ConversationModel) SetArchivedContext(ctx context.Context, conversationID, userID int, archived bool) error {
+	ctx, done := m.DB.start(ctx, "ConversationModel.SetArchived")
+	defer done()
+	stmt := `
+		UPDATE ConversationParticipants SET archived = ?
+		WHERE conversation_id = ? AND user_id = ? AND left_conversation = FALSE
+	`
+	return m.updateParticipant(ctx, stmt, archived, conversationID, userID)
+}
+
+// LeaveContext removes userID from the conversation. They can no longer read
+// it nor post to it. It returns ErrNoRecord if userID is not an active
+// participant.
+func (m *ConversationModel) LeaveContext(ctx context.Context, conversationID, userID int) error {
+	ctx, done := m.DB.start(ctx, "ConversationModel.Leave")
+	defer done()
+	stmt := `
+		UPDATE ConversationParticipants SET left_conversation = TRUE
+		WHERE conversation_id = ? AND user_id = ? AND left_conversation = FALSE
+	`
+	return m.updateParticipant(ctx, stmt, conversationID, userID)
+}
+
+// updateParticipant runs an update on a single participant row and returns
+// ErrNoRecord if no row matched.
+func (m *ConversationModel) updateParticipant(ctx context.Context, stmt string, args ...any) error {
+	result, err := m.DB.ExecContext(ctx, stmt, args...)
+	if err != nil {
+		return fmt.Errorf("updating participant: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("updating participant: %w", err)
+	}
+	if n == 0 {
+		return ErrNoRecord
+	}
+	return nil
+}
+
+// blockedBetween reports whether userID and any of the others have blocked
+// each other.
+func blockedBetween(ctx context.Context, q querier, userID int, others []int) (bool, error) {
+	if len(others) == 0 {
+		return false, nil
+	}
+
+	ids := make([]any, len(others))
+	placeholders := make([]string, len(others))
+	for i, id := range others {
+		ids[i] = id
+		placeholders[i] = "?"
+	}
+	args := append([]any{userID}, ids...)
+	args = append(args, userID)
+	args = append(args, ids...)
+
+	stmt := fmt.Sprintf(
+		`
+			SELECT EXISTS (
+			    SELECT 1 FROM UserBlocks
+			    WHERE (blocked_id = ? AND blocker_id IN (%[1]s))
+			       OR (blocker_id = ? AND blocked_id IN (%[1]s))
+			)
+		`,
+		strings.Join(placeholders, ", "),
+	)
+	var blocked bool
+	err := q.QueryRowContext(ctx, stmt, args...).Scan(&blocked)
+	if err != nil {
+		return false, fmt.Errorf("checking blocks: %w", err)
+	}
+	return blocked, nil
+}
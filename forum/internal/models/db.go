@@ -0,0 +1,584 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+// Dialect describes how a database speaks the SQL of the models. Queries are
+// written for SQLite, with ? placeholders, and adapted by the dialect of the
+// database they run on.
+//
+// Both databases accept the ON CONFLICT upserts of the models, as long as the
+// columns of the existing row are qualified with the table name, and the TRUE
+// and FALSE literals. Their full-text searches differ: posts are indexed in
+// an FTS4 table on SQLite, and by an expression index on PostgreSQL.
+type Dialect struct {
+	// Name is the name of the database/sql driver.
+	Name string
+	// numbered is true if placeholders are written $1, $2...
+	numbered bool
+	// returning is true if the ids of inserted rows are read with a
+	// RETURNING clause rather than from the result of the statement.
+	returning bool
+	// greatest is the scalar function returning the largest of its
+	// arguments.
+	greatest string
+	// rowLocks is true if the rows read by a transaction can be locked. SQLite
+	// serializes its writers instead.
+	rowLocks bool
+}
+
+var (
+	// SQLite is the dialect of SQLite databases.
+	SQLite = &Dialect{Name: "sqlite3", greatest: "MAX"}
+	// Postgres is the dialect of PostgreSQL databases.
+	Postgres = &Dialect{Name: "postgres", numbered: true, returning: true, greatest: "GREATEST", rowLocks: true}
+)
+
+// Rebind rewrites the ? placeholders of query for the dialect. Question marks
+// in quoted strings and identifiers are left as is.
+func (d *Dialect) Rebind(query string) string {
+	if !d.numbered || !strings.Contains(query, "?") {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	var quote byte
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case quote != 0:
+			// A doubled quote escapes itself, and is read as two strings
+			// following each other.
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '?':
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// schema adapts a statement of the schema, written for SQLite, to the
+// dialect.
+func (d *Dialect) schema(stmt string) string {
+	if d == Postgres {
+		return postgresSchema(stmt)
+	}
+	return stmt
+}
+
+// Greatest returns an expression evaluating to the largest of a and b.
+func (d *Dialect) Greatest(a, b string) string {
+	return fmt.Sprintf("%s(%s, %s)", d.greatest, a, b)
+}
+
+// SkipLocked returns the clause of a SELECT locking the selected rows of
+// table until the end of the transaction, and skipping the rows already locked
+// by other transactions.
+func (d *Dialect) SkipLocked(table string) string {
+	if !d.rowLocks {
+		return ""
+	}
+	return "FOR UPDATE OF " + table + " SKIP LOCKED"
+}
+
+// EqualFold returns a condition comparing column to a placeholder without
+// regard to case, able to use the index on the column.
+func (d *Dialect) EqualFold(column string) string {
+	if d == SQLite {
+		return column + " = ? COLLATE NOCASE"
+	}
+	return "lower(" + column + ") = lower(?)"
+}
+
+// MatchPosts returns a condition selecting the posts, aliased P, whose body
+// contains every word of a placeholder. The argument of the placeholder is
+// returned by SearchTerms.
+func (d *Dialect) MatchPosts() string {
+	if d == SQLite {
+		return "P.id IN (SELECT docid FROM PostsSearch WHERE PostsSearch MATCH ?)"
+	}
+	return "to_tsvector('simple', P.body) @@ plainto_tsquery('simple', ?)"
+}
+
+// SearchTerms returns the argument of the placeholder of MatchPosts
+// searching for words, as split by SearchWords. On SQLite, each word is
+// quoted so that none is read as an operator of the query syntax.
+func (d *Dialect) SearchTerms(words []string) string {
+	if d != SQLite {
+		return strings.Join(words, " ")
+	}
+	quoted := make([]string, len(words))
+	for i, w := range words {
+		quoted[i] = `"` + w + `"`
+	}
+	return strings.Join(quoted, " ")
+}
+
+// SearchWords splits a search into the words it looks for: the runs of
+// letters and digits, lowercased.
+func SearchWords(query string) []string {
+	return strings.FieldsFunc(strings.ToLower(query), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// DB is a database handle with its dialect. Its methods take queries written
+// for SQLite and rebind them for the dialect.
+//
+// The embedded pool runs every statement changing the database. On SQLite,
+// it is a single connection, so that writers queue in the process rather
+// than fail with "database is locked", and queries run on a pool of their
+// own.
+type DB struct {
+	*sql.DB
+	Dialect *Dialect
+	// QueryTimeout, if positive, bounds the time each call to a model may
+	// take.
+	QueryTimeout time.Duration
+	read         *sql.DB
+}
+
+// Options tune the connections to a database. PostgreSQL ignores all but
+// QueryTimeout.
+type Options struct {
+	// QueryTimeout, if positive, bounds the time each call to a model may
+	// take.
+	QueryTimeout time.Duration
+	// JournalMode is the journal mode of the database, such as "wal".
+	JournalMode string
+	// BusyTimeout is how long a connection waits for a lock held by another
+	// one, such as another process, before failing.
+	BusyTimeout time.Duration
+	// ForeignKeys enforces the REFERENCES clauses of the schema.
+	ForeignKeys bool
+	// Synchronous is how often SQLite waits for the data to reach the disk:
+	// "off", "normal", "full" or "extra".
+	Synchronous string
+	// ReadConns is the number of connections running queries. With 0, they
+	// share the write connection.
+	ReadConns int
+}
+
+// Open opens the database named by dsn and checks that it can be reached: a
+// postgres:// or postgresql:// URL selects PostgreSQL, anything else is the
+// path of a SQLite database.
+func Open(dsn string, opts Options) (*DB, error) {
+	if !strings.HasPrefix(dsn, "postgres://") && !strings.HasPrefix(dsn, "postgresql://") {
+		return openSQLite(dsn, opts)
+	}
+
+	sqlDB, err := openPool(Postgres.Name, dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &DB{DB: sqlDB, Dialect: Postgres, QueryTimeout: opts.QueryTimeout, read: sqlDB}, nil
+}
+
+// openSQLite opens the write connection to the SQLite database at path, then
+// its read pool, applying opts as pragmas of every connection.
+func openSQLite(path string, opts Options) (*DB, error) {
+	params := url.Values{}
+	if opts.BusyTimeout > 0 {
+		params.Set("_busy_timeout", strconv.FormatInt(opts.BusyTimeout.Milliseconds(), 10))
+	}
+	if opts.ForeignKeys {
+		params.Set("_foreign_keys", "on")
+	}
+
+	// The journal mode is stored in the database by the write connection,
+	// which takes the write lock as soon as it begins a transaction: a
+	// transaction reading before writing cannot fail to upgrade its lock.
+	write := url.Values{"_txlock": {"immediate"}}
+	if opts.JournalMode != "" {
+		write.Set("_journal_mode", opts.JournalMode)
+	}
+	if opts.Synchronous != "" {
+		write.Set("_synchronous", opts.Synchronous)
+	}
+	for k, v := range params {
+		write[k] = v
+	}
+	writeDB, err := openPool(SQLite.Name, withParams(path, write))
+	if err != nil {
+		return nil, err
+	}
+	writeDB.SetMaxOpenConns(1)
+	db := &DB{DB: writeDB, Dialect: SQLite, QueryTimeout: opts.QueryTimeout, read: writeDB}
+	if opts.ReadConns == 0 {
+		return db, nil
+	}
+
+	params.Set("_query_only", "on")
+	db.read, err = openPool(SQLite.Name, withParams(path, params))
+	if err != nil {
+		writeDB.Close()
+		return nil, err
+	}
+	db.read.SetMaxOpenConns(opts.ReadConns)
+	db.read.SetMaxIdleConns(opts.ReadConns)
+	return db, nil
+}
+
+// openPool opens a connection pool and checks that the database can be
+// reached.
+func openPool(driver, dsn string) (*sql.DB, error) {
+	pool, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	err = pool.Ping()
+	if err != nil {
+		pool.Close()
+		return nil, err
+	}
+	return pool, nil
+}
+
+// withParams appends the connection parameters params to the SQLite dsn.
+func withParams(dsn string, params url.Values) string {
+	if strings.Contains(dsn, "?") {
+		return dsn + "&" + params.Encode()
+	}
+	return dsn + "?" + params.Encode()
+}
+
+// DataVersion returns a number that changes whenever another connection to
+// the SQLite database, such as another process, commits a change. The writes
+// made through db leave it as is.
+func (db *DB) DataVersion(ctx context.Context) (int64, error) {
+	if db.Dialect != SQLite {
+		return 0, errors.New("models: only SQLite databases report their data version")
+	}
+	var version int64
+	err := db.DB.QueryRowContext(ctx, `PRAGMA data_version`).Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("reading data version: %w", err)
+	}
+	return version, nil
+}
+
+// Close closes the connection pools of db.
+func (db *DB) Close() error {
+	var err error
+	if db.read != db.DB {
+		err = db.read.Close()
+	}
+	return errors.Join(db.DB.Close(), err)
+}
+
+func (db *DB) Exec(query string, args ...any) (sql.Result, error) {
+	return db.ExecContext(context.Background(), query, args...)
+}
+
+func (db *DB) Query(query string, args ...any) (*sql.Rows, error) {
+	return db.QueryContext(context.Background(), query, args...)
+}
+
+func (db *DB) QueryRow(query string, args ...any) *sql.Row {
+	return db.QueryRowContext(context.Background(), query, args...)
+}
+
+// ExecContext runs a statement, in the transaction of ctx if it carries one
+// of db.
+func (db *DB) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	if tx := db.txFrom(ctx); tx != nil {
+		return tx.ExecContext(ctx, query, args...)
+	}
+	return db.DB.ExecContext(ctx, db.Dialect.Rebind(query), args...)
+}
+
+// QueryContext runs a query, in the transaction of ctx if it carries one of
+// db.
+func (db *DB) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	if tx := db.txFrom(ctx); tx != nil {
+		return tx.QueryContext(ctx, query, args...)
+	}
+	return db.pool(query).QueryContext(ctx, db.Dialect.Rebind(query), args...)
+}
+
+// QueryRowContext runs a query returning at most one row, in the transaction
+// of ctx if it carries one of db.
+func (db *DB) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	if tx := db.txFrom(ctx); tx != nil {
+		return tx.QueryRowContext(ctx, query, args...)
+	}
+	return db.pool(query).QueryRowContext(ctx, db.Dialect.Rebind(query), args...)
+}
+
+// pool returns the pool to run query on: the read pool for a SELECT, the
+// write pool for anything else, such as an UPDATE ... RETURNING.
+func (db *DB) pool(query string) *sql.DB {
+	query = strings.TrimSpace(query)
+	if len(query) >= 6 && strings.EqualFold(query[:6], "SELECT") {
+		return db.read
+	}
+	return db.DB
+}
+
+// Begin starts a transaction speaking the dialect of db.
+func (db *DB) Begin() (*Tx, error) {
+	return db.BeginTx(context.Background())
+}
+
+// BeginTx starts a transaction speaking the dialect of db. If ctx carries a
+// transaction of db, the new one is nested in it as a savepoint: committing
+// it only makes its changes part of the enclosing transaction.
+func (db *DB) BeginTx(ctx context.Context) (*Tx, error) {
+	if parent := db.txFrom(ctx); parent != nil {
+		return parent.nest(ctx)
+	}
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Tx{Tx: tx, Dialect: db.Dialect, db: db, hooks: new([]func())}, nil
+}
+
+// txKey is the context key of the transaction of a unit of work.
+type txKey struct{}
+
+// WithTx runs fn as a unit of work: the models called with the context it is
+// given all run in one transaction, committed if fn returns nil and rolled
+// back otherwise. A unit of work started inside another one is nested in it.
+func (db *DB) WithTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	tx, err := db.BeginTx(ctx)
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	err = fn(context.WithValue(ctx, txKey{}, tx))
+	if err != nil {
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("committing transaction: %w", err)
+	}
+	return nil
+}
+
+// txFrom returns the transaction of db carried by ctx, or nil.
+func (db *DB) txFrom(ctx context.Context) *Tx {
+	tx, _ := ctx.Value(txKey{}).(*Tx)
+	if tx == nil || tx.db != db {
+		return nil
+	}
+	return tx
+}
+
+// afterCommit runs fn once the transaction of ctx commits, or right away if
+// ctx carries none. fn is dropped if the transaction is rolled back.
+func (db *DB) afterCommit(ctx context.Context, fn func()) {
+	if tx := db.txFrom(ctx); tx != nil {
+		*tx.hooks = append(*tx.hooks, fn)
+		return
+	}
+	fn()
+}
+
+// start begins a model method: it bounds ctx by the query timeout of db and
+// times the method for QueryObserver. The returned function, meant to be
+// deferred, ends it.
+func (db *DB) start(ctx context.Context, method string) (context.Context, func()) {
+	done := observe(method)
+	if db.QueryTimeout <= 0 {
+		return ctx, done
+	}
+	ctx, cancel := context.WithTimeout(ctx, db.QueryTimeout)
+	return ctx, func() {
+		cancel()
+		done()
+	}
+}
+
+// Insert runs an INSERT statement and returns the id of the inserted row.
+func (db *DB) Insert(query string, args ...any) (int, error) {
+	return db.InsertContext(context.Background(), query, args...)
+}
+
+// InsertContext runs an INSERT statement, in the transaction of ctx if it
+// carries one of db, and returns the id of the inserted row.
+func (db *DB) InsertContext(ctx context.Context, query string, args ...any) (int, error) {
+	return insert(ctx, db, db.Dialect, query, args...)
+}
+
+// createTables runs the statements creating the tables of a model, adapted to
+// the dialect of db.
+func (db *DB) createTables(stmts ...string) error {
+	for _, stmt := range stmts {
+		_, err := db.Exec(db.Dialect.schema(stmt))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Tx is a transaction with the dialect of its database, or a savepoint in
+// one.
+type Tx struct {
+	*sql.Tx
+	Dialect *Dialect
+	db      *DB
+	// savepoint is the name of the savepoint, empty for a transaction.
+	savepoint string
+	depth     int
+	done      bool
+	// hooks are the functions to run once the outermost transaction commits,
+	// shared by its savepoints.
+	hooks *[]func()
+	// mark is the number of hooks when the savepoint was created.
+	mark int
+}
+
+// nest starts a savepoint in tx.
+func (tx *Tx) nest(ctx context.Context) (*Tx, error) {
+	sp := &Tx{
+		Tx:        tx.Tx,
+		Dialect:   tx.Dialect,
+		db:        tx.db,
+		savepoint: fmt.Sprintf("sp%d", tx.depth+1),
+		depth:     tx.depth + 1,
+		hooks:     tx.hooks,
+		mark:      len(*tx.hooks),
+	}
+	_, err := tx.Tx.ExecContext(ctx, "SAVEPOINT "+sp.savepoint)
+	if err != nil {
+		return nil, err
+	}
+	return sp, nil
+}
+
+// Commit commits the transaction and runs the functions waiting for it, or
+// releases the savepoint.
+func (tx *Tx) Commit() error {
+	if tx.savepoint == "" {
+		err := tx.Tx.Commit()
+		if err != nil {
+			return err
+		}
+		if tx.hooks != nil {
+			for _, fn := range *tx.hooks {
+				fn()
+			}
+		}
+		return nil
+	}
+	if tx.done {
+		return sql.ErrTxDone
+	}
+	tx.done = true
+	_, err := tx.Tx.Exec("RELEASE SAVEPOINT " + tx.savepoint)
+	return err
+}
+
+// Rollback rolls back the transaction, or the changes made since the
+// savepoint.
+func (tx *Tx) Rollback() error {
+	if tx.savepoint == "" {
+		return tx.Tx.Rollback()
+	}
+	if tx.done {
+		return sql.ErrTxDone
+	}
+	tx.done = true
+	*tx.hooks = (*tx.hooks)[:tx.mark]
+	_, err := tx.Tx.Exec("ROLLBACK TO SAVEPOINT " + tx.savepoint)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Tx.Exec("RELEASE SAVEPOINT " + tx.savepoint)
+	return err
+}
+
+func (tx *Tx) Exec(query string, args ...any) (sql.Result, error) {
+	return tx.Tx.Exec(tx.Dialect.Rebind(query), args...)
+}
+
+func (tx *Tx) Query(query string, args ...any) (*sql.Rows, error) {
+	return tx.Tx.Query(tx.Dialect.Rebind(query), args...)
+}
+
+func (tx *Tx) QueryRow(query string, args ...any) *sql.Row {
+	return tx.Tx.QueryRow(tx.Dialect.Rebind(query), args...)
+}
+
+func (tx *Tx) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return tx.Tx.ExecContext(ctx, tx.Dialect.Rebind(query), args...)
+}
+
+func (tx *Tx) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return tx.Tx.QueryContext(ctx, tx.Dialect.Rebind(query), args...)
+}
+
+func (tx *Tx) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	return tx.Tx.QueryRowContext(ctx, tx.Dialect.Rebind(query), args...)
+}
+
+// Insert runs an INSERT statement and returns the id of the inserted row.
+func (tx *Tx) Insert(query string, args ...any) (int, error) {
+	return tx.InsertContext(context.Background(), query, args...)
+}
+
+// InsertContext runs an INSERT statement and returns the id of the inserted
+// row.
+func (tx *Tx) InsertContext(ctx context.Context, query string, args ...any) (int, error) {
+	return insert(ctx, tx, tx.Dialect, query, args...)
+}
+
+// insert runs an INSERT statement on q and returns the id of the inserted
+// row, read the way the dialect allows.
+func insert(ctx context.Context, q execQuerier, d *Dialect, query string, args ...any) (int, error) {
+	if d.returning {
+		var id int
+		err := q.QueryRowContext(ctx, query+" RETURNING id", args...).Scan(&id)
+		return id, err
+	}
+	result, err := q.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("getting last insert id: %w", err)
+	}
+	return int(id), nil
+}
+
+// isUniqueViolation reports whether err is the violation of the unique
+// constraint on a column of a table, or of its unique index named
+// table_column.
+func isUniqueViolation(err error, table, column string) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique &&
+			strings.Contains(sqliteErr.Error(), table+"."+column)
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		name := strings.ToLower(table) + "_" + column
+		return pqErr.Code == "23505" && (pqErr.Constraint == name+"_key" || pqErr.Constraint == name)
+	}
+	return false
+}
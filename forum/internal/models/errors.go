@@ -8,4 +8,8 @@ var (
 	ErrNoRecord           = errors.New("models: no matching record found")
 	ErrInvalidCredentials = errors.New("models: invalid credentials")
 	ErrDuplicateEmail     = errors.New("models: duplicate email")
+	ErrDuplicateUsername  = errors.New("models: duplicate username")
+	ErrBlocked            = errors.New("models: blocked by user")
+	ErrDisabled           = errors.New("models: user disabled")
+	ErrLocked             = errors.New("models: thread locked")
 )
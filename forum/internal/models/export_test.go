@@ -0,0 +1,9 @@
+package models
+
+// WithDialect returns a handle of the database of db speaking d, so that
+// the queries rewritten for PostgreSQL can be run on SQLite.
+func WithDialect(db *DB, d *Dialect) *DB {
+	c := *db
+	c.Dialect = d
+	return &c
+}
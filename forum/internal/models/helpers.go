@@ -1,16 +1,21 @@
 package models
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
+	"time"
 )
 
-// NewModels creates all models necessary for the application.
-func NewModels(db *sql.DB) (*ThreadModel, *UserModel, *PostModel, error) {
-	threadModel, err := NewThreadModel(db)
+// NewModels creates all models necessary for the application and brings the
+// schema up to date.
+func NewModels(db *DB) (*ThreadModel, *UserModel, *PostModel, error) {
+	// Threads reference Users, which must exist first on PostgreSQL.
+	userModel, err := NewUserModel(db)
 	if err != nil {
 		return nil, nil, nil, err
 	}
-	userModel, err := NewUserModel(db)
+	threadModel, err := NewThreadModel(db)
 	if err != nil {
 		return nil, nil, nil, err
 	}
@@ -18,5 +23,54 @@ func NewModels(db *sql.DB) (*ThreadModel, *UserModel, *PostModel, error) {
 	if err != nil {
 		return nil, nil, nil, err
 	}
+	err = migrate(db)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("migrating schema: %w", err)
+	}
 	return threadModel, userModel, postModel, nil
 }
+
+// querier is implemented by both *DB and *Tx.
+type querier interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// execQuerier is implemented by both *DB and *Tx.
+type execQuerier interface {
+	querier
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// QueryObserver, when set, receives the duration of every model method
+// querying the database, named as "Model.Method". It must be set before the
+// models are used.
+var QueryObserver func(method string, d time.Duration)
+
+// observe starts timing a model method. The returned function, meant to be
+// deferred, reports its duration to QueryObserver.
+func observe(method string) func() {
+	if QueryObserver == nil {
+		return func() {}
+	}
+	start := time.Now()
+	return func() { QueryObserver(method, time.Since(start)) }
+}
+
+// ids returns the ids selected by query, which takes args.
+func (db *DB) ids(ctx context.Context, query string, args ...any) ([]int, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []int
+	for rows.Next() {
+		var id int
+		err := rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
@@ -0,0 +1,80 @@
+package models_test
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"forum/internal/models"
+	"forum/internal/testutil"
+)
+
+// TestConcurrentPosts posts to a thread from many goroutines through two
+// handles on the same SQLite database, as two server processes would, while
+// others read the thread. None of them may fail with "database is locked".
+func TestConcurrentPosts(t *testing.T) {
+	if testing.Short() {
+		t.Skip("load test")
+	}
+	const (
+		writers = 16
+		posts   = 25
+		readers = 8
+	)
+
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "test.sqlite")
+	var handles []*stores
+	for i := 0; i < 2; i++ {
+		db, err := models.Open(path, sqliteOptions)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+		handles = append(handles, sqlStores(t, db))
+	}
+	author := handles[0].addUser(t, "alice")
+	thread := handles[0].addThread(t, "Load", author)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, writers*posts+readers*posts)
+	for i := 0; i < writers; i++ {
+		s := handles[i%len(handles)]
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < posts; j++ {
+				_, err := s.posts.InsertContext(ctx, fmt.Sprintf("post %d.%d", i, j), thread, author)
+				if err != nil {
+					errs <- fmt.Errorf("inserting post: %w", err)
+				}
+			}
+		}(i)
+	}
+	for i := 0; i < readers; i++ {
+		s := handles[i%len(handles)]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < posts; j++ {
+				_, err := s.threads.GetContext(ctx, thread)
+				if err != nil {
+					errs <- fmt.Errorf("getting thread: %w", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	th, err := handles[1].threads.GetContext(ctx, thread)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.Equal(t, th.ReplyCount, writers*posts)
+}
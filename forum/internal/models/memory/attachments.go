@@ -0,0 +1,80 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+
+	"forum/internal/models"
+)
+
+// AttachmentModel is an in-memory models.AttachmentStore.
+type AttachmentModel struct {
+	DB *DB
+}
+
+// NewAttachmentModel returns an AttachmentModel storing its data in db.
+func NewAttachmentModel(db *DB) *AttachmentModel {
+	return &AttachmentModel{DB: db}
+}
+
+func (m *AttachmentModel) InsertContext(ctx context.Context, postID, userID int, filename, contentType string, size int64, storageKey string) (int, error) {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+	for _, a := range m.DB.attachments {
+		if a.StorageKey == storageKey {
+			return 0, fmt.Errorf("inserting new attachment in db: storage key %q already used", storageKey)
+		}
+	}
+	a := &models.Attachment{
+		ID:          m.DB.nextID("attachments"),
+		PostID:      postID,
+		UserID:      userID,
+		Filename:    filename,
+		ContentType: contentType,
+		Size:        size,
+		StorageKey:  storageKey,
+		Created:     now(),
+	}
+	m.DB.attachments = append(m.DB.attachments, a)
+	return a.ID, nil
+}
+
+func (m *AttachmentModel) GetContext(ctx context.Context, id int) (*models.Attachment, error) {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+	for _, a := range m.DB.attachments {
+		if a.ID == id {
+			c := *a
+			return &c, nil
+		}
+	}
+	return nil, models.ErrNoRecord
+}
+
+func (m *AttachmentModel) ForPostsContext(ctx context.Context, posts []*models.Post) error {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+	byID := make(map[int]*models.Post, len(posts))
+	for _, p := range posts {
+		byID[p.ID] = p
+	}
+	for _, a := range m.DB.attachments {
+		if p, ok := byID[a.PostID]; ok {
+			c := *a
+			p.Attachments = append(p.Attachments, &c)
+		}
+	}
+	return nil
+}
+
+func (m *AttachmentModel) UsedBytesContext(ctx context.Context, userID int) (int64, error) {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+	var n int64
+	for _, a := range m.DB.attachments {
+		if a.UserID == userID {
+			n += a.Size
+		}
+	}
+	return n, nil
+}
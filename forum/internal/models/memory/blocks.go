@@ -0,0 +1,68 @@
+package memory
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"forum/internal/models"
+)
+
+type blockKey struct {
+	blockerID, blockedID int
+}
+
+// BlockModel is an in-memory models.BlockStore.
+type BlockModel struct {
+	DB *DB
+}
+
+// NewBlockModel returns a BlockModel storing its data in db.
+func NewBlockModel(db *DB) *BlockModel {
+	return &BlockModel{DB: db}
+}
+
+func (m *BlockModel) InsertContext(ctx context.Context, blockerID, blockedID int) error {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+	key := blockKey{blockerID, blockedID}
+	if _, ok := m.DB.blocks[key]; !ok {
+		m.DB.blocks[key] = now()
+	}
+	return nil
+}
+
+func (m *BlockModel) DeleteContext(ctx context.Context, blockerID, blockedID int) error {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+	delete(m.DB.blocks, blockKey{blockerID, blockedID})
+	return nil
+}
+
+func (m *BlockModel) BlockedContext(ctx context.Context, blockerID int) ([]*models.User, error) {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+	var users []*models.User
+	for key := range m.DB.blocks {
+		if u := m.DB.user(key.blockedID); key.blockerID == blockerID && u != nil {
+			users = append(users, &models.User{ID: u.id, Username: u.username})
+		}
+	}
+	slices.SortFunc(users, func(a, b *models.User) int {
+		return strings.Compare(a.Username, b.Username)
+	})
+	return users, nil
+}
+
+// blockedBetween reports whether userID and any of the others have blocked
+// each other. db.mu must be held.
+func (db *DB) blockedBetween(userID int, others []int) bool {
+	for _, other := range others {
+		_, blocks := db.blocks[blockKey{userID, other}]
+		_, blocked := db.blocks[blockKey{other, userID}]
+		if blocks || blocked {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,245 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"forum/internal/models"
+)
+
+type conversation struct {
+	id            int
+	subject       string
+	created       time.Time
+	lastMessageAt time.Time
+	participants  []*participant
+}
+
+type participant struct {
+	userID            int
+	lastReadMessageID int
+	archived          bool
+	left              bool
+}
+
+type message struct {
+	id             int
+	conversationID int
+	authorID       int
+	body           string
+	created        time.Time
+}
+
+// ConversationModel is an in-memory models.ConversationStore.
+type ConversationModel struct {
+	DB *DB
+}
+
+// NewConversationModel returns a ConversationModel storing its data in db.
+func NewConversationModel(db *DB) *ConversationModel {
+	return &ConversationModel{DB: db}
+}
+
+// participant returns userID as an active participant of the conversation
+// with the given id, or nil. db.mu must be held.
+func (db *DB) participant(conversationID, userID int) (*conversation, *participant) {
+	for _, c := range db.conversations {
+		if c.id != conversationID {
+			continue
+		}
+		for _, p := range c.participants {
+			if p.userID == userID && !p.left {
+				return c, p
+			}
+		}
+	}
+	return nil, nil
+}
+
+func (m *ConversationModel) InsertContext(ctx context.Context, subject, body string, authorID int, recipientIDs []int) (int, error) {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+	if m.DB.blockedBetween(authorID, recipientIDs) {
+		return 0, models.ErrBlocked
+	}
+
+	c := &conversation{id: m.DB.nextID("conversations"), subject: subject, created: now()}
+	for _, userID := range append([]int{authorID}, recipientIDs...) {
+		if slices.ContainsFunc(c.participants, func(p *participant) bool { return p.userID == userID }) {
+			return 0, fmt.Errorf("adding participant %d: already a participant", userID)
+		}
+		c.participants = append(c.participants, &participant{userID: userID})
+	}
+	m.DB.conversations = append(m.DB.conversations, c)
+	c.participants[0].lastReadMessageID = m.DB.insertMessage(c, authorID, body)
+	return c.id, nil
+}
+
+func (m *ConversationModel) ReplyContext(ctx context.Context, conversationID, authorID int, body string) (int, error) {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+	c, author := m.DB.participant(conversationID, authorID)
+	if c == nil {
+		return 0, models.ErrNoRecord
+	}
+	var others []int
+	for _, p := range c.participants {
+		if p.userID != authorID && !p.left {
+			others = append(others, p.userID)
+		}
+	}
+	if m.DB.blockedBetween(authorID, others) {
+		return 0, models.ErrBlocked
+	}
+
+	id := m.DB.insertMessage(c, authorID, body)
+	for _, p := range c.participants {
+		p.archived = false
+	}
+	author.lastReadMessageID = id
+	return id, nil
+}
+
+// insertMessage adds a message to c and bumps its last activity. db.mu must
+// be held.
+func (db *DB) insertMessage(c *conversation, authorID int, body string) int {
+	msg := &message{
+		id:             db.nextID("messages"),
+		conversationID: c.id,
+		authorID:       authorID,
+		body:           body,
+		created:        now(),
+	}
+	db.messages = append(db.messages, msg)
+	c.lastMessageAt = msg.created
+	return msg.id
+}
+
+func (m *ConversationModel) GetContext(ctx context.Context, conversationID, userID int) (*models.Conversation, error) {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+	c, p := m.DB.participant(conversationID, userID)
+	if c == nil {
+		return nil, models.ErrNoRecord
+	}
+
+	mc := m.DB.conversationModel(c, p)
+	for _, msg := range m.DB.messages {
+		if msg.conversationID == c.id {
+			author := m.DB.userRef(msg.authorID)
+			mc.Messages = append(mc.Messages, &models.PrivateMessage{
+				ID:      msg.id,
+				Body:    msg.body,
+				Author:  &models.User{ID: author.ID, Username: author.Username},
+				Created: msg.created,
+			})
+		}
+	}
+	return mc, nil
+}
+
+// conversationModel returns c as seen by p, with its active participants.
+// db.mu must be held.
+func (db *DB) conversationModel(c *conversation, p *participant) *models.Conversation {
+	mc := &models.Conversation{
+		ID:            c.id,
+		Subject:       c.subject,
+		Created:       c.created,
+		LastMessageAt: c.lastMessageAt,
+		Archived:      p.archived,
+	}
+	for _, other := range c.participants {
+		if u := db.user(other.userID); u != nil && !other.left {
+			mc.Participants = append(mc.Participants, &models.User{ID: u.id, Username: u.username})
+		}
+	}
+	slices.SortFunc(mc.Participants, func(a, b *models.User) int {
+		return strings.Compare(a.Username, b.Username)
+	})
+	return mc
+}
+
+// unread returns the number of messages of c that p has not read. db.mu must
+// be held.
+func (db *DB) unread(c *conversation, p *participant) int {
+	n := 0
+	for _, msg := range db.messages {
+		if msg.conversationID == c.id && msg.id > p.lastReadMessageID && msg.authorID != p.userID {
+			n++
+		}
+	}
+	return n
+}
+
+func (m *ConversationModel) InboxContext(ctx context.Context, userID int, archived bool) ([]*models.Conversation, error) {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+	var conversations []*models.Conversation
+	for _, c := range m.DB.conversations {
+		if _, p := m.DB.participant(c.id, userID); p != nil && p.archived == archived {
+			mc := m.DB.conversationModel(c, p)
+			mc.Unread = m.DB.unread(c, p)
+			conversations = append(conversations, mc)
+		}
+	}
+	slices.SortFunc(conversations, func(a, b *models.Conversation) int {
+		if c := b.LastMessageAt.Compare(a.LastMessageAt); c != 0 {
+			return c
+		}
+		return b.ID - a.ID
+	})
+	return conversations, nil
+}
+
+func (m *ConversationModel) UnreadCountContext(ctx context.Context, userID int) (int, error) {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+	n := 0
+	for _, c := range m.DB.conversations {
+		if _, p := m.DB.participant(c.id, userID); p != nil && m.DB.unread(c, p) > 0 {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *ConversationModel) MarkReadContext(ctx context.Context, conversationID, userID, messageID int) error {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+	for _, c := range m.DB.conversations {
+		if c.id != conversationID {
+			continue
+		}
+		for _, p := range c.participants {
+			if p.userID == userID {
+				p.lastReadMessageID = max(p.lastReadMessageID, messageID)
+			}
+		}
+	}
+	return nil
+}
+
+func (m *ConversationModel) SetArchivedContext(ctx context.Context, conversationID, userID int, archived bool) error {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+	_, p := m.DB.participant(conversationID, userID)
+	if p == nil {
+		return models.ErrNoRecord
+	}
+	p.archived = archived
+	return nil
+}
+
+func (m *ConversationModel) LeaveContext(ctx context.Context, conversationID, userID int) error {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+	_, p := m.DB.participant(conversationID, userID)
+	if p == nil {
+		return models.ErrNoRecord
+	}
+	p.left = true
+	return nil
+}
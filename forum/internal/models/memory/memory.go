@@ -0,0 +1,65 @@
+// Package memory implements the stores of package models in memory, so that
+// the application can be tested without a database. The models of a DB share
+// its data, as the models of package models share a database, and follow the
+// same rules: the same errors, orderings and defaults.
+package memory
+
+import (
+	"sync"
+	"time"
+
+	"forum/internal/models"
+)
+
+// DB holds the data of the in-memory models. The zero value is not usable;
+// use New.
+type DB struct {
+	mu sync.Mutex
+	// txMu is held by the unit of work in progress.
+	txMu sync.Mutex
+	seq  map[string]int
+
+	users         []*user
+	threads       []*thread
+	posts         []*post
+	reads         map[readKey]int
+	conversations []*conversation
+	messages      []*message
+	blocks        map[blockKey]time.Time
+	attachments   []*models.Attachment
+	webhooks      []*webhook
+	deliveries    []*delivery
+	attempts      []*attempt
+}
+
+// New returns an empty DB.
+func New() *DB {
+	return &DB{
+		seq:    map[string]int{},
+		reads:  map[readKey]int{},
+		blocks: map[blockKey]time.Time{},
+	}
+}
+
+// nextID returns the next ID of a table, starting at 1. db.mu must be held.
+func (db *DB) nextID(table string) int {
+	db.seq[table]++
+	return db.seq[table]
+}
+
+// now returns the current time, in UTC like the timestamps stored by SQLite.
+func now() time.Time {
+	return time.Now().UTC()
+}
+
+var (
+	_ models.ThreadStore       = (*ThreadModel)(nil)
+	_ models.PostStore         = (*PostModel)(nil)
+	_ models.UserStore         = (*UserModel)(nil)
+	_ models.ReadStore         = (*ReadModel)(nil)
+	_ models.ConversationStore = (*ConversationModel)(nil)
+	_ models.BlockStore        = (*BlockModel)(nil)
+	_ models.AttachmentStore   = (*AttachmentModel)(nil)
+	_ models.WebhookStore      = (*WebhookModel)(nil)
+	_ models.Transactor        = (*DB)(nil)
+)
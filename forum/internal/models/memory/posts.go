@@ -0,0 +1,126 @@
+package memory
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"forum/internal/cache"
+	"forum/internal/models"
+	"forum/internal/pubsub"
+)
+
+type post struct {
+	id       int
+	body     string
+	authorID int
+	threadID int
+	created  time.Time
+}
+
+// PostModel is an in-memory models.PostStore. Like models.PostModel, it
+// announces new posts on Hub and invalidates the data derived from their
+// thread in Cache, if set.
+type PostModel struct {
+	DB    *DB
+	Hub   pubsub.Publisher
+	Cache cache.Cache
+}
+
+// NewPostModel returns a PostModel storing its data in db.
+func NewPostModel(db *DB) *PostModel {
+	return &PostModel{DB: db}
+}
+
+// postModel returns p with its author. db.mu must be held.
+func (db *DB) postModel(p *post) *models.Post {
+	return &models.Post{
+		ID:      p.id,
+		Body:    p.body,
+		Author:  db.userRef(p.authorID),
+		Created: p.created,
+	}
+}
+
+func (m *PostModel) InsertContext(ctx context.Context, body string, threadId, authorId int) (int, error) {
+	m.DB.mu.Lock()
+	t := m.DB.thread(threadId)
+	if t == nil {
+		m.DB.mu.Unlock()
+		return 0, models.ErrNoRecord
+	}
+	if t.locked {
+		m.DB.mu.Unlock()
+		return 0, models.ErrLocked
+	}
+	p := &post{
+		id:       m.DB.nextID("posts"),
+		body:     body,
+		authorID: authorId,
+		threadID: threadId,
+		created:  now(),
+	}
+	m.DB.posts = append(m.DB.posts, p)
+	t.lastPostAt = p.created
+	t.lastPostAuthorID = authorId
+	t.lastPostID = p.id
+	t.replyCount++
+	m.DB.mu.Unlock()
+
+	m.DB.afterCommit(ctx, func() {
+		if m.Cache != nil {
+			m.Cache.Invalidate(models.TagThreads, models.ThreadTag(threadId))
+		}
+		if m.Hub != nil {
+			m.Hub.Publish(models.ThreadTopic(threadId), pubsub.Event{ID: int64(p.id), Type: models.EventPostCreated})
+		}
+	})
+	return p.id, nil
+}
+
+func (m *PostModel) SinceContext(ctx context.Context, threadID, afterID, limit int) ([]*models.Post, error) {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+	var posts []*models.Post
+	for _, p := range m.DB.posts {
+		if p.threadID == threadID && p.id > afterID {
+			posts = append(posts, m.DB.postModel(p))
+		}
+	}
+	return page(posts, limit, 0), nil
+}
+
+func (m *PostModel) SearchContext(ctx context.Context, query string, limit, offset int) ([]*models.SearchResult, error) {
+	words := models.SearchWords(query)
+	if len(words) == 0 {
+		return nil, nil
+	}
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+	var results []*models.SearchResult
+	for i := len(m.DB.posts) - 1; i >= 0; i-- {
+		p := m.DB.posts[i]
+		if !containsWords(p.body, words) {
+			continue
+		}
+		t := m.DB.thread(p.threadID)
+		results = append(results, &models.SearchResult{
+			Post:        m.DB.postModel(p),
+			ThreadID:    t.id,
+			ThreadTitle: t.title,
+		})
+	}
+	return page(results, limit, offset), nil
+}
+
+// containsWords reports whether text contains every one of words, as split
+// by models.SearchWords.
+func containsWords(text string, words []string) bool {
+	have := models.SearchWords(text)
+	for _, w := range words {
+		if !slices.Contains(have, w) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,84 @@
+package memory
+
+import (
+	"context"
+	"forum/internal/models"
+)
+
+type readKey struct {
+	userID, threadID int
+}
+
+// ReadModel is an in-memory models.ReadStore.
+type ReadModel struct {
+	DB *DB
+}
+
+// NewReadModel returns a ReadModel storing its data in db.
+func NewReadModel(db *DB) *ReadModel {
+	return &ReadModel{DB: db}
+}
+
+func (m *ReadModel) MarkReadContext(ctx context.Context, userID, threadID, postID int) error {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+	key := readKey{userID, threadID}
+	m.DB.reads[key] = max(m.DB.reads[key], postID)
+	return nil
+}
+
+func (m *ReadModel) MarkAllReadContext(ctx context.Context, userID int) error {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+	u := m.DB.user(userID)
+	if u == nil {
+		return nil
+	}
+	u.readAllPostID = 0
+	for _, p := range m.DB.posts {
+		u.readAllPostID = max(u.readAllPostID, p.id)
+	}
+	u.readAllThreadID = 0
+	for _, t := range m.DB.threads {
+		u.readAllThreadID = max(u.readAllThreadID, t.id)
+	}
+	return nil
+}
+
+// lastRead returns the id of the last post of the thread read by u. db.mu
+// must be held.
+func (db *DB) lastRead(u *user, threadID int) int {
+	return max(db.reads[readKey{u.id, threadID}], u.readAllPostID)
+}
+
+func (m *ReadModel) FlagUnreadContext(ctx context.Context, userID int, threads []*models.Thread) error {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+	u := m.DB.user(userID)
+	if u == nil {
+		return nil
+	}
+	for _, t := range threads {
+		if m.DB.thread(t.ID) != nil {
+			_, viewed := m.DB.reads[readKey{u.id, t.ID}]
+			t.Unread = t.LastPostID > m.DB.lastRead(u, t.ID) || (!viewed && t.ID > u.readAllThreadID)
+		}
+	}
+	return nil
+}
+
+func (m *ReadModel) FirstUnreadContext(ctx context.Context, userID, threadID int) (int, error) {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+	u := m.DB.user(userID)
+	if u == nil {
+		return 0, models.ErrNoRecord
+	}
+	lastRead := m.DB.lastRead(u, threadID)
+	for _, p := range m.DB.posts {
+		if p.threadID == threadID && p.id > lastRead {
+			return p.id, nil
+		}
+	}
+	return 0, models.ErrNoRecord
+}
@@ -0,0 +1,167 @@
+package memory
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"forum/internal/cache"
+	"forum/internal/models"
+)
+
+type thread struct {
+	id               int
+	title            string
+	authorID         int
+	created          time.Time
+	lastPostAt       time.Time
+	lastPostAuthorID int
+	lastPostID       int
+	replyCount       int
+	locked           bool
+}
+
+// ThreadModel is an in-memory models.ThreadStore. Like models.ThreadModel,
+// it invalidates the data derived from threads in Cache, if set.
+type ThreadModel struct {
+	DB    *DB
+	Cache cache.Cache
+}
+
+// NewThreadModel returns a ThreadModel storing its data in db.
+func NewThreadModel(db *DB) *ThreadModel {
+	return &ThreadModel{DB: db}
+}
+
+// thread returns the thread with the given id, or nil. db.mu must be held.
+func (db *DB) thread(id int) *thread {
+	for _, t := range db.threads {
+		if t.id == id {
+			return t
+		}
+	}
+	return nil
+}
+
+func (m *ThreadModel) InsertContext(ctx context.Context, title string, authorId int) (int, error) {
+	m.DB.mu.Lock()
+	t := &thread{
+		id:               m.DB.nextID("threads"),
+		title:            title,
+		authorID:         authorId,
+		created:          now(),
+		lastPostAuthorID: authorId,
+	}
+	t.lastPostAt = t.created
+	m.DB.threads = append(m.DB.threads, t)
+	m.DB.mu.Unlock()
+
+	if m.Cache != nil {
+		m.DB.afterCommit(ctx, func() { m.Cache.Invalidate(models.TagThreads) })
+	}
+	return t.id, nil
+}
+
+func (m *ThreadModel) GetContext(ctx context.Context, id int) (*models.Thread, error) {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+	t := m.DB.thread(id)
+	if t == nil {
+		return nil, models.ErrNoRecord
+	}
+	return m.DB.threadModel(t, false), nil
+}
+
+func (m *ThreadModel) ExistsContext(ctx context.Context, id int) (bool, error) {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+	return m.DB.thread(id) != nil, nil
+}
+
+func (m *ThreadModel) LatestsContext(ctx context.Context, limit int) ([]*models.Thread, error) {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+	latests := slices.Clone(m.DB.threads)
+	slices.SortFunc(latests, func(a, b *thread) int {
+		if c := b.lastPostAt.Compare(a.lastPostAt); c != 0 {
+			return c
+		}
+		return b.id - a.id
+	})
+
+	var threads []*models.Thread
+	for _, t := range page(latests, limit, 0) {
+		mt := m.DB.threadModel(t, true)
+		if len(mt.Posts) > 1 {
+			mt.Posts = mt.Posts[:1]
+		}
+		threads = append(threads, mt)
+	}
+	return threads, nil
+}
+
+func (m *ThreadModel) NewestContext(ctx context.Context, limit int) ([]*models.Thread, error) {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+	newest := slices.Clone(m.DB.threads)
+	slices.SortFunc(newest, func(a, b *thread) int {
+		if c := b.created.Compare(a.created); c != 0 {
+			return c
+		}
+		return b.id - a.id
+	})
+
+	var threads []*models.Thread
+	for _, t := range page(newest, limit, 0) {
+		author := m.DB.userRef(t.authorID)
+		threads = append(threads, &models.Thread{
+			ID:      t.id,
+			Title:   t.title,
+			Created: t.created,
+			Author:  &models.User{ID: author.ID, Username: author.Username},
+		})
+	}
+	return threads, nil
+}
+
+func (m *ThreadModel) SetLockedContext(ctx context.Context, id int, locked bool) error {
+	m.DB.mu.Lock()
+	t := m.DB.thread(id)
+	if t == nil {
+		m.DB.mu.Unlock()
+		return models.ErrNoRecord
+	}
+	t.locked = locked
+	m.DB.mu.Unlock()
+
+	if m.Cache != nil {
+		m.DB.afterCommit(ctx, func() { m.Cache.Invalidate(models.TagThreads, models.ThreadTag(id)) })
+	}
+	return nil
+}
+
+// threadModel returns t with its author, last poster and posts, newest first
+// if desc is true. db.mu must be held.
+func (db *DB) threadModel(t *thread, desc bool) *models.Thread {
+	last := db.userRef(t.lastPostAuthorID)
+	mt := &models.Thread{
+		ID:         t.id,
+		Title:      t.title,
+		Author:     db.userRef(t.authorID),
+		Created:    t.created,
+		LastPostAt: t.lastPostAt,
+		LastPoster: &models.User{ID: last.ID, Username: last.Username},
+		LastPostID: t.lastPostID,
+		ReplyCount: t.replyCount,
+		Locked:     t.locked,
+	}
+	for _, p := range db.posts {
+		if p.threadID == t.id {
+			mt.Posts = append(mt.Posts, db.postModel(p))
+		}
+	}
+	if desc {
+		slices.Reverse(mt.Posts)
+	}
+	return mt
+}
@@ -0,0 +1,119 @@
+package memory
+
+import (
+	"context"
+	"maps"
+)
+
+// txKey is the context key of the unit of work of a DB.
+type txKey struct{}
+
+// tx is a unit of work in progress.
+type tx struct {
+	db *DB
+	// hooks are the functions to run once the unit of work succeeds.
+	hooks []func()
+}
+
+// WithTx runs fn as a unit of work. If fn fails, the data of db is restored
+// as it was when the unit of work began. Units of work run one at a time, but
+// the calls made outside of them are not held back, and are undone as well by
+// a rollback: the in-memory models are meant for tests.
+func (db *DB) WithTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	parent := db.txFrom(ctx)
+	if parent == nil {
+		db.txMu.Lock()
+		defer db.txMu.Unlock()
+	}
+
+	db.mu.Lock()
+	saved := db.clone()
+	db.mu.Unlock()
+
+	t := &tx{db: db}
+	err := fn(context.WithValue(ctx, txKey{}, t))
+	if err != nil {
+		db.mu.Lock()
+		db.restore(saved)
+		db.mu.Unlock()
+		return err
+	}
+	if parent != nil {
+		parent.hooks = append(parent.hooks, t.hooks...)
+		return nil
+	}
+	for _, fn := range t.hooks {
+		fn()
+	}
+	return nil
+}
+
+// txFrom returns the unit of work of db carried by ctx, or nil.
+func (db *DB) txFrom(ctx context.Context) *tx {
+	t, _ := ctx.Value(txKey{}).(*tx)
+	if t == nil || t.db != db {
+		return nil
+	}
+	return t
+}
+
+// afterCommit runs fn once the unit of work of ctx succeeds, or right away if
+// ctx carries none.
+func (db *DB) afterCommit(ctx context.Context, fn func()) {
+	if t := db.txFrom(ctx); t != nil {
+		t.hooks = append(t.hooks, fn)
+		return
+	}
+	fn()
+}
+
+// clone returns a copy of the data of db. db.mu must be held.
+func (db *DB) clone() *DB {
+	c := &DB{
+		seq:           maps.Clone(db.seq),
+		users:         cloneAll(db.users),
+		threads:       cloneAll(db.threads),
+		posts:         cloneAll(db.posts),
+		reads:         maps.Clone(db.reads),
+		conversations: cloneAll(db.conversations),
+		messages:      cloneAll(db.messages),
+		blocks:        maps.Clone(db.blocks),
+		attachments:   cloneAll(db.attachments),
+		webhooks:      cloneAll(db.webhooks),
+		deliveries:    cloneAll(db.deliveries),
+		attempts:      cloneAll(db.attempts),
+	}
+	for _, conv := range c.conversations {
+		conv.participants = cloneAll(conv.participants)
+	}
+	return c
+}
+
+// restore replaces the data of db by the copy c. db.mu must be held.
+func (db *DB) restore(c *DB) {
+	db.seq = c.seq
+	db.users = c.users
+	db.threads = c.threads
+	db.posts = c.posts
+	db.reads = c.reads
+	db.conversations = c.conversations
+	db.messages = c.messages
+	db.blocks = c.blocks
+	db.attachments = c.attachments
+	db.webhooks = c.webhooks
+	db.deliveries = c.deliveries
+	db.attempts = c.attempts
+}
+
+// cloneAll returns copies of the values pointed to by s.
+func cloneAll[T any](s []*T) []*T {
+	if s == nil {
+		return nil
+	}
+	c := make([]*T, len(s))
+	for i, v := range s {
+		copied := *v
+		c[i] = &copied
+	}
+	return c
+}
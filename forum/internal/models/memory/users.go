@@ -0,0 +1,325 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"forum/internal/cache"
+	"forum/internal/models"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+type user struct {
+	id              int
+	username        string
+	email           string
+	hashedPassword  []byte
+	created         time.Time
+	avatarVersion   int
+	role            string
+	bio             string
+	location        string
+	website         string
+	hideActivity    bool
+	readAllPostID   int
+	readAllThreadID int
+	disabled        bool
+}
+
+// UserModel is an in-memory models.UserStore. Passwords are hashed with the
+// minimum cost, to keep tests fast. Like models.UserModel, it invalidates the
+// data derived from users in Cache, if set.
+type UserModel struct {
+	DB    *DB
+	Cache cache.Cache
+}
+
+// NewUserModel returns a UserModel storing its data in db.
+func NewUserModel(db *DB) *UserModel {
+	return &UserModel{DB: db}
+}
+
+// user returns the user with the given id, or nil. db.mu must be held.
+func (db *DB) user(id int) *user {
+	for _, u := range db.users {
+		if u.id == id {
+			return u
+		}
+	}
+	return nil
+}
+
+// userRef returns the user with the given id as embedded in threads and
+// posts. A missing user is returned with the zero ID. db.mu must be held.
+func (db *DB) userRef(id int) *models.User {
+	u := db.user(id)
+	if u == nil {
+		return &models.User{}
+	}
+	return &models.User{ID: u.id, Username: u.username, AvatarVersion: u.avatarVersion}
+}
+
+func (u *user) model() *models.User {
+	return &models.User{
+		ID:             u.id,
+		Username:       u.username,
+		Email:          u.email,
+		HashedPassword: u.hashedPassword,
+		Role:           u.role,
+		Disabled:       u.disabled,
+	}
+}
+
+func (m *UserModel) InsertContext(ctx context.Context, username, email, password string) (int, error) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		return 0, fmt.Errorf("hashing password: %w", err)
+	}
+
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+	for _, u := range m.DB.users {
+		if u.email == email {
+			return 0, fmt.Errorf("inserting new user in db: %w", models.ErrDuplicateEmail)
+		}
+		if strings.EqualFold(u.username, username) {
+			return 0, fmt.Errorf("inserting new user in db: %w", models.ErrDuplicateUsername)
+		}
+	}
+	u := &user{
+		id:             m.DB.nextID("users"),
+		username:       username,
+		email:          email,
+		hashedPassword: hashedPassword,
+		created:        now(),
+		role:           models.RoleMember,
+	}
+	m.DB.users = append(m.DB.users, u)
+	return u.id, nil
+}
+
+func (m *UserModel) GetContext(ctx context.Context, id int) (*models.User, error) {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+	u := m.DB.user(id)
+	if u == nil {
+		return nil, models.ErrNoRecord
+	}
+	return u.model(), nil
+}
+
+// byUsername returns the user with the given username, ignoring case, or
+// nil. Among users sharing it, the one spelled exactly as asked wins, then the
+// oldest one. db.mu must be held.
+func (db *DB) byUsername(username string) *user {
+	var found *user
+	for _, u := range db.users {
+		if u.username == username {
+			return u
+		}
+		if found == nil && strings.EqualFold(u.username, username) {
+			found = u
+		}
+	}
+	return found
+}
+
+func (m *UserModel) GetByUsernameContext(ctx context.Context, username string) (*models.User, error) {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+	u := m.DB.byUsername(username)
+	if u == nil {
+		return nil, models.ErrNoRecord
+	}
+	return u.model(), nil
+}
+
+func (m *UserModel) UsernameExistsContext(ctx context.Context, username string) (bool, error) {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+	return m.DB.byUsername(username) != nil, nil
+}
+
+func (m *UserModel) SetAvatarVersionContext(ctx context.Context, id, old, version int) error {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+	u := m.DB.user(id)
+	if u == nil || u.avatarVersion != old {
+		return models.ErrNoRecord
+	}
+	u.avatarVersion = version
+	if m.Cache != nil {
+		m.DB.afterCommit(ctx, func() { m.Cache.Invalidate(models.TagUsers) })
+	}
+	return nil
+}
+
+func (m *UserModel) RoleContext(ctx context.Context, id int) (string, error) {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+	u := m.DB.user(id)
+	if u == nil {
+		return "", models.ErrNoRecord
+	}
+	return u.role, nil
+}
+
+func (m *UserModel) SetRoleContext(ctx context.Context, id int, role string) error {
+	if !slices.Contains(models.Roles, role) {
+		return fmt.Errorf("unknown role %q", role)
+	}
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+	u := m.DB.user(id)
+	if u == nil {
+		return models.ErrNoRecord
+	}
+	u.role = role
+	return nil
+}
+
+func (m *UserModel) SetDisabledContext(ctx context.Context, id int, disabled bool) error {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+	u := m.DB.user(id)
+	if u == nil {
+		return models.ErrNoRecord
+	}
+	u.disabled = disabled
+	return nil
+}
+
+func (m *UserModel) ExistsContext(ctx context.Context, email string) (bool, error) {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+	for _, u := range m.DB.users {
+		if u.email == email {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *UserModel) AuthenticateContext(ctx context.Context, email, password string) (int, error) {
+	m.DB.mu.Lock()
+	var found *user
+	for _, u := range m.DB.users {
+		if u.email == email {
+			found = u
+			break
+		}
+	}
+	m.DB.mu.Unlock()
+	if found == nil {
+		return 0, models.ErrInvalidCredentials
+	}
+
+	err := bcrypt.CompareHashAndPassword(found.hashedPassword, []byte(password))
+	if err != nil {
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return 0, models.ErrInvalidCredentials
+		}
+		return 0, fmt.Errorf("verifying password: %w", err)
+	}
+	if found.disabled {
+		return 0, models.ErrDisabled
+	}
+	return found.id, nil
+}
+
+func (m *UserModel) GetProfileContext(ctx context.Context, username string) (*models.Profile, error) {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+	u := m.DB.byUsername(username)
+	if u == nil {
+		return nil, models.ErrNoRecord
+	}
+	return m.DB.profile(u), nil
+}
+
+func (m *UserModel) GetProfileByIDContext(ctx context.Context, id int) (*models.Profile, error) {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+	u := m.DB.user(id)
+	if u == nil {
+		return nil, models.ErrNoRecord
+	}
+	return m.DB.profile(u), nil
+}
+
+// profile returns the public profile of u. db.mu must be held.
+func (db *DB) profile(u *user) *models.Profile {
+	p := &models.Profile{
+		ID:            u.id,
+		Username:      u.username,
+		Joined:        u.created,
+		Bio:           u.bio,
+		Location:      u.location,
+		Website:       u.website,
+		HideActivity:  u.hideActivity,
+		AvatarVersion: u.avatarVersion,
+	}
+	for _, t := range db.threads {
+		if t.authorID == u.id {
+			p.ThreadCount++
+		}
+	}
+	for _, post := range db.posts {
+		if post.authorID == u.id {
+			p.PostCount++
+		}
+	}
+	return p
+}
+
+func (m *UserModel) UpdateProfileContext(ctx context.Context, id int, bio, location, website string, hideActivity bool) error {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+	u := m.DB.user(id)
+	if u == nil {
+		return models.ErrNoRecord
+	}
+	u.bio, u.location, u.website, u.hideActivity = bio, location, website, hideActivity
+	return nil
+}
+
+func (m *UserModel) ActivityContext(ctx context.Context, userID, limit, offset int) ([]*models.Activity, error) {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+
+	var activity []*models.Activity
+	for _, t := range m.DB.threads {
+		if t.authorID == userID {
+			activity = append(activity, &models.Activity{
+				Kind: "thread", ThreadID: t.id, ThreadTitle: t.title, Created: t.created,
+			})
+		}
+	}
+	for _, p := range m.DB.posts {
+		if p.authorID == userID {
+			t := m.DB.thread(p.threadID)
+			if t == nil {
+				continue
+			}
+			activity = append(activity, &models.Activity{
+				Kind: "post", ThreadID: t.id, ThreadTitle: t.title, PostID: p.id, Body: p.body, Created: p.created,
+			})
+		}
+	}
+	slices.SortStableFunc(activity, func(a, b *models.Activity) int {
+		return b.Created.Compare(a.Created)
+	})
+	return page(activity, limit, offset), nil
+}
+
+// page returns at most limit elements of s, skipping the first offset ones.
+func page[T any](s []T, limit, offset int) []T {
+	offset = min(offset, len(s))
+	s = s[offset:]
+	return s[:min(limit, len(s))]
+}
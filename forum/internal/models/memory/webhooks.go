@@ -0,0 +1,248 @@
+package memory
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"forum/internal/models"
+)
+
+type webhook struct {
+	models.Webhook
+}
+
+type delivery struct {
+	id            int
+	webhookID     int
+	event         string
+	payload       []byte
+	status        string
+	attempts      int
+	nextAttemptAt time.Time
+	created       time.Time
+}
+
+type attempt struct {
+	models.WebhookAttempt
+}
+
+// WebhookModel is an in-memory models.WebhookStore.
+type WebhookModel struct {
+	DB *DB
+}
+
+// NewWebhookModel returns a WebhookModel storing its data in db.
+func NewWebhookModel(db *DB) *WebhookModel {
+	return &WebhookModel{DB: db}
+}
+
+// webhook returns the webhook with the given id, or nil. db.mu must be held.
+func (db *DB) webhook(id int) *webhook {
+	for _, w := range db.webhooks {
+		if w.ID == id {
+			return w
+		}
+	}
+	return nil
+}
+
+func (w *webhook) model() *models.Webhook {
+	c := w.Webhook
+	c.Events = slices.Clone(w.Events)
+	return &c
+}
+
+func (m *WebhookModel) InsertContext(ctx context.Context, url, secret string, events []string) (int, error) {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+	w := &webhook{models.Webhook{
+		ID:      m.DB.nextID("webhooks"),
+		URL:     url,
+		Secret:  secret,
+		Events:  slices.Clone(events),
+		Active:  true,
+		Created: now(),
+	}}
+	m.DB.webhooks = append(m.DB.webhooks, w)
+	return w.ID, nil
+}
+
+func (m *WebhookModel) GetContext(ctx context.Context, id int) (*models.Webhook, error) {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+	w := m.DB.webhook(id)
+	if w == nil {
+		return nil, models.ErrNoRecord
+	}
+	return w.model(), nil
+}
+
+func (m *WebhookModel) AllContext(ctx context.Context) ([]*models.Webhook, error) {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+	var webhooks []*models.Webhook
+	for _, w := range m.DB.webhooks {
+		webhooks = append(webhooks, w.model())
+	}
+	return webhooks, nil
+}
+
+func (m *WebhookModel) SetActiveContext(ctx context.Context, id int, active bool) error {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+	if w := m.DB.webhook(id); w != nil {
+		w.Active = active
+	}
+	return nil
+}
+
+func (m *WebhookModel) DeleteContext(ctx context.Context, id int) error {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+	deleted := map[int]bool{}
+	m.DB.deliveries = slices.DeleteFunc(m.DB.deliveries, func(d *delivery) bool {
+		deleted[d.id] = d.webhookID == id
+		return deleted[d.id]
+	})
+	m.DB.attempts = slices.DeleteFunc(m.DB.attempts, func(a *attempt) bool {
+		return deleted[a.DeliveryID]
+	})
+	m.DB.webhooks = slices.DeleteFunc(m.DB.webhooks, func(w *webhook) bool {
+		return w.ID == id
+	})
+	return nil
+}
+
+func (m *WebhookModel) EnqueueContext(ctx context.Context, event string, payload []byte) (int, error) {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+	n := 0
+	for _, w := range m.DB.webhooks {
+		if !w.Active || !w.Subscribes(event) {
+			continue
+		}
+		m.DB.deliveries = append(m.DB.deliveries, &delivery{
+			id:            m.DB.nextID("deliveries"),
+			webhookID:     w.ID,
+			event:         event,
+			payload:       slices.Clone(payload),
+			status:        models.DeliveryPending,
+			nextAttemptAt: now(),
+			created:       now(),
+		})
+		n++
+	}
+	return n, nil
+}
+
+func (m *WebhookModel) ClaimContext(ctx context.Context, limit int, lease time.Duration) ([]*models.WebhookDelivery, error) {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+	t := now()
+	var due []*delivery
+	for _, d := range m.DB.deliveries {
+		if d.status == models.DeliveryPending && !d.nextAttemptAt.After(t) {
+			due = append(due, d)
+		}
+	}
+	slices.SortFunc(due, func(a, b *delivery) int {
+		if c := a.nextAttemptAt.Compare(b.nextAttemptAt); c != 0 {
+			return c
+		}
+		return a.id - b.id
+	})
+
+	var deliveries []*models.WebhookDelivery
+	for _, d := range page(due, limit, 0) {
+		deliveries = append(deliveries, m.DB.deliveryModel(d, false))
+		d.nextAttemptAt = t.Add(lease)
+	}
+	return deliveries, nil
+}
+
+func (m *WebhookModel) RecordAttemptContext(ctx context.Context, a *models.WebhookAttempt, status string, next time.Time) error {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+	rec := &attempt{*a}
+	rec.ID = m.DB.nextID("attempts")
+	rec.Attempted = a.Attempted.UTC()
+	rec.Duration = a.Duration.Truncate(time.Millisecond)
+	m.DB.attempts = append(m.DB.attempts, rec)
+
+	for _, d := range m.DB.deliveries {
+		if d.id == a.DeliveryID {
+			d.attempts++
+			d.status = status
+			d.nextAttemptAt = next.UTC()
+		}
+	}
+	return nil
+}
+
+func (m *WebhookModel) RedeliverContext(ctx context.Context, id int) error {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+	for _, d := range m.DB.deliveries {
+		if d.id == id {
+			d.status = models.DeliveryPending
+			d.attempts = 0
+			d.nextAttemptAt = now()
+			return nil
+		}
+	}
+	return models.ErrNoRecord
+}
+
+func (m *WebhookModel) GetDeliveryContext(ctx context.Context, id int) (*models.WebhookDelivery, error) {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+	for _, d := range m.DB.deliveries {
+		if d.id == id && m.DB.webhook(d.webhookID) != nil {
+			return m.DB.deliveryModel(d, true), nil
+		}
+	}
+	return nil, models.ErrNoRecord
+}
+
+func (m *WebhookModel) DeliveriesContext(ctx context.Context, webhookID, limit int) ([]*models.WebhookDelivery, error) {
+	m.DB.mu.Lock()
+	defer m.DB.mu.Unlock()
+	if m.DB.webhook(webhookID) == nil {
+		return nil, nil
+	}
+	var deliveries []*models.WebhookDelivery
+	for i := len(m.DB.deliveries) - 1; i >= 0; i-- {
+		if d := m.DB.deliveries[i]; d.webhookID == webhookID {
+			deliveries = append(deliveries, m.DB.deliveryModel(d, true))
+		}
+	}
+	return page(deliveries, limit, 0), nil
+}
+
+// deliveryModel returns d with the URL and secret of its webhook, and its
+// log of attempts if withLog is true. db.mu must be held.
+func (db *DB) deliveryModel(d *delivery, withLog bool) *models.WebhookDelivery {
+	w := db.webhook(d.webhookID)
+	md := &models.WebhookDelivery{
+		ID:            d.id,
+		WebhookID:     d.webhookID,
+		URL:           w.URL,
+		Secret:        w.Secret,
+		Event:         d.event,
+		Payload:       slices.Clone(d.payload),
+		Status:        d.status,
+		Attempts:      d.attempts,
+		NextAttemptAt: d.nextAttemptAt,
+		Created:       d.created,
+	}
+	if withLog {
+		for _, a := range db.attempts {
+			if a.DeliveryID == d.id {
+				c := a.WebhookAttempt
+				md.Log = append(md.Log, &c)
+			}
+		}
+	}
+	return md
+}
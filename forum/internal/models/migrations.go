@@ -0,0 +1,469 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"forum/internal/logging"
+)
+
+// migration is a schema change applied once, on top of the tables created by
+// the models' createTable methods.
+//
+// The statements are written for SQLite and adapted like the rest of the
+// schema on PostgreSQL, which runs the postgres statements instead where a
+// migration sets them.
+//
+// A migration rebuilding SQLite tables sets rebuild: it runs with foreign
+// keys unenforced, as SQLite requires, and fails if it leaves a violation.
+//
+// A migration changing data the statements cannot report on sets before,
+// which runs ahead of them in the same transaction, carried by ctx.
+type migration struct {
+	version  int
+	stmts    []string
+	postgres []string
+	rebuild  bool
+	before   func(ctx context.Context, db *DB) error
+}
+
+// migrations lists every schema change in the order it must be applied.
+// Never edit or reorder an entry once it has shipped: append a new one.
+var migrations = []migration{
+	{
+		version: 1,
+		stmts: []string{
+			`ALTER TABLE Threads ADD COLUMN last_post_at DATE`,
+			`ALTER TABLE Threads ADD COLUMN last_post_author_id INTEGER REFERENCES Users`,
+			`ALTER TABLE Threads ADD COLUMN reply_count INTEGER NOT NULL DEFAULT 0`,
+			`
+				UPDATE Threads SET
+				    reply_count = (SELECT COUNT(*) FROM Posts P WHERE P.thread_id = Threads.id),
+				    last_post_at = COALESCE(
+				        (SELECT MAX(P.created) FROM Posts P WHERE P.thread_id = Threads.id),
+				        Threads.created
+				    ),
+				    last_post_author_id = COALESCE(
+				        (SELECT P.author_id FROM Posts P WHERE P.thread_id = Threads.id
+				         ORDER BY P.created DESC, P.id DESC LIMIT 1),
+				        Threads.author_id
+				    )
+			`,
+			`CREATE INDEX IF NOT EXISTS threads_last_post_at ON Threads (last_post_at)`,
+			// The home page looks up the latest post of each thread it lists.
+			`CREATE INDEX IF NOT EXISTS posts_thread ON Posts (thread_id, created)`,
+		},
+	},
+	{
+		version: 2,
+		stmts: []string{
+			`ALTER TABLE Threads ADD COLUMN last_post_id INTEGER NOT NULL DEFAULT 0`,
+			`
+				UPDATE Threads SET last_post_id = COALESCE(
+				    (SELECT MAX(P.id) FROM Posts P WHERE P.thread_id = Threads.id), 0
+				)
+			`,
+			`ALTER TABLE Users ADD COLUMN read_all_post_id INTEGER NOT NULL DEFAULT 0`,
+		},
+	},
+	{
+		// Usernames are looked up regardless of case. The index is not
+		// unique: old databases hold accounts sharing a username, which
+		// are left as they are.
+		version: 3,
+		stmts: []string{
+			`CREATE INDEX IF NOT EXISTS users_username ON Users (username COLLATE NOCASE)`,
+		},
+		postgres: []string{
+			`CREATE INDEX IF NOT EXISTS users_username ON Users (lower(username))`,
+		},
+	},
+	{
+		version: 4,
+		stmts: []string{
+			`ALTER TABLE Users ADD COLUMN created DATE`,
+			`
+				UPDATE Users SET created = COALESCE(
+				    (SELECT MIN(created) FROM (
+				        SELECT created FROM Threads WHERE author_id = Users.id
+				        UNION ALL
+				        SELECT created FROM Posts WHERE author_id = Users.id
+				    )),
+				    CURRENT_TIMESTAMP
+				)
+			`,
+			`ALTER TABLE Users ADD COLUMN bio TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE Users ADD COLUMN location TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE Users ADD COLUMN website TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE Users ADD COLUMN hide_activity INTEGER NOT NULL DEFAULT 0`,
+			`CREATE INDEX IF NOT EXISTS threads_author ON Threads (author_id, created)`,
+			`CREATE INDEX IF NOT EXISTS posts_author ON Posts (author_id, created)`,
+		},
+		postgres: []string{
+			`ALTER TABLE Users ADD COLUMN created TIMESTAMPTZ`,
+			`
+				UPDATE Users SET created = COALESCE(
+				    (SELECT MIN(created) FROM (
+				        SELECT created FROM Threads WHERE author_id = Users.id
+				        UNION ALL
+				        SELECT created FROM Posts WHERE author_id = Users.id
+				    ) AS A),
+				    CURRENT_TIMESTAMP
+				)
+			`,
+			`ALTER TABLE Users ADD COLUMN bio TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE Users ADD COLUMN location TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE Users ADD COLUMN website TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE Users ADD COLUMN hide_activity BOOLEAN NOT NULL DEFAULT FALSE`,
+			`CREATE INDEX IF NOT EXISTS threads_author ON Threads (author_id, created)`,
+			`CREATE INDEX IF NOT EXISTS posts_author ON Posts (author_id, created)`,
+		},
+	},
+	{
+		version: 5,
+		stmts: []string{
+			`ALTER TABLE Users ADD COLUMN avatar_version INTEGER NOT NULL DEFAULT 0`,
+		},
+	},
+	{
+		version: 6,
+		stmts: []string{
+			`ALTER TABLE Users ADD COLUMN role TEXT NOT NULL DEFAULT 'member'`,
+		},
+	},
+	{
+		// Threads and Posts of old databases reference a Users_old table
+		// that no longer exists, which fails every insert once foreign
+		// keys are enforced. They are rebuilt without the rows that never
+		// had a valid author or thread, which could not be shown anyway
+		// and are kept aside in tables of their own.
+		version: 7,
+		rebuild: true,
+		before:  quarantineOrphans,
+		stmts: []string{
+			`
+				UPDATE Threads SET
+				    reply_count = (SELECT COUNT(*) FROM Posts P WHERE P.thread_id = Threads.id),
+				    last_post_at = COALESCE(
+				        (SELECT MAX(P.created) FROM Posts P WHERE P.thread_id = Threads.id),
+				        Threads.created
+				    ),
+				    last_post_author_id = COALESCE(
+				        (SELECT P.author_id FROM Posts P WHERE P.thread_id = Threads.id
+				         ORDER BY P.created DESC, P.id DESC LIMIT 1),
+				        Threads.author_id
+				    ),
+				    last_post_id = COALESCE(
+				        (SELECT MAX(P.id) FROM Posts P WHERE P.thread_id = Threads.id), 0
+				    )
+			`,
+			`
+				CREATE TABLE Threads_new (
+				    id INTEGER PRIMARY KEY,
+				    title TEXT NOT NULL,
+				    author_id INTEGER NOT NULL REFERENCES Users,
+				    created DATE NOT NULL,
+				    last_post_at DATE,
+				    last_post_author_id INTEGER REFERENCES Users,
+				    reply_count INTEGER NOT NULL DEFAULT 0,
+				    last_post_id INTEGER NOT NULL DEFAULT 0
+				)
+			`,
+			`
+				INSERT INTO Threads_new (id, title, author_id, created, last_post_at,
+				                         last_post_author_id, reply_count, last_post_id)
+				SELECT id, title, author_id, created, last_post_at,
+				       last_post_author_id, reply_count, last_post_id
+				FROM Threads
+			`,
+			`DROP TABLE Threads`,
+			`ALTER TABLE Threads_new RENAME TO Threads`,
+			`CREATE INDEX threads_last_post_at ON Threads (last_post_at)`,
+			`CREATE INDEX threads_author ON Threads (author_id, created)`,
+			`
+				CREATE TABLE Posts_new (
+				    id INTEGER PRIMARY KEY,
+				    body TEXT NOT NULL,
+				    author_id INTEGER NOT NULL REFERENCES Users,
+				    thread_id INTEGER NOT NULL REFERENCES Threads,
+				    created DATE NOT NULL
+				)
+			`,
+			`
+				INSERT INTO Posts_new (id, body, author_id, thread_id, created)
+				SELECT id, body, author_id, thread_id, created FROM Posts
+			`,
+			`DROP TABLE Posts`,
+			`ALTER TABLE Posts_new RENAME TO Posts`,
+			`CREATE INDEX posts_author ON Posts (author_id, created)`,
+			`CREATE INDEX posts_thread ON Posts (thread_id, created)`,
+		},
+		postgres: []string{},
+	},
+	{
+		version: 8,
+		stmts: []string{
+			`ALTER TABLE Users ADD COLUMN disabled INTEGER NOT NULL DEFAULT 0`,
+			`ALTER TABLE Threads ADD COLUMN locked INTEGER NOT NULL DEFAULT 0`,
+		},
+		postgres: []string{
+			`ALTER TABLE Users ADD COLUMN disabled BOOLEAN NOT NULL DEFAULT FALSE`,
+			`ALTER TABLE Threads ADD COLUMN locked BOOLEAN NOT NULL DEFAULT FALSE`,
+		},
+	},
+	{
+		// Threads without replies are unread until viewed, except those
+		// that already existed: they were never flagged before.
+		version: 9,
+		stmts: []string{
+			`ALTER TABLE Users ADD COLUMN read_all_thread_id INTEGER NOT NULL DEFAULT 0`,
+			`UPDATE Users SET read_all_thread_id = (SELECT COALESCE(MAX(id), 0) FROM Threads)`,
+		},
+	},
+	{
+		// Posts are searched through a full-text index of their body, kept
+		// up to date by triggers on SQLite.
+		version: 10,
+		stmts: []string{
+			`CREATE VIRTUAL TABLE IF NOT EXISTS PostsSearch USING fts4(content="Posts", body, tokenize=unicode61 "remove_diacritics=0")`,
+			`
+				CREATE TRIGGER IF NOT EXISTS posts_search_insert AFTER INSERT ON Posts BEGIN
+				    INSERT INTO PostsSearch (docid, body) VALUES (new.id, new.body);
+				END
+			`,
+			`
+				CREATE TRIGGER IF NOT EXISTS posts_search_update_before BEFORE UPDATE OF body ON Posts BEGIN
+				    DELETE FROM PostsSearch WHERE docid = old.id;
+				END
+			`,
+			`
+				CREATE TRIGGER IF NOT EXISTS posts_search_update_after AFTER UPDATE OF body ON Posts BEGIN
+				    INSERT INTO PostsSearch (docid, body) VALUES (new.id, new.body);
+				END
+			`,
+			`
+				CREATE TRIGGER IF NOT EXISTS posts_search_delete BEFORE DELETE ON Posts BEGIN
+				    DELETE FROM PostsSearch WHERE docid = old.id;
+				END
+			`,
+			`INSERT INTO PostsSearch (PostsSearch) VALUES ('rebuild')`,
+		},
+		postgres: []string{
+			`CREATE INDEX IF NOT EXISTS posts_search ON Posts USING GIN (to_tsvector('simple', body))`,
+		},
+	},
+}
+
+// quarantineOrphans moves the threads without an author and the posts
+// without an author or a thread, along with their attachments, to tables of
+// their own, OrphanThreads, OrphanPosts and OrphanAttachments, which keep the
+// columns but none of the constraints of the original tables. Nothing is lost:
+// the administrator can look into them, restore what belongs somewhere and
+// drop them once done. The read marks of the orphan threads are deleted, and
+// the files of the attachments are left in the blob store. What was moved is
+// logged. PostgreSQL databases always enforced their foreign keys, so they
+// have no orphans.
+func quarantineOrphans(ctx context.Context, db *DB) error {
+	if db.Dialect != SQLite {
+		return nil
+	}
+
+	orphanThreads := `SELECT id FROM Threads WHERE author_id NOT IN (SELECT id FROM Users)`
+	orphanPosts := `
+		SELECT id FROM Posts
+		WHERE author_id NOT IN (SELECT id FROM Users)
+		   OR thread_id NOT IN (SELECT id FROM Threads WHERE author_id IN (SELECT id FROM Users))
+	`
+	threads, err := db.ids(ctx, orphanThreads)
+	if err != nil {
+		return fmt.Errorf("listing threads without an author: %w", err)
+	}
+	posts, err := db.ids(ctx, orphanPosts)
+	if err != nil {
+		return fmt.Errorf("listing posts without an author or thread: %w", err)
+	}
+	if len(threads) == 0 && len(posts) == 0 {
+		return nil
+	}
+
+	// The tables of the read marks and attachments only exist once their
+	// models have created them.
+	var keys []string
+	exists, err := db.hasTable(ctx, "Attachments")
+	if err != nil {
+		return err
+	}
+	if exists {
+		rows, err := db.QueryContext(ctx, `SELECT storage_key FROM Attachments WHERE post_id IN (`+orphanPosts+`)`)
+		if err != nil {
+			return fmt.Errorf("listing attachments: %w", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var key string
+			err := rows.Scan(&key)
+			if err != nil {
+				return fmt.Errorf("scanning attachment: %w", err)
+			}
+			keys = append(keys, key)
+		}
+		if err = rows.Err(); err != nil {
+			return fmt.Errorf("iterating over attachments: %w", err)
+		}
+		rows.Close()
+
+		err = db.quarantine(ctx, "Attachments", `post_id IN (`+orphanPosts+`)`)
+		if err != nil {
+			return err
+		}
+	}
+	exists, err = db.hasTable(ctx, "ThreadReads")
+	if err != nil {
+		return err
+	}
+	if exists {
+		_, err = db.ExecContext(ctx, `DELETE FROM ThreadReads WHERE thread_id IN (`+orphanThreads+`)`)
+		if err != nil {
+			return fmt.Errorf("deleting read marks: %w", err)
+		}
+	}
+
+	// Posts go first: which ones are orphans depends on the threads.
+	err = db.quarantine(ctx, "Posts", `id IN (`+orphanPosts+`)`)
+	if err != nil {
+		return err
+	}
+	err = db.quarantine(ctx, "Threads", `id IN (`+orphanThreads+`)`)
+	if err != nil {
+		return err
+	}
+
+	logging.FromContext(ctx).Warn("Moved threads and posts without an author or thread to the Orphan tables",
+		"threads", threads, "posts", posts, "attachments", keys)
+	return nil
+}
+
+// quarantine moves the rows of table matching where to the table named after
+// it with an Orphan prefix, creating it as needed.
+func (db *DB) quarantine(ctx context.Context, table, where string) error {
+	orphans := "Orphan" + table
+	_, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+orphans+` AS SELECT * FROM `+table+` WHERE 0`)
+	if err != nil {
+		return fmt.Errorf("creating %s: %w", orphans, err)
+	}
+	_, err = db.ExecContext(ctx, `INSERT INTO `+orphans+` SELECT * FROM `+table+` WHERE `+where)
+	if err != nil {
+		return fmt.Errorf("copying to %s: %w", orphans, err)
+	}
+	_, err = db.ExecContext(ctx, `DELETE FROM `+table+` WHERE `+where)
+	if err != nil {
+		return fmt.Errorf("deleting from %s: %w", table, err)
+	}
+	return nil
+}
+
+// SchemaVersion returns the latest schema version known to this binary.
+func SchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+// migrate applies every migration newer than the version recorded in the
+// database, each one in its own transaction.
+func migrate(db *DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS SchemaVersion (version INTEGER NOT NULL)`)
+	if err != nil {
+		return fmt.Errorf("creating SchemaVersion table: %w", err)
+	}
+
+	current, err := db.Version(context.Background())
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		err := applyMigration(db, m)
+		if err != nil {
+			return fmt.Errorf("applying migration %d: %w", m.version, err)
+		}
+	}
+	return nil
+}
+
+// applyMigration runs the statements of m and records its version atomically.
+func applyMigration(db *DB, m migration) error {
+	var stmts []string
+	if db.Dialect == Postgres && m.postgres != nil {
+		stmts = m.postgres
+	} else {
+		for _, stmt := range m.stmts {
+			stmts = append(stmts, db.Dialect.schema(stmt))
+		}
+	}
+
+	// The pragmas and the transaction must run on the same connection.
+	ctx := context.Background()
+	conn, err := db.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	rebuild := m.rebuild && db.Dialect == SQLite
+	if rebuild {
+		var enforced bool
+		err := conn.QueryRowContext(ctx, `PRAGMA foreign_keys`).Scan(&enforced)
+		if err != nil {
+			return err
+		}
+		if enforced {
+			_, err = conn.ExecContext(ctx, `PRAGMA foreign_keys = OFF`)
+			if err != nil {
+				return err
+			}
+			defer conn.ExecContext(ctx, `PRAGMA foreign_keys = ON`)
+		}
+	}
+
+	sqlTx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	tx := &Tx{Tx: sqlTx, Dialect: db.Dialect, db: db, hooks: new([]func())}
+	defer tx.Rollback()
+
+	if m.before != nil {
+		err := m.before(context.WithValue(ctx, txKey{}, tx), db)
+		if err != nil {
+			return err
+		}
+	}
+	for _, stmt := range stmts {
+		_, err := tx.Exec(stmt)
+		if err != nil {
+			return err
+		}
+	}
+	if rebuild {
+		var (
+			table, parent string
+			rowid         sql.NullInt64
+			fkid          int
+		)
+		err := tx.QueryRow(`PRAGMA foreign_key_check`).Scan(&table, &rowid, &parent, &fkid)
+		if err == nil {
+			return fmt.Errorf("row %d of %s references a missing row of %s", rowid.Int64, table, parent)
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("checking foreign keys: %w", err)
+		}
+	}
+	_, err = tx.Exec(`INSERT INTO SchemaVersion (version) VALUES (?)`, m.version)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
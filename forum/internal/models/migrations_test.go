@@ -0,0 +1,272 @@
+package models_test
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"forum/internal/models"
+	"forum/internal/testutil"
+)
+
+// firstSchema is the schema of a SQLite database created by the first
+// release, before any migration.
+var firstSchema = []string{
+	`CREATE TABLE Users (
+	    id INTEGER PRIMARY KEY,
+	    username TEXT NOT NULL,
+	    email TEXT NOT NULL UNIQUE,
+	    hashed_password TEXT NOT NULL
+	)`,
+	`CREATE TABLE Threads (
+	    id INTEGER PRIMARY KEY,
+	    title TEXT NOT NULL,
+	    author_id INTEGER NOT NULL REFERENCES Users,
+	    created DATE NOT NULL
+	)`,
+	`CREATE TABLE Posts (
+	    id INTEGER PRIMARY KEY,
+	    body TEXT NOT NULL,
+	    author_id INTEGER NOT NULL REFERENCES Users,
+	    thread_id INTEGER NOT NULL REFERENCES Threads,
+	    created DATE NOT NULL
+	)`,
+}
+
+// openOld creates a SQLite database from stmts, then opens it with the
+// models, which migrate it.
+func openOld(t *testing.T, stmts []string) (*models.DB, *stores) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.sqlite")
+	old, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, stmt := range stmts {
+		_, err := old.Exec(stmt)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	old.Close()
+
+	db, err := models.Open(path, sqliteOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db, sqlStores(t, db)
+}
+
+// TestMigrateActivity upgrades a database of the first release, whose
+// threads have no activity recorded yet.
+func TestMigrateActivity(t *testing.T) {
+	_, s := openOld(t, append(firstSchema,
+		`INSERT INTO Users (id, username, email, hashed_password)
+		 VALUES (1, 'alice', 'alice@example.com', ''), (2, 'bob', 'bob@example.com', '')`,
+		`INSERT INTO Threads (id, title, author_id, created)
+		 VALUES (1, 'Quiet', 1, '2024-01-01 00:00:00'), (2, 'Busy', 1, '2024-01-01 00:00:00')`,
+		`INSERT INTO Posts (id, body, author_id, thread_id, created)
+		 VALUES (1, 'First', 2, 2, '2024-01-02 00:00:00'), (2, 'Second', 1, 2, '2024-01-03 00:00:00')`,
+	))
+
+	ctx := context.Background()
+	for _, tt := range []struct {
+		id         int
+		replies    int
+		lastPostID int
+		lastPoster string
+		lastPostAt time.Time
+	}{
+		{1, 0, 0, "alice", time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{2, 2, 2, "alice", time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC)},
+	} {
+		thread, err := s.threads.GetContext(ctx, tt.id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.Equal(t, thread.ReplyCount, tt.replies)
+		testutil.Equal(t, thread.LastPostID, tt.lastPostID)
+		testutil.Equal(t, thread.LastPoster.Username, tt.lastPoster)
+		if !thread.LastPostAt.Equal(tt.lastPostAt) {
+			t.Errorf("thread %d: last post at %v; want %v", tt.id, thread.LastPostAt, tt.lastPostAt)
+		}
+	}
+
+	latests, err := s.threads.LatestsContext(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(latests) != 2 || latests[0].ID != 2 {
+		t.Errorf("latest threads start with %v; want the busy thread", latests)
+	}
+
+	// The existing posts are indexed for searches.
+	results, err := s.posts.SearchContext(ctx, "second", 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Post.ID != 2 {
+		t.Errorf("search found %v; want post 2", results)
+	}
+}
+
+// TestMigrateSharedUsernames upgrades a database of the first release, whose
+// accounts could share a username, which they keep.
+func TestMigrateSharedUsernames(t *testing.T) {
+	db, s := openOld(t, append(firstSchema,
+		`INSERT INTO Users (id, username, email, hashed_password)
+		 VALUES (13, 'fang4', 'a@example.com', ''), (20, 'Fang4', 'b@example.com', ''),
+		        (23, 'fang4', 'c@example.com', '')`,
+	))
+
+	var renamed int
+	err := db.QueryRow(`SELECT COUNT(*) FROM Users WHERE lower(username) <> 'fang4'`).Scan(&renamed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.Equal(t, renamed, 0)
+
+	// The account spelled exactly as asked comes first, then the oldest.
+	ctx := context.Background()
+	for name, want := range map[string]int{"fang4": 13, "Fang4": 20, "FANG4": 13} {
+		u, err := s.users.GetByUsernameContext(ctx, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.Equal(t, u.ID, want)
+		p, err := s.users.GetProfileContext(ctx, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.Equal(t, p.ID, want)
+	}
+
+	// New accounts cannot share a username.
+	_, err = s.users.InsertContext(ctx, "FANG4", "d@example.com", "Passw0rd!")
+	isErr(t, err, models.ErrDuplicateUsername)
+}
+
+// version6Schema is the schema of a SQLite database at version 6, whose
+// Threads and Posts tables still reference the Users_old table of the first
+// releases.
+var version6Schema = []string{
+	`CREATE TABLE Users (
+	    id INTEGER PRIMARY KEY,
+	    username TEXT NOT NULL,
+	    email TEXT NOT NULL UNIQUE,
+	    hashed_password TEXT NOT NULL,
+	    read_all_post_id INTEGER NOT NULL DEFAULT 0,
+	    created DATE,
+	    bio TEXT NOT NULL DEFAULT '',
+	    location TEXT NOT NULL DEFAULT '',
+	    website TEXT NOT NULL DEFAULT '',
+	    hide_activity INTEGER NOT NULL DEFAULT 0,
+	    avatar_version INTEGER NOT NULL DEFAULT 0,
+	    role TEXT NOT NULL DEFAULT 'member'
+	)`,
+	`CREATE INDEX users_username ON Users (username COLLATE NOCASE)`,
+	`CREATE TABLE Threads (
+	    id INTEGER PRIMARY KEY,
+	    title TEXT NOT NULL,
+	    author_id INTEGER NOT NULL REFERENCES "Users_old",
+	    created DATE NOT NULL,
+	    last_post_at DATE,
+	    last_post_author_id INTEGER REFERENCES Users,
+	    reply_count INTEGER NOT NULL DEFAULT 0,
+	    last_post_id INTEGER NOT NULL DEFAULT 0
+	)`,
+	`CREATE INDEX threads_last_post_at ON Threads (last_post_at)`,
+	`CREATE INDEX threads_author ON Threads (author_id, created)`,
+	`CREATE TABLE Posts (
+	    id INTEGER PRIMARY KEY,
+	    body TEXT NOT NULL,
+	    author_id INTEGER NOT NULL REFERENCES "Users_old",
+	    thread_id INTEGER NOT NULL REFERENCES Threads,
+	    created DATE NOT NULL
+	)`,
+	`CREATE INDEX posts_author ON Posts (author_id, created)`,
+	`CREATE TABLE ThreadReads (
+	    user_id INTEGER NOT NULL REFERENCES Users,
+	    thread_id INTEGER NOT NULL REFERENCES Threads,
+	    last_read_post_id INTEGER NOT NULL,
+	    PRIMARY KEY (user_id, thread_id)
+	) WITHOUT ROWID`,
+	`CREATE TABLE Attachments (
+	    id INTEGER PRIMARY KEY,
+	    post_id INTEGER NOT NULL REFERENCES Posts,
+	    user_id INTEGER NOT NULL REFERENCES Users,
+	    filename TEXT NOT NULL,
+	    content_type TEXT NOT NULL,
+	    size INTEGER NOT NULL,
+	    storage_key TEXT NOT NULL UNIQUE,
+	    created DATE NOT NULL
+	)`,
+	`CREATE TABLE SchemaVersion (version INTEGER NOT NULL)`,
+	`INSERT INTO SchemaVersion (version) VALUES (1), (2), (3), (4), (5), (6)`,
+}
+
+// TestMigrateOrphans upgrades a version 6 database holding threads and posts
+// whose author or thread is gone, with read marks and attachments on them.
+func TestMigrateOrphans(t *testing.T) {
+	db, s := openOld(t, append(version6Schema,
+		`INSERT INTO Users (id, username, email, hashed_password, created)
+		 VALUES (1, 'alice', 'alice@example.com', '', '2024-01-01 00:00:00')`,
+		// Thread 1 is kept, without the post of the deleted user 9. Thread
+		// 2 and its post go with their author, to the Orphan tables.
+		`INSERT INTO Threads (id, title, author_id, created, last_post_at, last_post_author_id, reply_count, last_post_id)
+		 VALUES (1, 'Kept', 1, '2024-01-01 00:00:00', '2024-01-03 00:00:00', 9, 2, 2),
+		        (2, 'Gone', 9, '2024-01-01 00:00:00', '2024-01-04 00:00:00', 1, 1, 3)`,
+		`INSERT INTO Posts (id, body, author_id, thread_id, created)
+		 VALUES (1, 'Kept', 1, 1, '2024-01-02 00:00:00'),
+		        (2, 'Gone', 9, 1, '2024-01-03 00:00:00'),
+		        (3, 'Gone', 1, 2, '2024-01-04 00:00:00')`,
+		`INSERT INTO ThreadReads (user_id, thread_id, last_read_post_id) VALUES (1, 1, 2), (1, 2, 3)`,
+		`INSERT INTO Attachments (post_id, user_id, filename, content_type, size, storage_key, created)
+		 VALUES (1, 1, 'kept.txt', 'text/plain', 1, 'kept', '2024-01-02 00:00:00'),
+		        (3, 1, 'gone.txt', 'text/plain', 1, 'gone', '2024-01-04 00:00:00')`,
+	))
+
+	ctx := context.Background()
+	thread, err := s.threads.GetContext(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.Equal(t, thread.ReplyCount, 1)
+	testutil.Equal(t, thread.LastPostID, 1)
+	testutil.Equal(t, thread.LastPoster.Username, "alice")
+	_, err = s.threads.GetContext(ctx, 2)
+	isErr(t, err, models.ErrNoRecord)
+
+	for _, tt := range []struct {
+		query string
+		want  int
+	}{
+		{`SELECT COUNT(*) FROM Posts`, 1},
+		{`SELECT COUNT(*) FROM ThreadReads WHERE thread_id = 1`, 1},
+		{`SELECT COUNT(*) FROM ThreadReads`, 1},
+		{`SELECT COUNT(*) FROM Attachments WHERE storage_key = 'kept'`, 1},
+		{`SELECT COUNT(*) FROM Attachments`, 1},
+		{`SELECT COUNT(*) FROM OrphanThreads WHERE id = 2 AND title = 'Gone'`, 1},
+		{`SELECT COUNT(*) FROM OrphanThreads`, 1},
+		{`SELECT COUNT(*) FROM OrphanPosts WHERE id IN (2, 3) AND body = 'Gone'`, 2},
+		{`SELECT COUNT(*) FROM OrphanPosts`, 2},
+		{`SELECT COUNT(*) FROM OrphanAttachments WHERE post_id = 3 AND storage_key = 'gone'`, 1},
+		{`SELECT COUNT(*) FROM OrphanAttachments`, 1},
+	} {
+		var got int
+		err := db.QueryRow(tt.query).Scan(&got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %d; want %d", tt.query, got, tt.want)
+		}
+	}
+
+	// The rebuilt tables reference Users, so posting works with foreign
+	// keys enforced.
+	s.addPost(t, "New", 1, 1)
+}
@@ -0,0 +1,1080 @@
+package models_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"forum/internal/models"
+	"forum/internal/testutil"
+)
+
+// isErr fails the test unless err is target.
+func isErr(t *testing.T, err, target error) {
+	t.Helper()
+	if !errors.Is(err, target) {
+		t.Errorf("got error %v; want %v", err, target)
+	}
+}
+
+// addUser creates a user named username, with the password Passw0rd!.
+func (s *stores) addUser(t *testing.T, username string) int {
+	t.Helper()
+	ctx := context.Background()
+	id, err := s.users.InsertContext(ctx, username, username+"@example.com", "Passw0rd!")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func (s *stores) addThread(t *testing.T, title string, authorID int) int {
+	t.Helper()
+	ctx := context.Background()
+	id, err := s.threads.InsertContext(ctx, title, authorID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func (s *stores) addPost(t *testing.T, body string, threadID, authorID int) int {
+	t.Helper()
+	ctx := context.Background()
+	id, err := s.posts.InsertContext(ctx, body, threadID, authorID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestRebind(t *testing.T) {
+	stmt := `SELECT id FROM Users WHERE email = ? AND id IN (?, ?)`
+	testutil.Equal(t, models.SQLite.Rebind(stmt), stmt)
+	testutil.Equal(t, models.Postgres.Rebind(stmt), `SELECT id FROM Users WHERE email = $1 AND id IN ($2, $3)`)
+
+	stmt = `SELECT 'what?', 'it''s ?', "odd?name" FROM Users WHERE bio = ?`
+	testutil.Equal(t, models.Postgres.Rebind(stmt), `SELECT 'what?', 'it''s ?', "odd?name" FROM Users WHERE bio = $1`)
+}
+
+// TestPostgresDialectOnSQLite runs queries rewritten for PostgreSQL on
+// SQLite, which also accepts their numbered placeholders, RETURNING clauses
+// and lower() lookups, so that they are checked without a PostgreSQL
+// server. The postgres backend runs every model test in CI.
+func TestPostgresDialectOnSQLite(t *testing.T) {
+	db, err := models.Open(filepath.Join(t.TempDir(), "test.sqlite"), sqliteOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	sqlStores(t, db)
+
+	pg := models.WithDialect(db, models.Postgres)
+	users := &models.UserModel{DB: pg}
+	threads := &models.ThreadModel{DB: pg}
+	posts := &models.PostModel{DB: pg}
+	reads := &models.ReadModel{DB: pg}
+	ctx := context.Background()
+
+	alice, err := users.InsertContext(ctx, "Alice", "alice@example.com", "Passw0rd!")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := users.InsertContext(ctx, "bob", "bob@example.com", "Passw0rd!")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.Equal(t, bob, alice+1)
+	_, err = users.InsertContext(ctx, "ALICE", "other@example.com", "Passw0rd!")
+	isErr(t, err, models.ErrDuplicateUsername)
+	u, err := users.GetByUsernameContext(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.Equal(t, u.ID, alice)
+
+	thread, err := threads.InsertContext(ctx, "Hello", alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	post, err := posts.InsertContext(ctx, "Welcome", thread, bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := threads.GetContext(ctx, thread)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.Equal(t, got.LastPostID, post)
+	testutil.Equal(t, got.ReplyCount, 1)
+
+	// The second mark is an upsert of the first.
+	for range 2 {
+		err = reads.MarkReadContext(ctx, alice, thread, post)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestUsers(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s *stores) {
+		ctx := context.Background()
+		id := s.addUser(t, "Alice")
+		_, err := s.users.InsertContext(ctx, "bob", "Alice@example.com", "Passw0rd!")
+		isErr(t, err, models.ErrDuplicateEmail)
+		_, err = s.users.InsertContext(ctx, "ALICE", "bob@example.com", "Passw0rd!")
+		isErr(t, err, models.ErrDuplicateUsername)
+
+		u, err := s.users.GetContext(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.Equal(t, u.Username, "Alice")
+		testutil.Equal(t, u.Email, "Alice@example.com")
+		testutil.Equal(t, u.Role, "member")
+		_, err = s.users.GetContext(ctx, id+1)
+		isErr(t, err, models.ErrNoRecord)
+
+		// Usernames are matched regardless of case.
+		u, err = s.users.GetByUsernameContext(ctx, "ALICE")
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.Equal(t, u.ID, id)
+		_, err = s.users.GetByUsernameContext(ctx, "bob")
+		isErr(t, err, models.ErrNoRecord)
+		for name, want := range map[string]bool{"alice": true, "bob": false} {
+			exists, err := s.users.UsernameExistsContext(ctx, name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			testutil.Equal(t, exists, want)
+		}
+		for email, want := range map[string]bool{"Alice@example.com": true, "bob@example.com": false} {
+			exists, err := s.users.ExistsContext(ctx, email)
+			if err != nil {
+				t.Fatal(err)
+			}
+			testutil.Equal(t, exists, want)
+		}
+
+		got, err := s.users.AuthenticateContext(ctx, "Alice@example.com", "Passw0rd!")
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.Equal(t, got, id)
+		_, err = s.users.AuthenticateContext(ctx, "Alice@example.com", "wrong")
+		isErr(t, err, models.ErrInvalidCredentials)
+		_, err = s.users.AuthenticateContext(ctx, "bob@example.com", "Passw0rd!")
+		isErr(t, err, models.ErrInvalidCredentials)
+
+		err = s.users.SetAvatarVersionContext(ctx, id, 0, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = s.users.SetAvatarVersionContext(ctx, id, 0, 4)
+		isErr(t, err, models.ErrNoRecord)
+		profile, err := s.users.GetProfileByIDContext(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.Equal(t, profile.AvatarVersion, 3)
+		role, err := s.users.RoleContext(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.Equal(t, role, "member")
+	})
+}
+
+func TestProfiles(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s *stores) {
+		ctx := context.Background()
+		id := s.addUser(t, "alice")
+		threadID := s.addThread(t, "Hello", id)
+		s.addPost(t, "First reply", threadID, id)
+
+		err := s.users.UpdateProfileContext(ctx, id, "Bio", "Paris", "https://example.com", true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		isErr(t, s.users.UpdateProfileContext(ctx, id+1, "", "", "", false), models.ErrNoRecord)
+
+		p, err := s.users.GetProfileContext(ctx, "Alice")
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.Equal(t, p.ID, id)
+		testutil.Equal(t, p.Bio, "Bio")
+		testutil.Equal(t, p.Location, "Paris")
+		testutil.Equal(t, p.Website, "https://example.com")
+		testutil.Equal(t, p.HideActivity, true)
+		testutil.Equal(t, p.ThreadCount, 1)
+		testutil.Equal(t, p.PostCount, 1)
+		if p.Joined.IsZero() {
+			t.Error("profile has no join date")
+		}
+		p, err = s.users.GetProfileByIDContext(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.Equal(t, p.Username, "alice")
+		_, err = s.users.GetProfileContext(ctx, "bob")
+		isErr(t, err, models.ErrNoRecord)
+
+		activity, err := s.users.ActivityContext(ctx, id, 10, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		kinds := map[string]int{}
+		for _, a := range activity {
+			kinds[a.Kind]++
+			testutil.Equal(t, a.ThreadID, threadID)
+			testutil.Equal(t, a.ThreadTitle, "Hello")
+		}
+		testutil.Equal(t, len(activity), 2)
+		testutil.Equal(t, kinds["thread"], 1)
+		testutil.Equal(t, kinds["post"], 1)
+
+		activity, err = s.users.ActivityContext(ctx, id, 10, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.Equal(t, len(activity), 1)
+	})
+}
+
+func TestThreadsAndPosts(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s *stores) {
+		ctx := context.Background()
+		alice := s.addUser(t, "alice")
+		bob := s.addUser(t, "bob")
+		first := s.addThread(t, "First", alice)
+		second := s.addThread(t, "Second", alice)
+		p1 := s.addPost(t, "One", first, bob)
+		p2 := s.addPost(t, "Two", first, alice)
+		_, err := s.posts.InsertContext(ctx, "Lost", second+1, bob)
+		isErr(t, err, models.ErrNoRecord)
+
+		thread, err := s.threads.GetContext(ctx, first)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.Equal(t, thread.Title, "First")
+		testutil.Equal(t, thread.Author.Username, "alice")
+		testutil.Equal(t, thread.ReplyCount, 2)
+		testutil.Equal(t, thread.LastPostID, p2)
+		testutil.Equal(t, thread.LastPoster.Username, "alice")
+		if len(thread.Posts) != 2 {
+			t.Fatalf("got %d posts; want 2", len(thread.Posts))
+		}
+		testutil.Equal(t, thread.Posts[0].ID, p1)
+		testutil.Equal(t, thread.Posts[0].Author.Username, "bob")
+		testutil.Equal(t, thread.Posts[1].ID, p2)
+
+		thread, err = s.threads.GetContext(ctx, second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.Equal(t, thread.ReplyCount, 0)
+		testutil.Equal(t, thread.LastPoster.Username, "alice")
+		_, err = s.threads.GetContext(ctx, second+1)
+		isErr(t, err, models.ErrNoRecord)
+
+		for id, want := range map[int]bool{first: true, second + 1: false} {
+			exists, err := s.threads.ExistsContext(ctx, id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			testutil.Equal(t, exists, want)
+		}
+
+		newest, err := s.threads.NewestContext(ctx, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(newest) != 1 || newest[0].ID != second {
+			t.Errorf("Newest(1) = %v; want thread %d", newest, second)
+		}
+		latests, err := s.threads.LatestsContext(ctx, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.Equal(t, len(latests), 2)
+		for _, l := range latests {
+			switch l.ID {
+			case first:
+				testutil.Equal(t, len(l.Posts), 1)
+				testutil.Equal(t, l.Posts[0].ID, p2)
+				testutil.Equal(t, l.Posts[0].Author.Username, "alice")
+			case second:
+				testutil.Equal(t, len(l.Posts), 0)
+			}
+		}
+
+		for _, tt := range []struct {
+			afterID, limit int
+			want           []int
+		}{
+			{0, 10, []int{p1, p2}},
+			{p1, 10, []int{p2}},
+			{0, 1, []int{p1}},
+			{p2, 10, nil},
+		} {
+			posts, err := s.posts.SinceContext(ctx, first, tt.afterID, tt.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ids []int
+			for _, p := range posts {
+				ids = append(ids, p.ID)
+			}
+			if len(ids) != len(tt.want) || (len(ids) > 0 && (ids[0] != tt.want[0] || ids[len(ids)-1] != tt.want[len(tt.want)-1])) {
+				t.Errorf("Since(%d, %d) = %v; want %v", tt.afterID, tt.limit, ids, tt.want)
+			}
+		}
+	})
+}
+
+func TestSearchPosts(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s *stores) {
+		ctx := context.Background()
+		alice := s.addUser(t, "alice")
+		bob := s.addUser(t, "bob")
+		tips := s.addThread(t, "Tips", alice)
+		news := s.addThread(t, "News", bob)
+		first := s.addPost(t, "Sourdough needs a warm kitchen.", tips, alice)
+		second := s.addPost(t, "A warm oven helps the SOURDOUGH rise", news, bob)
+		s.addPost(t, "Cold kitchens are fine for pizza dough", tips, bob)
+
+		search := func(query string, limit, offset int) []int {
+			t.Helper()
+			results, err := s.posts.SearchContext(ctx, query, limit, offset)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ids []int
+			for _, r := range results {
+				ids = append(ids, r.Post.ID)
+			}
+			return ids
+		}
+		equalIDs := func(got, want []int) {
+			t.Helper()
+			testutil.Equal(t, fmt.Sprint(got), fmt.Sprint(want))
+		}
+
+		// Every word must match, regardless of case, as a whole word.
+		equalIDs(search("sourdough", 10, 0), []int{second, first})
+		equalIDs(search("Warm  sourdough!", 10, 0), []int{second, first})
+		equalIDs(search("warm kitchen", 10, 0), []int{first})
+		equalIDs(search("dough", 10, 0), []int{second + 1})
+		equalIDs(search("sour", 10, 0), nil)
+		equalIDs(search("sourdough", 1, 1), []int{first})
+
+		// The query syntax of the database is not exposed.
+		equalIDs(search(`warm OR pizza`, 10, 0), nil)
+		equalIDs(search(`"sourdough`, 10, 0), []int{second, first})
+		equalIDs(search("sourdough NOT warm", 10, 0), nil)
+		equalIDs(search(`*`, 10, 0), nil)
+		equalIDs(search("", 10, 0), nil)
+
+		results, err := s.posts.SearchContext(ctx, "oven", 10, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.Equal(t, len(results), 1)
+		testutil.Equal(t, results[0].ThreadID, news)
+		testutil.Equal(t, results[0].ThreadTitle, "News")
+		testutil.Equal(t, results[0].Post.Body, "A warm oven helps the SOURDOUGH rise")
+		testutil.Equal(t, results[0].Post.Author.Username, "bob")
+
+		// Deleted posts leave the index.
+		posts, ok := s.posts.(*models.PostModel)
+		if !ok {
+			return
+		}
+		_, _, err = posts.PurgeByUserContext(ctx, bob)
+		if err != nil {
+			t.Fatal(err)
+		}
+		equalIDs(search("sourdough", 10, 0), []int{first})
+	})
+}
+
+func TestSearchWords(t *testing.T) {
+	testutil.Equal(t, fmt.Sprint(models.SearchWords(`Café, "crème" brûlée! OR x*`)), `[café crème brûlée or x]`)
+	testutil.Equal(t, models.SQLite.SearchTerms([]string{"warm", "or"}), `"warm" "or"`)
+	testutil.Equal(t, models.Postgres.SearchTerms([]string{"warm", "or"}), `warm or`)
+}
+
+func TestWithTx(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s *stores) {
+		ctx := context.Background()
+		alice := s.addUser(t, "alice")
+		errRollback := errors.New("rollback")
+
+		// A failed unit of work leaves nothing behind.
+		var thread int
+		err := s.tx.WithTx(ctx, func(ctx context.Context) error {
+			var err error
+			thread, err = s.threads.InsertContext(ctx, "Undone", alice)
+			if err != nil {
+				return err
+			}
+			_, err = s.posts.InsertContext(ctx, "Undone", thread, alice)
+			if err != nil {
+				return err
+			}
+			return errRollback
+		})
+		isErr(t, err, errRollback)
+		exists, err := s.threads.ExistsContext(ctx, thread)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.Equal(t, exists, false)
+
+		// A failed nested unit of work, or model call, only undoes its own
+		// changes.
+		var bob int
+		err = s.tx.WithTx(ctx, func(ctx context.Context) error {
+			var err error
+			thread, err = s.threads.InsertContext(ctx, "Kept", alice)
+			if err != nil {
+				return err
+			}
+			_, err = s.posts.InsertContext(ctx, "Lost", thread+1, alice)
+			isErr(t, err, models.ErrNoRecord)
+			err = s.tx.WithTx(ctx, func(ctx context.Context) error {
+				_, err := s.users.InsertContext(ctx, "carol", "carol@example.com", "Passw0rd!")
+				if err != nil {
+					return err
+				}
+				return errRollback
+			})
+			isErr(t, err, errRollback)
+			bob, err = s.users.InsertContext(ctx, "bob", "bob@example.com", "Passw0rd!")
+			if err != nil {
+				return err
+			}
+			_, err = s.posts.InsertContext(ctx, "Kept", thread, bob)
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		th, err := s.threads.GetContext(ctx, thread)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.Equal(t, th.ReplyCount, 1)
+		testutil.Equal(t, th.LastPoster.ID, bob)
+		exists, err = s.users.UsernameExistsContext(ctx, "carol")
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.Equal(t, exists, false)
+	})
+}
+
+func TestQueryTimeout(t *testing.T) {
+	db, err := models.Open(filepath.Join(t.TempDir(), "test.sqlite"), sqliteOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	s := sqlStores(t, db)
+	ctx := context.Background()
+	alice := s.addUser(t, "alice")
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = s.users.GetContext(cancelled, alice)
+	isErr(t, err, context.Canceled)
+	err = s.tx.WithTx(cancelled, func(ctx context.Context) error { return nil })
+	isErr(t, err, context.Canceled)
+
+	db.QueryTimeout = time.Nanosecond
+	_, err = s.users.GetContext(ctx, alice)
+	isErr(t, err, context.DeadlineExceeded)
+	db.QueryTimeout = time.Minute
+	_, err = s.users.GetContext(ctx, alice)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReads(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s *stores) {
+		ctx := context.Background()
+		alice := s.addUser(t, "alice")
+		bob := s.addUser(t, "bob")
+		first := s.addThread(t, "First", alice)
+		second := s.addThread(t, "Second", alice)
+		empty := s.addThread(t, "Empty", alice)
+		p1 := s.addPost(t, "One", first, alice)
+		p2 := s.addPost(t, "Two", first, alice)
+		p3 := s.addPost(t, "Three", second, alice)
+
+		unread := func() map[int]bool {
+			t.Helper()
+			threads, err := s.threads.LatestsContext(ctx, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = s.reads.FlagUnreadContext(ctx, bob, threads)
+			if err != nil {
+				t.Fatal(err)
+			}
+			flags := map[int]bool{}
+			for _, thread := range threads {
+				flags[thread.ID] = thread.Unread
+			}
+			return flags
+		}
+		firstUnread := func(threadID, want int) {
+			t.Helper()
+			id, err := s.reads.FirstUnreadContext(ctx, bob, threadID)
+			if want == 0 {
+				isErr(t, err, models.ErrNoRecord)
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			testutil.Equal(t, id, want)
+		}
+
+		flags := unread()
+		testutil.Equal(t, flags[first], true)
+		testutil.Equal(t, flags[second], true)
+		testutil.Equal(t, flags[empty], true)
+		firstUnread(first, p1)
+		firstUnread(empty, 0)
+
+		// A thread without replies is read once viewed.
+		err := s.reads.MarkReadContext(ctx, bob, empty, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.Equal(t, unread()[empty], false)
+
+		err = s.reads.MarkReadContext(ctx, bob, first, p2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The marker never moves backwards.
+		err = s.reads.MarkReadContext(ctx, bob, first, p1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.Equal(t, unread()[first], false)
+		firstUnread(first, 0)
+		firstUnread(second, p3)
+
+		err = s.reads.MarkAllReadContext(ctx, bob)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.Equal(t, unread()[second], false)
+		firstUnread(second, 0)
+
+		p4 := s.addPost(t, "Four", second, alice)
+		testutil.Equal(t, unread()[second], true)
+		firstUnread(second, p4)
+
+		// Marking everything as read covers the threads without replies,
+		// but not the ones created afterwards.
+		older := s.addThread(t, "Older", alice)
+		testutil.Equal(t, unread()[older], true)
+		err = s.reads.MarkAllReadContext(ctx, bob)
+		if err != nil {
+			t.Fatal(err)
+		}
+		newer := s.addThread(t, "Newer", alice)
+		flags = unread()
+		testutil.Equal(t, flags[older], false)
+		testutil.Equal(t, flags[newer], true)
+	})
+}
+
+func TestConversations(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s *stores) {
+		ctx := context.Background()
+		alice := s.addUser(t, "alice")
+		bob := s.addUser(t, "bob")
+		carol := s.addUser(t, "carol")
+
+		unreadCount := func(userID, want int) {
+			t.Helper()
+			n, err := s.conversations.UnreadCountContext(ctx, userID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			testutil.Equal(t, n, want)
+		}
+		inbox := func(userID int, archived bool) []*models.Conversation {
+			t.Helper()
+			conversations, err := s.conversations.InboxContext(ctx, userID, archived)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return conversations
+		}
+
+		id, err := s.conversations.InsertContext(ctx, "Plans", "Hi both", alice, []int{carol, bob})
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := s.conversations.GetContext(ctx, id, alice)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.Equal(t, c.Subject, "Plans")
+		if len(c.Participants) != 3 || len(c.Messages) != 1 {
+			t.Fatalf("got %d participants and %d messages; want 3 and 1", len(c.Participants), len(c.Messages))
+		}
+		testutil.Equal(t, c.Participants[0].Username, "alice")
+		testutil.Equal(t, c.Participants[1].Username, "bob")
+		testutil.Equal(t, c.Participants[2].Username, "carol")
+		testutil.Equal(t, c.Messages[0].Body, "Hi both")
+
+		unreadCount(alice, 0)
+		unreadCount(bob, 1)
+		conversations := inbox(bob, false)
+		if len(conversations) != 1 || conversations[0].Unread != 1 {
+			t.Fatalf("inbox of bob = %+v; want one unread conversation", conversations)
+		}
+		testutil.Equal(t, len(conversations[0].Participants), 3)
+		testutil.Equal(t, conversations[0].Participants[0].Username, "alice")
+		err = s.conversations.MarkReadContext(ctx, id, bob, c.Messages[0].ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		unreadCount(bob, 0)
+
+		// A reply brings an archived conversation back to the inbox.
+		err = s.conversations.SetArchivedContext(ctx, id, bob, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.Equal(t, len(inbox(bob, false)), 0)
+		archived := inbox(bob, true)
+		if len(archived) != 1 || !archived[0].Archived {
+			t.Fatalf("archived conversations of bob = %+v; want one", archived)
+		}
+		_, err = s.conversations.ReplyContext(ctx, id, alice, "Anyone?")
+		if err != nil {
+			t.Fatal(err)
+		}
+		conversations = inbox(bob, false)
+		if len(conversations) != 1 || conversations[0].Unread != 1 {
+			t.Fatalf("inbox of bob = %+v; want one unread conversation", conversations)
+		}
+
+		err = s.conversations.LeaveContext(ctx, id, carol)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = s.conversations.GetContext(ctx, id, carol)
+		isErr(t, err, models.ErrNoRecord)
+		_, err = s.conversations.ReplyContext(ctx, id, carol, "Still here")
+		isErr(t, err, models.ErrNoRecord)
+		isErr(t, s.conversations.LeaveContext(ctx, id, carol), models.ErrNoRecord)
+		c, err = s.conversations.GetContext(ctx, id, alice)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.Equal(t, len(c.Participants), 2)
+
+		// Blocked users can neither start nor continue a conversation, and
+		// blocking twice is harmless.
+		for range 2 {
+			err = s.blocks.InsertContext(ctx, bob, alice)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		blocked, err := s.blocks.BlockedContext(ctx, bob)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(blocked) != 1 || blocked[0].Username != "alice" {
+			t.Errorf("bob blocked %v; want alice", blocked)
+		}
+		_, err = s.conversations.InsertContext(ctx, "Again", "Hello?", alice, []int{bob})
+		isErr(t, err, models.ErrBlocked)
+		_, err = s.conversations.ReplyContext(ctx, id, alice, "Hello?")
+		isErr(t, err, models.ErrBlocked)
+
+		err = s.blocks.DeleteContext(ctx, bob, alice)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = s.conversations.ReplyContext(ctx, id, alice, "Hello?")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Nor can users reply to someone they have blocked themselves.
+		err = s.blocks.InsertContext(ctx, alice, bob)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = s.conversations.ReplyContext(ctx, id, alice, "Hello?")
+		isErr(t, err, models.ErrBlocked)
+	})
+}
+
+func TestAttachments(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s *stores) {
+		ctx := context.Background()
+		alice := s.addUser(t, "alice")
+		threadID := s.addThread(t, "Files", alice)
+		postID := s.addPost(t, "See attached", threadID, alice)
+
+		id, err := s.attachments.InsertContext(ctx, postID, alice, "cat.png", "image/png", 1234, "key-1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = s.attachments.InsertContext(ctx, postID, alice, "notes.txt", "text/plain", 66, "key-2")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		a, err := s.attachments.GetContext(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.Equal(t, a.PostID, postID)
+		testutil.Equal(t, a.Filename, "cat.png")
+		testutil.Equal(t, a.Size, int64(1234))
+		testutil.Equal(t, a.StorageKey, "key-1")
+		_, err = s.attachments.GetContext(ctx, id+2)
+		isErr(t, err, models.ErrNoRecord)
+
+		thread, err := s.threads.GetContext(ctx, threadID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = s.attachments.ForPostsContext(ctx, thread.Posts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.Equal(t, len(thread.Posts[0].Attachments), 2)
+
+		used, err := s.attachments.UsedBytesContext(ctx, alice)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.Equal(t, used, int64(1300))
+	})
+}
+
+func TestWebhooks(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s *stores) {
+		ctx := context.Background()
+		claim := func(lease time.Duration) []*models.WebhookDelivery {
+			t.Helper()
+			deliveries, err := s.webhooks.ClaimContext(ctx, 10, lease)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return deliveries
+		}
+		getDelivery := func(id int) *models.WebhookDelivery {
+			t.Helper()
+			d, err := s.webhooks.GetDeliveryContext(ctx, id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return d
+		}
+
+		id, err := s.webhooks.InsertContext(ctx, "https://example.com/hook", "s3cret", []string{"post.created", "thread.created"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		inactive, err := s.webhooks.InsertContext(ctx, "https://example.com/off", "s3cret", []string{"post.created"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = s.webhooks.SetActiveContext(ctx, inactive, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		webhooks, err := s.webhooks.AllContext(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(webhooks) != 2 || !webhooks[0].Active || webhooks[1].Active || len(webhooks[0].Events) != 2 {
+			t.Fatalf("webhooks = %+v", webhooks)
+		}
+
+		payload := []byte(`{"id":1}`)
+		for event, want := range map[string]int{"post.created": 1, "user.created": 0} {
+			n, err := s.webhooks.EnqueueContext(ctx, event, payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			testutil.Equal(t, n, want)
+		}
+		// Deliveries queued by a unit of work that fails are dropped.
+		errRollback := errors.New("rollback")
+		err = s.tx.WithTx(ctx, func(ctx context.Context) error {
+			n, err := s.webhooks.EnqueueContext(ctx, "thread.created", payload)
+			if err != nil {
+				return err
+			}
+			testutil.Equal(t, n, 1)
+			return errRollback
+		})
+		isErr(t, err, errRollback)
+
+		// Claimed deliveries are leased.
+		claimed := claim(time.Minute)
+		testutil.Equal(t, len(claim(time.Minute)), 0)
+		if len(claimed) != 1 {
+			t.Fatalf("claimed %d deliveries; want 1", len(claimed))
+		}
+		d := claimed[0]
+		testutil.Equal(t, d.WebhookID, id)
+		testutil.Equal(t, d.URL, "https://example.com/hook")
+		testutil.Equal(t, d.Event, "post.created")
+		if !bytes.Equal(d.Payload, payload) {
+			t.Errorf("payload = %q; want %q", d.Payload, payload)
+		}
+
+		err = s.webhooks.RecordAttemptContext(ctx, &models.WebhookAttempt{
+			DeliveryID: d.ID,
+			Attempted:  time.Now(),
+			StatusCode: 500,
+			Duration:   20 * time.Millisecond,
+		}, models.DeliveryPending, time.Now().Add(-time.Second))
+		if err != nil {
+			t.Fatal(err)
+		}
+		claimed = claim(time.Minute)
+		if len(claimed) != 1 || claimed[0].Attempts != 1 {
+			t.Fatalf("claimed %+v; want the delivery attempted once", claimed)
+		}
+		got := getDelivery(d.ID)
+		if len(got.Log) != 1 || got.Log[0].StatusCode != 500 || got.Log[0].Duration != 20*time.Millisecond {
+			t.Errorf("log = %+v; want the failed attempt", got.Log)
+		}
+
+		err = s.webhooks.RecordAttemptContext(ctx, &models.WebhookAttempt{
+			DeliveryID: d.ID,
+			Attempted:  time.Now(),
+			StatusCode: 500,
+		}, models.DeliveryFailed, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.Equal(t, len(claim(0)), 0)
+		err = s.webhooks.RedeliverContext(ctx, d.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = getDelivery(d.ID)
+		testutil.Equal(t, got.Status, models.DeliveryPending)
+		testutil.Equal(t, got.Attempts, 0)
+		isErr(t, s.webhooks.RedeliverContext(ctx, d.ID+1), models.ErrNoRecord)
+
+		err = s.webhooks.DeleteContext(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = s.webhooks.GetContext(ctx, id)
+		isErr(t, err, models.ErrNoRecord)
+		_, err = s.webhooks.GetDeliveryContext(ctx, d.ID)
+		isErr(t, err, models.ErrNoRecord)
+		deliveries, err := s.webhooks.DeliveriesContext(ctx, id, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.Equal(t, len(deliveries), 0)
+	})
+}
+
+func TestAdminUsers(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s *stores) {
+		users, ok := s.users.(*models.UserModel)
+		if !ok {
+			t.Skip("administration is only implemented by the SQL models")
+		}
+		ctx := context.Background()
+		alice := s.addUser(t, "alice")
+		s.addUser(t, "bob")
+
+		list, err := users.ListContext(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.Equal(t, len(list), 2)
+		testutil.Equal(t, list[0].Username, "alice")
+		testutil.Equal(t, list[0].Role, models.RoleMember)
+
+		err = users.SetRoleContext(ctx, alice, models.RoleModerator)
+		if err != nil {
+			t.Fatal(err)
+		}
+		role, err := users.RoleContext(ctx, alice)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.Equal(t, role, models.RoleModerator)
+		if users.SetRoleContext(ctx, alice, "owner") == nil {
+			t.Error("got no error setting an unknown role")
+		}
+		err = users.SetRoleContext(ctx, 999, models.RoleAdmin)
+		isErr(t, err, models.ErrNoRecord)
+
+		err = users.SetPasswordContext(ctx, alice, "N3w password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = users.AuthenticateContext(ctx, "alice@example.com", "Passw0rd!")
+		isErr(t, err, models.ErrInvalidCredentials)
+		_, err = users.AuthenticateContext(ctx, "alice@example.com", "N3w password")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = users.SetDisabledContext(ctx, alice, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = users.AuthenticateContext(ctx, "alice@example.com", "N3w password")
+		isErr(t, err, models.ErrDisabled)
+		_, err = users.AuthenticateContext(ctx, "alice@example.com", "wrong")
+		isErr(t, err, models.ErrInvalidCredentials)
+		u, err := users.GetContext(ctx, alice)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.Equal(t, u.Disabled, true)
+	})
+}
+
+func TestAdminThreads(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, s *stores) {
+		threads, ok := s.threads.(*models.ThreadModel)
+		if !ok {
+			t.Skip("administration is only implemented by the SQL models")
+		}
+		posts := s.posts.(*models.PostModel)
+		ctx := context.Background()
+		alice := s.addUser(t, "alice")
+		spammer := s.addUser(t, "spammer")
+		first := s.addThread(t, "First", alice)
+		second := s.addThread(t, "Second", alice)
+		s.addPost(t, "Hello", first, alice)
+		spam := s.addPost(t, "Buy now", first, spammer)
+		s.addPost(t, "Welcome", second, alice)
+		_, err := s.attachments.InsertContext(ctx, spam, spammer, "ad.png", "image/png", 10, "key-spam")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = threads.SetLockedContext(ctx, first, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = posts.InsertContext(ctx, "Too late", first, alice)
+		isErr(t, err, models.ErrLocked)
+		thread, err := threads.GetContext(ctx, first)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.Equal(t, thread.Locked, true)
+		testutil.Equal(t, thread.ReplyCount, 2)
+
+		purged, keys, err := posts.PurgeByUserContext(ctx, spammer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.Equal(t, purged, 1)
+		testutil.Equal(t, len(keys), 1)
+		testutil.Equal(t, keys[0], "key-spam")
+		thread, err = threads.GetContext(ctx, first)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.Equal(t, thread.ReplyCount, 1)
+		testutil.Equal(t, thread.LastPoster.Username, "alice")
+
+		moved, err := threads.MoveContext(ctx, first, second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.Equal(t, moved, 1)
+		_, err = threads.GetContext(ctx, first)
+		isErr(t, err, models.ErrNoRecord)
+		thread, err = threads.GetContext(ctx, second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.Equal(t, thread.ReplyCount, 2)
+		testutil.Equal(t, len(thread.Posts), 2)
+		_, err = threads.MoveContext(ctx, second, 999)
+		isErr(t, err, models.ErrNoRecord)
+
+		deleted, keys, err := threads.DeleteContext(ctx, second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.Equal(t, deleted, 2)
+		testutil.Equal(t, len(keys), 0)
+		_, _, err = threads.DeleteContext(ctx, second)
+		isErr(t, err, models.ErrNoRecord)
+	})
+}
+
+// TestBackgroundWrappers checks that every XxxContext method of the SQL models
+// has an Xxx wrapper taking the same arguments but the context.
+func TestBackgroundWrappers(t *testing.T) {
+	ctxType := reflect.TypeFor[context.Context]()
+	for _, model := range []any{
+		&models.AttachmentModel{},
+		&models.BlockModel{},
+		&models.ConversationModel{},
+		&models.PostModel{},
+		&models.ReadModel{},
+		&models.ThreadModel{},
+		&models.UserModel{},
+		&models.WebhookModel{},
+	} {
+		typ := reflect.TypeOf(model)
+		for i := range typ.NumMethod() {
+			method := typ.Method(i)
+			name, ok := strings.CutSuffix(method.Name, "Context")
+			if !ok {
+				continue
+			}
+			wrapper, ok := typ.MethodByName(name)
+			if !ok {
+				t.Errorf("%s.%s has no %s wrapper", typ.Elem().Name(), method.Name, name)
+				continue
+			}
+			in := method.Type.NumIn()
+			if wrapper.Type.NumIn() != in-1 || method.Type.In(1) != ctxType {
+				t.Errorf("%s.%s: got %s; want %s without the context", typ.Elem().Name(), name, wrapper.Type, method.Type)
+				continue
+			}
+			for j := 2; j < in; j++ {
+				if wrapper.Type.In(j-1) != method.Type.In(j) {
+					t.Errorf("%s.%s: got %s; want %s without the context", typ.Elem().Name(), name, wrapper.Type, method.Type)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package models
+
+import "regexp"
+
+// sqliteTypes matches the parts of the SQLite schema that PostgreSQL spells
+// differently.
+var sqliteTypes = regexp.MustCompile(`\b(INTEGER PRIMARY KEY|DATE|BLOB|WITHOUT ROWID)\b`)
+
+// postgresTypes maps the matches of sqliteTypes to PostgreSQL.
+var postgresTypes = map[string]string{
+	"INTEGER PRIMARY KEY": "INTEGER GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY",
+	"DATE":                "TIMESTAMPTZ",
+	"BLOB":                "BYTEA",
+	"WITHOUT ROWID":       "",
+}
+
+// postgresSchema rewrites a statement of the SQLite schema, such as the
+// CREATE TABLE of a model, for PostgreSQL.
+func postgresSchema(stmt string) string {
+	return sqliteTypes.ReplaceAllStringFunc(stmt, func(s string) string {
+		return postgresTypes[s]
+	})
+}
@@ -1,27 +1,53 @@
 package models
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"forum/internal/cache"
+	"forum/internal/pubsub"
 	"time"
 )
 
+// EventPostCreated is the type of the event published on the topic of a
+// thread when a post is added to it. Its ID is the ID of the post.
+const EventPostCreated = "post"
+
 // Post holds data about a single post in a Thread.
 type Post struct {
-	ID      int
-	Body    string
-	Author  *User
-	Created time.Time
+	ID          int
+	Body        string
+	Author      *User
+	Created     time.Time
+	Attachments []*Attachment
+}
+
+// SearchResult is a post found by a search, with the thread it is in.
+type SearchResult struct {
+	Post        *Post
+	ThreadID    int
+	ThreadTitle string
 }
 
-// PostModel holds a database handle for manipulating posts.
+// PostModel holds a database handle for manipulating posts. New posts are
+// announced on Hub, if set, and invalidate the data derived from their
+// thread in Cache, if set, once they are committed.
 type PostModel struct {
-	DB *sql.DB
+	DB    *DB
+	Hub   pubsub.Publisher
+	Cache cache.Cache
+}
+
+// ThreadTopic returns the topic on which the events of a thread are
+// published.
+func ThreadTopic(threadID int) string {
+	return fmt.Sprintf("thread:%d", threadID)
 }
 
 // NewPostModel creates a Posts table and returns a new PostModel.
-func NewPostModel(db *sql.DB) (*PostModel, error) {
-	m := PostModel{db}
+func NewPostModel(db *DB) (*PostModel, error) {
+	m := PostModel{DB: db}
 	err := m.createTable()
 	if err != nil {
 		return nil, fmt.Errorf("creating table: %w", err)
@@ -40,26 +66,255 @@ func (m *PostModel) createTable() error {
 		    created DATE NOT NULL
 		);
 	`
-	_, err := m.DB.Exec(stmt)
+	err := m.DB.createTables(stmt)
 	if err != nil {
 		return fmt.Errorf("creating Posts table: %w", err)
 	}
 	return nil
 }
 
-// Insert inserts a new post in the Posts table.
-func (m *PostModel) Insert(body string, threadId, authorId int) (int, error) {
-	stmt := `
+// InsertContext inserts a new post in the Posts table and bumps the last
+// activity and reply count of its thread in the same transaction. It returns
+// ErrNoRecord if the thread does not exist, and ErrLocked if it is locked.
+func (m *PostModel) InsertContext(ctx context.Context, body string, threadId, authorId int) (int, error) {
+	ctx, done := m.DB.start(ctx, "PostModel.Insert")
+	defer done()
+	tx, err := m.DB.BeginTx(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// The thread is updated before the post is inserted, so that a missing
+	// thread is reported the same way whether foreign keys are enforced or
+	// not.
+	var locked bool
+	stmt := `UPDATE Threads SET reply_count = reply_count + 1 WHERE id = ? RETURNING locked`
+	err = tx.QueryRowContext(ctx, stmt, threadId).Scan(&locked)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrNoRecord
+	}
+	if err != nil {
+		return 0, fmt.Errorf("bumping thread activity: %w", err)
+	}
+	if locked {
+		return 0, ErrLocked
+	}
+
+	stmt = `
 		INSERT INTO Posts (body, thread_id, author_id, created)
 		VALUES (?, ?, ?, CURRENT_TIMESTAMP)
 	`
-	result, err := m.DB.Exec(stmt, body, threadId, authorId)
+	id, err := tx.InsertContext(ctx, stmt, body, threadId, authorId)
 	if err != nil {
 		return 0, err
 	}
-	id, err := result.LastInsertId()
+
+	stmt = `
+		UPDATE Threads SET
+		    last_post_at = (SELECT created FROM Posts WHERE id = ?),
+		    last_post_author_id = ?,
+		    last_post_id = ?
+		WHERE id = ?
+	`
+	_, err = tx.ExecContext(ctx, stmt, id, authorId, id, threadId)
 	if err != nil {
-		return 0, err
+		return 0, fmt.Errorf("bumping thread activity: %w", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, fmt.Errorf("committing post: %w", err)
+	}
+
+	m.DB.afterCommit(ctx, func() {
+		if m.Cache != nil {
+			m.Cache.Invalidate(TagThreads, ThreadTag(threadId))
+		}
+		if m.Hub != nil {
+			m.Hub.Publish(ThreadTopic(threadId), pubsub.Event{ID: int64(id), Type: EventPostCreated})
+		}
+	})
+	return id, nil
+}
+
+// SinceContext returns up to limit posts of a thread with an ID greater than
+// afterID, oldest first.
+func (m *PostModel) SinceContext(ctx context.Context, threadID, afterID, limit int) ([]*Post, error) {
+	ctx, done := m.DB.start(ctx, "PostModel.Since")
+	defer done()
+	stmt := `
+		SELECT P.id, P.body, P.created, U.id, U.username, U.avatar_version
+		FROM Posts P
+		JOIN Users U ON P.author_id = U.id
+		WHERE P.thread_id = ? AND P.id > ?
+		ORDER BY P.id
+		LIMIT ?
+	`
+	rows, err := m.DB.QueryContext(ctx, stmt, threadID, afterID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("getting posts: %w", err)
+	}
+	defer rows.Close()
+
+	var posts []*Post
+	for rows.Next() {
+		var (
+			p Post
+			u User
+		)
+		err := rows.Scan(&p.ID, &p.Body, &p.Created, &u.ID, &u.Username, &u.AvatarVersion)
+		if err != nil {
+			return nil, fmt.Errorf("scanning post: %w", err)
+		}
+		p.Author = &u
+		posts = append(posts, &p)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating over posts: %w", err)
+	}
+	return posts, nil
+}
+
+// SearchContext returns up to limit posts whose body contains every word of
+// query, newest first, skipping the first offset. A query without any word
+// matches nothing.
+func (m *PostModel) SearchContext(ctx context.Context, query string, limit, offset int) ([]*SearchResult, error) {
+	words := SearchWords(query)
+	if len(words) == 0 {
+		return nil, nil
+	}
+	ctx, done := m.DB.start(ctx, "PostModel.Search")
+	defer done()
+	stmt := `
+		SELECT P.id, P.body, P.created, U.id, U.username, U.avatar_version, T.id, T.title
+		FROM Posts P
+		JOIN Users U ON P.author_id = U.id
+		JOIN Threads T ON P.thread_id = T.id
+		WHERE ` + m.DB.Dialect.MatchPosts() + `
+		ORDER BY P.id DESC
+		LIMIT ? OFFSET ?
+	`
+	rows, err := m.DB.QueryContext(ctx, stmt, m.DB.Dialect.SearchTerms(words), limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("searching posts: %w", err)
+	}
+	defer rows.Close()
+
+	var results []*SearchResult
+	for rows.Next() {
+		var (
+			p Post
+			u User
+			r SearchResult
+		)
+		err := rows.Scan(&p.ID, &p.Body, &p.Created, &u.ID, &u.Username, &u.AvatarVersion, &r.ThreadID, &r.ThreadTitle)
+		if err != nil {
+			return nil, fmt.Errorf("scanning post: %w", err)
+		}
+		p.Author = &u
+		r.Post = &p
+		results = append(results, &r)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating over posts: %w", err)
+	}
+	return results, nil
+}
+
+// PurgeByUserContext deletes every post of the user, with their attachments,
+// and updates the threads they were in. It returns the number of posts
+// deleted and the storage keys of the attachments, whose files the caller
+// removes from the blob store.
+func (m *PostModel) PurgeByUserContext(ctx context.Context, userID int) (int, []string, error) {
+	ctx, done := m.DB.start(ctx, "PostModel.PurgeByUser")
+	defer done()
+	var (
+		purged  int
+		keys    []string
+		threads []int
+	)
+	err := m.DB.WithTx(ctx, func(ctx context.Context) error {
+		rows, err := m.DB.QueryContext(ctx, `SELECT DISTINCT thread_id FROM Posts WHERE author_id = ?`, userID)
+		if err != nil {
+			return fmt.Errorf("listing threads: %w", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var id int
+			err := rows.Scan(&id)
+			if err != nil {
+				return fmt.Errorf("scanning thread id: %w", err)
+			}
+			threads = append(threads, id)
+		}
+		if err = rows.Err(); err != nil {
+			return fmt.Errorf("iterating over threads: %w", err)
+		}
+		rows.Close()
+
+		keys, purged, err = m.DB.deletePosts(ctx, `author_id = ?`, userID)
+		if err != nil {
+			return err
+		}
+		return m.DB.recountThreads(ctx, threads...)
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	if m.Cache != nil && len(threads) > 0 {
+		tags := []string{TagThreads}
+		for _, id := range threads {
+			tags = append(tags, ThreadTag(id))
+		}
+		m.DB.afterCommit(ctx, func() { m.Cache.Invalidate(tags...) })
+	}
+	return purged, keys, nil
+}
+
+// deletePosts deletes the posts matching the condition where, which takes
+// args, along with their attachments if the attachment model has created
+// their table. It returns the storage keys of the attachments and the number
+// of posts deleted.
+func (db *DB) deletePosts(ctx context.Context, where string, args ...any) ([]string, int, error) {
+	exists, err := db.hasTable(ctx, "Attachments")
+	if err != nil {
+		return nil, 0, err
+	}
+	var keys []string
+	if exists {
+		posts := `SELECT id FROM Posts WHERE ` + where
+		rows, err := db.QueryContext(ctx, `SELECT storage_key FROM Attachments WHERE post_id IN (`+posts+`)`, args...)
+		if err != nil {
+			return nil, 0, fmt.Errorf("listing attachments: %w", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var key string
+			err := rows.Scan(&key)
+			if err != nil {
+				return nil, 0, fmt.Errorf("scanning attachment: %w", err)
+			}
+			keys = append(keys, key)
+		}
+		if err = rows.Err(); err != nil {
+			return nil, 0, fmt.Errorf("iterating over attachments: %w", err)
+		}
+		rows.Close()
+
+		_, err = db.ExecContext(ctx, `DELETE FROM Attachments WHERE post_id IN (`+posts+`)`, args...)
+		if err != nil {
+			return nil, 0, fmt.Errorf("deleting attachments: %w", err)
+		}
+	}
+
+	result, err := db.ExecContext(ctx, `DELETE FROM Posts WHERE `+where, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("deleting posts: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return nil, 0, fmt.Errorf("deleting posts: %w", err)
 	}
-	return int(id), nil
+	return keys, int(n), nil
 }
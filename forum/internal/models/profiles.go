@@ -0,0 +1,148 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Profile holds the public data about a user. It never carries their email.
+type Profile struct {
+	ID            int
+	Username      string
+	Joined        time.Time
+	Bio           string
+	Location      string
+	Website       string
+	HideActivity  bool
+	ThreadCount   int
+	PostCount     int
+	AvatarVersion int
+}
+
+// Activity is a thread or a post created by a user.
+type Activity struct {
+	Kind        string
+	ThreadID    int
+	ThreadTitle string
+	PostID      int
+	Body        string
+	Created     time.Time
+}
+
+// IsPost reports whether the activity is a post rather than a new thread.
+func (a *Activity) IsPost() bool {
+	return a.Kind == "post"
+}
+
+// GetProfileContext retrieves the public profile of the user with the given
+// username, ignoring case, chosen like GetByUsernameContext does.
+func (m *UserModel) GetProfileContext(ctx context.Context, username string) (*Profile, error) {
+	ctx, done := m.DB.start(ctx, "UserModel.GetProfile")
+	defer done()
+	stmt := `
+		SELECT U.id, U.username, U.created, U.bio, U.location, U.website, U.hide_activity,
+		       U.avatar_version,
+		       (SELECT COUNT(*) FROM Threads WHERE author_id = U.id),
+		       (SELECT COUNT(*) FROM Posts WHERE author_id = U.id)
+		FROM Users U
+		WHERE ` + m.DB.Dialect.EqualFold("U.username") + `
+		ORDER BY U.username = ? DESC, U.id LIMIT 1`
+	return m.scanProfile(m.DB.QueryRowContext(ctx, stmt, username, username))
+}
+
+// GetProfileByIDContext retrieves the public profile of the user with the
+// given id.
+func (m *UserModel) GetProfileByIDContext(ctx context.Context, id int) (*Profile, error) {
+	ctx, done := m.DB.start(ctx, "UserModel.GetProfileByID")
+	defer done()
+	stmt := `
+		SELECT U.id, U.username, U.created, U.bio, U.location, U.website, U.hide_activity,
+		       U.avatar_version,
+		       (SELECT COUNT(*) FROM Threads WHERE author_id = U.id),
+		       (SELECT COUNT(*) FROM Posts WHERE author_id = U.id)
+		FROM Users U
+		WHERE U.id = ?
+	`
+	return m.scanProfile(m.DB.QueryRowContext(ctx, stmt, id))
+}
+
+// scanProfile creates a Profile from a row returned by GetProfile or
+// GetProfileByID.
+func (m *UserModel) scanProfile(row *sql.Row) (*Profile, error) {
+	var p Profile
+	err := row.Scan(
+		&p.ID, &p.Username, &p.Joined, &p.Bio, &p.Location, &p.Website, &p.HideActivity,
+		&p.AvatarVersion, &p.ThreadCount, &p.PostCount,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, fmt.Errorf("querying profile: %w", err)
+	}
+	return &p, nil
+}
+
+// UpdateProfileContext updates the public profile of the user with the given
+// id.
+func (m *UserModel) UpdateProfileContext(ctx context.Context, id int, bio, location, website string, hideActivity bool) error {
+	ctx, done := m.DB.start(ctx, "UserModel.UpdateProfile")
+	defer done()
+	stmt := `
+		UPDATE Users SET bio = ?, location = ?, website = ?, hide_activity = ?
+		WHERE id = ?
+	`
+	result, err := m.DB.ExecContext(ctx, stmt, bio, location, website, hideActivity, id)
+	if err != nil {
+		return fmt.Errorf("updating profile: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("updating profile: %w", err)
+	}
+	if n == 0 {
+		return ErrNoRecord
+	}
+	return nil
+}
+
+// ActivityContext retrieves the threads and posts created by the user, newest
+// first, skipping the first offset ones and returning at most limit.
+func (m *UserModel) ActivityContext(ctx context.Context, userID, limit, offset int) ([]*Activity, error) {
+	ctx, done := m.DB.start(ctx, "UserModel.Activity")
+	defer done()
+	stmt := `
+		SELECT 'thread', T.id, T.title, 0, '', T.created AS created
+		FROM Threads T
+		WHERE T.author_id = ?
+		UNION ALL
+		SELECT 'post', T.id, T.title, P.id, P.body, P.created AS created
+		FROM Posts P
+		JOIN Threads T ON P.thread_id = T.id
+		WHERE P.author_id = ?
+		ORDER BY created DESC
+		LIMIT ? OFFSET ?
+	`
+	rows, err := m.DB.QueryContext(ctx, stmt, userID, userID, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("getting activity: %w", err)
+	}
+	defer rows.Close()
+
+	var activity []*Activity
+	for rows.Next() {
+		var a Activity
+		err := rows.Scan(&a.Kind, &a.ThreadID, &a.ThreadTitle, &a.PostID, &a.Body, &a.Created)
+		if err != nil {
+			return nil, fmt.Errorf("scanning activity: %w", err)
+		}
+		activity = append(activity, &a)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating over activity: %w", err)
+	}
+	return activity, nil
+}
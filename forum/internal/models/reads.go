@@ -0,0 +1,164 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ReadModel holds a database handle to track which posts each user has read.
+//
+// Only the last read post of a (user, thread) pair is stored, so viewing a
+// thread costs a single upsert. Marking everything as read moves per-user
+// watermarks, on posts and on threads, instead of touching every thread. A
+// thread without replies is unread until the user views it, unless it was
+// created before they last marked everything as read.
+type ReadModel struct {
+	DB *DB
+}
+
+// NewReadModel creates a ThreadReads table and returns a new ReadModel.
+func NewReadModel(db *DB) (*ReadModel, error) {
+	m := ReadModel{db}
+	err := m.createTable()
+	if err != nil {
+		return nil, fmt.Errorf("creating table: %w", err)
+	}
+	return &m, nil
+}
+
+// createTable creates a ThreadReads table.
+func (m *ReadModel) createTable() error {
+	stmt := `
+		CREATE TABLE IF NOT EXISTS ThreadReads (
+		    user_id INTEGER NOT NULL REFERENCES Users,
+		    thread_id INTEGER NOT NULL REFERENCES Threads,
+		    last_read_post_id INTEGER NOT NULL,
+		    PRIMARY KEY (user_id, thread_id)
+		) WITHOUT ROWID;
+	`
+	err := m.DB.createTables(stmt)
+	if err != nil {
+		return fmt.Errorf("creating ThreadReads table: %w", err)
+	}
+	return nil
+}
+
+// MarkReadContext records that the user has read the thread up to postID. It
+// never moves the marker backwards.
+func (m *ReadModel) MarkReadContext(ctx context.Context, userID, threadID, postID int) error {
+	ctx, done := m.DB.start(ctx, "ReadModel.MarkRead")
+	defer done()
+	stmt := `
+		INSERT INTO ThreadReads (user_id, thread_id, last_read_post_id)
+		VALUES (?, ?, ?)
+		ON CONFLICT (user_id, thread_id) DO UPDATE
+		SET last_read_post_id = excluded.last_read_post_id
+		WHERE excluded.last_read_post_id > ThreadReads.last_read_post_id
+	`
+	_, err := m.DB.ExecContext(ctx, stmt, userID, threadID, postID)
+	if err != nil {
+		return fmt.Errorf("marking thread %d as read: %w", threadID, err)
+	}
+	return nil
+}
+
+// MarkAllReadContext marks every existing thread and post as read for the
+// user.
+func (m *ReadModel) MarkAllReadContext(ctx context.Context, userID int) error {
+	ctx, done := m.DB.start(ctx, "ReadModel.MarkAllRead")
+	defer done()
+	stmt := `
+		UPDATE Users SET
+		    read_all_post_id = (SELECT COALESCE(MAX(id), 0) FROM Posts),
+		    read_all_thread_id = (SELECT COALESCE(MAX(id), 0) FROM Threads)
+		WHERE id = ?
+	`
+	_, err := m.DB.ExecContext(ctx, stmt, userID)
+	if err != nil {
+		return fmt.Errorf("marking all threads as read: %w", err)
+	}
+	return nil
+}
+
+// FlagUnreadContext sets Unread on each thread that has posts the user has not
+// read, or that the user has never viewed and was created since they last
+// marked everything as read.
+func (m *ReadModel) FlagUnreadContext(ctx context.Context, userID int, threads []*Thread) error {
+	ctx, done := m.DB.start(ctx, "ReadModel.FlagUnread")
+	defer done()
+	if len(threads) == 0 {
+		return nil
+	}
+
+	args := []any{userID, userID}
+	placeholders := make([]string, len(threads))
+	byID := make(map[int]*Thread, len(threads))
+	for i, t := range threads {
+		placeholders[i] = "?"
+		args = append(args, t.ID)
+		byID[t.ID] = t
+	}
+
+	stmt := fmt.Sprintf(
+		`
+			SELECT T.id, %s, R.user_id IS NOT NULL, U.read_all_thread_id
+			FROM Threads T
+			JOIN Users U ON U.id = ?
+			LEFT JOIN ThreadReads R ON R.thread_id = T.id AND R.user_id = ?
+			WHERE T.id IN (%s)
+		`,
+		m.DB.Dialect.Greatest("COALESCE(R.last_read_post_id, 0)", "U.read_all_post_id"),
+		strings.Join(placeholders, ", "),
+	)
+	rows, err := m.DB.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return fmt.Errorf("getting read markers: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var threadID, lastRead, readAllThreadID int
+		var viewed bool
+		err := rows.Scan(&threadID, &lastRead, &viewed, &readAllThreadID)
+		if err != nil {
+			return fmt.Errorf("scanning read marker: %w", err)
+		}
+		t := byID[threadID]
+		t.Unread = t.LastPostID > lastRead || (!viewed && t.ID > readAllThreadID)
+	}
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("iterating over read markers: %w", err)
+	}
+	return nil
+}
+
+// FirstUnreadContext returns the id of the first post of the thread the user
+// has not read yet. It returns ErrNoRecord if the user has read every post.
+func (m *ReadModel) FirstUnreadContext(ctx context.Context, userID, threadID int) (int, error) {
+	ctx, done := m.DB.start(ctx, "ReadModel.FirstUnread")
+	defer done()
+	stmt := fmt.Sprintf(
+		`
+			SELECT P.id
+			FROM Posts P
+			JOIN Users U ON U.id = ?
+			LEFT JOIN ThreadReads R ON R.thread_id = P.thread_id AND R.user_id = U.id
+			WHERE P.thread_id = ? AND P.id > %s
+			ORDER BY P.created ASC, P.id ASC
+			LIMIT 1
+		`,
+		m.DB.Dialect.Greatest("COALESCE(R.last_read_post_id, 0)", "U.read_all_post_id"),
+	)
+	var id int
+	err := m.DB.QueryRowContext(ctx, stmt, userID, threadID).Scan(&id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrNoRecord
+		}
+		return 0, fmt.Errorf("getting first unread post: %w", err)
+	}
+	return id, nil
+}
@@ -0,0 +1,112 @@
+package models
+
+import (
+	"context"
+	"time"
+)
+
+// The interfaces below describe what the application needs from each model.
+// They are implemented by the models of this package, backed by SQLite or
+// PostgreSQL, and by the in-memory models of package memory, used in tests.
+// Every method takes the context of the request it serves.
+
+// ThreadStore stores threads.
+type ThreadStore interface {
+	InsertContext(ctx context.Context, title string, authorId int) (int, error)
+	GetContext(ctx context.Context, id int) (*Thread, error)
+	ExistsContext(ctx context.Context, id int) (bool, error)
+	LatestsContext(ctx context.Context, limit int) ([]*Thread, error)
+	NewestContext(ctx context.Context, limit int) ([]*Thread, error)
+}
+
+// PostStore stores the posts of threads.
+type PostStore interface {
+	InsertContext(ctx context.Context, body string, threadId, authorId int) (int, error)
+	SinceContext(ctx context.Context, threadID, afterID, limit int) ([]*Post, error)
+	SearchContext(ctx context.Context, query string, limit, offset int) ([]*SearchResult, error)
+}
+
+// UserStore stores users and their profiles.
+type UserStore interface {
+	InsertContext(ctx context.Context, username, email, password string) (int, error)
+	GetContext(ctx context.Context, id int) (*User, error)
+	GetByUsernameContext(ctx context.Context, username string) (*User, error)
+	UsernameExistsContext(ctx context.Context, username string) (bool, error)
+	SetAvatarVersionContext(ctx context.Context, id, old, version int) error
+	RoleContext(ctx context.Context, id int) (string, error)
+	ExistsContext(ctx context.Context, email string) (bool, error)
+	AuthenticateContext(ctx context.Context, email, password string) (int, error)
+	GetProfileContext(ctx context.Context, username string) (*Profile, error)
+	GetProfileByIDContext(ctx context.Context, id int) (*Profile, error)
+	UpdateProfileContext(ctx context.Context, id int, bio, location, website string, hideActivity bool) error
+	ActivityContext(ctx context.Context, userID, limit, offset int) ([]*Activity, error)
+}
+
+// ReadStore tracks which posts each user has read.
+type ReadStore interface {
+	MarkReadContext(ctx context.Context, userID, threadID, postID int) error
+	MarkAllReadContext(ctx context.Context, userID int) error
+	FlagUnreadContext(ctx context.Context, userID int, threads []*Thread) error
+	FirstUnreadContext(ctx context.Context, userID, threadID int) (int, error)
+}
+
+// ConversationStore stores private conversations.
+type ConversationStore interface {
+	InsertContext(ctx context.Context, subject, body string, authorID int, recipientIDs []int) (int, error)
+	ReplyContext(ctx context.Context, conversationID, authorID int, body string) (int, error)
+	GetContext(ctx context.Context, conversationID, userID int) (*Conversation, error)
+	InboxContext(ctx context.Context, userID int, archived bool) ([]*Conversation, error)
+	UnreadCountContext(ctx context.Context, userID int) (int, error)
+	MarkReadContext(ctx context.Context, conversationID, userID, messageID int) error
+	SetArchivedContext(ctx context.Context, conversationID, userID int, archived bool) error
+	LeaveContext(ctx context.Context, conversationID, userID int) error
+}
+
+// BlockStore stores the users each user has blocked.
+type BlockStore interface {
+	InsertContext(ctx context.Context, blockerID, blockedID int) error
+	DeleteContext(ctx context.Context, blockerID, blockedID int) error
+	BlockedContext(ctx context.Context, blockerID int) ([]*User, error)
+}
+
+// AttachmentStore stores the records of the files attached to posts.
+type AttachmentStore interface {
+	InsertContext(ctx context.Context, postID, userID int, filename, contentType string, size int64, storageKey string) (int, error)
+	GetContext(ctx context.Context, id int) (*Attachment, error)
+	ForPostsContext(ctx context.Context, posts []*Post) error
+	UsedBytesContext(ctx context.Context, userID int) (int64, error)
+}
+
+// WebhookStore stores webhooks and their queue of deliveries.
+type WebhookStore interface {
+	InsertContext(ctx context.Context, url, secret string, events []string) (int, error)
+	GetContext(ctx context.Context, id int) (*Webhook, error)
+	AllContext(ctx context.Context) ([]*Webhook, error)
+	SetActiveContext(ctx context.Context, id int, active bool) error
+	DeleteContext(ctx context.Context, id int) error
+	EnqueueContext(ctx context.Context, event string, payload []byte) (int, error)
+	ClaimContext(ctx context.Context, limit int, lease time.Duration) ([]*WebhookDelivery, error)
+	RecordAttemptContext(ctx context.Context, a *WebhookAttempt, status string, next time.Time) error
+	RedeliverContext(ctx context.Context, id int) error
+	GetDeliveryContext(ctx context.Context, id int) (*WebhookDelivery, error)
+	DeliveriesContext(ctx context.Context, webhookID, limit int) ([]*WebhookDelivery, error)
+}
+
+// Transactor runs units of work: the calls fn makes to the stores with the
+// context it is given happen in one transaction, committed if fn returns nil
+// and rolled back otherwise.
+type Transactor interface {
+	WithTx(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+var (
+	_ ThreadStore       = (*ThreadModel)(nil)
+	_ PostStore         = (*PostModel)(nil)
+	_ UserStore         = (*UserModel)(nil)
+	_ ReadStore         = (*ReadModel)(nil)
+	_ ConversationStore = (*ConversationModel)(nil)
+	_ BlockStore        = (*BlockModel)(nil)
+	_ AttachmentStore   = (*AttachmentModel)(nil)
+	_ WebhookStore      = (*WebhookModel)(nil)
+	_ Transactor        = (*DB)(nil)
+)
@@ -1,29 +1,54 @@
 package models
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"forum/internal/cache"
 	"time"
 )
 
-// Thread holds data about a thread.
+// Thread holds data about a thread. Locked threads take no new posts.
 type Thread struct {
 	ID          int
 	Title       string
 	Author      *User
 	Created     time.Time
+	LastPostAt  time.Time
+	LastPoster  *User
+	LastPostID  int
+	ReplyCount  int
+	Locked      bool
+	Unread      bool
 	Posts       []*Post
 	FieldErrors map[string]string
 }
 
-// ThreadModel holds a database handle to manipulate a Thread.
+// ThreadModel holds a database handle to manipulate a Thread. The latest
+// threads are kept in Cache, if set, for CacheTTL.
 type ThreadModel struct {
-	DB *sql.DB
+	DB       *DB
+	Cache    cache.Cache
+	CacheTTL time.Duration
+}
+
+// Cache tags of the data derived from threads, and from the accounts of users,
+// such as their avatar.
+const (
+	TagThreads = "threads"
+	TagUsers   = "users"
+)
+
+// ThreadTag returns the cache tag of the data derived from a thread and its
+// posts.
+func ThreadTag(id int) string {
+	return fmt.Sprintf("thread:%d", id)
 }
 
 // NewThreadModel creates a Threads table and returns a ThreadModel.
-func NewThreadModel(db *sql.DB) (*ThreadModel, error) {
-	m := ThreadModel{db}
+func NewThreadModel(db *DB) (*ThreadModel, error) {
+	m := ThreadModel{DB: db}
 	err := m.createTable()
 	if err != nil {
 		return nil, fmt.Errorf("creating table: %w", err)
@@ -41,55 +66,118 @@ func (m *ThreadModel) createTable() error {
 		    created DATE NOT NULL
 		)
 	`
-	_, err := m.DB.Exec(stmt)
+	err := m.DB.createTables(stmt)
 	if err != nil {
 		return fmt.Errorf("creating Threads table: %w", err)
 	}
 	return nil
 }
 
-// Insert inserts a new thread in the database.
-func (m *ThreadModel) Insert(title string, authorId int) (int, error) {
+// InsertContext inserts a new thread in the database.
+func (m *ThreadModel) InsertContext(ctx context.Context, title string, authorId int) (int, error) {
+	ctx, done := m.DB.start(ctx, "ThreadModel.Insert")
+	defer done()
 	stmt := `
-		INSERT INTO Threads (title, author_id, created)
-		VALUES (?, ?, CURRENT_TIMESTAMP)
+		INSERT INTO Threads (title, author_id, created, last_post_at, last_post_author_id)
+		VALUES (?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ?)
 	`
-	result, err := m.DB.Exec(stmt, title, authorId)
+	id, err := m.DB.InsertContext(ctx, stmt, title, authorId, authorId)
 	if err != nil {
 		return 0, fmt.Errorf("inserting new thread in db: %w", err)
 	}
-	id, err := result.LastInsertId()
-	if err != nil {
-		return 0, fmt.Errorf("getting last thread id: %w", err)
+	if m.Cache != nil {
+		m.DB.afterCommit(ctx, func() { m.Cache.Invalidate(TagThreads) })
 	}
-	return int(id), nil
+	return id, nil
 }
 
-// Get retrieves the thread with the given id from the database.
-func (m *ThreadModel) Get(id int) (*Thread, error) {
+// GetContext retrieves the thread with the given id from the database.
+func (m *ThreadModel) GetContext(ctx context.Context, id int) (*Thread, error) {
+	ctx, done := m.DB.start(ctx, "ThreadModel.Get")
+	defer done()
 	stmt := `
-		SELECT T.id, T.title, T.created, U.id, U.username, U.email
-		FROM Threads T, Users U
-		WHERE T.author_id = U.id AND T.id = ?
+		SELECT T.id, T.title, T.created, U.id, U.username, U.avatar_version,
+		       T.last_post_at, T.last_post_id, T.reply_count,
+		       COALESCE(L.id, 0), COALESCE(L.username, ''), T.locked
+		FROM Threads T
+		JOIN Users U ON T.author_id = U.id
+		LEFT JOIN Users L ON T.last_post_author_id = L.id
+		WHERE T.id = ?
 	`
-	row := m.DB.QueryRow(stmt, id)
-	t, err := m.newThread(row, "ASC")
+	t, err := scanThread(m.DB.QueryRowContext(ctx, stmt, id))
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
 		return nil, fmt.Errorf("creating new thread: %w", err)
 	}
+	t.Posts, err = m.getPosts(ctx, t.ID, "ASC")
+	if err != nil {
+		return nil, fmt.Errorf("getting posts with thread id %v: %w", t.ID, err)
+	}
 	return t, nil
 }
 
-// Latests retrieves the 10 latests threads from the database.
-func (m *ThreadModel) Latests() ([]*Thread, error) {
+// ExistsContext checks if a thread with the given id exists.
+func (m *ThreadModel) ExistsContext(ctx context.Context, id int) (bool, error) {
+	ctx, done := m.DB.start(ctx, "ThreadModel.Exists")
+	defer done()
+	var exists bool
+	err := m.DB.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM Threads WHERE id = ?)`, id).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("checking thread existence: %w", err)
+	}
+	return exists, nil
+}
+
+// LatestsContext retrieves the limit threads with the most recent activity
+// from the database, or from the cache. Each thread carries its latest post
+// only.
+func (m *ThreadModel) LatestsContext(ctx context.Context, limit int) ([]*Thread, error) {
+	if m.Cache == nil || m.DB.txFrom(ctx) != nil {
+		return m.latests(ctx, limit)
+	}
+	key := fmt.Sprintf("threads:latests:%d", limit)
+	v, err := m.Cache.GetOrLoad(key, m.CacheTTL, []string{TagThreads, TagUsers}, func() (any, error) {
+		// The threads are shared by the callers waiting for them, so
+		// the load is not cancelled with the request of the first one.
+		return m.latests(context.WithoutCancel(ctx), limit)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Callers flag the threads they get, so each gets its own copies.
+	cached := v.([]*Thread)
+	threads := make([]*Thread, len(cached))
+	for i, t := range cached {
+		c := *t
+		threads[i] = &c
+	}
+	return threads, nil
+}
+
+func (m *ThreadModel) latests(ctx context.Context, limit int) ([]*Thread, error) {
+	ctx, done := m.DB.start(ctx, "ThreadModel.Latests")
+	defer done()
 	stmt := `
-		SELECT T.id, T.title, T.created, U.id, U.username, U.email
-		FROM Threads T, Users U
-		WHERE T.author_id = U.id
-		ORDER BY T.created DESC
-		LIMIT 9
+		SELECT T.id, T.title, T.created, U.id, U.username, U.avatar_version,
+		       T.last_post_at, T.last_post_id, T.reply_count,
+		       COALESCE(L.id, 0), COALESCE(L.username, ''), T.locked,
+		       P.id, P.body, P.created, PU.id, PU.username, PU.avatar_version
+		FROM Threads T
+		JOIN Users U ON T.author_id = U.id
+		LEFT JOIN Users L ON T.last_post_author_id = L.id
+		LEFT JOIN Posts P ON P.id = (
+		    SELECT id FROM Posts WHERE thread_id = T.id
+		    ORDER BY created DESC, id DESC
+		    LIMIT 1
+		)
+		LEFT JOIN Users PU ON P.author_id = PU.id
+		ORDER BY T.last_post_at DESC, T.id DESC
+		LIMIT ?
 	`
-	rows, err := m.DB.Query(stmt)
+	rows, err := m.DB.QueryContext(ctx, stmt, limit)
 	if err != nil {
 		return nil, fmt.Errorf("getting latests threads: %w", err)
 	}
@@ -97,59 +185,276 @@ func (m *ThreadModel) Latests() ([]*Thread, error) {
 
 	var threads []*Thread
 	for rows.Next() {
-		t, err := m.newThread(rows, "DESC")
+		var (
+			postID, authorID, avatarVersion sql.NullInt64
+			body, username                  sql.NullString
+			created                         sql.NullTime
+		)
+		t, err := scanThread(rows, &postID, &body, &created, &authorID, &username, &avatarVersion)
 		if err != nil {
 			return nil, fmt.Errorf("creating thread: %w", err)
 		}
+		if postID.Valid {
+			t.Posts = []*Post{{
+				ID:      int(postID.Int64),
+				Body:    body.String,
+				Created: created.Time,
+				Author: &User{
+					ID:            int(authorID.Int64),
+					Username:      username.String,
+					AvatarVersion: int(avatarVersion.Int64),
+				},
+			}}
+		}
 		threads = append(threads, t)
 	}
 	if err = rows.Err(); err != nil {
 		return nil, fmt.Errorf("iterating over rows for latests threads: %w", err)
 	}
+	return threads, nil
+}
+
+// NewestContext retrieves the most recently created threads, without their
+// posts.
+func (m *ThreadModel) NewestContext(ctx context.Context, limit int) ([]*Thread, error) {
+	ctx, done := m.DB.start(ctx, "ThreadModel.Newest")
+	defer done()
+	stmt := `
+		SELECT T.id, T.title, T.created, U.id, U.username
+		FROM Threads T
+		JOIN Users U ON T.author_id = U.id
+		ORDER BY T.created DESC, T.id DESC
+		LIMIT ?
+	`
+	rows, err := m.DB.QueryContext(ctx, stmt, limit)
+	if err != nil {
+		return nil, fmt.Errorf("getting newest threads: %w", err)
+	}
+	defer rows.Close()
+
+	var threads []*Thread
+	for rows.Next() {
+		var (
+			t Thread
+			u User
+		)
+		err := rows.Scan(&t.ID, &t.Title, &t.Created, &u.ID, &u.Username)
+		if err != nil {
+			return nil, fmt.Errorf("scanning thread: %w", err)
+		}
+		t.Author = &u
+		threads = append(threads, &t)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating over rows for newest threads: %w", err)
+	}
 
 	return threads, nil
 }
 
+// SetLockedContext locks or unlocks the thread.
+func (m *ThreadModel) SetLockedContext(ctx context.Context, id int, locked bool) error {
+	ctx, done := m.DB.start(ctx, "ThreadModel.SetLocked")
+	defer done()
+	result, err := m.DB.ExecContext(ctx, `UPDATE Threads SET locked = ? WHERE id = ?`, locked, id)
+	if err != nil {
+		return fmt.Errorf("locking thread: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("locking thread: %w", err)
+	}
+	if n == 0 {
+		return ErrNoRecord
+	}
+	if m.Cache != nil {
+		m.DB.afterCommit(ctx, func() { m.Cache.Invalidate(TagThreads, ThreadTag(id)) })
+	}
+	return nil
+}
+
+// DeleteContext removes the thread with its posts, their attachments and the
+// read marks of the thread. It returns the number of posts deleted and the
+// storage keys of the attachments, whose files the caller removes from the
+// blob store.
+func (m *ThreadModel) DeleteContext(ctx context.Context, id int) (int, []string, error) {
+	ctx, done := m.DB.start(ctx, "ThreadModel.Delete")
+	defer done()
+	var (
+		deleted int
+		keys    []string
+	)
+	err := m.DB.WithTx(ctx, func(ctx context.Context) error {
+		var err error
+		keys, deleted, err = m.DB.deletePosts(ctx, `thread_id = ?`, id)
+		if err != nil {
+			return err
+		}
+		err = m.DB.deleteReads(ctx, id)
+		if err != nil {
+			return err
+		}
+		result, err := m.DB.ExecContext(ctx, `DELETE FROM Threads WHERE id = ?`, id)
+		if err != nil {
+			return fmt.Errorf("deleting thread: %w", err)
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("deleting thread: %w", err)
+		}
+		if n == 0 {
+			return ErrNoRecord
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, nil, err
+	}
+	if m.Cache != nil {
+		m.DB.afterCommit(ctx, func() { m.Cache.Invalidate(TagThreads, ThreadTag(id)) })
+	}
+	return deleted, keys, nil
+}
+
+// MoveContext moves the posts of the thread to the thread targetID, then
+// deletes the emptied thread. It returns the number of posts moved, and
+// ErrNoRecord, naming the thread, if either is missing.
+func (m *ThreadModel) MoveContext(ctx context.Context, id, targetID int) (int, error) {
+	if id == targetID {
+		return 0, errors.New("moving a thread to itself")
+	}
+	ctx, done := m.DB.start(ctx, "ThreadModel.Move")
+	defer done()
+	var moved int
+	err := m.DB.WithTx(ctx, func(ctx context.Context) error {
+		var exists bool
+		err := m.DB.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM Threads WHERE id = ?)`, targetID).Scan(&exists)
+		if err != nil {
+			return fmt.Errorf("checking thread existence: %w", err)
+		}
+		if !exists {
+			return fmt.Errorf("thread %d: %w", targetID, ErrNoRecord)
+		}
+
+		result, err := m.DB.ExecContext(ctx, `UPDATE Posts SET thread_id = ? WHERE thread_id = ?`, targetID, id)
+		if err != nil {
+			return fmt.Errorf("moving posts: %w", err)
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("moving posts: %w", err)
+		}
+		moved = int(n)
+		err = m.DB.deleteReads(ctx, id)
+		if err != nil {
+			return err
+		}
+		result, err = m.DB.ExecContext(ctx, `DELETE FROM Threads WHERE id = ?`, id)
+		if err != nil {
+			return fmt.Errorf("deleting thread: %w", err)
+		}
+		n, err = result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("deleting thread: %w", err)
+		}
+		if n == 0 {
+			return fmt.Errorf("thread %d: %w", id, ErrNoRecord)
+		}
+		return m.DB.recountThreads(ctx, targetID)
+	})
+	if err != nil {
+		return 0, err
+	}
+	if m.Cache != nil {
+		m.DB.afterCommit(ctx, func() { m.Cache.Invalidate(TagThreads, ThreadTag(id), ThreadTag(targetID)) })
+	}
+	return moved, nil
+}
+
+// deleteReads removes the read marks of a thread, if the read model has
+// created their table.
+func (db *DB) deleteReads(ctx context.Context, threadID int) error {
+	exists, err := db.hasTable(ctx, "ThreadReads")
+	if err != nil || !exists {
+		return err
+	}
+	_, err = db.ExecContext(ctx, `DELETE FROM ThreadReads WHERE thread_id = ?`, threadID)
+	if err != nil {
+		return fmt.Errorf("deleting read marks: %w", err)
+	}
+	return nil
+}
+
+// recountThreads recomputes the reply count and last post of the threads,
+// after posts were moved or deleted.
+func (db *DB) recountThreads(ctx context.Context, ids ...int) error {
+	stmt := `
+		UPDATE Threads SET
+		    reply_count = (SELECT COUNT(*) FROM Posts P WHERE P.thread_id = Threads.id),
+		    last_post_at = COALESCE(
+		        (SELECT MAX(P.created) FROM Posts P WHERE P.thread_id = Threads.id),
+		        Threads.created
+		    ),
+		    last_post_author_id = COALESCE(
+		        (SELECT P.author_id FROM Posts P WHERE P.thread_id = Threads.id
+		         ORDER BY P.created DESC, P.id DESC LIMIT 1),
+		        Threads.author_id
+		    ),
+		    last_post_id = COALESCE(
+		        (SELECT MAX(P.id) FROM Posts P WHERE P.thread_id = Threads.id), 0
+		    )
+		WHERE id = ?
+	`
+	for _, id := range ids {
+		_, err := db.ExecContext(ctx, stmt, id)
+		if err != nil {
+			return fmt.Errorf("recounting thread %d: %w", id, err)
+		}
+	}
+	return nil
+}
+
 // scanner implements the Scan function.
 type scanner interface {
 	Scan(dest ...any) error
 }
 
-// newThread creates a new Thread. It also creates a User to represent
-// the Thread's author, and the Posts associated with that Thread.
-func (m *ThreadModel) newThread(s scanner, postOrder string) (*Thread, error) {
+// scanThread creates a new Thread from a row. It also creates the Users
+// representing its author and last poster, but not its Posts. The columns
+// following those of the thread are scanned into dest.
+func scanThread(s scanner, dest ...any) (*Thread, error) {
 	var (
 		t Thread
 		u User
+		l User
 	)
-	err := s.Scan(
+	err := s.Scan(append([]any{
 		&t.ID, &t.Title, &t.Created,
-		&u.ID, &u.Username, &u.Email,
-	)
+		&u.ID, &u.Username, &u.AvatarVersion,
+		&t.LastPostAt, &t.LastPostID, &t.ReplyCount,
+		&l.ID, &l.Username, &t.Locked,
+	}, dest...)...)
 	if err != nil {
 		return nil, fmt.Errorf("scanning row: %w", err)
 	}
 	t.Author = &u
-	t.Posts, err = m.getPosts(t.ID, postOrder)
-	if err != nil {
-		return nil, fmt.Errorf("getting posts with thread id %v: %w", t.ID, err)
-	}
+	t.LastPoster = &l
 	return &t, nil
 }
 
 // getPosts retrieves all Posts related to the Thread with the given threadID.
 // The value of order must be "ASC" or "DESC".
-func (m *ThreadModel) getPosts(threadID int, order string) ([]*Post, error) {
+func (m *ThreadModel) getPosts(ctx context.Context, threadID int, order string) ([]*Post, error) {
 	stmt := fmt.Sprintf(
 		`
-			SELECT P.id, P.body, P.created, U.id, U.username, U.email
+			SELECT P.id, P.body, P.created, U.id, U.username, U.avatar_version
 			FROM Posts P, Users U
 			WHERE P.author_id = U.id AND P.thread_id = ?
-			ORDER BY P.created %v
+			ORDER BY P.created %v, P.id %v
 		`,
-		order,
+		order, order,
 	)
-	rows, err := m.DB.Query(stmt, threadID, order)
+	rows, err := m.DB.QueryContext(ctx, stmt, threadID)
 	if err != nil {
 		return nil, err
 	}
@@ -163,7 +468,7 @@ func (m *ThreadModel) getPosts(threadID int, order string) ([]*Post, error) {
 		)
 		err := rows.Scan(
 			&p.ID, &p.Body, &p.Created,
-			&u.ID, &u.Username, &u.Email,
+			&u.ID, &u.Username, &u.AvatarVersion,
 		)
 		if err != nil {
 			return nil, err
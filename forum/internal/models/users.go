@@ -1,29 +1,52 @@
 package models
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
+	"forum/internal/cache"
+	"slices"
 
 	"golang.org/x/crypto/bcrypt"
 )
 
-// User holds data about a user.
+// Roles a user can hold. Admins manage the forum's settings, such as its
+// webhooks.
+const (
+	RoleMember    = "member"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// Roles lists the roles a user can hold, from the least to the most
+// privileged.
+var Roles = []string{RoleMember, RoleModerator, RoleAdmin}
+
+// User holds data about a user. Email, HashedPassword, Role and Disabled are
+// only loaded by the UserModel lookups; users embedded in threads, posts and
+// conversations only carry their ID and Username. Disabled users can no
+// longer log in.
 type User struct {
 	ID             int
 	Username       string
 	Email          string
 	HashedPassword []byte
+	AvatarVersion  int
+	Role           string
+	Disabled       bool
 }
 
-// UserModel holds a database handle to manipulate a User.
+// UserModel holds a database handle to manipulate a User. The data derived
+// from users is invalidated in Cache, if set, when their avatar changes.
 type UserModel struct {
-	DB *sql.DB
+	DB    *DB
+	Cache cache.Cache
 }
 
 // NewUserModel creates a Users table and returns a new UserModel.
-func NewUserModel(db *sql.DB) (*UserModel, error) {
-	m := UserModel{db}
+func NewUserModel(db *DB) (*UserModel, error) {
+	m := UserModel{DB: db}
 	err := m.createTable()
 	if err != nil {
 		return nil, fmt.Errorf("creating table: %w", err)
@@ -41,56 +64,150 @@ func (m *UserModel) createTable() error {
 		    hashed_password TEXT NOT NULL
 		);
 	`
-	_, err := m.DB.Exec(stmt)
+	err := m.DB.createTables(stmt)
 	if err != nil {
 		return fmt.Errorf("creating Users table: %w", err)
 	}
 	return nil
 }
 
-// Insert adds a new record to the "Users" table.
-func (m *UserModel) Insert(username, email, password string) (int, error) {
+// InsertContext adds a new record to the "Users" table. It returns
+// ErrDuplicateUsername if the username is taken, ignoring case: the check
+// runs in the transaction of the insert, as the index on usernames is not
+// unique.
+func (m *UserModel) InsertContext(ctx context.Context, username, email, password string) (int, error) {
+	ctx, done := m.DB.start(ctx, "UserModel.Insert")
+	defer done()
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	if err != nil {
 		return 0, fmt.Errorf("hashing password: %w", err)
 	}
 
-	stmt := `
-		INSERT INTO Users (username, email, hashed_password)
-		VALUES (?, ?, ?)
-	`
-	result, err := m.DB.Exec(stmt, username, email, string(hashedPassword))
+	var id int
+	err = m.DB.WithTx(ctx, func(ctx context.Context) error {
+		exists, err := m.UsernameExistsContext(ctx, username)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return ErrDuplicateUsername
+		}
+		stmt := `
+			INSERT INTO Users (username, email, hashed_password, created)
+			VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+		`
+		id, err = m.DB.InsertContext(ctx, stmt, username, email, string(hashedPassword))
+		if isUniqueViolation(err, "Users", "email") {
+			return ErrDuplicateEmail
+		}
+		return err
+	})
 	if err != nil {
 		return 0, fmt.Errorf("inserting new user in db: %w", err)
 	}
+	return id, nil
+}
 
-	id, err := result.LastInsertId()
+// GetContext retrieves a user by their ID.
+func (m *UserModel) GetContext(ctx context.Context, id int) (*User, error) {
+	ctx, done := m.DB.start(ctx, "UserModel.Get")
+	defer done()
+	var user User
+	stmt := `SELECT id, username, email, hashed_password, role, disabled FROM Users WHERE id = ?`
+
+	err := m.DB.QueryRowContext(ctx, stmt, id).Scan(&user.ID, &user.Username, &user.Email, &user.HashedPassword, &user.Role, &user.Disabled)
 	if err != nil {
-		return 0, fmt.Errorf("getting last user id: %w", err)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, fmt.Errorf("querying user by ID: %w", err)
 	}
-	return int(id), nil
+	return &user, nil
 }
 
-// Get retrieves a user by their ID.
-func (m *UserModel) Get(id int) (*User, error) {
+// GetByUsernameContext retrieves a user by their username, ignoring case.
+// Accounts of old databases may share a username: the one spelled exactly
+// as asked is returned, or else the oldest one.
+func (m *UserModel) GetByUsernameContext(ctx context.Context, username string) (*User, error) {
+	ctx, done := m.DB.start(ctx, "UserModel.GetByUsername")
+	defer done()
 	var user User
-	stmt := `SELECT id, username, email, hashed_password FROM Users WHERE id = ?`
+	stmt := `SELECT id, username, email, hashed_password, role, disabled FROM Users WHERE ` + m.DB.Dialect.EqualFold("username") + `
+		ORDER BY username = ? DESC, id LIMIT 1`
 
-	err := m.DB.QueryRow(stmt, id).Scan(&user.ID, &user.Username, &user.Email, &user.HashedPassword)
+	err := m.DB.QueryRowContext(ctx, stmt, username, username).Scan(&user.ID, &user.Username, &user.Email, &user.HashedPassword, &user.Role, &user.Disabled)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, ErrNoRecord
 		}
-		return nil, fmt.Errorf("querying user by ID: %w", err)
+		return nil, fmt.Errorf("querying user by username: %w", err)
 	}
 	return &user, nil
 }
 
-// Exists checks if a user with the given email exists.
-func (m *UserModel) Exists(email string) (bool, error) {
+// UsernameExistsContext checks if a user with the given username exists,
+// ignoring case.
+func (m *UserModel) UsernameExistsContext(ctx context.Context, username string) (bool, error) {
+	ctx, done := m.DB.start(ctx, "UserModel.UsernameExists")
+	defer done()
+	stmt := `SELECT id FROM Users WHERE ` + m.DB.Dialect.EqualFold("username") + ` LIMIT 1`
+	var id int
+	err := m.DB.QueryRowContext(ctx, stmt, username).Scan(&id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, nil
+		}
+		return false, fmt.Errorf("checking username existence: %w", err)
+	}
+	return true, nil
+}
+
+// SetAvatarVersionContext moves the avatar version of the user from old to
+// version, once the avatar files of the new version are saved. It returns
+// ErrNoRecord if the user does not exist or their version is no longer old.
+func (m *UserModel) SetAvatarVersionContext(ctx context.Context, id, old, version int) error {
+	ctx, done := m.DB.start(ctx, "UserModel.SetAvatarVersion")
+	defer done()
+	stmt := `UPDATE Users SET avatar_version = ? WHERE id = ? AND avatar_version = ?`
+	result, err := m.DB.ExecContext(ctx, stmt, version, id, old)
+	if err != nil {
+		return fmt.Errorf("setting avatar version: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("setting avatar version: %w", err)
+	}
+	if n == 0 {
+		return ErrNoRecord
+	}
+	if m.Cache != nil {
+		m.DB.afterCommit(ctx, func() { m.Cache.Invalidate(TagUsers) })
+	}
+	return nil
+}
+
+// RoleContext returns the role of the user.
+func (m *UserModel) RoleContext(ctx context.Context, id int) (string, error) {
+	ctx, done := m.DB.start(ctx, "UserModel.Role")
+	defer done()
+	var role string
+	err := m.DB.QueryRowContext(ctx, `SELECT role FROM Users WHERE id = ?`, id).Scan(&role)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrNoRecord
+		}
+		return "", fmt.Errorf("querying user role: %w", err)
+	}
+	return role, nil
+}
+
+// ExistsContext checks if a user with the given email exists.
+func (m *UserModel) ExistsContext(ctx context.Context, email string) (bool, error) {
+	ctx, done := m.DB.start(ctx, "UserModel.Exists")
+	defer done()
 	stmt := `SELECT id FROM Users WHERE email = ? LIMIT 1`
 	var id int
-	err := m.DB.QueryRow(stmt, email).Scan(&id)
+	err := m.DB.QueryRowContext(ctx, stmt, email).Scan(&id)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return false, nil
@@ -100,13 +217,17 @@ func (m *UserModel) Exists(email string) (bool, error) {
 	return true, nil
 }
 
-// Authenticate verifies a user's credentials.
-func (m *UserModel) Authenticate(email, password string) (int, error) {
+// AuthenticateContext verifies a user's credentials. It returns ErrDisabled
+// if they are right but the user is disabled.
+func (m *UserModel) AuthenticateContext(ctx context.Context, email, password string) (int, error) {
+	ctx, done := m.DB.start(ctx, "UserModel.Authenticate")
+	defer done()
 	var id int
 	var hashedPassword []byte
-	stmt := `SELECT id, hashed_password FROM Users WHERE email = ?`
+	var disabled bool
+	stmt := `SELECT id, hashed_password, disabled FROM Users WHERE email = ?`
 
-	err := m.DB.QueryRow(stmt, email).Scan(&id, &hashedPassword)
+	err := m.DB.QueryRowContext(ctx, stmt, email).Scan(&id, &hashedPassword, &disabled)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return 0, ErrInvalidCredentials
@@ -121,6 +242,80 @@ func (m *UserModel) Authenticate(email, password string) (int, error) {
 		}
 		return 0, fmt.Errorf("verifying password: %w", err)
 	}
+	if disabled {
+		return 0, ErrDisabled
+	}
 
 	return id, nil
 }
+
+// ListContext returns every user, in the order they signed up.
+func (m *UserModel) ListContext(ctx context.Context) ([]*User, error) {
+	ctx, done := m.DB.start(ctx, "UserModel.List")
+	defer done()
+	stmt := `SELECT id, username, email, role, disabled FROM Users ORDER BY id`
+	rows, err := m.DB.QueryContext(ctx, stmt)
+	if err != nil {
+		return nil, fmt.Errorf("listing users: %w", err)
+	}
+	defer rows.Close()
+
+	var users []*User
+	for rows.Next() {
+		var u User
+		err := rows.Scan(&u.ID, &u.Username, &u.Email, &u.Role, &u.Disabled)
+		if err != nil {
+			return nil, fmt.Errorf("scanning user: %w", err)
+		}
+		users = append(users, &u)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating over users: %w", err)
+	}
+	return users, nil
+}
+
+// SetRoleContext gives the user one of Roles.
+func (m *UserModel) SetRoleContext(ctx context.Context, id int, role string) error {
+	if !slices.Contains(Roles, role) {
+		return fmt.Errorf("unknown role %q", role)
+	}
+	ctx, done := m.DB.start(ctx, "UserModel.SetRole")
+	defer done()
+	return m.update(ctx, `UPDATE Users SET role = ? WHERE id = ?`, role, id)
+}
+
+// SetDisabledContext disables or enables the user.
+func (m *UserModel) SetDisabledContext(ctx context.Context, id int, disabled bool) error {
+	ctx, done := m.DB.start(ctx, "UserModel.SetDisabled")
+	defer done()
+	return m.update(ctx, `UPDATE Users SET disabled = ? WHERE id = ?`, disabled, id)
+}
+
+// SetPasswordContext replaces the password of the user.
+func (m *UserModel) SetPasswordContext(ctx context.Context, id int, password string) error {
+	ctx, done := m.DB.start(ctx, "UserModel.SetPassword")
+	defer done()
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("hashing password: %w", err)
+	}
+	return m.update(ctx, `UPDATE Users SET hashed_password = ? WHERE id = ?`, string(hashedPassword), id)
+}
+
+// update runs stmt, which updates the user whose id is its last argument, and
+// returns ErrNoRecord if there is no such user.
+func (m *UserModel) update(ctx context.Context, stmt string, args ...any) error {
+	result, err := m.DB.ExecContext(ctx, stmt, args...)
+	if err != nil {
+		return fmt.Errorf("updating user: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("updating user: %w", err)
+	}
+	if n == 0 {
+		return ErrNoRecord
+	}
+	return nil
+}
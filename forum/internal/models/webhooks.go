@@ -0,0 +1,510 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Statuses of a WebhookDelivery.
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// Webhook holds data about an endpoint notified of forum events.
+type Webhook struct {
+	ID      int
+	URL     string
+	Secret  string
+	Events  []string
+	Active  bool
+	Created time.Time
+}
+
+// Subscribes reports whether the webhook wants to be notified of event.
+func (w *Webhook) Subscribes(event string) bool {
+	return slices.Contains(w.Events, event)
+}
+
+// WebhookDelivery is an event queued for delivery to a webhook, along with
+// the log of the attempts made so far.
+type WebhookDelivery struct {
+	ID            int
+	WebhookID     int
+	URL           string
+	Secret        string
+	Event         string
+	Payload       []byte
+	Status        string
+	Attempts      int
+	NextAttemptAt time.Time
+	Created       time.Time
+	Log           []*WebhookAttempt
+}
+
+// WebhookAttempt records the outcome of one attempt to send a delivery.
+// StatusCode is 0 when no response was received.
+type WebhookAttempt struct {
+	ID         int
+	DeliveryID int
+	Attempted  time.Time
+	StatusCode int
+	Error      string
+	Duration   time.Duration
+}
+
+// WebhookModel holds a database handle to manipulate webhooks and their
+// queue of deliveries.
+type WebhookModel struct {
+	DB *DB
+}
+
+// NewWebhookModel creates the webhook tables and returns a new WebhookModel.
+func NewWebhookModel(db *DB) (*WebhookModel, error) {
+	m := WebhookModel{db}
+	err := m.createTable()
+	if err != nil {
+		return nil, fmt.Errorf("creating table: %w", err)
+	}
+	return &m, nil
+}
+
+// createTable creates the Webhooks, WebhookDeliveries and WebhookAttempts
+// tables.
+func (m *WebhookModel) createTable() error {
+	stmts := []string{
+		`
+			CREATE TABLE IF NOT EXISTS Webhooks (
+			    id INTEGER PRIMARY KEY,
+			    url TEXT NOT NULL,
+			    secret TEXT NOT NULL,
+			    events TEXT NOT NULL,
+			    active BOOLEAN NOT NULL DEFAULT TRUE,
+			    created DATE NOT NULL
+			);
+		`,
+		`
+			CREATE TABLE IF NOT EXISTS WebhookDeliveries (
+			    id INTEGER PRIMARY KEY,
+			    webhook_id INTEGER NOT NULL REFERENCES Webhooks,
+			    event TEXT NOT NULL,
+			    payload BLOB NOT NULL,
+			    status TEXT NOT NULL,
+			    attempts INTEGER NOT NULL DEFAULT 0,
+			    next_attempt_at DATE NOT NULL,
+			    created DATE NOT NULL
+			);
+		`,
+		`
+			CREATE INDEX IF NOT EXISTS webhook_deliveries_due
+			ON WebhookDeliveries (status, next_attempt_at);
+		`,
+		`
+			CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook
+			ON WebhookDeliveries (webhook_id, id);
+		`,
+		`
+			CREATE TABLE IF NOT EXISTS WebhookAttempts (
+			    id INTEGER PRIMARY KEY,
+			    delivery_id INTEGER NOT NULL REFERENCES WebhookDeliveries,
+			    attempted DATE NOT NULL,
+			    status_code INTEGER NOT NULL,
+			    error TEXT NOT NULL,
+			    duration_ms INTEGER NOT NULL
+			);
+		`,
+		`
+			CREATE INDEX IF NOT EXISTS webhook_attempts_delivery
+			ON WebhookAttempts (delivery_id, id);
+		`,
+	}
+	err := m.DB.createTables(stmts...)
+	if err != nil {
+		return fmt.Errorf("creating webhook tables: %w", err)
+	}
+	return nil
+}
+
+// InsertContext adds a webhook notified of the given events.
+func (m *WebhookModel) InsertContext(ctx context.Context, url, secret string, events []string) (int, error) {
+	ctx, done := m.DB.start(ctx, "WebhookModel.Insert")
+	defer done()
+	stmt := `
+		INSERT INTO Webhooks (url, secret, events, created)
+		VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+	`
+	id, err := m.DB.InsertContext(ctx, stmt, url, secret, strings.Join(events, ","))
+	if err != nil {
+		return 0, fmt.Errorf("inserting new webhook in db: %w", err)
+	}
+	return id, nil
+}
+
+// GetContext retrieves the webhook with the given id.
+func (m *WebhookModel) GetContext(ctx context.Context, id int) (*Webhook, error) {
+	ctx, done := m.DB.start(ctx, "WebhookModel.Get")
+	defer done()
+	stmt := `SELECT id, url, secret, events, active, created FROM Webhooks WHERE id = ?`
+	w, err := scanWebhook(m.DB.QueryRowContext(ctx, stmt, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, fmt.Errorf("querying webhook: %w", err)
+	}
+	return w, nil
+}
+
+// AllContext returns every webhook, oldest first.
+func (m *WebhookModel) AllContext(ctx context.Context) ([]*Webhook, error) {
+	ctx, done := m.DB.start(ctx, "WebhookModel.All")
+	defer done()
+	stmt := `SELECT id, url, secret, events, active, created FROM Webhooks ORDER BY id`
+	rows, err := m.DB.QueryContext(ctx, stmt)
+	if err != nil {
+		return nil, fmt.Errorf("getting webhooks: %w", err)
+	}
+	defer rows.Close()
+
+	var webhooks []*Webhook
+	for rows.Next() {
+		w, err := scanWebhook(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scanning webhook: %w", err)
+		}
+		webhooks = append(webhooks, w)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating over webhooks: %w", err)
+	}
+	return webhooks, nil
+}
+
+// scanWebhook reads a webhook from a row selected by Get or All.
+func scanWebhook(row interface{ Scan(...any) error }) (*Webhook, error) {
+	var w Webhook
+	var events string
+	err := row.Scan(&w.ID, &w.URL, &w.Secret, &events, &w.Active, &w.Created)
+	if err != nil {
+		return nil, err
+	}
+	if events != "" {
+		w.Events = strings.Split(events, ",")
+	}
+	return &w, nil
+}
+
+// SetActiveContext enables or disables a webhook. Events raised while a
+// webhook is disabled are not queued for it.
+func (m *WebhookModel) SetActiveContext(ctx context.Context, id int, active bool) error {
+	ctx, done := m.DB.start(ctx, "WebhookModel.SetActive")
+	defer done()
+	_, err := m.DB.ExecContext(ctx, `UPDATE Webhooks SET active = ? WHERE id = ?`, active, id)
+	if err != nil {
+		return fmt.Errorf("updating webhook: %w", err)
+	}
+	return nil
+}
+
+// DeleteContext removes a webhook along with its deliveries and their log.
+func (m *WebhookModel) DeleteContext(ctx context.Context, id int) error {
+	ctx, done := m.DB.start(ctx, "WebhookModel.Delete")
+	defer done()
+	tx, err := m.DB.BeginTx(ctx)
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmts := []string{
+		`
+			DELETE FROM WebhookAttempts WHERE delivery_id IN (
+			    SELECT id FROM WebhookDeliveries WHERE webhook_id = ?
+			)
+		`,
+		`DELETE FROM WebhookDeliveries WHERE webhook_id = ?`,
+		`DELETE FROM Webhooks WHERE id = ?`,
+	}
+	for _, stmt := range stmts {
+		_, err := tx.ExecContext(ctx, stmt, id)
+		if err != nil {
+			return fmt.Errorf("deleting webhook: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// EnqueueContext queues a delivery of the payload to every active webhook
+// subscribed to event, and returns the number of deliveries queued. Called
+// in the unit of work of the action that raised event, the deliveries are
+// only queued if it commits.
+func (m *WebhookModel) EnqueueContext(ctx context.Context, event string, payload []byte) (int, error) {
+	ctx, done := m.DB.start(ctx, "WebhookModel.Enqueue")
+	defer done()
+
+	stmt := `
+		INSERT INTO WebhookDeliveries (webhook_id, event, payload, status, next_attempt_at, created)
+		VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+	`
+	n := 0
+	err := m.DB.WithTx(ctx, func(ctx context.Context) error {
+		webhooks, err := m.AllContext(ctx)
+		if err != nil {
+			return err
+		}
+		now := time.Now().UTC()
+		for _, w := range webhooks {
+			if !w.Active || !w.Subscribes(event) {
+				continue
+			}
+			_, err := m.DB.ExecContext(ctx, stmt, w.ID, event, payload, DeliveryPending, now)
+			if err != nil {
+				return fmt.Errorf("queuing delivery to webhook %d: %w", w.ID, err)
+			}
+			n++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// ClaimContext returns up to limit pending deliveries that are due, and
+// postpones their next attempt by lease so that they are not claimed again
+// while being sent. A delivery whose sender dies is retried once the lease
+// expires.
+func (m *WebhookModel) ClaimContext(ctx context.Context, limit int, lease time.Duration) ([]*WebhookDelivery, error) {
+	ctx, done := m.DB.start(ctx, "WebhookModel.Claim")
+	defer done()
+	tx, err := m.DB.BeginTx(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt := `
+		SELECT D.id, D.webhook_id, W.url, W.secret, D.event, D.payload, D.status,
+		       D.attempts, D.next_attempt_at, D.created
+		FROM WebhookDeliveries D
+		JOIN Webhooks W ON D.webhook_id = W.id
+		WHERE D.status = ? AND D.next_attempt_at <= ?
+		ORDER BY D.next_attempt_at, D.id
+		LIMIT ?
+	` + m.DB.Dialect.SkipLocked("D")
+	now := time.Now().UTC()
+	rows, err := tx.QueryContext(ctx, stmt, DeliveryPending, now, limit)
+	if err != nil {
+		return nil, fmt.Errorf("getting due deliveries: %w", err)
+	}
+	deliveries, err := scanDeliveries(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, d := range deliveries {
+		_, err := tx.ExecContext(ctx,
+			`UPDATE WebhookDeliveries SET next_attempt_at = ? WHERE id = ?`,
+			now.Add(lease), d.ID,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("leasing delivery %d: %w", d.ID, err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, fmt.Errorf("committing claim: %w", err)
+	}
+	return deliveries, nil
+}
+
+// RecordAttemptContext logs an attempt to send a delivery and sets its new
+// status. A pending delivery is attempted again at next.
+func (m *WebhookModel) RecordAttemptContext(ctx context.Context, a *WebhookAttempt, status string, next time.Time) error {
+	ctx, done := m.DB.start(ctx, "WebhookModel.RecordAttempt")
+	defer done()
+	tx, err := m.DB.BeginTx(ctx)
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt := `
+		INSERT INTO WebhookAttempts (delivery_id, attempted, status_code, error, duration_ms)
+		VALUES (?, ?, ?, ?, ?)
+	`
+	_, err = tx.ExecContext(ctx, stmt, a.DeliveryID, a.Attempted.UTC(), a.StatusCode, a.Error, a.Duration.Milliseconds())
+	if err != nil {
+		return fmt.Errorf("logging delivery attempt: %w", err)
+	}
+
+	stmt = `
+		UPDATE WebhookDeliveries
+		SET attempts = attempts + 1, status = ?, next_attempt_at = ?
+		WHERE id = ?
+	`
+	_, err = tx.ExecContext(ctx, stmt, status, next.UTC(), a.DeliveryID)
+	if err != nil {
+		return fmt.Errorf("updating delivery: %w", err)
+	}
+	return tx.Commit()
+}
+
+// RedeliverContext queues a delivery again for an immediate attempt, with a
+// fresh budget of retries. Its log of previous attempts is kept.
+func (m *WebhookModel) RedeliverContext(ctx context.Context, id int) error {
+	ctx, done := m.DB.start(ctx, "WebhookModel.Redeliver")
+	defer done()
+	stmt := `
+		UPDATE WebhookDeliveries SET status = ?, attempts = 0, next_attempt_at = ?
+		WHERE id = ?
+	`
+	result, err := m.DB.ExecContext(ctx, stmt, DeliveryPending, time.Now().UTC(), id)
+	if err != nil {
+		return fmt.Errorf("requeuing delivery: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("requeuing delivery: %w", err)
+	}
+	if n == 0 {
+		return ErrNoRecord
+	}
+	return nil
+}
+
+// GetDeliveryContext retrieves a delivery along with its log of attempts.
+func (m *WebhookModel) GetDeliveryContext(ctx context.Context, id int) (*WebhookDelivery, error) {
+	ctx, done := m.DB.start(ctx, "WebhookModel.GetDelivery")
+	defer done()
+	stmt := `
+		SELECT D.id, D.webhook_id, W.url, W.secret, D.event, D.payload, D.status,
+		       D.attempts, D.next_attempt_at, D.created
+		FROM WebhookDeliveries D
+		JOIN Webhooks W ON D.webhook_id = W.id
+		WHERE D.id = ?
+	`
+	rows, err := m.DB.QueryContext(ctx, stmt, id)
+	if err != nil {
+		return nil, fmt.Errorf("getting delivery: %w", err)
+	}
+	deliveries, err := scanDeliveries(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(deliveries) == 0 {
+		return nil, ErrNoRecord
+	}
+
+	err = m.loadAttempts(ctx, deliveries)
+	if err != nil {
+		return nil, err
+	}
+	return deliveries[0], nil
+}
+
+// DeliveriesContext returns the latest deliveries to a webhook, newest first,
+// along with their log of attempts.
+func (m *WebhookModel) DeliveriesContext(ctx context.Context, webhookID, limit int) ([]*WebhookDelivery, error) {
+	ctx, done := m.DB.start(ctx, "WebhookModel.Deliveries")
+	defer done()
+	stmt := `
+		SELECT D.id, D.webhook_id, W.url, W.secret, D.event, D.payload, D.status,
+		       D.attempts, D.next_attempt_at, D.created
+		FROM WebhookDeliveries D
+		JOIN Webhooks W ON D.webhook_id = W.id
+		WHERE D.webhook_id = ?
+		ORDER BY D.id DESC
+		LIMIT ?
+	`
+	rows, err := m.DB.QueryContext(ctx, stmt, webhookID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("getting deliveries: %w", err)
+	}
+	deliveries, err := scanDeliveries(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	err = m.loadAttempts(ctx, deliveries)
+	if err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// scanDeliveries reads and closes rows of deliveries.
+func scanDeliveries(rows *sql.Rows) ([]*WebhookDelivery, error) {
+	defer rows.Close()
+
+	var deliveries []*WebhookDelivery
+	for rows.Next() {
+		var d WebhookDelivery
+		err := rows.Scan(
+			&d.ID, &d.WebhookID, &d.URL, &d.Secret, &d.Event, &d.Payload, &d.Status,
+			&d.Attempts, &d.NextAttemptAt, &d.Created,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("scanning delivery: %w", err)
+		}
+		deliveries = append(deliveries, &d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating over deliveries: %w", err)
+	}
+	return deliveries, nil
+}
+
+// loadAttempts loads the log of each delivery into its Log field.
+func (m *WebhookModel) loadAttempts(ctx context.Context, deliveries []*WebhookDelivery) error {
+	if len(deliveries) == 0 {
+		return nil
+	}
+
+	args := make([]any, len(deliveries))
+	placeholders := make([]string, len(deliveries))
+	byID := make(map[int]*WebhookDelivery, len(deliveries))
+	for i, d := range deliveries {
+		args[i] = d.ID
+		placeholders[i] = "?"
+		byID[d.ID] = d
+	}
+
+	stmt := fmt.Sprintf(
+		`
+			SELECT id, delivery_id, attempted, status_code, error, duration_ms
+			FROM WebhookAttempts WHERE delivery_id IN (%s)
+			ORDER BY id
+		`,
+		strings.Join(placeholders, ", "),
+	)
+	rows, err := m.DB.QueryContext(ctx, stmt, args...)
+	if err != nil {
+		return fmt.Errorf("getting delivery attempts: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var a WebhookAttempt
+		var ms int64
+		err := rows.Scan(&a.ID, &a.DeliveryID, &a.Attempted, &a.StatusCode, &a.Error, &ms)
+		if err != nil {
+			return fmt.Errorf("scanning delivery attempt: %w", err)
+		}
+		a.Duration = time.Duration(ms) * time.Millisecond
+		d := byID[a.DeliveryID]
+		d.Log = append(d.Log, &a)
+	}
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("iterating over delivery attempts: %w", err)
+	}
+	return nil
+}
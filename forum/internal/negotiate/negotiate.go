@@ -0,0 +1,37 @@
+// Package negotiate picks a content coding from an Accept-Encoding header.
+package negotiate
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Encoding returns the coding preferred by an Accept-Encoding header among
+// offers, which are listed in the server's order of preference. It returns
+// "" when the client accepts none of them, meaning the identity.
+func Encoding(accept string, offers ...string) string {
+	q := map[string]float64{}
+	for _, part := range strings.Split(accept, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		weight := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(v, 64)
+			if err == nil {
+				weight = parsed
+			}
+		}
+		q[strings.ToLower(strings.TrimSpace(coding))] = weight
+	}
+
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		weight, ok := q[offer]
+		if !ok {
+			weight, ok = q["*"]
+		}
+		if ok && weight > bestQ {
+			best, bestQ = offer, weight
+		}
+	}
+	return best
+}
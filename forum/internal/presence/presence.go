@@ -0,0 +1,263 @@
+// Package presence tracks who is viewing and typing in each thread, and who
+// has been active on the forum recently. Changes to the viewers of a thread
+// are published as diffs on a pubsub topic, for the connections following
+// that thread to relay.
+package presence
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"forum/internal/pubsub"
+)
+
+// Types of the messages published on the topic of a thread.
+const (
+	MessageSnapshot = "snapshot"
+	MessageJoin     = "join"
+	MessageLeave    = "leave"
+	MessageTyping   = "typing"
+	MessageIdle     = "idle"
+)
+
+// User identifies a member in presence messages.
+type User struct {
+	ID       int    `json:"id"`
+	Username string `json:"username"`
+}
+
+// Message is a change to the presence in a thread, or the full state of it
+// when Type is MessageSnapshot.
+type Message struct {
+	Type    string `json:"type"`
+	User    *User  `json:"user,omitempty"`
+	Viewers []User `json:"viewers,omitempty"`
+	Typing  []User `json:"typing,omitempty"`
+}
+
+// Topic returns the pubsub topic on which the presence changes of a thread
+// are published.
+func Topic(threadID int) string {
+	return fmt.Sprintf("presence:%d", threadID)
+}
+
+type viewer struct {
+	user        User
+	conns       int
+	expires     time.Time
+	typingUntil time.Time
+}
+
+// Tracker records presence. Its zero value is not usable: create one with
+// NewTracker.
+type Tracker struct {
+	// ViewerTTL is how long a viewer is kept without news from any of its
+	// connections.
+	ViewerTTL time.Duration
+	// TypingTTL is how long a member is shown as typing after their last
+	// keystroke.
+	TypingTTL time.Duration
+	// OnlineWindow is how long a member who loaded a page counts as online.
+	OnlineWindow time.Duration
+
+	mu      sync.Mutex
+	hub     pubsub.Publisher
+	threads map[int]map[int]*viewer
+	seen    map[int]seenUser
+}
+
+type seenUser struct {
+	user User
+	at   time.Time
+}
+
+// NewTracker returns a Tracker publishing presence changes on hub.
+func NewTracker(hub pubsub.Publisher) *Tracker {
+	return &Tracker{
+		ViewerTTL:    time.Minute,
+		TypingTTL:    6 * time.Second,
+		OnlineWindow: 5 * time.Minute,
+		hub:          hub,
+		threads:      map[int]map[int]*viewer{},
+		seen:         map[int]seenUser{},
+	}
+}
+
+// Join records a new connection of user to a thread, and returns the
+// current state of the thread.
+func (t *Tracker) Join(threadID int, user User) Message {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	t.seen[user.ID] = seenUser{user, now}
+
+	viewers, ok := t.threads[threadID]
+	if !ok {
+		viewers = map[int]*viewer{}
+		t.threads[threadID] = viewers
+	}
+	v, ok := viewers[user.ID]
+	if !ok {
+		v = &viewer{user: user}
+		viewers[user.ID] = v
+		t.publish(threadID, Message{Type: MessageJoin, User: &v.user})
+	}
+	v.conns++
+	v.expires = now.Add(t.ViewerTTL)
+	return t.snapshot(threadID)
+}
+
+// Touch keeps user in the viewers of a thread.
+func (t *Tracker) Touch(threadID, userID int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if v, ok := t.threads[threadID][userID]; ok {
+		v.expires = time.Now().Add(t.ViewerTTL)
+	}
+}
+
+// Leave records that a connection of user to a thread closed. They leave
+// the thread when their last connection does.
+func (t *Tracker) Leave(threadID, userID int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	v, ok := t.threads[threadID][userID]
+	if !ok {
+		return
+	}
+	v.conns--
+	if v.conns <= 0 {
+		t.remove(threadID, v)
+	}
+}
+
+// Typing records that user is typing a reply in a thread.
+func (t *Tracker) Typing(threadID, userID int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	v, ok := t.threads[threadID][userID]
+	if !ok {
+		return
+	}
+	now := time.Now()
+	if v.typingUntil.Before(now) {
+		t.publish(threadID, Message{Type: MessageTyping, User: &v.user})
+	}
+	v.typingUntil = now.Add(t.TypingTTL)
+	v.expires = now.Add(t.ViewerTTL)
+}
+
+// Seen records that user loaded a page.
+func (t *Tracker) Seen(user User) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.seen[user.ID] = seenUser{user, time.Now()}
+}
+
+// Online returns the members seen within OnlineWindow, sorted by username.
+func (t *Tracker) Online() []User {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	since := time.Now().Add(-t.OnlineWindow)
+	var users []User
+	for _, s := range t.seen {
+		if s.at.After(since) {
+			users = append(users, s.user)
+		}
+	}
+	sortUsers(users)
+	return users
+}
+
+// Run expires stale viewers, typing indicators and online members until ctx
+// is cancelled.
+func (t *Tracker) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			t.sweep(time.Now())
+		}
+	}
+}
+
+// sweep expires what is stale at now.
+func (t *Tracker) sweep(now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for threadID, viewers := range t.threads {
+		for _, v := range viewers {
+			if v.expires.Before(now) {
+				t.remove(threadID, v)
+				continue
+			}
+			if !v.typingUntil.IsZero() && v.typingUntil.Before(now) {
+				v.typingUntil = time.Time{}
+				t.publish(threadID, Message{Type: MessageIdle, User: &v.user})
+			}
+		}
+	}
+
+	since := now.Add(-t.OnlineWindow)
+	for id, s := range t.seen {
+		if s.at.Before(since) {
+			delete(t.seen, id)
+		}
+	}
+}
+
+// remove takes v out of the viewers of a thread. t.mu must be held.
+func (t *Tracker) remove(threadID int, v *viewer) {
+	viewers := t.threads[threadID]
+	delete(viewers, v.user.ID)
+	if len(viewers) == 0 {
+		delete(t.threads, threadID)
+	}
+	t.publish(threadID, Message{Type: MessageLeave, User: &v.user})
+}
+
+// snapshot returns the state of a thread. t.mu must be held.
+func (t *Tracker) snapshot(threadID int) Message {
+	now := time.Now()
+	msg := Message{Type: MessageSnapshot}
+	for _, v := range t.threads[threadID] {
+		msg.Viewers = append(msg.Viewers, v.user)
+		if v.typingUntil.After(now) {
+			msg.Typing = append(msg.Typing, v.user)
+		}
+	}
+	sortUsers(msg.Viewers)
+	sortUsers(msg.Typing)
+	return msg
+}
+
+// publish sends msg to the connections following a thread. t.mu must be
+// held, so that messages are published in the order of the changes.
+func (t *Tracker) publish(threadID int, msg Message) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	t.hub.Publish(Topic(threadID), pubsub.Event{Type: msg.Type, Data: data})
+}
+
+func sortUsers(users []User) {
+	slices.SortFunc(users, func(a, b User) int {
+		return strings.Compare(strings.ToLower(a.Username), strings.ToLower(b.Username))
+	})
+}
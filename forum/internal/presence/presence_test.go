@@ -0,0 +1,146 @@
+package presence
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"forum/internal/pubsub"
+)
+
+// recorder is a pubsub.Publisher keeping the messages published.
+type recorder struct {
+	topics   []string
+	messages []Message
+}
+
+func (r *recorder) Publish(topic string, e pubsub.Event) {
+	var msg Message
+	err := json.Unmarshal(e.Data, &msg)
+	if err != nil {
+		panic(err)
+	}
+	r.topics = append(r.topics, topic)
+	r.messages = append(r.messages, msg)
+}
+
+// took returns the types and usernames of the messages published since the
+// last call, such as "join alice".
+func (r *recorder) took() []string {
+	var got []string
+	for _, msg := range r.messages {
+		got = append(got, msg.Type+" "+msg.User.Username)
+	}
+	r.topics, r.messages = nil, nil
+	return got
+}
+
+func equalStrings(t *testing.T, got, want []string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("got %q; want %q", got, want)
+	}
+	for i := range got {
+		if got := got[i]; got != want[i] {
+			t.Errorf("got[i] = %v; want %v", got, want[i])
+		}
+	}
+}
+
+func usernames(users []User) []string {
+	var names []string
+	for _, u := range users {
+		names = append(names, u.Username)
+	}
+	return names
+}
+
+var (
+	alice = User{ID: 1, Username: "alice"}
+	bob   = User{ID: 2, Username: "Bob"}
+)
+
+func TestJoinLeave(t *testing.T) {
+	rec := &recorder{}
+	tr := NewTracker(rec)
+
+	snapshot := tr.Join(7, bob)
+	equalStrings(t, usernames(snapshot.Viewers), []string{"Bob"})
+	snapshot = tr.Join(7, alice)
+	equalStrings(t, usernames(snapshot.Viewers), []string{"alice", "Bob"})
+	// A second connection of alice does not join again.
+	tr.Join(7, alice)
+	equalStrings(t, rec.topics, []string{Topic(7), Topic(7)})
+	equalStrings(t, rec.took(), []string{"join Bob", "join alice"})
+
+	tr.Leave(7, alice.ID)
+	equalStrings(t, rec.took(), nil)
+	tr.Leave(7, alice.ID)
+	tr.Leave(7, bob.ID)
+	equalStrings(t, rec.took(), []string{"leave alice", "leave Bob"})
+	if got := len(tr.threads); got != 0 {
+		t.Errorf("len(tr.threads) = %v; want 0", got)
+	}
+
+	// Leaving a thread one is not viewing is ignored.
+	tr.Leave(7, alice.ID)
+	equalStrings(t, rec.took(), nil)
+}
+
+func TestTyping(t *testing.T) {
+	rec := &recorder{}
+	tr := NewTracker(rec)
+	tr.Join(7, alice)
+	tr.Join(7, bob)
+	rec.took()
+
+	tr.Typing(7, alice.ID)
+	tr.Typing(7, alice.ID)
+	// Bob is not viewing thread 8.
+	tr.Typing(8, bob.ID)
+	equalStrings(t, rec.took(), []string{"typing alice"})
+	snapshot := tr.Join(7, bob)
+	equalStrings(t, usernames(snapshot.Typing), []string{"alice"})
+
+	tr.sweep(time.Now().Add(tr.TypingTTL + time.Second))
+	equalStrings(t, rec.took(), []string{"idle alice"})
+	snapshot = tr.Join(7, bob)
+	equalStrings(t, usernames(snapshot.Typing), nil)
+}
+
+func TestSweep(t *testing.T) {
+	rec := &recorder{}
+	tr := NewTracker(rec)
+	tr.Join(7, alice)
+	tr.Join(7, bob)
+	rec.took()
+
+	tr.sweep(time.Now().Add(tr.ViewerTTL / 2))
+	equalStrings(t, rec.took(), nil)
+
+	// Alice keeps viewing the thread; bob's connection went silent.
+	later := time.Now().Add(tr.ViewerTTL + time.Second)
+	tr.mu.Lock()
+	tr.threads[7][alice.ID].expires = later.Add(tr.ViewerTTL)
+	tr.mu.Unlock()
+	tr.sweep(later)
+	equalStrings(t, rec.took(), []string{"leave Bob"})
+	equalStrings(t, usernames(tr.Join(7, alice).Viewers), []string{"alice"})
+}
+
+func TestOnline(t *testing.T) {
+	tr := NewTracker(&recorder{})
+	tr.Seen(bob)
+	tr.Join(7, alice)
+	equalStrings(t, usernames(tr.Online()), []string{"alice", "Bob"})
+
+	tr.mu.Lock()
+	tr.seen[bob.ID] = seenUser{bob, time.Now().Add(-tr.OnlineWindow - time.Second)}
+	tr.mu.Unlock()
+	equalStrings(t, usernames(tr.Online()), []string{"alice"})
+
+	tr.sweep(time.Now())
+	if got := len(tr.seen); got != 1 {
+		t.Errorf("len(tr.seen) = %v; want 1", got)
+	}
+}
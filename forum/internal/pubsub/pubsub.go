@@ -0,0 +1,118 @@
+// Package pubsub carries events from the code that produces them to the
+// clients following them live. The Hub interface hides where events travel,
+// so that the in-process MemoryHub can be replaced by one shared between
+// several instances of the server.
+package pubsub
+
+import "sync"
+
+// Event is a notification published on a topic. Subscribers are expected to
+// fetch the state it refers to rather than rely on Data alone, so that an
+// event missed by a dropped subscriber can be recovered from the database.
+type Event struct {
+	ID   int64
+	Type string
+	Data []byte
+}
+
+// Publisher publishes events on topics.
+type Publisher interface {
+	Publish(topic string, e Event)
+}
+
+// Hub delivers the events published on a topic to its subscribers.
+type Hub interface {
+	Publisher
+	Subscribe(topic string) Subscription
+}
+
+// Subscription receives the events of a topic. Its channel is closed when
+// the subscription is closed, or when the subscriber falls too far behind;
+// it should then resubscribe and catch up on what it missed.
+type Subscription interface {
+	Events() <-chan Event
+	Close()
+}
+
+// MemoryHub is a Hub delivering events within the process.
+type MemoryHub struct {
+	mu     sync.Mutex
+	topics map[string]map[*memorySubscription]struct{}
+	buffer int
+}
+
+// NewMemoryHub returns a MemoryHub buffering up to buffer events per
+// subscriber. A subscriber with a full buffer is dropped rather than allowed
+// to block publishers.
+func NewMemoryHub(buffer int) *MemoryHub {
+	return &MemoryHub{
+		topics: map[string]map[*memorySubscription]struct{}{},
+		buffer: buffer,
+	}
+}
+
+// Publish sends e to the subscribers of topic without blocking.
+func (h *MemoryHub) Publish(topic string, e Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for s := range h.topics[topic] {
+		select {
+		case s.ch <- e:
+		default:
+			h.remove(s)
+		}
+	}
+}
+
+// Subscribe returns a subscription to the events published on topic from
+// now on.
+func (h *MemoryHub) Subscribe(topic string) Subscription {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s := &memorySubscription{hub: h, topic: topic, ch: make(chan Event, h.buffer)}
+	subs, ok := h.topics[topic]
+	if !ok {
+		subs = map[*memorySubscription]struct{}{}
+		h.topics[topic] = subs
+	}
+	subs[s] = struct{}{}
+	return s
+}
+
+// Subscribers returns the number of subscribers of topic.
+func (h *MemoryHub) Subscribers(topic string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.topics[topic])
+}
+
+// remove unregisters s and closes its channel. h.mu must be held.
+func (h *MemoryHub) remove(s *memorySubscription) {
+	subs := h.topics[s.topic]
+	if _, ok := subs[s]; !ok {
+		return
+	}
+	delete(subs, s)
+	if len(subs) == 0 {
+		delete(h.topics, s.topic)
+	}
+	close(s.ch)
+}
+
+type memorySubscription struct {
+	hub   *MemoryHub
+	topic string
+	ch    chan Event
+}
+
+func (s *memorySubscription) Events() <-chan Event {
+	return s.ch
+}
+
+func (s *memorySubscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	s.hub.remove(s)
+}
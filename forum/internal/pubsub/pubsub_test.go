@@ -0,0 +1,82 @@
+package pubsub
+
+import (
+	"testing"
+)
+
+func TestMemoryHub(t *testing.T) {
+	h := NewMemoryHub(2)
+	a := h.Subscribe("thread:1")
+	b := h.Subscribe("thread:1")
+	other := h.Subscribe("thread:2")
+	if got := h.Subscribers("thread:1"); got != 2 {
+		t.Errorf("h.Subscribers(\"thread:1\") = %v; want 2", got)
+	}
+
+	h.Publish("thread:1", Event{ID: 1, Type: "post"})
+	for _, s := range []Subscription{a, b} {
+		e := <-s.Events()
+		if e.ID != int64(1) {
+			t.Errorf("e.ID = %v; want %v", e.ID, int64(1))
+		}
+		if e.Type != "post" {
+			t.Errorf("e.Type = %q; want %q", e.Type, "post")
+		}
+	}
+	select {
+	case e := <-other.Events():
+		t.Errorf("got %+v on another topic", e)
+	default:
+	}
+
+	a.Close()
+	_, ok := <-a.Events()
+	if ok {
+		t.Error("the events of a closed subscription are still open")
+	}
+	if got := h.Subscribers("thread:1"); got != 1 {
+		t.Errorf("h.Subscribers(\"thread:1\") = %v; want 1", got)
+	}
+	// Closing twice is harmless.
+	a.Close()
+
+	b.Close()
+	other.Close()
+	if got := h.Subscribers("thread:1"); got != 0 {
+		t.Errorf("h.Subscribers(\"thread:1\") = %v; want 0", got)
+	}
+	if got := len(h.topics); got != 0 {
+		t.Errorf("len(h.topics) = %v; want 0", got)
+	}
+}
+
+// TestMemoryHubSlowSubscriber checks that a subscriber with a full buffer is
+// dropped instead of blocking the publisher.
+func TestMemoryHubSlowSubscriber(t *testing.T) {
+	h := NewMemoryHub(2)
+	slow := h.Subscribe("thread:1")
+	fast := h.Subscribe("thread:1")
+
+	for id := int64(1); id <= 3; id++ {
+		h.Publish("thread:1", Event{ID: id})
+		e := <-fast.Events()
+		if e.ID != id {
+			t.Errorf("e.ID = %v; want %v", e.ID, id)
+		}
+	}
+	if got := h.Subscribers("thread:1"); got != 1 {
+		t.Errorf("h.Subscribers(\"thread:1\") = %v; want 1", got)
+	}
+
+	var got []int64
+	for e := range slow.Events() {
+		got = append(got, e.ID)
+	}
+	if got := len(got); got != 2 {
+		t.Errorf("len(got) = %v; want 2", got)
+	}
+	slow.Close()
+	if got := h.Subscribers("thread:1"); got != 1 {
+		t.Errorf("h.Subscribers(\"thread:1\") = %v; want 1", got)
+	}
+}
@@ -0,0 +1,141 @@
+// Package testutil helps writing end-to-end tests of HTTP handlers: a test
+// server with a client that keeps its cookies like a browser but stops at
+// redirects, so that tests can check them, and small assertions.
+package testutil
+
+import (
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// Server is a test server and a client with its own cookies.
+type Server struct {
+	*httptest.Server
+	client *http.Client
+}
+
+// NewServer starts a server for h, closed at the end of the test.
+func NewServer(t *testing.T, h http.Handler) *Server {
+	t.Helper()
+	ts := httptest.NewServer(h)
+	t.Cleanup(ts.Close)
+	return newSession(t, ts)
+}
+
+// NewSession returns a client of the same server with no cookies, such as
+// a second visitor.
+func (s *Server) NewSession(t *testing.T) *Server {
+	t.Helper()
+	return newSession(t, s.Server)
+}
+
+func newSession(t *testing.T, ts *httptest.Server) *Server {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Server{
+		Server: ts,
+		client: &http.Client{
+			Transport: ts.Client().Transport,
+			Jar:       jar,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// Response is a response with its body read.
+type Response struct {
+	Status int
+	Header http.Header
+	Body   string
+}
+
+// Location returns the path a redirect points to.
+func (r *Response) Location() string {
+	return r.Header.Get("Location")
+}
+
+// Do sends a request, failing the test if no response is received.
+func (s *Server) Do(t *testing.T, req *http.Request) *Response {
+	t.Helper()
+	resp, err := s.client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Response{Status: resp.StatusCode, Header: resp.Header, Body: string(body)}
+}
+
+// Get requests the page at path.
+func (s *Server) Get(t *testing.T, path string) *Response {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, s.URL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s.Do(t, req)
+}
+
+// PostForm submits form to path.
+func (s *Server) PostForm(t *testing.T, path string, form url.Values) *Response {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, s.URL+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return s.Do(t, req)
+}
+
+// Cookie returns the value of the cookie the client sends to the server
+// under name, or "".
+func (s *Server) Cookie(t *testing.T, name string) string {
+	t.Helper()
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range s.client.Jar.Cookies(u) {
+		if c.Name == name {
+			return c.Value
+		}
+	}
+	return ""
+}
+
+// Equal fails the test if got is not want.
+func Equal[T comparable](t *testing.T, got, want T) {
+	t.Helper()
+	if got != want {
+		t.Errorf("got %v; want %v", got, want)
+	}
+}
+
+// Contains fails the test if s does not contain substr.
+func Contains(t *testing.T, s, substr string) {
+	t.Helper()
+	if !strings.Contains(s, substr) {
+		t.Errorf("%q not found in:\n%s", substr, s)
+	}
+}
+
+// NotContains fails the test if s contains substr.
+func NotContains(t *testing.T, s, substr string) {
+	t.Helper()
+	if strings.Contains(s, substr) {
+		t.Errorf("%q unexpectedly found in:\n%s", substr, s)
+	}
+}
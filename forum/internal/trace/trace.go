@@ -0,0 +1,129 @@
+// Package trace propagates W3C Trace Context: it continues the trace of an
+// incoming traceparent header, or starts one, and passes it on to the
+// outgoing requests made on behalf of a request.
+//
+// See https://www.w3.org/TR/trace-context/.
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// Headers carrying the trace context.
+const (
+	ParentHeader = "traceparent"
+	StateHeader  = "tracestate"
+)
+
+// FlagSampled marks a trace recorded by its caller.
+const FlagSampled = 0x01
+
+// Span identifies the current operation within a trace.
+type Span struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Flags   byte
+	// State is the vendor-specific tracestate, passed on untouched.
+	State string
+}
+
+// New starts a trace.
+func New() Span {
+	var s Span
+	rand.Read(s.TraceID[:])
+	rand.Read(s.SpanID[:])
+	return s
+}
+
+// Parse parses a traceparent header. It reports false when the header is
+// missing or invalid, in which case a new trace should be started.
+func Parse(header string) (Span, bool) {
+	var s Span
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return s, false
+	}
+	// Version 00 has exactly four fields; later versions may add more.
+	if parts[0] == "00" && len(parts) != 4 {
+		return s, false
+	}
+	if !decodeHex(s.TraceID[:], parts[1]) || !decodeHex(s.SpanID[:], parts[2]) {
+		return s, false
+	}
+	var flags [1]byte
+	if !decodeHex(flags[:], parts[3]) {
+		return s, false
+	}
+	s.Flags = flags[0]
+	if s.TraceID == [16]byte{} || s.SpanID == [8]byte{} {
+		return s, false
+	}
+	return s, true
+}
+
+// decodeHex decodes lowercase hexadecimal text filling dst exactly.
+func decodeHex(dst []byte, text string) bool {
+	if len(text) != 2*len(dst) || strings.ToLower(text) != text {
+		return false
+	}
+	_, err := hex.Decode(dst, []byte(text))
+	return err == nil
+}
+
+// FromRequest continues the trace of r with a new span, or starts a trace
+// when r carries none.
+func FromRequest(r *http.Request) Span {
+	parent, ok := Parse(r.Header.Get(ParentHeader))
+	if !ok {
+		return New()
+	}
+	s := parent
+	rand.Read(s.SpanID[:])
+	s.State = r.Header.Get(StateHeader)
+	return s
+}
+
+// TraceIDString returns the trace ID in hexadecimal.
+func (s Span) TraceIDString() string {
+	return hex.EncodeToString(s.TraceID[:])
+}
+
+// SpanIDString returns the span ID in hexadecimal.
+func (s Span) SpanIDString() string {
+	return hex.EncodeToString(s.SpanID[:])
+}
+
+// String formats the span as a traceparent header.
+func (s Span) String() string {
+	return "00-" + s.TraceIDString() + "-" + s.SpanIDString() + "-" + hex.EncodeToString([]byte{s.Flags})
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying s.
+func NewContext(ctx context.Context, s Span) context.Context {
+	return context.WithValue(ctx, contextKey{}, s)
+}
+
+// FromContext returns the span carried by ctx, if any.
+func FromContext(ctx context.Context) (Span, bool) {
+	s, ok := ctx.Value(contextKey{}).(Span)
+	return s, ok
+}
+
+// Inject sets the trace headers of an outgoing request made with ctx, so
+// that the callee continues the trace.
+func Inject(ctx context.Context, h http.Header) {
+	s, ok := FromContext(ctx)
+	if !ok {
+		return
+	}
+	h.Set(ParentHeader, s.String())
+	if s.State != "" {
+		h.Set(StateHeader, s.State)
+	}
+}
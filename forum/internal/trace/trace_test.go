@@ -0,0 +1,97 @@
+package trace
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"forum/internal/testutil"
+)
+
+const (
+	traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	spanID  = "00f067aa0ba902b7"
+)
+
+func TestParse(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		header string
+		ok     bool
+		flags  byte
+	}{
+		{"sampled", "00-" + traceID + "-" + spanID + "-01", true, FlagSampled},
+		{"not sampled", "00-" + traceID + "-" + spanID + "-00", true, 0},
+		{"surrounding spaces", " 00-" + traceID + "-" + spanID + "-01 ", true, FlagSampled},
+		{"later version with more fields", "cc-" + traceID + "-" + spanID + "-01-what-the-future-holds", true, FlagSampled},
+		{"empty", "", false, 0},
+		{"version 00 with more fields", "00-" + traceID + "-" + spanID + "-01-extra", false, 0},
+		{"invalid version", "ff-" + traceID + "-" + spanID + "-01", false, 0},
+		{"long version", "000-" + traceID + "-" + spanID + "-01", false, 0},
+		{"missing flags", "00-" + traceID + "-" + spanID, false, 0},
+		{"uppercase", "00-4BF92F3577B34DA6A3CE929D0E0E4736-" + spanID + "-01", false, 0},
+		{"short trace ID", "00-" + traceID[2:] + "-" + spanID + "-01", false, 0},
+		{"short span ID", "00-" + traceID + "-" + spanID[2:] + "-01", false, 0},
+		{"not hexadecimal", "00-" + traceID + "-00f067aa0ba902bz-01", false, 0},
+		{"zero trace ID", "00-00000000000000000000000000000000-" + spanID + "-01", false, 0},
+		{"zero span ID", "00-" + traceID + "-0000000000000000-01", false, 0},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s, ok := Parse(tt.header)
+			testutil.Equal(t, ok, tt.ok)
+			if !ok {
+				return
+			}
+			testutil.Equal(t, s.TraceIDString(), traceID)
+			testutil.Equal(t, s.SpanIDString(), spanID)
+			testutil.Equal(t, s.Flags, tt.flags)
+		})
+	}
+}
+
+func TestString(t *testing.T) {
+	header := "00-" + traceID + "-" + spanID + "-01"
+	s, ok := Parse(header)
+	testutil.Equal(t, ok, true)
+	testutil.Equal(t, s.String(), header)
+
+	s = New()
+	parsed, ok := Parse(s.String())
+	testutil.Equal(t, ok, true)
+	testutil.Equal(t, parsed, s)
+}
+
+func TestFromRequest(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(ParentHeader, "00-"+traceID+"-"+spanID+"-01")
+	r.Header.Set(StateHeader, "congo=t61rcWkgMzE")
+
+	s := FromRequest(r)
+	testutil.Equal(t, s.TraceIDString(), traceID)
+	testutil.Equal(t, s.Flags, byte(FlagSampled))
+	testutil.Equal(t, s.State, "congo=t61rcWkgMzE")
+	if s.SpanIDString() == spanID {
+		t.Errorf("span ID = %s; want a new one", spanID)
+	}
+
+	// An invalid parent starts a new trace, without its state.
+	r.Header.Set(ParentHeader, "00-"+traceID+"-"+spanID)
+	s = FromRequest(r)
+	if s.TraceIDString() == traceID {
+		t.Errorf("trace ID = %s; want a new one", traceID)
+	}
+	testutil.Equal(t, s.State, "")
+}
+
+func TestInject(t *testing.T) {
+	h := http.Header{}
+	Inject(context.Background(), h)
+	testutil.Equal(t, len(h), 0)
+
+	s, _ := Parse("00-" + traceID + "-" + spanID + "-01")
+	s.State = "congo=t61rcWkgMzE"
+	Inject(NewContext(context.Background(), s), h)
+	testutil.Equal(t, h.Get(ParentHeader), "00-"+traceID+"-"+spanID+"-01")
+	testutil.Equal(t, h.Get(StateHeader), "congo=t61rcWkgMzE")
+}
@@ -2,6 +2,7 @@ package validator
 
 import (
 	"net/mail"
+	"net/url"
 	"strings"
 	"unicode"
 	"unicode/utf8"
@@ -75,3 +76,12 @@ func ValidateEmail(value string) bool {
 	_, err := mail.ParseAddress(value)
 	return err == nil
 }
+
+// ValidateURL checks if the provided value is an absolute http or https URL.
+func ValidateURL(value string) bool {
+	u, err := url.Parse(value)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
@@ -0,0 +1,231 @@
+// Package webhook delivers forum events to the endpoints registered as
+// webhooks. Deliveries are queued in the database and sent in the background
+// with retries, so that events survive restarts and slow receivers.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"forum/internal/models"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Events that can be delivered to webhooks.
+const (
+	EventThreadCreated = "thread.created"
+	EventPostCreated   = "post.created"
+	EventUserCreated   = "user.created"
+)
+
+// Events lists every event a webhook can subscribe to.
+var Events = []string{EventThreadCreated, EventPostCreated, EventUserCreated}
+
+// Headers sent with every delivery. The signature is the hex-encoded
+// HMAC-SHA256 of "<timestamp>.<body>" keyed with the webhook's secret,
+// prefixed with "sha256=".
+const (
+	HeaderEvent     = "X-Forum-Event"
+	HeaderDelivery  = "X-Forum-Delivery"
+	HeaderTimestamp = "X-Forum-Timestamp"
+	HeaderSignature = "X-Forum-Signature"
+)
+
+// Envelope is the JSON body of a delivery.
+type Envelope struct {
+	Event   string    `json:"event"`
+	Created time.Time `json:"created"`
+	Data    any       `json:"data"`
+}
+
+// Payload returns the JSON body delivered for event with the given data.
+func Payload(event string, data any) ([]byte, error) {
+	return json.Marshal(Envelope{Event: event, Created: time.Now().UTC(), Data: data})
+}
+
+// Sign returns the signature header value of body sent at timestamp.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether signature is valid for body sent at timestamp.
+// Receivers should also reject timestamps too far in the past.
+func Verify(secret, timestamp, signature string, body []byte) bool {
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	return hmac.Equal([]byte(Sign(secret, ts, body)), []byte(signature))
+}
+
+// Dispatcher sends the queued deliveries to their webhooks.
+type Dispatcher struct {
+	// MaxAttempts is the number of attempts after which a delivery is
+	// marked as failed.
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry. It doubles after each
+	// failed attempt, up to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// PollInterval is how often the queue is checked for due retries.
+	PollInterval time.Duration
+	// Lease is how long a claimed delivery is hidden from other senders.
+	Lease time.Duration
+
+	queue  models.WebhookStore
+	client *http.Client
+	logger *slog.Logger
+	wake   chan struct{}
+}
+
+// NewDispatcher returns a Dispatcher sending the deliveries of queue.
+func NewDispatcher(queue models.WebhookStore, logger *slog.Logger) *Dispatcher {
+	return &Dispatcher{
+		MaxAttempts:  8,
+		BaseDelay:    30 * time.Second,
+		MaxDelay:     6 * time.Hour,
+		PollInterval: 10 * time.Second,
+		Lease:        time.Minute,
+		queue:        queue,
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		logger: logger,
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+// Notify wakes the dispatcher up after new deliveries have been queued.
+func (d *Dispatcher) Notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run sends deliveries as they become due, until ctx is cancelled.
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			n, err := d.RunOnce(ctx)
+			if err != nil {
+				d.logger.Error(err.Error())
+			}
+			if err != nil || n == 0 {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+	}
+}
+
+// RunOnce sends a batch of due deliveries and returns how many were sent.
+func (d *Dispatcher) RunOnce(ctx context.Context) (int, error) {
+	deliveries, err := d.queue.ClaimContext(ctx, 10, d.Lease)
+	if err != nil {
+		return 0, fmt.Errorf("claiming deliveries: %w", err)
+	}
+	for _, delivery := range deliveries {
+		if ctx.Err() != nil {
+			return 0, nil
+		}
+		d.deliver(ctx, delivery)
+	}
+	return len(deliveries), nil
+}
+
+// deliver makes one attempt to send delivery and records its outcome.
+func (d *Dispatcher) deliver(ctx context.Context, delivery *models.WebhookDelivery) {
+	start := time.Now()
+	code, err := d.send(ctx, delivery, start)
+	attempt := &models.WebhookAttempt{
+		DeliveryID: delivery.ID,
+		Attempted:  start,
+		StatusCode: code,
+		Duration:   time.Since(start),
+	}
+
+	status, next := models.DeliverySucceeded, start
+	if err != nil {
+		attempt.Error = err.Error()
+		status, next = models.DeliveryPending, start.Add(d.backoff(delivery.Attempts+1))
+		if delivery.Attempts+1 >= d.MaxAttempts {
+			status = models.DeliveryFailed
+		}
+		d.logger.Warn("webhook delivery failed", "delivery", delivery.ID, "url", delivery.URL, "error", err)
+	}
+
+	// The outcome of a request allowed to complete is recorded as well.
+	err = d.queue.RecordAttemptContext(context.WithoutCancel(ctx), attempt, status, next)
+	if err != nil {
+		d.logger.Error(err.Error(), "delivery", delivery.ID)
+	}
+}
+
+// send posts the payload of delivery and returns the response status code.
+// Any status outside 2xx is an error. A request in flight is allowed to
+// complete when ctx is cancelled, within the client's timeout.
+func (d *Dispatcher) send(ctx context.Context, delivery *models.WebhookDelivery, now time.Time) (int, error) {
+	req, err := http.NewRequestWithContext(context.WithoutCancel(ctx), http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	ts := now.Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "ForumNova-Webhook/1.0")
+	req.Header.Set(HeaderEvent, delivery.Event)
+	req.Header.Set(HeaderDelivery, strconv.Itoa(delivery.ID))
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(ts, 10))
+	req.Header.Set(HeaderSignature, Sign(delivery.Secret, ts, delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return 0, err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// backoff returns the delay before the attempt following the given number
+// of failed attempts.
+func (d *Dispatcher) backoff(failed int) time.Duration {
+	delay := d.BaseDelay
+	for i := 1; i < failed && delay < d.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, d.MaxDelay)
+}
@@ -0,0 +1,298 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"forum/internal/models"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// receiver is an httptest server recording the deliveries it gets. It
+// answers with the queued status codes, then with 204.
+type receiver struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []*http.Request
+	bodies   [][]byte
+	statuses []int
+}
+
+func newReceiver(t *testing.T, statuses ...int) *receiver {
+	rec := &receiver{statuses: statuses}
+	rec.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("reading delivery body: %v", err)
+		}
+
+		rec.mu.Lock()
+		rec.requests = append(rec.requests, r)
+		rec.bodies = append(rec.bodies, body)
+		status := http.StatusNoContent
+		if len(rec.statuses) > 0 {
+			status, rec.statuses = rec.statuses[0], rec.statuses[1:]
+		}
+		rec.mu.Unlock()
+
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(rec.Close)
+	return rec
+}
+
+func (rec *receiver) count() int {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return len(rec.requests)
+}
+
+// newQueue returns a WebhookModel backed by a fresh database.
+func newQueue(t *testing.T) *models.WebhookModel {
+	db, err := models.Open(filepath.Join(t.TempDir(), "test.sqlite"), models.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	queue, err := models.NewWebhookModel(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return queue
+}
+
+// newTestDispatcher returns a Dispatcher retrying without delay.
+func newTestDispatcher(queue *models.WebhookModel) *Dispatcher {
+	d := NewDispatcher(queue, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	d.BaseDelay = 0
+	d.MaxDelay = 0
+	d.MaxAttempts = 3
+	return d
+}
+
+// enqueue queues a thread.created event and checks that n deliveries were
+// queued.
+func enqueue(t *testing.T, queue *models.WebhookModel, n int) {
+	payload, err := Payload(EventThreadCreated, map[string]any{"id": 1, "title": "Hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	queued, err := queue.Enqueue(EventThreadCreated, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if queued != n {
+		t.Fatalf("Enqueue queued %d deliveries; want %d", queued, n)
+	}
+}
+
+// drain runs the dispatcher until the queue has no due delivery left.
+func drain(t *testing.T, d *Dispatcher) {
+	for range 10 {
+		n, err := d.RunOnce(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n == 0 {
+			return
+		}
+	}
+	t.Fatal("queue not drained after 10 rounds")
+}
+
+func TestDeliverySigned(t *testing.T) {
+	rec := newReceiver(t)
+	queue := newQueue(t)
+	id, err := queue.Insert(rec.URL, "s3cret", []string{EventThreadCreated})
+	if err != nil {
+		t.Fatal(err)
+	}
+	enqueue(t, queue, 1)
+
+	drain(t, newTestDispatcher(queue))
+
+	if rec.count() != 1 {
+		t.Fatalf("receiver got %d requests; want 1", rec.count())
+	}
+	req, body := rec.requests[0], rec.bodies[0]
+	if got := req.Header.Get(HeaderEvent); got != EventThreadCreated {
+		t.Errorf("%s = %q; want %q", HeaderEvent, got, EventThreadCreated)
+	}
+	if got := req.Header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q; want application/json", got)
+	}
+	if !Verify("s3cret", req.Header.Get(HeaderTimestamp), req.Header.Get(HeaderSignature), body) {
+		t.Errorf("signature %q does not verify", req.Header.Get(HeaderSignature))
+	}
+	if Verify("wrong", req.Header.Get(HeaderTimestamp), req.Header.Get(HeaderSignature), body) {
+		t.Error("signature verifies with the wrong secret")
+	}
+
+	var env struct {
+		Event string
+		Data  struct{ Title string }
+	}
+	err = json.Unmarshal(body, &env)
+	if err != nil {
+		t.Fatalf("decoding payload: %v", err)
+	}
+	if env.Event != EventThreadCreated || env.Data.Title != "Hello" {
+		t.Errorf("payload = %s", body)
+	}
+
+	deliveries, err := queue.Deliveries(id, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 {
+		t.Fatalf("got %d deliveries; want 1", len(deliveries))
+	}
+	d := deliveries[0]
+	if req.Header.Get(HeaderDelivery) != strconv.Itoa(d.ID) {
+		t.Errorf("%s = %q; want %d", HeaderDelivery, req.Header.Get(HeaderDelivery), d.ID)
+	}
+	if d.Status != models.DeliverySucceeded || len(d.Log) != 1 || d.Log[0].StatusCode != http.StatusNoContent {
+		t.Errorf("delivery = %+v; want one successful attempt logged", d)
+	}
+}
+
+func TestDeliveryRetried(t *testing.T) {
+	rec := newReceiver(t, http.StatusInternalServerError, http.StatusBadGateway)
+	queue := newQueue(t)
+	id, err := queue.Insert(rec.URL, "s3cret", []string{EventThreadCreated})
+	if err != nil {
+		t.Fatal(err)
+	}
+	enqueue(t, queue, 1)
+
+	drain(t, newTestDispatcher(queue))
+
+	deliveries, err := queue.Deliveries(id, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := deliveries[0]
+	if d.Status != models.DeliverySucceeded {
+		t.Errorf("status = %q; want %q", d.Status, models.DeliverySucceeded)
+	}
+	var codes []int
+	for _, a := range d.Log {
+		codes = append(codes, a.StatusCode)
+	}
+	want := []int{500, 502, 204}
+	if len(codes) != len(want) || codes[0] != want[0] || codes[1] != want[1] || codes[2] != want[2] {
+		t.Errorf("logged status codes %v; want %v", codes, want)
+	}
+	if d.Log[0].Error == "" {
+		t.Error("failed attempt logged without an error")
+	}
+}
+
+func TestDeliveryFailsThenRedelivered(t *testing.T) {
+	rec := newReceiver(t, 500, 500, 500)
+	queue := newQueue(t)
+	id, err := queue.Insert(rec.URL, "s3cret", []string{EventThreadCreated})
+	if err != nil {
+		t.Fatal(err)
+	}
+	enqueue(t, queue, 1)
+
+	dispatcher := newTestDispatcher(queue)
+	drain(t, dispatcher)
+
+	deliveries, err := queue.Deliveries(id, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := deliveries[0]
+	if d.Status != models.DeliveryFailed || d.Attempts != 3 {
+		t.Fatalf("status = %q after %d attempts; want %q after 3", d.Status, d.Attempts, models.DeliveryFailed)
+	}
+
+	err = queue.Redeliver(d.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	drain(t, dispatcher)
+
+	d, err = queue.GetDelivery(d.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Status != models.DeliverySucceeded || len(d.Log) != 4 {
+		t.Errorf("status = %q with %d attempts logged; want %q with 4", d.Status, len(d.Log), models.DeliverySucceeded)
+	}
+	if rec.count() != 4 {
+		t.Errorf("receiver got %d requests; want 4", rec.count())
+	}
+}
+
+func TestBackoffDelaysRetry(t *testing.T) {
+	rec := newReceiver(t, http.StatusServiceUnavailable)
+	queue := newQueue(t)
+	_, err := queue.Insert(rec.URL, "s3cret", []string{EventThreadCreated})
+	if err != nil {
+		t.Fatal(err)
+	}
+	enqueue(t, queue, 1)
+
+	dispatcher := newTestDispatcher(queue)
+	dispatcher.BaseDelay = time.Hour
+	dispatcher.MaxDelay = time.Hour
+	drain(t, dispatcher)
+
+	if rec.count() != 1 {
+		t.Errorf("receiver got %d requests; want 1 before the backoff expires", rec.count())
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	d := &Dispatcher{BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+	tests := []struct {
+		failed int
+		want   time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second},
+		{50, 10 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := d.backoff(tt.failed); got != tt.want {
+			t.Errorf("backoff(%d) = %v; want %v", tt.failed, got, tt.want)
+		}
+	}
+}
+
+func TestEnqueueFiltersWebhooks(t *testing.T) {
+	rec := newReceiver(t)
+	queue := newQueue(t)
+	_, err := queue.Insert(rec.URL, "a", []string{EventThreadCreated, EventPostCreated})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = queue.Insert(rec.URL, "b", []string{EventUserCreated})
+	if err != nil {
+		t.Fatal(err)
+	}
+	disabled, err := queue.Insert(rec.URL, "c", []string{EventThreadCreated})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = queue.SetActive(disabled, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	enqueue(t, queue, 1)
+}
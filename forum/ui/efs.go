@@ -0,0 +1,10 @@
+// Package ui holds the templates and static files of the web server,
+// embedded in the binary.
+package ui
+
+import "embed"
+
+// Files holds the html and static directories.
+//
+//go:embed "html" "static"
+var Files embed.FS
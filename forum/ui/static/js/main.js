@@ -5,4 +5,81 @@ for (var i = 0; i < navLinks.length; i++) {
 		link.classList.add("live");
 		break;
 	}
-}
\ No newline at end of file
+}
+
+// Append the posts added to a thread while it is open.
+var postList = document.querySelector(".post-list[data-events]");
+if (postList && window.EventSource) {
+	var source = new EventSource(postList.getAttribute("data-events"));
+	source.addEventListener("post", function (e) {
+		if (document.getElementById("post-" + e.lastEventId)) {
+			return;
+		}
+		var template = document.createElement("template");
+		template.innerHTML = e.data.trim();
+		postList.appendChild(template.content);
+	});
+}
+
+
+// Show who is viewing and typing in a thread, and tell the others when the
+// reply form is being typed in.
+var presenceBar = document.querySelector("[data-presence]");
+if (presenceBar && window.WebSocket) {
+	var presenceURL = (location.protocol == "https:" ? "wss://" : "ws://") + location.host + presenceBar.getAttribute("data-presence");
+	var viewers = {};
+	var typing = {};
+	var presenceSocket = null;
+	var lastTypingSent = 0;
+
+	var names = function (users) {
+		return Object.keys(users).map(function (id) { return users[id]; }).sort();
+	};
+
+	var renderPresence = function () {
+		var viewing = names(viewers);
+		var typists = names(typing);
+		presenceBar.querySelector(".presence-viewers").textContent =
+			viewing.length ? "Viewing this thread: " + viewing.join(", ") : "";
+		presenceBar.querySelector(".presence-typing").textContent =
+			typists.length ? typists.join(", ") + (typists.length == 1 ? " is" : " are") + " typing…" : "";
+	};
+
+	var connectPresence = function () {
+		presenceSocket = new WebSocket(presenceURL);
+		presenceSocket.onmessage = function (e) {
+			var msg = JSON.parse(e.data);
+			if (msg.type == "snapshot") {
+				viewers = {};
+				typing = {};
+				(msg.viewers || []).forEach(function (u) { viewers[u.id] = u.username; });
+				(msg.typing || []).forEach(function (u) { typing[u.id] = u.username; });
+			} else if (msg.type == "join") {
+				viewers[msg.user.id] = msg.user.username;
+			} else if (msg.type == "leave") {
+				delete viewers[msg.user.id];
+				delete typing[msg.user.id];
+			} else if (msg.type == "typing") {
+				typing[msg.user.id] = msg.user.username;
+			} else if (msg.type == "idle") {
+				delete typing[msg.user.id];
+			}
+			renderPresence();
+		};
+		presenceSocket.onclose = function () {
+			setTimeout(connectPresence, 3000);
+		};
+	};
+	connectPresence();
+
+	var replyBody = document.querySelector("textarea[name='body']");
+	if (replyBody) {
+		replyBody.addEventListener("input", function () {
+			var now = Date.now();
+			if (now - lastTypingSent > 2000 && presenceSocket.readyState == WebSocket.OPEN) {
+				lastTypingSent = now;
+				presenceSocket.send(JSON.stringify({ type: "typing" }));
+			}
+		});
+	}
+}